@@ -0,0 +1,52 @@
+// Copyright 2012-2013 Apcera Inc. All rights reserved.
+// +build ignore
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"github.com/apcera/nats"
+	"github.com/apcera/nats/server"
+	"log"
+	"os"
+	"os/signal"
+)
+
+func usage() {
+	log.Fatalf("Usage: nats-server [-a host] [-p port] [--user user --pass pass] [--tlscert file --tlskey file]\n")
+}
+
+func main() {
+	var host = flag.String("a", server.DefaultHost, "Host to bind to")
+	var port = flag.Int("p", nats.DefaultPort, "Port to listen on")
+	var user = flag.String("user", "", "Username required of clients")
+	var pass = flag.String("pass", "", "Password required of clients")
+	var tlsCert = flag.String("tlscert", "", "TLS certificate file")
+	var tlsKey = flag.String("tlskey", "", "TLS key file")
+
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+
+	opts := server.Options{Host: *host, Port: *port, User: *user, Pass: *pass}
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Can't load certificate: %v\n", err)
+		}
+		opts.TlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	s, err := server.Run(opts)
+	if err != nil {
+		log.Fatalf("Can't start server: %v\n", err)
+	}
+	log.Printf("Listening on %s\n", s.Addr())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+
+	s.Shutdown()
+}
@@ -0,0 +1,432 @@
+// Copyright 2012-2013 Apcera Inc. All rights reserved.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_CRLF_  = "\r\n"
+	_EMPTY_ = ""
+)
+
+const (
+	infoProto = "INFO %s" + _CRLF_
+	okProto   = "+OK" + _CRLF_
+	pongProto = "PONG" + _CRLF_
+	errProto  = "-ERR '%s'" + _CRLF_
+	msgProto  = "MSG %s %s %d" + _CRLF_
+	msgRProto = "MSG %s %s %s %d" + _CRLF_
+)
+
+// Errors sent to a client with -ERR before its connection is closed.
+var (
+	errUnknownOp      = errors.New("Unknown Protocol Operation")
+	errAuthorization  = errors.New("Authorization Violation")
+	errMaxPayload     = errors.New("Maximum Payload Exceeded")
+	errMaxControlLine = errors.New("Maximum Control Line Exceeded")
+	errInvalidSubject = errors.New("Invalid Subject")
+	errParse          = errors.New("Parser Error")
+)
+
+const (
+	// Same as the client, longer control lines are a protocol error.
+	maxControlLine = 1024
+
+	// The size of the bufio reader/writer on top of the socket.
+	defaultBufSize = 32768
+
+	// How long a write to a client may block before it is considered a
+	// slow consumer and disconnected.
+	writeDeadline = 2 * time.Second
+)
+
+type connectInfo struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Ssl      bool   `json:"ssl_required"`
+	Name     string `json:"name"`
+}
+
+// A client is a connection to the server.
+type client struct {
+	mu     sync.Mutex
+	srv    *Server
+	nc     net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	fch    chan struct{}
+	done   chan struct{}
+	opts   connectInfo
+	authed bool
+	subs   map[string]*subscription
+	closed bool
+}
+
+// A subscription is a client's interest in a subject. When max is set it
+// is removed after that many messages have been delivered.
+type subscription struct {
+	client    *client
+	subject   string
+	queue     string
+	sid       string
+	max       uint64
+	delivered uint64
+}
+
+func newClient(s *Server, nc net.Conn) *client {
+	return &client{
+		srv:    s,
+		nc:     nc,
+		bw:     bufio.NewWriterSize(nc, defaultBufSize),
+		fch:    make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		authed: s.opts.User == _EMPTY_,
+		subs:   make(map[string]*subscription),
+	}
+}
+
+// readLoop sends INFO, upgrades the connection to TLS if required, then
+// processes the client's protocol until it disconnects or misbehaves.
+func (c *client) readLoop() {
+	defer c.srv.wg.Done()
+	defer c.close()
+
+	c.mu.Lock()
+	c.setWriteDeadline()
+	c.bw.Write(c.srv.infoB)
+	err := c.bw.Flush()
+	c.clearWriteDeadline()
+	if err == nil && c.srv.opts.TlsConfig != nil {
+		c.nc = tls.Server(c.nc, c.srv.opts.TlsConfig)
+		c.bw = bufio.NewWriterSize(c.nc, defaultBufSize)
+	}
+	c.br = bufio.NewReaderSize(c.nc, defaultBufSize)
+	c.mu.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	for {
+		line, err := c.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || len(line) > maxControlLine {
+			c.sendErr(errMaxControlLine)
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err := c.processLine(line); err != nil {
+			c.sendErr(err)
+			return
+		}
+	}
+}
+
+// flusher writes out what has been buffered for the client whenever it
+// is kicked, so that the messages delivered in the meantime go out in a
+// single write. Deliveries to the client wait for the flush to finish.
+func (c *client) flusher() {
+	defer c.srv.wg.Done()
+
+	for {
+		select {
+		case <-c.fch:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		var err error
+		if c.bw.Buffered() > 0 {
+			c.setWriteDeadline()
+			err = c.bw.Flush()
+			c.clearWriteDeadline()
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// setWriteDeadline gives the socket writes that follow writeDeadline to
+// complete, until clearWriteDeadline is called. Writes to c.bw may reach
+// the socket right away when they don't fit in the buffer, so it should
+// be called before them too. The lock should be held.
+func (c *client) setWriteDeadline() {
+	c.nc.SetWriteDeadline(time.Now().Add(writeDeadline))
+}
+
+// clearWriteDeadline removes the deadline, so that it can't expire under
+// a later write. The lock should be held.
+func (c *client) clearWriteDeadline() {
+	c.nc.SetWriteDeadline(time.Time{})
+}
+
+// kickFlusher wakes the flusher up. The lock should be held.
+func (c *client) kickFlusher() {
+	select {
+	case c.fch <- struct{}{}:
+	default:
+	}
+}
+
+func (c *client) send(proto string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.setWriteDeadline()
+	c.bw.WriteString(proto)
+	c.clearWriteDeadline()
+	c.kickFlusher()
+}
+
+// sendErr writes the error to the client synchronously, since its
+// connection is about to be closed.
+func (c *client) sendErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.setWriteDeadline()
+	fmt.Fprintf(c.bw, errProto, err)
+	c.bw.Flush()
+	c.clearWriteDeadline()
+}
+
+func (c *client) sendOK() {
+	if c.opts.Verbose {
+		c.send(okProto)
+	}
+}
+
+func (c *client) processLine(line []byte) error {
+	args := strings.Fields(string(line))
+	if len(args) == 0 {
+		return nil
+	}
+
+	op := strings.ToUpper(args[0])
+	if !c.authed && op != "CONNECT" {
+		return errAuthorization
+	}
+
+	switch op {
+	case "CONNECT":
+		return c.processConnect(bytes.TrimSpace(line[len(args[0]):]))
+	case "PUB":
+		return c.processPub(args[1:])
+	case "SUB":
+		return c.processSub(args[1:])
+	case "UNSUB":
+		return c.processUnsub(args[1:])
+	case "PING":
+		c.send(pongProto)
+	case "PONG":
+	default:
+		return errUnknownOp
+	}
+	return nil
+}
+
+func (c *client) processConnect(arg []byte) error {
+	var opts connectInfo
+	if err := json.Unmarshal(arg, &opts); err != nil {
+		return errParse
+	}
+	if c.srv.opts.User != _EMPTY_ {
+		if opts.User != c.srv.opts.User || opts.Pass != c.srv.opts.Pass {
+			return errAuthorization
+		}
+	}
+
+	c.mu.Lock()
+	c.opts = opts
+	c.authed = true
+	c.mu.Unlock()
+
+	c.sendOK()
+	return nil
+}
+
+// PUB <subject> [reply] <size>
+func (c *client) processPub(args []string) error {
+	var subj, reply, size string
+	switch len(args) {
+	case 2:
+		subj, size = args[0], args[1]
+	case 3:
+		subj, reply, size = args[0], args[1], args[2]
+	default:
+		return errParse
+	}
+
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		return errParse
+	}
+	if n > c.srv.opts.MaxPayload {
+		return errMaxPayload
+	}
+	if !validSubject(subj, false) {
+		return errInvalidSubject
+	}
+
+	buf := make([]byte, n+len(_CRLF_))
+	if _, err := io.ReadFull(c.br, buf); err != nil {
+		return err
+	}
+	if string(buf[n:]) != _CRLF_ {
+		return errParse
+	}
+
+	c.sendOK()
+	c.srv.route(subj, reply, buf[:n])
+	return nil
+}
+
+// SUB <subject> [queue] <sid>
+func (c *client) processSub(args []string) error {
+	sub := &subscription{client: c}
+	switch len(args) {
+	case 2:
+		sub.subject, sub.sid = args[0], args[1]
+	case 3:
+		sub.subject, sub.queue, sub.sid = args[0], args[1], args[2]
+	default:
+		return errParse
+	}
+	if !validSubject(sub.subject, true) {
+		return errInvalidSubject
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	old := c.subs[sub.sid]
+	c.subs[sub.sid] = sub
+	c.mu.Unlock()
+
+	// The client re-sends its subscriptions after a reconnect, so reusing
+	// a sid replaces the subscription.
+	if old != nil {
+		c.srv.sl.Remove(old)
+	}
+	c.srv.sl.Insert(sub)
+
+	c.sendOK()
+	return nil
+}
+
+// UNSUB <sid> [max]
+func (c *client) processUnsub(args []string) error {
+	var max uint64
+	switch len(args) {
+	case 1:
+	case 2:
+		n, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return errParse
+		}
+		max = n
+	default:
+		return errParse
+	}
+
+	c.mu.Lock()
+	sub := c.subs[args[0]]
+	done := sub != nil && (max == 0 || sub.delivered >= max)
+	if sub != nil && !done {
+		sub.max = max
+	}
+	c.mu.Unlock()
+
+	if done {
+		c.unsubscribe(sub)
+	}
+
+	c.sendOK()
+	return nil
+}
+
+// unsubscribe removes the subscription from the client and the server.
+func (c *client) unsubscribe(sub *subscription) {
+	c.mu.Lock()
+	if c.subs[sub.sid] == sub {
+		delete(c.subs, sub.sid)
+	}
+	c.mu.Unlock()
+
+	c.srv.sl.Remove(sub)
+}
+
+// deliver queues a message for the subscription. It returns true when the
+// subscription has reached its maximum and should be removed.
+func (c *client) deliver(sub *subscription, subj, reply string, data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || (sub.max > 0 && sub.delivered >= sub.max) {
+		return false
+	}
+	sub.delivered++
+
+	c.setWriteDeadline()
+	if reply != _EMPTY_ {
+		fmt.Fprintf(c.bw, msgRProto, subj, sub.sid, reply, len(data))
+	} else {
+		fmt.Fprintf(c.bw, msgProto, subj, sub.sid, len(data))
+	}
+	c.bw.Write(data)
+	c.bw.WriteString(_CRLF_)
+	c.clearWriteDeadline()
+	c.kickFlusher()
+
+	return sub.max > 0 && sub.delivered >= sub.max
+}
+
+// close disconnects the client and drops its subscriptions. It is safe
+// to call more than once.
+func (c *client) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	subs := c.subs
+	c.subs = nil
+	close(c.done)
+	c.nc.Close()
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		c.srv.sl.Remove(sub)
+	}
+	c.srv.removeClient(c)
+}
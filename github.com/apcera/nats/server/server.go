@@ -0,0 +1,269 @@
+// Copyright 2012-2013 Apcera Inc. All rights reserved.
+
+// Package server is an embeddable NATS server. It speaks the same text
+// protocol as the Ruby nats-server, which makes it useful for testing
+// clients without an external process and for running a single node
+// inside another program.
+package server
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	mrand "math/rand"
+)
+
+const (
+	Version           = "0.1"
+	DefaultHost       = "localhost"
+	DefaultMaxPayload = 1024 * 1024
+)
+
+var (
+	ErrServerRunning = errors.New("nats: Server already started")
+)
+
+// Options configure a Server. The zero value listens on a random port of
+// the loopback interface and lets any client in.
+type Options struct {
+	Host string
+	Port int
+
+	// Clients must CONNECT with these credentials when User is set.
+	User string
+	Pass string
+
+	// Clients must upgrade their connection to TLS after the INFO
+	// message when a configuration is given.
+	TlsConfig *tls.Config
+
+	// The largest payload a client may publish. Zero means the default.
+	MaxPayload int
+}
+
+// A Server routes the messages published by its clients to the
+// subscriptions interested in them.
+type Server struct {
+	mu      sync.Mutex
+	opts    Options
+	info    serverInfo
+	infoB   []byte
+	ln      net.Listener
+	sl      *sublist
+	clients map[*client]struct{}
+	wg      sync.WaitGroup
+	done    bool
+}
+
+type serverInfo struct {
+	Id           string `json:"server_id"`
+	Host         string `json:"host"`
+	Port         uint   `json:"port"`
+	Version      string `json:"version"`
+	AuthRequired bool   `json:"auth_required"`
+	SslRequired  bool   `json:"ssl_required"`
+	MaxPayload   int64  `json:"max_payload"`
+}
+
+// New creates a server with the given options. It doesn't accept
+// connections until it is started.
+func New(opts Options) *Server {
+	if opts.Host == _EMPTY_ {
+		opts.Host = DefaultHost
+	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = DefaultMaxPayload
+	}
+	return &Server{
+		opts:    opts,
+		sl:      newSublist(),
+		clients: make(map[*client]struct{}),
+	}
+}
+
+// Run creates a server with the given options and starts it.
+func Run(opts Options) (*Server, error) {
+	s := New(opts)
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start listens on the configured address and begins accepting clients
+// in the background.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln != nil {
+		return ErrServerRunning
+	}
+
+	hp := net.JoinHostPort(s.opts.Host, strconv.Itoa(s.opts.Port))
+	ln, err := net.Listen("tcp", hp)
+	if err != nil {
+		return err
+	}
+
+	s.ln = ln
+	s.done = false
+	s.info = serverInfo{
+		Id:           newId(),
+		Host:         s.opts.Host,
+		Port:         uint(ln.Addr().(*net.TCPAddr).Port),
+		Version:      Version,
+		AuthRequired: s.opts.User != _EMPTY_,
+		SslRequired:  s.opts.TlsConfig != nil,
+		MaxPayload:   int64(s.opts.MaxPayload),
+	}
+	b, _ := json.Marshal(s.info)
+	s.infoB = []byte(fmt.Sprintf(infoProto, b))
+
+	s.wg.Add(1)
+	go s.acceptLoop(ln)
+
+	return nil
+}
+
+// Shutdown stops accepting clients and closes the connections of the
+// ones connected. The server may be started again afterwards.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	if s.ln == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.ln.Close()
+	s.ln = nil
+
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.close()
+	}
+	s.wg.Wait()
+}
+
+// Addr returns the address the server is listening on, or nil if it
+// hasn't been started.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Url returns a URL that clients can connect to, including the
+// credentials when authorization is required.
+func (s *Server) Url() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln == nil {
+		return _EMPTY_
+	}
+	hp := net.JoinHostPort(s.opts.Host, strconv.Itoa(int(s.info.Port)))
+	if s.opts.User != _EMPTY_ {
+		return fmt.Sprintf("nats://%s:%s@%s", s.opts.User, s.opts.Pass, hp)
+	}
+	return fmt.Sprintf("nats://%s", hp)
+}
+
+// NumClients returns the number of connected clients.
+func (s *Server) NumClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+// NumSubscriptions returns the number of subscriptions of all clients.
+func (s *Server) NumSubscriptions() int {
+	return s.sl.Count()
+}
+
+func (s *Server) acceptLoop(ln net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		c := newClient(s, nc)
+		s.clients[c] = struct{}{}
+		s.wg.Add(2)
+		s.mu.Unlock()
+
+		go c.readLoop()
+		go c.flusher()
+	}
+}
+
+func (s *Server) removeClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, c)
+}
+
+// route delivers a message to every plain subscription of the subject and
+// to one member, chosen at random, of every queue group.
+func (s *Server) route(subj, reply string, data []byte) {
+	var qsubs map[string][]*subscription
+
+	for _, sub := range s.sl.Match(subj) {
+		if sub.queue == _EMPTY_ {
+			s.deliver(sub, subj, reply, data)
+			continue
+		}
+		if qsubs == nil {
+			qsubs = make(map[string][]*subscription)
+		}
+		qsubs[sub.queue] = append(qsubs[sub.queue], sub)
+	}
+
+	for _, qs := range qsubs {
+		s.deliver(qs[mrand.Intn(len(qs))], subj, reply, data)
+	}
+}
+
+func (s *Server) deliver(sub *subscription, subj, reply string, data []byte) {
+	if sub.client.deliver(sub, subj, reply, data) {
+		sub.client.unsubscribe(sub)
+	}
+}
+
+// Generate a unique id for the server.
+func newId() string {
+	u := make([]byte, 11)
+	io.ReadFull(rand.Reader, u)
+	return hex.EncodeToString(u)
+}
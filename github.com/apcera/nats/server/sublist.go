@@ -0,0 +1,167 @@
+// Copyright 2012-2013 Apcera Inc. All rights reserved.
+
+package server
+
+import (
+	"strings"
+	"sync"
+)
+
+// Wildcard tokens. A '*' matches exactly one token of a subject, a '>'
+// matches one or more trailing tokens.
+const (
+	_PWC_ = "*"
+	_FWC_ = ">"
+	_TSEP = "."
+)
+
+// A sublist maps subjects to the subscriptions interested in them. It is
+// a tree of levels, one per token, where wildcard tokens are stored as
+// ordinary nodes and followed in addition to the literal ones on a match.
+type sublist struct {
+	mu    sync.RWMutex
+	root  *level
+	count int
+}
+
+type level struct {
+	nodes map[string]*node
+}
+
+type node struct {
+	next *level
+	subs []*subscription
+}
+
+func newLevel() *level {
+	return &level{nodes: make(map[string]*node)}
+}
+
+func newSublist() *sublist {
+	return &sublist{root: newLevel()}
+}
+
+// validSubject reports whether subj is well formed. Wildcards are only
+// allowed when subscribing, and '>' only as the last token.
+func validSubject(subj string, wildcards bool) bool {
+	if subj == _EMPTY_ {
+		return false
+	}
+	toks := strings.Split(subj, _TSEP)
+	for i, t := range toks {
+		switch t {
+		case _EMPTY_:
+			return false
+		case _PWC_:
+			if !wildcards {
+				return false
+			}
+		case _FWC_:
+			if !wildcards || i != len(toks)-1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Insert adds the subscription under its subject.
+func (s *sublist) Insert(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.root
+	var n *node
+	for _, t := range strings.Split(sub.subject, _TSEP) {
+		if l == nil {
+			n.next = newLevel()
+			l = n.next
+		}
+		n = l.nodes[t]
+		if n == nil {
+			n = &node{}
+			l.nodes[t] = n
+		}
+		l = n.next
+	}
+	n.subs = append(n.subs, sub)
+	s.count++
+}
+
+// Remove drops the subscription, pruning the nodes left empty. It is a
+// no-op if the subscription isn't in the list.
+func (s *sublist) Remove(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.root.remove(strings.Split(sub.subject, _TSEP), sub) {
+		s.count--
+	}
+}
+
+func (l *level) remove(toks []string, sub *subscription) bool {
+	n := l.nodes[toks[0]]
+	if n == nil {
+		return false
+	}
+
+	var removed bool
+	if len(toks) == 1 {
+		for i, x := range n.subs {
+			if x == sub {
+				n.subs = append(n.subs[:i], n.subs[i+1:]...)
+				removed = true
+				break
+			}
+		}
+	} else if n.next != nil {
+		removed = n.next.remove(toks[1:], sub)
+		if removed && len(n.next.nodes) == 0 {
+			n.next = nil
+		}
+	}
+
+	if len(n.subs) == 0 && n.next == nil {
+		delete(l.nodes, toks[0])
+	}
+	return removed
+}
+
+// Match returns the subscriptions interested in a literal subject.
+func (s *sublist) Match(subj string) []*subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.root.match(strings.Split(subj, _TSEP), nil)
+}
+
+func (l *level) match(toks []string, out []*subscription) []*subscription {
+	if n := l.nodes[_FWC_]; n != nil {
+		out = append(out, n.subs...)
+	}
+	if n := l.nodes[_PWC_]; n != nil {
+		out = n.match(toks[1:], out)
+	}
+	if n := l.nodes[toks[0]]; n != nil {
+		out = n.match(toks[1:], out)
+	}
+	return out
+}
+
+func (n *node) match(toks []string, out []*subscription) []*subscription {
+	if len(toks) == 0 {
+		return append(out, n.subs...)
+	}
+	if n.next == nil {
+		return out
+	}
+	return n.next.match(toks, out)
+}
+
+// Count returns the number of subscriptions in the list.
+func (s *sublist) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.count
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+)
+
+func newSub(subj string) *subscription {
+	return &subscription{subject: subj}
+}
+
+func verifyMatch(t *testing.T, s *sublist, subj string, expected ...*subscription) {
+	r := s.Match(subj)
+	if len(r) != len(expected) {
+		t.Fatalf("Matching %q: expected %d results, got %d", subj, len(expected), len(r))
+	}
+	for _, e := range expected {
+		found := false
+		for _, x := range r {
+			if x == e {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Matching %q: expected %q in the results", subj, e.subject)
+		}
+	}
+}
+
+func TestSublistLiteral(t *testing.T) {
+	s := newSublist()
+	foo, foobar := newSub("foo"), newSub("foo.bar")
+	s.Insert(foo)
+	s.Insert(foobar)
+
+	verifyMatch(t, s, "foo", foo)
+	verifyMatch(t, s, "foo.bar", foobar)
+	verifyMatch(t, s, "foo.baz")
+	verifyMatch(t, s, "bar")
+}
+
+func TestSublistPartialWildcard(t *testing.T) {
+	s := newSublist()
+	a, b, c := newSub("foo.*"), newSub("*.bar"), newSub("foo.*.baz")
+	s.Insert(a)
+	s.Insert(b)
+	s.Insert(c)
+
+	verifyMatch(t, s, "foo.bar", a, b)
+	verifyMatch(t, s, "foo.baz", a)
+	verifyMatch(t, s, "foo.bar.baz", c)
+	verifyMatch(t, s, "foo")
+	verifyMatch(t, s, "foo.bar.bar")
+}
+
+func TestSublistFullWildcard(t *testing.T) {
+	s := newSublist()
+	all, foo := newSub(">"), newSub("foo.>")
+	s.Insert(all)
+	s.Insert(foo)
+
+	verifyMatch(t, s, "foo", all)
+	verifyMatch(t, s, "foo.bar", all, foo)
+	verifyMatch(t, s, "foo.bar.baz", all, foo)
+	verifyMatch(t, s, "bar.baz", all)
+}
+
+func TestSublistRemove(t *testing.T) {
+	s := newSublist()
+	a, b, c := newSub("foo.bar"), newSub("foo.bar"), newSub("foo.*")
+	s.Insert(a)
+	s.Insert(b)
+	s.Insert(c)
+	if s.Count() != 3 {
+		t.Fatalf("Expected 3 subscriptions, got %d", s.Count())
+	}
+
+	s.Remove(a)
+	verifyMatch(t, s, "foo.bar", b, c)
+
+	// Removing twice, or something never inserted, is a no-op
+	s.Remove(a)
+	s.Remove(newSub("foo.bar.baz"))
+	if s.Count() != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %d", s.Count())
+	}
+
+	s.Remove(b)
+	s.Remove(c)
+	verifyMatch(t, s, "foo.bar")
+	if len(s.root.nodes) != 0 {
+		t.Fatalf("Expected empty nodes to be pruned, got %d", len(s.root.nodes))
+	}
+}
+
+func TestValidSubject(t *testing.T) {
+	for _, x := range []string{"foo", "foo.bar", "foo.*", "*.bar", ">", "foo.>"} {
+		if !validSubject(x, true) {
+			t.Errorf("Expected %q to be a valid subscription subject", x)
+		}
+	}
+	for _, x := range []string{"", ".", "foo.", ".foo", "foo..bar", "foo.>.bar", ">.foo"} {
+		if validSubject(x, true) {
+			t.Errorf("Expected %q to be an invalid subscription subject", x)
+		}
+	}
+	for _, x := range []string{"foo.*", ">", "foo.>"} {
+		if validSubject(x, false) {
+			t.Errorf("Expected %q to be an invalid publish subject", x)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package raft
 
+import (
+	"io"
+)
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -54,3 +58,76 @@ func newAppendEntriesResponse(term uint64, success bool, index uint64, commitInd
 		CommitIndex: commitIndex,
 	}
 }
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Encoding
+//--------------------------------------
+
+// Encodes the AppendEntries request to a buffer.
+func (req *AppendEntriesRequest) encode(w io.Writer) error {
+	var e encoder
+	e.uint64(req.Term)
+	e.string(req.LeaderName)
+	e.uint64(req.PrevLogIndex)
+	e.uint64(req.PrevLogTerm)
+	e.uint64(req.CommitIndex)
+	e.uint64(uint64(len(req.Entries)))
+	for _, entry := range req.Entries {
+		if err := entry.encode(&e); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the AppendEntries request from a buffer.
+func (req *AppendEntriesRequest) decode(r io.Reader) error {
+	d := newDecoder(r)
+	req.Term = d.uint64()
+	req.LeaderName = d.string()
+	req.PrevLogIndex = d.uint64()
+	req.PrevLogTerm = d.uint64()
+	req.CommitIndex = d.uint64()
+	count := d.uint64()
+	if d.err != nil {
+		return d.err
+	}
+
+	req.Entries = make([]*LogEntry, 0)
+	for i := uint64(0); i < count; i++ {
+		entry := &LogEntry{}
+		if _, err := entry.decode(d.r); err != nil {
+			return err
+		}
+		req.Entries = append(req.Entries, entry)
+	}
+	return nil
+}
+
+// Encodes the AppendEntries response to a buffer.
+func (resp *AppendEntriesResponse) encode(w io.Writer) error {
+	var e encoder
+	e.uint64(resp.Term)
+	e.uint64(resp.Index)
+	e.bool(resp.Success)
+	e.uint64(resp.CommitIndex)
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the AppendEntries response from a buffer.
+func (resp *AppendEntriesResponse) decode(r io.Reader) error {
+	d := newDecoder(r)
+	resp.Term = d.uint64()
+	resp.Index = d.uint64()
+	resp.Success = d.bool()
+	resp.CommitIndex = d.uint64()
+	return d.err
+}
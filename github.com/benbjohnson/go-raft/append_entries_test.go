@@ -3,15 +3,45 @@ package raft
 import (
 	"bytes"
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
+// Ensure that an AppendEntries request and response survive encoding.
+func TestAppendEntriesEncoding(t *testing.T) {
+	req, _ := createTestAppendEntriesRequest(3)
+	var b bytes.Buffer
+	if err := req.encode(&b); err != nil {
+		t.Fatalf("Request encoding error: %v", err)
+	}
+	req2 := &AppendEntriesRequest{}
+	if err := req2.decode(&b); err != nil {
+		t.Fatalf("Request decoding error: %v", err)
+	}
+	if req2.Term != 1 || req2.LeaderName != "leader" || req2.PrevLogIndex != 1 || req2.PrevLogTerm != 1 || req2.CommitIndex != 1 || len(req2.Entries) != 3 {
+		t.Fatalf("Request decoded incorrectly: %v", req2)
+	}
+	if e := req2.Entries[2]; e.Index != 1 || e.Term != 2 || !reflect.DeepEqual(e.Command, &joinCommand{Name: "localhost:1000"}) {
+		t.Fatalf("Entry decoded incorrectly: %v", e)
+	}
+
+	resp := newAppendEntriesResponse(3, true, 10, 8)
+	b.Reset()
+	if err := resp.encode(&b); err != nil {
+		t.Fatalf("Response encoding error: %v", err)
+	}
+	resp2 := &AppendEntriesResponse{}
+	if err := resp2.decode(&b); err != nil || !reflect.DeepEqual(resp, resp2) {
+		t.Fatalf("Response decoded incorrectly: %v (%v)", resp2, err)
+	}
+}
+
 func BenchmarkAppendEntriesEncoding(b *testing.B) {
 	req, tmp := createTestAppendEntriesRequest(2000)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		json.NewEncoder(&buf).Encode(req)
+		req.encode(&buf)
 	}
 	b.SetBytes(int64(len(tmp)))
 }
@@ -19,6 +49,27 @@ func BenchmarkAppendEntriesEncoding(b *testing.B) {
 func BenchmarkAppendEntriesDecoding(b *testing.B) {
 	req, buf := createTestAppendEntriesRequest(2000)
 	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req.decode(bytes.NewReader(buf))
+	}
+	b.SetBytes(int64(len(buf)))
+}
+
+func BenchmarkAppendEntriesJSONEncoding(b *testing.B) {
+	req, _ := createTestAppendEntriesRequest(2000)
+	tmp, _ := json.Marshal(req)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(req)
+	}
+	b.SetBytes(int64(len(tmp)))
+}
+
+func BenchmarkAppendEntriesJSONDecoding(b *testing.B) {
+	req, _ := createTestAppendEntriesRequest(2000)
+	buf, _ := json.Marshal(req)
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		json.NewDecoder(bytes.NewReader(buf)).Decode(req)
 	}
@@ -31,7 +82,8 @@ func createTestAppendEntriesRequest(entryCount int) (*AppendEntriesRequest, []by
 		entries = append(entries, newLogEntry(nil, 1, 2, &joinCommand{Name: "localhost:1000"}))
 	}
 	req := newAppendEntriesRequest(1, "leader", 1, 1, entries, 1)
-	buf, _ := json.Marshal(req)
+	var buf bytes.Buffer
+	req.encode(&buf)
 
-	return req, buf
+	return req, buf.Bytes()
 }
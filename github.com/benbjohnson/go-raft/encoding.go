@@ -0,0 +1,157 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Log entries and RPC messages are encoded in a compact binary form:
+// integers are uvarints, booleans are a single byte and strings, byte
+// slices and lists are prefixed by their length.
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The largest string or byte slice that will be decoded. Anything larger is
+// treated as corruption rather than allocated.
+const maxEncodedSize = 64 * 1024 * 1024
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// An encoder writes fields to an in-memory buffer.
+type encoder struct {
+	bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// A decoder reads fields written by an encoder. The first error is kept and
+// any read after it returns a zero value.
+type decoder struct {
+	r   byteReader
+	err error
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a new decoder reading from r.
+func newDecoder(r io.Reader) *decoder {
+	if br, ok := r.(byteReader); ok {
+		return &decoder{r: br}
+	}
+	return &decoder{r: bufio.NewReader(r)}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Encoding
+//--------------------------------------
+
+func (e *encoder) uint64(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.Write(e.scratch[:n])
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint64(uint64(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uint64(uint64(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint64(uint64(len(s)))
+	for _, v := range s {
+		e.string(v)
+	}
+}
+
+//--------------------------------------
+// Decoding
+//--------------------------------------
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var v uint64
+	v, d.err = binary.ReadUvarint(d.r)
+	return v
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	var c byte
+	c, d.err = d.r.ReadByte()
+	return c == 1
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint64()
+	if d.err != nil {
+		return nil
+	}
+	if n > maxEncodedSize {
+		d.err = fmt.Errorf("raft: Encoded field too large: %d", n)
+		return nil
+	}
+	b := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, b)
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.uint64()
+	if d.err != nil {
+		return nil
+	}
+	if n > maxEncodedSize {
+		d.err = fmt.Errorf("raft: Encoded list too large: %d", n)
+		return nil
+	}
+	s := make([]string, 0)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
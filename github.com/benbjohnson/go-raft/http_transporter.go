@@ -6,11 +6,27 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 )
 
 // Parts from this transporter were heavily influenced by Peter Bougon's
 // raft implementation: https://github.com/peterbourgon/raft
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// Requests and responses are sent in the binary encoding. Requests from
+// servers running an older version are JSON and are answered in JSON. Peers
+// running an older version answer binary requests with a 400 or with JSON,
+// after which they are sent JSON.
+const (
+	binaryContentType = "application/octet-stream"
+	jsonContentType   = "application/json"
+)
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -24,6 +40,17 @@ type HTTPTransporter struct {
 	prefix            string
 	appendEntriesPath string
 	requestVotePath   string
+	legacyPeers       map[string]bool
+	mutex             sync.Mutex
+}
+
+// The requests and responses sent by the transporter.
+type rpcRequest interface {
+	encode(w io.Writer) error
+}
+
+type rpcResponse interface {
+	decode(r io.Reader) error
 }
 
 type HTTPMuxer interface {
@@ -42,6 +69,7 @@ func NewHTTPTransporter(prefix string) *HTTPTransporter {
 		prefix:            prefix,
 		appendEntriesPath: fmt.Sprintf("%s%s", prefix, "/appendEntries"),
 		requestVotePath:   fmt.Sprintf("%s%s", prefix, "/requestVote"),
+		legacyPeers:       make(map[string]bool),
 	}
 }
 
@@ -88,48 +116,77 @@ func (t *HTTPTransporter) Install(server *Server, mux HTTPMuxer) {
 
 // Sends an AppendEntries RPC to a peer.
 func (t *HTTPTransporter) SendAppendEntriesRequest(server *Server, peer *Peer, req *AppendEntriesRequest) *AppendEntriesResponse {
-	var b bytes.Buffer
-	json.NewEncoder(&b).Encode(req)
-
 	url := fmt.Sprintf("http://%s%s", peer.Name(), t.AppendEntriesPath())
-	traceln(server.Name(), "POST", url)
-
-	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: t.DisableKeepAlives}}
-	httpResp, err := client.Post(url, "application/json", &b)
-	if httpResp == nil || err != nil {
-		return nil
-	}
-	defer httpResp.Body.Close()
-
 	resp := &AppendEntriesResponse{}
-	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil && err != io.EOF {
+	if !t.post(server, peer, url, req, resp) {
 		return nil
 	}
-
 	return resp
 }
 
 // Sends a RequestVote RPC to a peer.
 func (t *HTTPTransporter) SendVoteRequest(server *Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse {
+	url := fmt.Sprintf("http://%s%s", peer.Name(), t.RequestVotePath())
+	resp := &RequestVoteResponse{}
+	if !t.post(server, peer, url, req, resp) {
+		return nil
+	}
+	return resp
+}
+
+// Posts a request to a peer and decodes its response. The request is sent
+// in the binary encoding unless the peer is known to run an older version.
+// A peer that doesn't answer in the binary encoding is sent the request
+// again in JSON and is sent JSON from then on.
+func (t *HTTPTransporter) post(server *Server, peer *Peer, url string, req rpcRequest, resp rpcResponse) bool {
+	t.mutex.Lock()
+	legacy := t.legacyPeers[peer.Name()]
+	t.mutex.Unlock()
+
+	if !legacy {
+		var b bytes.Buffer
+		if err := req.encode(&b); err != nil {
+			return false
+		}
+
+		traceln(server.Name(), "POST", url)
+		httpResp, err := t.client().Post(url, binaryContentType, &b)
+		if httpResp == nil || err != nil {
+			return false
+		}
+		defer httpResp.Body.Close()
+
+		if httpResp.StatusCode != http.StatusBadRequest && httpResp.Header.Get("Content-Type") == binaryContentType {
+			return resp.decode(httpResp.Body) == nil
+		}
+
+		traceln(server.Name(), "legacy peer", peer.Name())
+		t.mutex.Lock()
+		t.legacyPeers[peer.Name()] = true
+		t.mutex.Unlock()
+	}
+
 	var b bytes.Buffer
-	json.NewEncoder(&b).Encode(req)
+	if err := json.NewEncoder(&b).Encode(req); err != nil {
+		return false
+	}
 
-	url := fmt.Sprintf("http://%s%s", peer.Name(), t.RequestVotePath())
 	traceln(server.Name(), "POST", url)
-
-	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: t.DisableKeepAlives}}
-	httpResp, err := client.Post(url, "application/json", &b)
+	httpResp, err := t.client().Post(url, jsonContentType, &b)
 	if httpResp == nil || err != nil {
-		return nil
+		return false
 	}
 	defer httpResp.Body.Close()
 
-	resp := &RequestVoteResponse{}
-	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil && err != io.EOF {
-		return nil
+	if httpResp.StatusCode != http.StatusOK {
+		return false
 	}
+	return json.NewDecoder(httpResp.Body).Decode(resp) == nil
+}
 
-	return resp
+// Creates the client requests are sent with.
+func (t *HTTPTransporter) client() *http.Client {
+	return &http.Client{Transport: &http.Transport{DisableKeepAlives: t.DisableKeepAlives}}
 }
 
 // Sends a SnapshotRequest RPC to a peer.
@@ -149,13 +206,27 @@ func (t *HTTPTransporter) appendEntriesHandler(server *Server) http.HandlerFunc
 
 		defer r.Body.Close()
 		req := &AppendEntriesRequest{}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		legacy := r.Header.Get("Content-Type") == jsonContentType
+		var err error
+		if legacy {
+			err = json.NewDecoder(r.Body).Decode(&req)
+		} else {
+			err = req.decode(r.Body)
+		}
+		if err != nil {
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
 
 		resp := server.AppendEntries(req)
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
+		if legacy {
+			w.Header().Set("Content-Type", jsonContentType)
+			err = json.NewEncoder(w).Encode(resp)
+		} else {
+			w.Header().Set("Content-Type", binaryContentType)
+			err = resp.encode(w)
+		}
+		if err != nil {
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -169,13 +240,27 @@ func (t *HTTPTransporter) requestVoteHandler(server *Server) http.HandlerFunc {
 
 		defer r.Body.Close()
 		req := &RequestVoteRequest{}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		legacy := r.Header.Get("Content-Type") == jsonContentType
+		var err error
+		if legacy {
+			err = json.NewDecoder(r.Body).Decode(&req)
+		} else {
+			err = req.decode(r.Body)
+		}
+		if err != nil {
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
 
 		resp := server.RequestVote(req)
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
+		if legacy {
+			w.Header().Set("Content-Type", jsonContentType)
+			err = json.NewEncoder(w).Encode(resp)
+		} else {
+			w.Header().Set("Content-Type", binaryContentType)
+			err = resp.encode(w)
+		}
+		if err != nil {
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -1,9 +1,12 @@
 package raft
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -43,6 +46,55 @@ func TestHTTPTransporter(t *testing.T) {
 	runTestHttpServers(t, &servers, transporter, f0, f1, f2)
 }
 
+// Ensure that a peer running a version that only speaks JSON is sent JSON.
+func TestHTTPTransporterLegacyPeer(t *testing.T) {
+	var mutex sync.Mutex
+	var contentTypes []string
+
+	// Answers like the handlers of older versions, which only decode JSON.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/appendEntries", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		mutex.Unlock()
+		req := &AppendEntriesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&AppendEntriesResponse{Term: req.Term, Index: 2, Success: true, CommitIndex: 1})
+	})
+	mux.HandleFunc("/raft/requestVote", func(w http.ResponseWriter, r *http.Request) {
+		req := &RequestVoteRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&RequestVoteResponse{Term: req.Term, VoteGranted: true})
+	})
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	transporter := NewHTTPTransporter("/raft")
+	server := newTestServer("1", transporter)
+	peer := newPeer(server, strings.TrimPrefix(httpServer.URL, "http://"), testHeartbeatTimeout)
+
+	for i := 0; i < 2; i++ {
+		resp := transporter.SendAppendEntriesRequest(server, peer, newAppendEntriesRequest(3, "1", 1, 1, nil, 1))
+		if resp == nil || resp.Term != 3 || resp.Index != 2 || !resp.Success || resp.CommitIndex != 1 {
+			t.Fatalf("Invalid AppendEntries response: %#v", resp)
+		}
+	}
+	if strings.Join(contentTypes, ",") != "application/octet-stream,application/json,application/json" {
+		t.Fatalf("Unexpected requests: %v", contentTypes)
+	}
+
+	resp := transporter.SendVoteRequest(server, peer, newRequestVoteRequest(4, "1", 1, 1))
+	if resp == nil || resp.Term != 4 || !resp.VoteGranted {
+		t.Fatalf("Invalid RequestVote response: %#v", resp)
+	}
+}
+
 //------------------------------------------------------------------------------
 //
 // Helper Functions
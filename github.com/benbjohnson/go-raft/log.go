@@ -2,13 +2,29 @@ package raft
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The size a log segment grows to before the next one is started.
+const defaultLogSegmentSize = 4 * 1024 * 1024
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -16,9 +32,17 @@ import (
 //------------------------------------------------------------------------------
 
 // A log is a collection of log entries that are persisted to durable storage.
+//
+// The committed entries are written to a directory of segment files, each
+// named after the index of its first entry. Compaction deletes the segments
+// that only hold entries before the compacted index and records that index
+// in a start file so they are skipped if they are still around.
 type Log struct {
 	ApplyFunc   func(Command) (interface{}, error)
-	file        *os.File
+	file        *os.File // the last segment, entries are appended to it
+	fileSize    int64
+	segments    []*logSegment
+	segmentSize int64
 	path        string
 	entries     []*LogEntry
 	results     []*logResult
@@ -28,6 +52,12 @@ type Log struct {
 	startTerm   uint64
 }
 
+// A segment file of the log.
+type logSegment struct {
+	path       string
+	firstIndex uint64
+}
+
 // The results of the applying a log entry.
 type logResult struct {
 	returnValue interface{}
@@ -43,7 +73,8 @@ type logResult struct {
 // Creates a new log.
 func newLog() *Log {
 	return &Log{
-		entries: make([]*LogEntry, 0),
+		entries:     make([]*LogEntry, 0),
+		segmentSize: defaultLogSegmentSize,
 	}
 }
 
@@ -132,64 +163,234 @@ func (l *Log) currentTerm() uint64 {
 // State
 //--------------------------------------
 
-// Opens the log file and reads existing entries. The log can remain open and
-// continue to append entries to the end of the log.
+// Opens the log directory and reads existing entries. The log can remain open
+// and continue to append entries to the end of the log. A log written by an
+// older version as a single file of JSON entries is migrated first.
 func (l *Log) open(path string) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	// Read all the entries from the log if one exists.
-	var lastIndex int = 0
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		// Open the log file.
-		file, err := os.Open(path)
+	l.path = path
+	if err := l.migrate(); err != nil {
+		return fmt.Errorf("raft.Log: Unable to migrate: %v", err)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+
+	// Entries up to the start index have been compacted away.
+	if err := l.readStart(); err != nil {
+		return err
+	}
+	if l.commitIndex < l.startIndex {
+		l.commitIndex = l.startIndex
+	}
+
+	// The fixed width names of the segments sort in index order.
+	names, err := filepath.Glob(filepath.Join(path, "*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	l.segments = make([]*logSegment, 0, len(names))
+	for i, name := range names {
+		firstIndex, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".log"), 16, 64)
 		if err != nil {
+			return fmt.Errorf("raft.Log: Invalid segment name: %s", name)
+		}
+		if err := l.readSegment(name, i == len(names)-1); err != nil {
 			return err
 		}
-		defer file.Close()
-		reader := bufio.NewReader(file)
+		l.segments = append(l.segments, &logSegment{path: name, firstIndex: firstIndex})
+	}
 
-		// Read the file and decode entries.
-		for {
-			if _, err := reader.Peek(1); err == io.EOF {
-				break
-			}
+	// Continue appending to the last segment or start the first one.
+	if len(l.segments) == 0 {
+		return l.createSegment(l.commitIndex + 1)
+	}
+	last := l.segments[len(l.segments)-1]
+	if l.file, err = os.OpenFile(last.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	l.fileSize = info.Size()
+	return nil
+}
 
-			// Instantiate log entry and decode into it.
-			entry := newLogEntry(l, 0, 0, nil)
-			n, err := entry.decode(reader)
-			if err != nil {
-				file.Close()
-				if err = os.Truncate(path, int64(lastIndex)); err != nil {
-					return fmt.Errorf("raft.Log: Unable to recover: %v", err)
-				}
-				break
-			}
+// Reads and applies the entries of a segment. A partially written entry at
+// the end of the last segment is truncated, anywhere else it is an error.
+func (l *Log) readSegment(path string, last bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
 
-			// Append entry.
-			l.entries = append(l.entries, entry)
-			l.commitIndex = entry.Index
+	var pos int64
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			break
+		}
 
-			// Apply the command.
-			returnValue, err := l.ApplyFunc(entry.Command)
-			l.results = append(l.results, &logResult{returnValue: returnValue, err: err})
+		// Instantiate log entry and decode into it.
+		entry := newLogEntry(l, 0, 0, nil)
+		n, err := entry.decode(reader)
+		if err != nil {
+			if !last {
+				return fmt.Errorf("raft.Log: Corrupt segment %s: %v", path, err)
+			}
+			file.Close()
+			if err = os.Truncate(path, pos); err != nil {
+				return fmt.Errorf("raft.Log: Unable to recover: %v", err)
+			}
+			break
+		}
+		pos += int64(n)
 
-			lastIndex += n
+		// Skip compacted entries and entries that were already read.
+		if entry.Index <= l.commitIndex {
+			continue
 		}
 
-		file.Close()
+		// Append entry.
+		l.entries = append(l.entries, entry)
+		l.commitIndex = entry.Index
+
+		// Apply the command.
+		returnValue, err := l.ApplyFunc(entry.Command)
+		l.results = append(l.results, &logResult{returnValue: returnValue, err: err})
 	}
 
-	// Open the file for appending.
-	var err error
-	l.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return nil
+}
+
+// Starts a new segment for the entries from the given index on and appends
+// to it from now on.
+func (l *Log) createSegment(firstIndex uint64) error {
+	path := filepath.Join(l.path, fmt.Sprintf("%016x.log", firstIndex))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
-	l.path = path
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.fileSize = 0
+	l.segments = append(l.segments, &logSegment{path: path, firstIndex: firstIndex})
+	return nil
+}
+
+// Reads the index and term the log starts after.
+func (l *Log) readStart() error {
+	b, err := ioutil.ReadFile(filepath.Join(l.path, "start"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(b) != 16 {
+		return fmt.Errorf("raft.Log: Invalid start file: %d bytes", len(b))
+	}
+	l.startIndex = binary.BigEndian.Uint64(b[0:8])
+	l.startTerm = binary.BigEndian.Uint64(b[8:16])
 	return nil
 }
 
+// Records the index and term the log starts after.
+func (l *Log) writeStart(index uint64, term uint64) error {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], index)
+	binary.BigEndian.PutUint64(b[8:16], term)
+
+	path := filepath.Join(l.path, "start")
+	file, err := os.OpenFile(path+".new", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b[:]); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// Converts a log written by an older version, a single file of JSON entries
+// at the log path, into a segment. The old file is moved aside first and only
+// removed once the segment is written so an interrupted migration starts over
+// on the next open.
+func (l *Log) migrate() error {
+	oldPath := l.path + ".json"
+	if info, err := os.Stat(l.path); err == nil && !info.IsDir() {
+		if err := os.Rename(l.path, oldPath); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Throw away what an interrupted migration left behind.
+	if err := os.RemoveAll(l.path); err != nil {
+		return err
+	}
+	if err := os.Mkdir(l.path, 0700); err != nil {
+		return err
+	}
+
+	file, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	// A partially written entry at the end of the old log is dropped.
+	var b bytes.Buffer
+	var firstIndex uint64
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			break
+		}
+		entry := newLogEntry(l, 0, 0, nil)
+		if _, err := entry.decodeJSON(reader); err != nil {
+			break
+		}
+		if firstIndex == 0 {
+			firstIndex = entry.Index
+		}
+		if err := entry.encode(&b); err != nil {
+			return err
+		}
+	}
+
+	if firstIndex > 0 {
+		path := filepath.Join(l.path, fmt.Sprintf("%016x.log", firstIndex))
+		segment, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = segment.Write(b.Bytes()); err == nil {
+			err = segment.Sync()
+		}
+		segment.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	file.Close()
+	return os.Remove(oldPath)
+}
+
 // Closes the log file.
 func (l *Log) close() {
 	l.mutex.Lock()
@@ -199,6 +400,7 @@ func (l *Log) close() {
 		l.file.Close()
 		l.file = nil
 	}
+	l.segments = nil
 	l.entries = make([]*LogEntry, 0)
 	l.results = make([]*logResult, 0)
 }
@@ -221,7 +423,7 @@ func (l *Log) getEntry(index uint64) *LogEntry {
 	if index <= l.startIndex || index > (l.startIndex+uint64(len(l.entries))) {
 		return nil
 	}
-	return l.entries[index-1]
+	return l.entries[index-1-l.startIndex]
 }
 
 // Checks if the log contains a given index/term combination.
@@ -230,6 +432,21 @@ func (l *Log) containsEntry(index uint64, term uint64) bool {
 	return (entry != nil && entry.Term == term)
 }
 
+// Checks if the log has an entry with the given index and term. The index
+// before the first entry of the log matches its start term.
+func (l *Log) matchEntry(index uint64, term uint64) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if index == l.startIndex {
+		return index == 0 || term == l.startTerm
+	}
+	if index < l.startIndex || index > l.startIndex+uint64(len(l.entries)) {
+		return false
+	}
+	return l.entries[index-1-l.startIndex].Term == term
+}
+
 // Retrieves a list of entries after a given index as well as the term of the
 // index provided. A nil list of entries is returned if the index no longer
 // exists because a snapshot was made.
@@ -271,8 +488,8 @@ func (l *Log) getEntryResult(entry *LogEntry, clear bool) (interface{}, error) {
 	}
 
 	// If a result exists for the entry then return it with its error.
-	if entry.Index > 0 && entry.Index <= uint64(len(l.results)) {
-		if result := l.results[entry.Index-1]; result != nil {
+	if entry.Index > l.startIndex && entry.Index <= l.startIndex+uint64(len(l.results)) {
+		if result := l.results[entry.Index-1-l.startIndex]; result != nil {
 
 			// keep the records before remove it
 			returnValue, err := result.returnValue, result.err
@@ -363,15 +580,27 @@ func (l *Log) setCommitIndex(index uint64) error {
 		return nil
 	}
 
-	// Find all entries whose index is between the previous index and the current index.
+	// Write all the entries between the previous index and the current index
+	// to storage at once so a batch of commands costs a single sync.
+	var b bytes.Buffer
 	for i := l.commitIndex + 1; i <= index; i++ {
-		entryIndex := i - 1 - l.startIndex
-		entry := l.entries[entryIndex]
-
-		// Write to storage.
-		if err := entry.encode(l.file); err != nil {
+		if err := l.entries[i-1-l.startIndex].encode(&b); err != nil {
 			return err
 		}
+	}
+	if b.Len() > 0 {
+		if _, err := l.file.Write(b.Bytes()); err != nil {
+			return err
+		}
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+		l.fileSize += int64(b.Len())
+	}
+
+	for i := l.commitIndex + 1; i <= index; i++ {
+		entryIndex := i - 1 - l.startIndex
+		entry := l.entries[entryIndex]
 
 		// Update commit index.
 		l.commitIndex = entry.Index
@@ -380,6 +609,13 @@ func (l *Log) setCommitIndex(index uint64) error {
 		returnValue, err := l.ApplyFunc(entry.Command)
 		l.results[entryIndex] = &logResult{returnValue: returnValue, err: err}
 	}
+
+	// Start a new segment once the current one is full.
+	if l.fileSize >= l.segmentSize {
+		if err := l.createSegment(l.commitIndex + 1); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -409,6 +645,7 @@ func (l *Log) truncate(index uint64, term uint64) error {
 	// If we're truncating everything then just clear the entries.
 	if index == l.startIndex {
 		l.entries = []*LogEntry{}
+		l.results = []*logResult{}
 	} else {
 		// Do not truncate if the entry at index does not have the matching term.
 		entry := l.entries[index-l.startIndex-1]
@@ -421,6 +658,7 @@ func (l *Log) truncate(index uint64, term uint64) error {
 		if index < l.startIndex+uint64(len(l.entries)) {
 			debugln("log.truncate.finish")
 			l.entries = l.entries[0 : index-l.startIndex]
+			l.results = l.results[0 : index-l.startIndex]
 		}
 	}
 
@@ -478,51 +716,45 @@ func (l *Log) appendEntry(entry *LogEntry) error {
 
 // compaction the log before index
 func (l *Log) compact(index uint64, term uint64) error {
-	var entries []*LogEntry
-
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	// nothing to compaction
-	// the index may be greater than the current index if
-	// we just recovery from on snapshot
-	if index >= l.internalCurrentIndex() {
-		entries = make([]*LogEntry, 0)
-	} else {
-
-		// get all log entries after index
-		entries = l.entries[index-l.startIndex:]
-	}
-
-	// create a new log file and add all the entries
-	file, err := os.OpenFile(l.path+".new", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
+	// Record the new start first so that the compacted entries are skipped
+	// on open even if their segments are not deleted yet.
+	if err := l.writeStart(index, term); err != nil {
 		return err
 	}
-	for _, entry := range entries {
-		err = entry.encode(file)
-		if err != nil {
+
+	// If everything written so far is compacted then continue in a new
+	// segment so that the current one can be deleted too. The index may be
+	// greater than the current index if we just recovered from a snapshot.
+	current := l.segments[len(l.segments)-1]
+	if current.firstIndex <= index && index >= l.commitIndex {
+		if err := l.createSegment(index + 1); err != nil {
 			return err
 		}
 	}
-	// close the current log file
-	l.file.Close()
-
-	// remove the current log file to .bak
-	err = os.Remove(l.path)
-	if err != nil {
-		return err
-	}
 
-	// rename the new log file
-	err = os.Rename(l.path+".new", l.path)
-	if err != nil {
-		return err
+	// Delete the segments that only hold entries up to the index. A segment
+	// ends where the next one starts.
+	segments := l.segments
+	for len(segments) > 1 && segments[1].firstIndex-1 <= index {
+		if err := os.Remove(segments[0].path); err != nil {
+			return err
+		}
+		segments = segments[1:]
 	}
-	l.file = file
+	l.segments = segments
 
 	// compaction the in memory log
-	l.entries = entries
+	if index >= l.internalCurrentIndex() {
+		l.entries = make([]*LogEntry, 0)
+		l.results = make([]*logResult, 0)
+	} else {
+		// get all log entries after index
+		l.entries = l.entries[index-l.startIndex:]
+		l.results = l.results[index-l.startIndex:]
+	}
 	l.startIndex = index
 	l.startTerm = term
 	return nil
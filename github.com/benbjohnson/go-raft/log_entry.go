@@ -3,6 +3,7 @@ package raft
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,15 @@ import (
 	"io"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// The size of the checksum and size that precede an encoded entry.
+const logEntryHeaderSize = 8
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -60,33 +70,95 @@ func newLogEntry(log *Log, index uint64, term uint64, command Command) *LogEntry
 // Encoding
 //--------------------------------------
 
-// Encodes the log entry to a buffer.
+// Encodes the log entry to a buffer. An entry is written as a checksum and
+// the size of the rest of the entry followed by the index, the term, the
+// command name and the command.
 func (e *LogEntry) encode(w io.Writer) error {
 	if w == nil {
 		return errors.New("raft.LogEntry: Writer required to encode")
 	}
 
-	encodedCommand, err := json.Marshal(e.Command)
-	if err != nil {
-		return err
+	var commandName string
+	var encodedCommand []byte
+	if e.Command != nil {
+		var err error
+		if encodedCommand, err = json.Marshal(e.Command); err != nil {
+			return err
+		}
+		commandName = e.Command.CommandName()
 	}
 
-	// Write log line to temporary buffer.
-	var b bytes.Buffer
-	if _, err = fmt.Fprintf(&b, "%016x %016x %s %s\n", e.Index, e.Term, e.Command.CommandName(), encodedCommand); err != nil {
-		return err
-	}
+	var body encoder
+	body.uint64(e.Index)
+	body.uint64(e.Term)
+	body.string(commandName)
+	body.bytes(encodedCommand)
 
-	// Generate checksum.
-	checksum := crc32.ChecksumIEEE(b.Bytes())
+	var header [logEntryHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], crc32.ChecksumIEEE(body.Bytes()))
+	binary.BigEndian.PutUint32(header[4:8], uint32(body.Len()))
 
-	// Write log entry with checksum.
-	_, err = fmt.Fprintf(w, "%08x %s", checksum, b.String())
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
 	return err
 }
 
 // Decodes the log entry from a buffer. Returns the number of bytes read.
-func (e *LogEntry) decode(r io.Reader) (pos int, err error) {
+func (e *LogEntry) decode(r io.Reader) (int, error) {
+	if r == nil {
+		return 0, errors.New("raft.LogEntry: Reader required to decode")
+	}
+
+	// Read the checksum and the size.
+	var header [logEntryHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, fmt.Errorf("raft.LogEntry: Unable to read header: %v", err)
+	}
+	checksum := binary.BigEndian.Uint32(header[0:4])
+	size := binary.BigEndian.Uint32(header[4:8])
+	if size > maxEncodedSize {
+		return 0, fmt.Errorf("raft.LogEntry: Invalid size: %d", size)
+	}
+
+	// Read the rest of the entry and verify the checksum.
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, fmt.Errorf("raft.LogEntry: Unable to read entry: %v", err)
+	}
+	if bchecksum := crc32.ChecksumIEEE(b); checksum != bchecksum {
+		return 0, fmt.Errorf("raft.LogEntry: Invalid checksum: Expected %08x, calculated %08x", checksum, bchecksum)
+	}
+
+	d := newDecoder(bytes.NewReader(b))
+	e.Index = d.uint64()
+	e.Term = d.uint64()
+	commandName := d.string()
+	encodedCommand := d.bytes()
+	if d.err != nil {
+		return 0, fmt.Errorf("raft.LogEntry: Unable to decode: %v", d.err)
+	}
+
+	e.Command = nil
+	if commandName != "" {
+		command, err := newCommand(commandName)
+		if err != nil {
+			return 0, fmt.Errorf("raft.LogEntry: Unable to instantiate command (%s): %v", commandName, err)
+		}
+		if err = json.Unmarshal(encodedCommand, command); err != nil {
+			return 0, fmt.Errorf("raft.LogEntry: Unable to decode: %v", err)
+		}
+		e.Command = command
+	}
+
+	return logEntryHeaderSize + int(size), nil
+}
+
+// Decodes the log entry from a line of a log written by an older version:
+// a checksum followed by the index, term, command name and JSON command.
+// It is only used to migrate those logs. Returns the number of bytes read.
+func (e *LogEntry) decodeJSON(r io.Reader) (pos int, err error) {
 	pos = 0
 
 	if r == nil {
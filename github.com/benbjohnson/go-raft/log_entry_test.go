@@ -1,8 +1,10 @@
 package raft
 
 import (
+	"bytes"
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +37,32 @@ func TestLogEntryUnmarshal(t *testing.T) {
 		t.Fatalf("Log entry unmarshaled incorrectly: %v | %v", e, newLogEntry(nil, 1, 2, &joinCommand{Name: "localhost:1000"}))
 	}
 }
+
+// Ensure that a log entry can be encoded and decoded.
+func TestLogEntryEncodeDecode(t *testing.T) {
+	var b bytes.Buffer
+	if err := newLogEntry(nil, 1, 2, &joinCommand{Name: "localhost:1000"}).encode(&b); err != nil {
+		t.Fatalf("Log entry encoding error: %v", err)
+	}
+	size := b.Len()
+
+	e := &LogEntry{}
+	if n, err := e.decode(&b); err != nil || n != size {
+		t.Fatalf("Log entry decoding error: %v (%d of %d bytes)", err, n, size)
+	}
+	if !(e.Index == 1 && e.Term == 2 && reflect.DeepEqual(e.Command, &joinCommand{Name: "localhost:1000"})) {
+		t.Fatalf("Log entry decoded incorrectly: %v", e)
+	}
+}
+
+// Ensure that a log entry with a bad checksum is not decoded.
+func TestLogEntryDecodeInvalidChecksum(t *testing.T) {
+	var b bytes.Buffer
+	newLogEntry(nil, 1, 2, &joinCommand{Name: "localhost:1000"}).encode(&b)
+	buf := b.Bytes()
+	buf[len(buf)-2]++
+
+	if _, err := (&LogEntry{}).decode(bytes.NewReader(buf)); err == nil || !strings.Contains(err.Error(), "Invalid checksum") {
+		t.Fatalf("Expected checksum error: %v", err)
+	}
+}
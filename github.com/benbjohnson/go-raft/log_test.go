@@ -1,8 +1,9 @@
 package raft
 
 import (
-	"io/ioutil"
+	"bytes"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -28,7 +29,7 @@ func TestLogNewLog(t *testing.T) {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	if err := log.appendEntry(newLogEntry(log, 1, 1, &testCommand1{"foo", 20})); err != nil {
 		t.Fatalf("Unable to append: %v", err)
@@ -44,11 +45,8 @@ func TestLogNewLog(t *testing.T) {
 	if err := log.setCommitIndex(2); err != nil {
 		t.Fatalf("Unable to partially commit: %v", err)
 	}
-	expected := `cf4aab23 0000000000000001 0000000000000001 cmd_1 {"val":"foo","i":20}` + "\n" +
-		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n"
-	actual, _ := ioutil.ReadFile(path)
-	if string(actual) != expected {
-		t.Fatalf("Unexpected buffer:\nexp:\n%s\ngot:\n%s", expected, string(actual))
+	if entries := reopenLog(path).entries; len(entries) != 2 || !reflect.DeepEqual(entries[1].Command, &testCommand2{100}) {
+		t.Fatalf("Unexpected entries written: %v", entries)
 	}
 	if index, term := log.commitInfo(); index != 2 || term != 1 {
 		t.Fatalf("Invalid commit info [IDX=%v, TERM=%v]", index, term)
@@ -58,12 +56,8 @@ func TestLogNewLog(t *testing.T) {
 	if err := log.setCommitIndex(3); err != nil {
 		t.Fatalf("Unable to commit: %v", err)
 	}
-	expected = `cf4aab23 0000000000000001 0000000000000001 cmd_1 {"val":"foo","i":20}` + "\n" +
-		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n" +
-		`6ac5807c 0000000000000003 0000000000000002 cmd_1 {"val":"bar","i":0}` + "\n"
-	actual, _ = ioutil.ReadFile(path)
-	if string(actual) != expected {
-		t.Fatalf("Unexpected buffer:\nexp:\n%s\ngot:\n%s", expected, string(actual))
+	if entries := reopenLog(path).entries; len(entries) != 3 || entries[2].Term != 2 || !reflect.DeepEqual(entries[2].Command, &testCommand1{"bar", 0}) {
+		t.Fatalf("Unexpected entries written: %v", entries)
 	}
 	if index, term := log.commitInfo(); index != 3 || term != 2 {
 		t.Fatalf("Invalid commit info [IDX=%v, TERM=%v]", index, term)
@@ -76,7 +70,7 @@ func TestLogExistingLog(t *testing.T) {
 		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n" +
 		`6ac5807c 0000000000000003 0000000000000002 cmd_1 {"val":"bar","i":0}` + "\n")
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	// Validate existing log entries.
 	if len(log.entries) != 3 {
@@ -99,7 +93,7 @@ func TestLogContainsEntries(t *testing.T) {
 		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n" +
 		`6ac5807c 0000000000000003 0000000000000002 cmd_1 {"val":"bar","i":0}` + "\n")
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	if log.containsEntry(0, 0) {
 		t.Fatalf("Zero-index entry should not exist in log.")
@@ -131,7 +125,7 @@ func TestLogRecovery(t *testing.T) {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	if err := log.appendEntry(newLogEntry(log, 3, 2, &testCommand1{"bat", -5})); err != nil {
 		t.Fatalf("Unable to append: %v", err)
@@ -152,23 +146,142 @@ func TestLogRecovery(t *testing.T) {
 	}
 
 	// Validate precommit log contents.
-	expected := `cf4aab23 0000000000000001 0000000000000001 cmd_1 {"val":"foo","i":20}` + "\n" +
-		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n"
-	actual, _ := ioutil.ReadFile(path)
-	if string(actual) != expected {
-		t.Fatalf("Unexpected buffer:\nexp:\n%s\ngot:\n%s", expected, string(actual))
+	if entries := reopenLog(path).entries; len(entries) != 2 {
+		t.Fatalf("Expected 2 entries written, got %d", len(entries))
 	}
 
 	// Validate committed log contents.
 	if err := log.setCommitIndex(3); err != nil {
 		t.Fatalf("Unable to partially commit: %v", err)
 	}
-	expected = `cf4aab23 0000000000000001 0000000000000001 cmd_1 {"val":"foo","i":20}` + "\n" +
-		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n" +
-		`3f3f884c 0000000000000003 0000000000000002 cmd_1 {"val":"bat","i":-5}` + "\n"
-	actual, _ = ioutil.ReadFile(path)
-	if string(actual) != expected {
-		t.Fatalf("Unexpected buffer:\nexp:\n%s\ngot:\n%s", expected, string(actual))
+	entries := reopenLog(path).entries
+	if len(entries) != 3 || entries[2].Index != 3 || !reflect.DeepEqual(entries[2].Command, &testCommand1{"bat", -5}) {
+		t.Fatalf("Unexpected entries written: %v", entries)
+	}
+}
+
+// Ensure that a partially written entry at the end of a segment is truncated
+// and that the log continues after it.
+func TestLogSegmentRecovery(t *testing.T) {
+	log, path := setupLog("")
+	defer os.RemoveAll(path)
+	for i := uint64(1); i <= 2; i++ {
+		log.appendEntry(newLogEntry(log, i, 1, &testCommand2{int(i)}))
+	}
+	if err := log.setCommitIndex(2); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+	segmentPath := log.segments[0].path
+	log.close()
+
+	// Write half of an entry.
+	var b bytes.Buffer
+	newLogEntry(nil, 3, 1, &testCommand2{3}).encode(&b)
+	f, _ := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write(b.Bytes()[:b.Len()/2])
+	f.Close()
+
+	log = reopenLog(path)
+	defer log.close()
+	if len(log.entries) != 2 || log.CommitIndex() != 2 {
+		t.Fatalf("Unexpected entries after recovery: %v", log.entries)
+	}
+	log.appendEntry(newLogEntry(log, 3, 2, &testCommand2{4}))
+	if err := log.setCommitIndex(3); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+	entries := reopenLog(path).entries
+	if len(entries) != 3 || entries[2].Term != 2 || !reflect.DeepEqual(entries[2].Command, &testCommand2{4}) {
+		t.Fatalf("Unexpected entries written: %v", entries)
+	}
+}
+
+// Ensure that a log written as a single file of JSON entries is migrated.
+func TestLogMigration(t *testing.T) {
+	log, path := setupLog(`cf4aab23 0000000000000001 0000000000000001 cmd_1 {"val":"foo","i":20}` + "\n" +
+		`4c08d91f 0000000000000002 0000000000000001 cmd_2 {"x":100}` + "\n")
+	defer os.RemoveAll(path)
+	log.close()
+
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Fatalf("Expected log directory: %v", err)
+	}
+	if _, err := os.Stat(path + ".json"); !os.IsNotExist(err) {
+		t.Fatalf("Expected old log to be removed: %v", err)
+	}
+	entries := reopenLog(path).entries
+	if len(entries) != 2 || !reflect.DeepEqual(entries[0].Command, &testCommand1{"foo", 20}) || !reflect.DeepEqual(entries[1].Command, &testCommand2{100}) {
+		t.Fatalf("Unexpected entries after migration: %v", entries)
+	}
+}
+
+//--------------------------------------
+// Segments
+//--------------------------------------
+
+// Ensure that a new segment is started when the current one is full and
+// that compaction deletes the segments before the compacted index.
+func TestLogSegments(t *testing.T) {
+	log, path := setupLog("")
+	defer os.RemoveAll(path)
+	log.segmentSize = 1
+
+	for i := uint64(1); i <= 5; i++ {
+		log.appendEntry(newLogEntry(log, i, 1, &testCommand2{int(i)}))
+		if err := log.setCommitIndex(i); err != nil {
+			t.Fatalf("Unable to commit: %v", err)
+		}
+	}
+	if names, _ := filepath.Glob(filepath.Join(path, "*.log")); len(names) != 6 {
+		t.Fatalf("Expected 6 segments, got %v", names)
+	}
+
+	if err := log.compact(3, 1); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+	names, _ := filepath.Glob(filepath.Join(path, "*.log"))
+	if len(names) != 3 || filepath.Base(names[0]) != "0000000000000004.log" {
+		t.Fatalf("Unexpected segments after compaction: %v", names)
+	}
+	log.close()
+
+	log = reopenLog(path)
+	defer log.close()
+	if log.startIndex != 3 || log.startTerm != 1 || log.CommitIndex() != 5 || len(log.entries) != 2 || log.entries[0].Index != 4 {
+		t.Fatalf("Unexpected log after compaction [START=%v/%v, COMMIT=%v]: %v", log.startIndex, log.startTerm, log.CommitIndex(), log.entries)
+	}
+	if !log.containsEntry(5, 1) || log.containsEntry(3, 1) {
+		t.Fatalf("Unexpected entries after compaction: %v", log.entries)
+	}
+}
+
+// Ensure that the log can be compacted past its end, as after recovering
+// from a snapshot, and continues after the compacted index.
+func TestLogCompactPastEnd(t *testing.T) {
+	log, path := setupLog("")
+	defer os.RemoveAll(path)
+	log.appendEntry(newLogEntry(log, 1, 1, &testCommand2{1}))
+	if err := log.setCommitIndex(1); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	log.updateCommitIndex(10)
+	if err := log.compact(10, 3); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(path, "*.log")); len(names) != 1 || filepath.Base(names[0]) != "000000000000000b.log" {
+		t.Fatalf("Unexpected segments after compaction: %v", names)
+	}
+	log.appendEntry(newLogEntry(log, 11, 3, &testCommand2{11}))
+	if err := log.setCommitIndex(11); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+	log.close()
+
+	log = reopenLog(path)
+	defer log.close()
+	if log.startIndex != 10 || len(log.entries) != 1 || log.entries[0].Index != 11 {
+		t.Fatalf("Unexpected log after compaction [START=%v]: %v", log.startIndex, log.entries)
 	}
 }
 
@@ -183,7 +296,7 @@ func TestLogTruncate(t *testing.T) {
 		t.Fatalf("Unable to open log: %v", err)
 	}
 	defer log.close()
-	defer os.Remove(path)
+	defer os.RemoveAll(path)
 
 	entry1 := newLogEntry(log, 1, 1, &testCommand1{"foo", 20})
 	if err := log.appendEntry(entry1); err != nil {
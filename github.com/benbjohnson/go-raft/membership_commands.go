@@ -0,0 +1,80 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Adds a peer to every server in the cluster. A learner only starts counting
+// toward the quorum once the leader has promoted it.
+type AddPeerCommand struct {
+	Name    string `json:"name"`
+	Learner bool   `json:"learner"`
+}
+
+// Promotes a learner to a voting member on every server in the cluster. The
+// leader issues it once the learner has caught up.
+type PromotePeerCommand struct {
+	Name string `json:"name"`
+}
+
+// Hands leadership over to a peer. When the peer commits it in the term it
+// was issued in, it starts an election without waiting for a timeout.
+type TransferLeadershipCommand struct {
+	Name string `json:"name"`
+	Term uint64 `json:"term"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Add peer
+//--------------------------------------
+
+// The name of the add peer command in the log
+func (c *AddPeerCommand) CommandName() string {
+	return "raft:addPeer"
+}
+
+// Adds the peer
+func (c *AddPeerCommand) Apply(server *Server) (interface{}, error) {
+	return nil, server.AddPeer(c.Name, c.Learner)
+}
+
+//--------------------------------------
+// Promote peer
+//--------------------------------------
+
+// The name of the promote peer command in the log
+func (c *PromotePeerCommand) CommandName() string {
+	return "raft:promotePeer"
+}
+
+// Promotes the peer
+func (c *PromotePeerCommand) Apply(server *Server) (interface{}, error) {
+	return nil, server.promotePeer(c.Name)
+}
+
+//--------------------------------------
+// Transfer leadership
+//--------------------------------------
+
+// The name of the transfer leadership command in the log
+func (c *TransferLeadershipCommand) CommandName() string {
+	return "raft:transferLeadership"
+}
+
+// Starts an election on the new leader. Commands replayed from the log or
+// committed in a later term are ignored.
+func (c *TransferLeadershipCommand) Apply(server *Server) (interface{}, error) {
+	if server.Name() == c.Name && server.State() == Follower && server.Term() == c.Term {
+		server.debugln("server.transfer.elect")
+		server.setState(Candidate)
+	}
+	return nil, nil
+}
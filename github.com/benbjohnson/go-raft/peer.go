@@ -12,12 +12,27 @@ import (
 //------------------------------------------------------------------------------
 
 // A peer is a reference to another server involved in the consensus protocol.
+//
+// The leader pipelines AppendEntries requests to the peer: up to the server's
+// MaxInflightAppendEntries() requests can be in flight at once and each one
+// carries at most MaxAppendEntriesBatchSize() entries. After a request fails
+// the peer falls back to one request at a time until one succeeds again.
+//
+// A learner peer receives the log but does not vote and does not count
+// toward the quorum. The leader promotes it once it has caught up.
 type Peer struct {
 	server           *Server
 	name             string
+	learner          bool
+	promoting        bool
 	prevLogIndex     uint64
+	nextIndex        uint64
+	lastAck          time.Time // when the last request the peer answered was sent
+	inflight         int
+	probing          bool
 	mutex            sync.RWMutex
 	stopChan         chan bool
+	flushChan        chan bool
 	heartbeatTimeout time.Duration
 }
 
@@ -32,6 +47,8 @@ func newPeer(server *Server, name string, heartbeatTimeout time.Duration) *Peer
 	return &Peer{
 		server:           server,
 		name:             name,
+		nextIndex:        1,
+		flushChan:        make(chan bool, 1),
 		heartbeatTimeout: heartbeatTimeout,
 	}
 }
@@ -47,6 +64,39 @@ func (p *Peer) Name() string {
 	return p.name
 }
 
+// Checks if the peer is a learner.
+func (p *Peer) Learner() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.learner
+}
+
+// Sets whether the peer is a learner.
+func (p *Peer) setLearner(learner bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.learner = learner
+}
+
+// Marks the peer as being promoted. Returns false if a promotion is already
+// under way.
+func (p *Peer) startPromoting() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.promoting {
+		return false
+	}
+	p.promoting = true
+	return true
+}
+
+// Sets whether the peer is being promoted.
+func (p *Peer) setPromoting(promoting bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.promoting = promoting
+}
+
 // Sets the heartbeat timeout.
 func (p *Peer) setHeartbeatTimeout(duration time.Duration) {
 	p.heartbeatTimeout = duration
@@ -63,11 +113,36 @@ func (p *Peer) getPrevLogIndex() uint64 {
 	return p.prevLogIndex
 }
 
-// Sets the previous log index.
+// Sets the previous log index. The next request will send the entries
+// after it.
 func (p *Peer) setPrevLogIndex(value uint64) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	p.prevLogIndex = value
+	p.nextIndex = value + 1
+	p.probing = false
+}
+
+//--------------------------------------
+// Last acknowledgment
+//--------------------------------------
+
+// Retrieves when the last request the peer answered in the leader's term
+// was sent.
+func (p *Peer) getLastAck() time.Time {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.lastAck
+}
+
+// Records that the peer answered a request sent at the given time. The time
+// only moves forward unless it is reset to zero.
+func (p *Peer) setLastAck(t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if t.IsZero() || t.After(p.lastAck) {
+		p.lastAck = t
+	}
 }
 
 //------------------------------------------------------------------------------
@@ -114,6 +189,7 @@ func (p *Peer) clone() *Peer {
 	defer p.mutex.Unlock()
 	return &Peer{
 		name:         p.name,
+		learner:      p.learner,
 		prevLogIndex: p.prevLogIndex,
 	}
 }
@@ -122,7 +198,17 @@ func (p *Peer) clone() *Peer {
 // Heartbeat
 //--------------------------------------
 
-// Listens to the heartbeat timeout and flushes an AppendEntries RPC.
+// Wakes up the heartbeat to send the new entries without waiting for
+// the heartbeat timeout.
+func (p *Peer) flush() {
+	select {
+	case p.flushChan <- true:
+	default:
+	}
+}
+
+// Listens to the heartbeat timeout and flushes AppendEntries RPCs. New
+// entries and responses also trigger a flush so the pipeline stays full.
 func (p *Peer) heartbeat(c chan bool) {
 	stopChan := p.stopChan
 
@@ -130,26 +216,31 @@ func (p *Peer) heartbeat(c chan bool) {
 
 	debugln("peer.heartbeat: ", p.Name(), p.heartbeatTimeout)
 
+	timer := time.NewTimer(p.heartbeatTimeout)
+	defer timer.Stop()
+
 	for {
+		heartbeat := false
+
 		select {
 		case <-stopChan:
 			debugln("peer.heartbeat.stop: ", p.Name())
 			return
 
-		case <-time.After(p.heartbeatTimeout):
+		case <-p.flushChan:
+			traceln("peer.heartbeat.flush: ", p.Name())
+
+		case <-timer.C:
 			debugln("peer.heartbeat.run: ", p.Name())
-			prevLogIndex := p.getPrevLogIndex()
-			entries, prevLogTerm := p.server.log.getEntriesAfter(prevLogIndex)
+			heartbeat = true
+		}
 
-			if p.server.State() != Leader {
-				return
-			}
+		if p.server.State() != Leader {
+			return
+		}
 
-			if entries != nil {
-				p.sendAppendEntriesRequest(newAppendEntriesRequest(p.server.currentTerm, p.server.name, prevLogIndex, prevLogTerm, entries, p.server.log.CommitIndex()))
-			} else {
-				p.sendSnapshotRequest(newSnapshotRequest(p.server.name, p.server.lastSnapshot))
-			}
+		if p.sendAppendEntriesRequests(heartbeat) || heartbeat {
+			timer.Reset(p.heartbeatTimeout)
 		}
 	}
 }
@@ -158,22 +249,110 @@ func (p *Peer) heartbeat(c chan bool) {
 // Append Entries
 //--------------------------------------
 
+// Retrieves the number of requests that can be in flight given the server's
+// MaxInflightAppendEntries(). The peer's lock should be held, which is why
+// the server's setting is read by the caller: the server's lock must never
+// be taken while holding a peer's lock.
+func (p *Peer) window(max int) int {
+	if p.probing || max <= 0 {
+		return 1
+	}
+	return max
+}
+
+// Sends AppendEntries requests for the entries that have not been sent yet
+// until the window is full. An empty request is sent as a heartbeat if there
+// is nothing else to send. Returns true if any request was sent.
+func (p *Peer) sendAppendEntriesRequests(heartbeat bool) bool {
+	sent := false
+	maxInflight := p.server.MaxInflightAppendEntries()
+
+	for {
+		p.mutex.Lock()
+		if p.inflight >= p.window(maxInflight) {
+			p.mutex.Unlock()
+			return sent
+		}
+		prevLogIndex := p.nextIndex - 1
+		p.mutex.Unlock()
+
+		entries, prevLogTerm := p.server.log.getEntriesAfter(prevLogIndex)
+
+		// The entries have been compacted so the peer needs the snapshot. It
+		// is only sent once the requests in flight have come back.
+		if entries == nil {
+			p.mutex.RLock()
+			inflight := p.inflight
+			p.mutex.RUnlock()
+
+			if inflight == 0 {
+				p.sendSnapshotRequest(newSnapshotRequest(p.server.name, p.server.lastSnapshot))
+				return true
+			}
+			return sent
+		}
+
+		// Nothing new to send.
+		if len(entries) == 0 && (sent || !heartbeat) {
+			return sent
+		}
+
+		if max := p.server.MaxAppendEntriesBatchSize(); max > 0 && len(entries) > max {
+			entries = entries[:max]
+		}
+
+		p.mutex.Lock()
+		p.nextIndex = prevLogIndex + uint64(len(entries)) + 1
+		p.inflight++
+		p.mutex.Unlock()
+
+		go p.sendAppendEntriesRequest(newAppendEntriesRequest(p.server.currentTerm, p.server.name, prevLogIndex, prevLogTerm, entries, p.server.log.CommitIndex()))
+		sent = true
+
+		if len(entries) == 0 {
+			return sent
+		}
+	}
+}
+
 // Sends an AppendEntries request to the peer through the transport.
 func (p *Peer) sendAppendEntriesRequest(req *AppendEntriesRequest) {
 	traceln("peer.flush.send: ", p.server.Name(), "->", p.Name(), " ", len(req.Entries))
 
+	start := time.Now()
 	resp := p.server.Transporter().SendAppendEntriesRequest(p.server, p, req)
+
+	p.mutex.Lock()
+	p.inflight--
+
+	// The peer acknowledged the leader's term when the request was sent.
+	if resp != nil && resp.Term <= req.Term && req.Term == p.server.currentTerm && start.After(p.lastAck) {
+		p.lastAck = start
+	}
+
 	if resp == nil {
 		debugln("peer.flush.timeout: ", p.server.Name(), "->", p.Name())
+
+		// The entries may not have arrived so resend everything after the
+		// last known index, one request at a time.
+		p.nextIndex = p.prevLogIndex + 1
+		p.probing = true
+		p.mutex.Unlock()
 		return
 	}
 	traceln("peer.flush.recv: ", p.Name())
 
-	// If successful then update the previous log index.
-	p.mutex.Lock()
+	// If successful then update the previous log index. Responses can come
+	// back out of order so it never goes backwards.
 	if resp.Success {
 		if len(req.Entries) > 0 {
-			p.prevLogIndex = req.Entries[len(req.Entries)-1].Index
+			lastIndex := req.Entries[len(req.Entries)-1].Index
+			if lastIndex > p.prevLogIndex {
+				p.prevLogIndex = lastIndex
+			}
+			if p.nextIndex <= p.prevLogIndex {
+				p.nextIndex = p.prevLogIndex + 1
+			}
 
 			// if peer append a log entry from the current term
 			// we set append to true
@@ -181,6 +360,7 @@ func (p *Peer) sendAppendEntriesRequest(req *AppendEntriesRequest) {
 				resp.append = true
 			}
 		}
+		p.probing = false
 		traceln("peer.flush.success: ", p.server.Name(), "->", p.Name(), "; idx =", p.prevLogIndex)
 
 		// If it was unsuccessful then decrement the previous log index and
@@ -208,9 +388,16 @@ func (p *Peer) sendAppendEntriesRequest(req *AppendEntriesRequest) {
 
 			debugln("peer.flush.decrement: ", p.server.Name(), "->", p.Name(), " idx =", p.prevLogIndex)
 		}
+
+		// Stop pipelining until the peer accepts a request again.
+		p.nextIndex = p.prevLogIndex + 1
+		p.probing = true
 	}
 	p.mutex.Unlock()
 
+	// There is room in the window for another request.
+	p.flush()
+
 	// Attach the peer to resp, thus server can know where it comes from
 	resp.peer = p.Name()
 	// Send response to server for processing.
@@ -231,7 +418,10 @@ func (p *Peer) sendSnapshotRequest(req *SnapshotRequest) {
 
 	// If successful then update the previous log index.
 	if resp.Success {
-		p.setPrevLogIndex(req.LastIndex)
+		p.mutex.Lock()
+		p.prevLogIndex = req.LastIndex
+		p.nextIndex = req.LastIndex + 1
+		p.mutex.Unlock()
 	} else {
 		debugln("peer.snap.failed: ", p.name)
 	}
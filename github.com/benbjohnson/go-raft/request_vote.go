@@ -1,5 +1,9 @@
 package raft
 
+import (
+	"io"
+)
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
@@ -45,3 +49,51 @@ func newRequestVoteResponse(term uint64, voteGranted bool) *RequestVoteResponse
 		VoteGranted: voteGranted,
 	}
 }
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Encoding
+//--------------------------------------
+
+// Encodes the RequestVote request to a buffer.
+func (req *RequestVoteRequest) encode(w io.Writer) error {
+	var e encoder
+	e.uint64(req.Term)
+	e.string(req.CandidateName)
+	e.uint64(req.LastLogIndex)
+	e.uint64(req.LastLogTerm)
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the RequestVote request from a buffer.
+func (req *RequestVoteRequest) decode(r io.Reader) error {
+	d := newDecoder(r)
+	req.Term = d.uint64()
+	req.CandidateName = d.string()
+	req.LastLogIndex = d.uint64()
+	req.LastLogTerm = d.uint64()
+	return d.err
+}
+
+// Encodes the RequestVote response to a buffer.
+func (resp *RequestVoteResponse) encode(w io.Writer) error {
+	var e encoder
+	e.uint64(resp.Term)
+	e.bool(resp.VoteGranted)
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the RequestVote response from a buffer.
+func (resp *RequestVoteResponse) decode(r io.Reader) error {
+	d := newDecoder(r)
+	resp.Term = d.uint64()
+	resp.VoteGranted = d.bool()
+	return d.err
+}
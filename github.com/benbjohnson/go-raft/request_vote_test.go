@@ -0,0 +1,30 @@
+package raft
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Ensure that a RequestVote request and response survive encoding.
+func TestRequestVoteEncoding(t *testing.T) {
+	req := newRequestVoteRequest(2, "candidate", 10, 1)
+	var b bytes.Buffer
+	if err := req.encode(&b); err != nil {
+		t.Fatalf("Request encoding error: %v", err)
+	}
+	req2 := &RequestVoteRequest{}
+	if err := req2.decode(&b); err != nil || !reflect.DeepEqual(req, req2) {
+		t.Fatalf("Request decoded incorrectly: %v (%v)", req2, err)
+	}
+
+	resp := newRequestVoteResponse(2, true)
+	b.Reset()
+	if err := resp.encode(&b); err != nil {
+		t.Fatalf("Response encoding error: %v", err)
+	}
+	resp2 := &RequestVoteResponse{}
+	if err := resp2.decode(&b); err != nil || !reflect.DeepEqual(resp, resp2) {
+		t.Fatalf("Response decoded incorrectly: %v (%v)", resp2, err)
+	}
+}
@@ -31,6 +31,11 @@ const (
 	DefaultElectionTimeout  = 150 * time.Millisecond
 )
 
+const (
+	DefaultMaxInflightAppendEntries  = 8
+	DefaultMaxAppendEntriesBatchSize = 64
+)
+
 var stopValue interface{}
 
 //------------------------------------------------------------------------------
@@ -42,6 +47,8 @@ var stopValue interface{}
 var NotLeaderError = errors.New("raft.Server: Not current leader")
 var DuplicatePeerError = errors.New("raft.Server: Duplicate peer")
 var CommandTimeoutError = errors.New("raft: Command timeout")
+var LeadershipTransferError = errors.New("raft.Server: Leadership transfer in progress")
+var ReadTimeoutError = errors.New("raft: Read timeout")
 
 //------------------------------------------------------------------------------
 //
@@ -63,13 +70,23 @@ type Server struct {
 	log        *Log
 	leader     string
 	peers      map[string]*Peer
+	learner    bool
 	mutex      sync.RWMutex
 	syncedPeer map[string]bool
 
+	// the peer leadership is being transferred to and when to give up
+	transferee       string
+	transferDeadline time.Time
+
 	c                chan *event
 	electionTimeout  time.Duration
 	heartbeatTimeout time.Duration
 
+	maxInflightAppendEntries  int
+	maxAppendEntriesBatchSize int
+
+	leaseReads bool
+
 	currentSnapshot *Snapshot
 	lastSnapshot    *Snapshot
 	stateMachine    StateMachine
@@ -109,6 +126,9 @@ func NewServer(name string, path string, transporter Transporter, stateMachine S
 		c:                make(chan *event, 256),
 		electionTimeout:  DefaultElectionTimeout,
 		heartbeatTimeout: DefaultHeartbeatTimeout,
+
+		maxInflightAppendEntries:  DefaultMaxInflightAppendEntries,
+		maxAppendEntriesBatchSize: DefaultMaxAppendEntriesBatchSize,
 	}
 
 	// Setup apply function.
@@ -199,6 +219,8 @@ func (s *Server) setState(state string) {
 
 // Retrieves the current term of the server.
 func (s *Server) Term() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	return s.currentTerm
 }
 
@@ -245,9 +267,47 @@ func (s *Server) MemberCount() int {
 	return len(s.peers) + 1
 }
 
-// Retrieves the number of servers required to make a quorum.
+// Retrieves the number of servers required to make a quorum. Learners are
+// not counted.
 func (s *Server) QuorumSize() int {
-	return (s.MemberCount() / 2) + 1
+	return (s.voterCount() / 2) + 1
+}
+
+// Retrieves the number of member servers that are not learners.
+func (s *Server) voterCount() int {
+	count := len(s.voters())
+	if !s.Learner() {
+		count++
+	}
+	return count
+}
+
+// Retrieves the peers that are not learners. The peers are copied out while
+// holding the server's lock and their flags are read after releasing it, so
+// a peer's lock is never taken while holding the server's.
+func (s *Server) voters() []*Peer {
+	s.mutex.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.mutex.RUnlock()
+
+	voters := peers[:0]
+	for _, peer := range peers {
+		if !peer.Learner() {
+			voters = append(voters, peer)
+		}
+	}
+	return voters
+}
+
+// Checks if the server is a learner. A learner receives the log but does not
+// vote or start elections until it is promoted.
+func (s *Server) Learner() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.learner
 }
 
 //--------------------------------------
@@ -284,6 +344,66 @@ func (s *Server) SetHeartbeatTimeout(duration time.Duration) {
 	}
 }
 
+//--------------------------------------
+// Reads
+//--------------------------------------
+
+// Checks if linearizable reads are served from the leader's lease.
+func (s *Server) LeaseReads() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.leaseReads
+}
+
+// Sets whether linearizable reads are served from the leader's lease instead
+// of confirming leadership with a heartbeat round for every read. The lease
+// lasts an election timeout from the last time a quorum acknowledged the
+// leader. It saves the round trip but assumes that no other leader is elected
+// in that time, which bounded clock drift does not guarantee if a follower
+// that lost touch with the leader starts an election early.
+func (s *Server) SetLeaseReads(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leaseReads = enabled
+}
+
+//--------------------------------------
+// Replication
+//--------------------------------------
+
+// Retrieves the max number of AppendEntries requests that can be in flight
+// to a peer at once.
+func (s *Server) MaxInflightAppendEntries() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.maxInflightAppendEntries
+}
+
+// Sets the max number of AppendEntries requests that can be in flight to a
+// peer at once. One means the leader waits for each response before sending
+// the next request.
+func (s *Server) SetMaxInflightAppendEntries(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.maxInflightAppendEntries = n
+}
+
+// Retrieves the max number of entries sent in one AppendEntries request. It
+// is also the max number of commands appended to the log at once.
+func (s *Server) MaxAppendEntriesBatchSize() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.maxAppendEntriesBatchSize
+}
+
+// Sets the max number of entries sent in one AppendEntries request. Zero
+// means no limit.
+func (s *Server) SetMaxAppendEntriesBatchSize(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.maxAppendEntriesBatchSize = n
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -294,9 +414,12 @@ func (s *Server) SetHeartbeatTimeout(duration time.Duration) {
 // Initialization
 //--------------------------------------
 
-// Reg the NOPCommand
+// Reg the NOPCommand and the membership commands
 func init() {
 	RegisterCommand(&NOPCommand{})
+	RegisterCommand(&AddPeerCommand{})
+	RegisterCommand(&PromotePeerCommand{})
+	RegisterCommand(&TransferLeadershipCommand{})
 }
 
 // Starts the server with a log at the given path.
@@ -460,7 +583,12 @@ func (s *Server) followerLoop() {
 			e.c <- err
 
 		case <-timeoutChan:
-			s.setState(Candidate)
+			// Learners wait to be promoted instead of starting an election.
+			if s.Learner() {
+				update = true
+			} else {
+				s.setState(Candidate)
+			}
 		}
 
 		// Converts to candidate if election timeout elapses without either:
@@ -490,7 +618,9 @@ func (s *Server) candidateLoop() {
 		// Send RequestVote RPCs to all other servers.
 		respChan := make(chan *RequestVoteResponse, len(s.peers))
 		for _, peer := range s.peers {
-			go peer.sendVoteRequest(newRequestVoteRequest(s.currentTerm, s.name, lastLogIndex, lastLogTerm), respChan)
+			if !peer.Learner() {
+				go peer.sendVoteRequest(newRequestVoteRequest(s.currentTerm, s.name, lastLogIndex, lastLogTerm), respChan)
+			}
 		}
 
 		// Wait for either:
@@ -567,33 +697,43 @@ func (s *Server) leaderLoop() {
 	// Update the peers prevLogIndex to leader's lastLogIndex and start heartbeat.
 	for _, peer := range s.peers {
 		peer.setPrevLogIndex(logIndex)
+		peer.setLastAck(time.Time{})
 		peer.startHeartbeat()
 	}
 
 	go s.Do(NOPCommand{})
 
 	// Begin to collect response from followers
+	var next *event
 	for {
 		var err error
-		select {
-		case e := <-s.c:
-			if e.target == &stopValue {
-				s.setState(Stopped)
-			} else if command, ok := e.target.(Command); ok {
-				s.processCommand(command, e)
-				continue
-			} else if req, ok := e.target.(*AppendEntriesRequest); ok {
-				e.returnValue, _ = s.processAppendEntriesRequest(req)
-			} else if resp, ok := e.target.(*AppendEntriesResponse); ok {
-				s.processAppendEntriesResponse(resp)
-			} else if req, ok := e.target.(*RequestVoteRequest); ok {
-				e.returnValue, _ = s.processRequestVoteRequest(req)
-			}
+		var e *event
 
-			// Callback to event.
-			e.c <- err
+		// An event may be left over from batching commands.
+		if next != nil {
+			e, next = next, nil
+		} else {
+			e = <-s.c
+		}
+
+		if e.target == &stopValue {
+			s.setState(Stopped)
+		} else if _, ok := e.target.(Command); ok {
+			var events []*event
+			events, next = s.batchCommands(e)
+			s.processCommands(events)
+			continue
+		} else if req, ok := e.target.(*AppendEntriesRequest); ok {
+			e.returnValue, _ = s.processAppendEntriesRequest(req)
+		} else if resp, ok := e.target.(*AppendEntriesResponse); ok {
+			s.processAppendEntriesResponse(resp)
+		} else if req, ok := e.target.(*RequestVoteRequest); ok {
+			e.returnValue, _ = s.processRequestVoteRequest(req)
 		}
 
+		// Callback to event.
+		e.c <- err
+
 		// Exit loop on state change.
 		if s.State() != Leader {
 			break
@@ -605,6 +745,7 @@ func (s *Server) leaderLoop() {
 		peer.stopHeartbeat()
 	}
 	s.syncedPeer = nil
+	s.transferee = ""
 }
 
 //--------------------------------------
@@ -618,32 +759,87 @@ func (s *Server) Do(command Command) (interface{}, error) {
 	return s.send(command)
 }
 
-// Processes a command.
-func (s *Server) processCommand(command Command, e *event) {
-	s.debugln("server.command.process")
+// Collects the commands waiting in the event queue after the given one so
+// they can be appended to the log together. The first event that is not a
+// command is returned to be processed next.
+func (s *Server) batchCommands(e *event) ([]*event, *event) {
+	events := []*event{e}
+	max := s.MaxAppendEntriesBatchSize()
 
-	// Create an entry for the command in the log.
-	entry := s.log.createEntry(s.currentTerm, command)
-	if err := s.log.appendEntry(entry); err != nil {
-		s.debugln("server.command.log.error:", err)
-		e.c <- err
-		return
+	for max <= 0 || len(events) < max {
+		select {
+		case e := <-s.c:
+			if _, ok := e.target.(Command); !ok {
+				return events, e
+			}
+			events = append(events, e)
+		default:
+			return events, nil
+		}
 	}
 
-	// Issue a callback for the entry once it's committed.
-	go func() {
-		// Wait for the entry to be committed.
-		select {
-		case <-entry.commit:
-			var err error
-			s.debugln("server.command.commit")
-			e.returnValue, err = s.log.getEntryResult(entry, true)
+	return events, nil
+}
+
+// Processes a batch of commands. The entries are appended to the log and
+// sent to the peers together so they are committed (and written to disk)
+// together.
+func (s *Server) processCommands(events []*event) {
+	s.debugln("server.command.process ", len(events))
+
+	// Commands are refused while leadership is being handed over so the
+	// new leader's log is as up-to-date as ours.
+	if s.transferee != "" && time.Now().After(s.transferDeadline) {
+		s.debugln("server.transfer.timeout: ", s.transferee)
+		s.transferee = ""
+	}
+
+	appended := false
+	for _, e := range events {
+		command := e.target.(Command)
+
+		if s.transferee != "" {
+			e.c <- LeadershipTransferError
+			continue
+		}
+		if c, ok := command.(*TransferLeadershipCommand); ok {
+			s.transferee = c.Name
+			s.transferDeadline = time.Now().Add(2 * s.ElectionTimeout())
+		}
+
+		// Create an entry for the command in the log.
+		entry := s.log.createEntry(s.currentTerm, command)
+		if err := s.log.appendEntries([]*LogEntry{entry}); err != nil {
+			s.debugln("server.command.log.error:", err)
 			e.c <- err
-		case <-time.After(time.Second):
-			s.debugln("server.command.timeout")
-			e.c <- CommandTimeoutError
+			continue
 		}
-	}()
+		appended = true
+
+		// Issue a callback for the entry once it's committed.
+		go func(e *event) {
+			// Wait for the entry to be committed.
+			select {
+			case <-entry.commit:
+				var err error
+				s.debugln("server.command.commit")
+				e.returnValue, err = s.log.getEntryResult(entry, true)
+				e.c <- err
+			case <-time.After(time.Second):
+				s.debugln("server.command.timeout")
+				e.c <- CommandTimeoutError
+			}
+		}(e)
+	}
+
+	if !appended {
+		return
+	}
+
+	// Send the new entries to the peers right away.
+	for _, peer := range s.peers {
+		peer.flush()
+	}
 
 	// Issue an append entries response for the server.
 	resp := newAppendEntriesResponse(s.currentTerm, true, s.log.currentIndex(), s.log.CommitIndex())
@@ -653,6 +849,117 @@ func (s *Server) processCommand(command Command, e *event) {
 	s.sendAsync(resp)
 }
 
+//--------------------------------------
+// Reads
+//--------------------------------------
+
+// Retrieves the index a read has to wait for to reflect every command
+// committed before the call. Only the leader can serve reads: it waits for
+// an entry of its own term to be committed and then confirms that it is
+// still the leader with a heartbeat round to a quorum, or checks its lease
+// if lease reads are enabled. Nothing is appended to the log.
+func (s *Server) ReadIndex() (uint64, error) {
+	if s.State() != Leader {
+		return 0, NotLeaderError
+	}
+	term := s.Term()
+	deadline := time.Now().Add(s.ElectionTimeout())
+
+	// The commit index is only known to be the latest once an entry from
+	// this term has been committed.
+	for {
+		if _, commitTerm := s.log.commitInfo(); commitTerm == term {
+			break
+		} else if commitTerm > term {
+			return 0, NotLeaderError
+		}
+		if time.Now().After(deadline) {
+			return 0, ReadTimeoutError
+		}
+		time.Sleep(time.Millisecond)
+	}
+	index := s.log.CommitIndex()
+
+	if s.LeaseReads() && s.leaseValid() {
+		return index, nil
+	}
+	if err := s.confirmLeadership(term, deadline); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// Runs a read-only query against the state machine once it reflects every
+// command committed before the call, without appending to the log. Commands
+// are applied as they are committed so the query runs as soon as the read
+// index is known. It runs concurrently with commands being applied.
+func (s *Server) QueryLinearizable(fn func(StateMachine) (interface{}, error)) (interface{}, error) {
+	if _, err := s.ReadIndex(); err != nil {
+		return nil, err
+	}
+	return fn(s.stateMachine)
+}
+
+// Sends a heartbeat to the voting peers and waits for a quorum to
+// acknowledge the leader's term.
+func (s *Server) confirmLeadership(term uint64, deadline time.Time) error {
+	peers := s.voters()
+
+	acks := 1
+	quorum := s.QuorumSize()
+	if acks >= quorum {
+		return nil
+	}
+
+	c := make(chan *AppendEntriesResponse, len(peers))
+	for _, peer := range peers {
+		go func(peer *Peer) {
+			start := time.Now()
+			resp := s.Transporter().SendAppendEntriesRequest(s, peer, newAppendEntriesRequest(term, s.name, 0, 0, nil, 0))
+			if resp != nil && resp.Term <= term {
+				peer.setLastAck(start)
+			}
+			c <- resp
+		}(peer)
+	}
+
+	timeout := time.After(deadline.Sub(time.Now()))
+	for _ = range peers {
+		select {
+		case resp := <-c:
+			if resp == nil {
+				continue
+			}
+			// Step down if a peer has seen a newer term.
+			if resp.Term > term {
+				s.sendAsync(resp)
+				return NotLeaderError
+			}
+			if acks++; acks >= quorum {
+				return nil
+			}
+		case <-timeout:
+			return ReadTimeoutError
+		}
+	}
+	return ReadTimeoutError
+}
+
+// Checks if a quorum has acknowledged the leader within the last election
+// timeout.
+func (s *Server) leaseValid() bool {
+	since := time.Now().Add(-s.ElectionTimeout())
+
+	acks := 1
+	for _, peer := range s.voters() {
+		if peer.getLastAck().After(since) {
+			acks++
+		}
+	}
+
+	return acks >= s.QuorumSize()
+}
+
 //--------------------------------------
 // Append Entries
 //--------------------------------------
@@ -677,19 +984,46 @@ func (s *Server) processAppendEntriesRequest(req *AppendEntriesRequest) (*Append
 	s.setCurrentTerm(req.Term, req.LeaderName, true)
 
 	// Reject if log doesn't contain a matching previous entry.
-	if err := s.log.truncate(req.PrevLogIndex, req.PrevLogTerm); err != nil {
-		s.debugln("server.ae.truncate.error: ", err)
+	if !s.log.matchEntry(req.PrevLogIndex, req.PrevLogTerm) {
+		s.debugln("server.ae.match.error: ", req.PrevLogIndex, req.PrevLogTerm)
+		return newAppendEntriesResponse(s.currentTerm, false, s.log.currentIndex(), s.log.CommitIndex()), true
+	}
+
+	// The leader pipelines requests so they may arrive late or more than
+	// once. Skip the entries we already have and only truncate the log where
+	// it conflicts with the leader's, otherwise an old request would remove
+	// the entries appended by a newer one.
+	entries := req.Entries
+	prevLogIndex, prevLogTerm := req.PrevLogIndex, req.PrevLogTerm
+	if len(entries) > 0 && entries[0].Index != prevLogIndex+1 {
+		s.debugln("server.ae.error: entries do not follow the previous entry")
 		return newAppendEntriesResponse(s.currentTerm, false, s.log.currentIndex(), s.log.CommitIndex()), true
 	}
+	for len(entries) > 0 && s.log.containsEntry(entries[0].Index, entries[0].Term) {
+		prevLogIndex, prevLogTerm = entries[0].Index, entries[0].Term
+		entries = entries[1:]
+	}
+
+	if len(entries) > 0 {
+		if err := s.log.truncate(prevLogIndex, prevLogTerm); err != nil {
+			s.debugln("server.ae.truncate.error: ", err)
+			return newAppendEntriesResponse(s.currentTerm, false, s.log.currentIndex(), s.log.CommitIndex()), true
+		}
+	}
 
 	// Append entries to the log.
-	if err := s.log.appendEntries(req.Entries); err != nil {
+	if err := s.log.appendEntries(entries); err != nil {
 		s.debugln("server.ae.append.error: ", err)
 		return newAppendEntriesResponse(s.currentTerm, false, s.log.currentIndex(), s.log.CommitIndex()), true
 	}
 
-	// Commit up to the commit index.
-	if err := s.log.setCommitIndex(req.CommitIndex); err != nil {
+	// Commit up to the commit index. A pipelined request may carry a commit
+	// index beyond its own entries, we can only commit what it covers.
+	commitIndex := req.CommitIndex
+	if lastIndex := req.PrevLogIndex + uint64(len(req.Entries)); commitIndex > lastIndex {
+		commitIndex = lastIndex
+	}
+	if err := s.log.setCommitIndex(commitIndex); err != nil {
 		s.debugln("server.ae.commit.error: ", err)
 		return newAppendEntriesResponse(s.currentTerm, false, s.log.currentIndex(), s.log.CommitIndex()), true
 	}
@@ -712,9 +1046,22 @@ func (s *Server) processAppendEntriesResponse(resp *AppendEntriesResponse) {
 		return
 	}
 
+	// Promote a learner once it has caught up with the committed entries.
+	peer := s.peers[resp.peer]
+	if peer != nil && peer.Learner() && peer.getPrevLogIndex() >= s.log.CommitIndex() && peer.startPromoting() {
+		s.debugln("server.peer.promote: ", peer.Name())
+		go func() {
+			// Let a later response try again if the command did not commit.
+			if _, err := s.Do(&PromotePeerCommand{Name: peer.Name()}); err != nil {
+				s.debugln("server.peer.promote.failed: ", peer.Name(), " ", err)
+				peer.setPromoting(false)
+			}
+		}()
+	}
+
 	// if one peer successfully append a log from the leader term,
-	// we add it to the synced list
-	if resp.append == true {
+	// we add it to the synced list. Learners do not count.
+	if resp.append == true && (peer == nil || !peer.Learner()) {
 		s.syncedPeer[resp.peer] = true
 	}
 
@@ -727,12 +1074,14 @@ func (s *Server) processAppendEntriesResponse(resp *AppendEntriesResponse) {
 	var indices []uint64
 	indices = append(indices, s.log.currentIndex())
 	for _, peer := range s.peers {
-		indices = append(indices, peer.getPrevLogIndex())
+		if !peer.Learner() {
+			indices = append(indices, peer.getPrevLogIndex())
+		}
 	}
 	sort.Sort(uint64Slice(indices))
 
 	// We can commit up to the index which the majority of the members have appended.
-	commitIndex := indices[s.QuorumSize()-1]
+	commitIndex := indices[len(indices)-s.QuorumSize()]
 	committedIndex := s.log.commitIndex
 
 	if commitIndex > committedIndex {
@@ -806,8 +1155,10 @@ func (s *Server) processRequestVoteRequest(req *RequestVoteRequest) (*RequestVot
 // Membership
 //--------------------------------------
 
-// Adds a peer to the server.
-func (s *Server) AddPeer(name string) error {
+// Adds a peer to the server. A learner receives the log but does not count
+// toward the quorum until it is promoted. Adding the server itself as a
+// learner keeps it from starting elections until it is promoted.
+func (s *Server) AddPeer(name string, learner bool) error {
 	s.debugln("server.peer.add: ", name, len(s.peers))
 
 	// Do not allow peers to be added twice.
@@ -818,15 +1169,67 @@ func (s *Server) AddPeer(name string) error {
 	// Only add the peer if it doesn't have the same name.
 	if s.name != name {
 		peer := newPeer(s, name, s.heartbeatTimeout)
+		peer.learner = learner
 		if s.State() == Leader {
 			peer.startHeartbeat()
 		}
 		s.peers[peer.name] = peer
+	} else if learner {
+		s.mutex.Lock()
+		s.learner = true
+		s.mutex.Unlock()
 	}
 
 	return nil
 }
 
+// Promotes a learner to a voting member.
+func (s *Server) promotePeer(name string) error {
+	s.debugln("server.peer.promote: ", name)
+
+	if s.name == name {
+		s.mutex.Lock()
+		s.learner = false
+		s.mutex.Unlock()
+		return nil
+	}
+
+	peer := s.peers[name]
+	if peer == nil {
+		return fmt.Errorf("raft: Peer not found: %s", name)
+	}
+	peer.setLearner(false)
+	return nil
+}
+
+//--------------------------------------
+// Leadership transfer
+//--------------------------------------
+
+// Hands leadership over to a peer. The transfer is replicated as a command
+// and once the peer has committed it, it starts an election right away. New
+// commands are refused until then. The function returns when the command is
+// committed, the peer becomes the leader shortly after.
+func (s *Server) TransferLeadership(name string) error {
+	if s.State() != Leader {
+		return NotLeaderError
+	}
+	if s.name == name {
+		return nil
+	}
+
+	peer := s.peers[name]
+	if peer == nil {
+		return fmt.Errorf("raft: Peer not found: %s", name)
+	}
+	if peer.Learner() {
+		return fmt.Errorf("raft: Cannot transfer leadership to learner: %s", name)
+	}
+
+	_, err := s.Do(&TransferLeadershipCommand{Name: name, Term: s.Term()})
+	return err
+}
+
 // Removes a peer from the server.
 func (s *Server) RemovePeer(name string) error {
 	s.debugln("server.peer.remove: ", name, len(s.peers))
@@ -894,13 +1297,20 @@ func (s *Server) takeSnapshot() error {
 	}
 
 	var peerNames []string
+	var learnerNames []string
 
 	for _, peer := range s.peers {
 		peerNames = append(peerNames, peer.Name())
+		if peer.Learner() {
+			learnerNames = append(learnerNames, peer.Name())
+		}
 	}
 	peerNames = append(peerNames, s.Name())
+	if s.Learner() {
+		learnerNames = append(learnerNames, s.Name())
+	}
 
-	s.currentSnapshot = &Snapshot{lastIndex, lastTerm, peerNames, state, path}
+	s.currentSnapshot = &Snapshot{lastIndex, lastTerm, peerNames, learnerNames, state, path}
 
 	s.saveSnapshot()
 
@@ -947,7 +1357,7 @@ func (s *Server) SnapshotRecovery(req *SnapshotRequest) (*SnapshotResponse, erro
 
 	//recovery the cluster configuration
 	for _, peerName := range req.Peers {
-		s.AddPeer(peerName)
+		s.AddPeer(peerName, containsString(req.Learners, peerName))
 	}
 
 	//update term and index
@@ -957,7 +1367,7 @@ func (s *Server) SnapshotRecovery(req *SnapshotRequest) (*SnapshotResponse, erro
 
 	snapshotPath := s.SnapshotPath(req.LastIndex, req.LastTerm)
 
-	s.currentSnapshot = &Snapshot{req.LastIndex, req.LastTerm, req.Peers, req.State, snapshotPath}
+	s.currentSnapshot = &Snapshot{req.LastIndex, req.LastTerm, req.Peers, req.Learners, req.State, snapshotPath}
 
 	s.saveSnapshot()
 
@@ -1039,7 +1449,7 @@ func (s *Server) LoadSnapshot() error {
 	}
 
 	for _, peerName := range s.lastSnapshot.Peers {
-		s.AddPeer(peerName)
+		s.AddPeer(peerName, containsString(s.lastSnapshot.Learners, peerName))
 	}
 
 	s.log.startTerm = s.lastSnapshot.LastTerm
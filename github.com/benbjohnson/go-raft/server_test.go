@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -254,7 +255,10 @@ func TestServerAppendEntriesRejectedIfAlreadyCommitted(t *testing.T) {
 func TestServerAppendEntriesOverwritesUncommittedEntries(t *testing.T) {
 	server := newTestServer("1", &testTransporter{})
 	server.Initialize()
-	server.StartLeader()
+	// not a leader: the no-op a leader appends for its term would race
+	// with these requests and end up in the log being compared
+	server.SetHeartbeatTimeout(time.Second * 10)
+	server.StartFollower()
 	defer server.Stop()
 
 	entry1 := newLogEntry(nil, 1, 1, &testCommand1{"foo", 10})
@@ -276,6 +280,46 @@ func TestServerAppendEntriesOverwritesUncommittedEntries(t *testing.T) {
 	}
 }
 
+// Ensure that pipelined requests arriving late or twice do not remove entries.
+func TestServerAppendEntriesOutOfOrder(t *testing.T) {
+	server := newTestServer("1", &testTransporter{})
+	server.Initialize()
+	server.SetHeartbeatTimeout(time.Second * 10)
+	server.StartFollower()
+	defer server.Stop()
+
+	entry1 := newLogEntry(nil, 1, 1, &testCommand1{"foo", 10})
+	entry2 := newLogEntry(nil, 2, 1, &testCommand1{"foo", 15})
+	entry3 := newLogEntry(nil, 3, 1, &testCommand1{"bar", 20})
+
+	// The second request arrives before the first one.
+	resp := server.AppendEntries(newAppendEntriesRequest(1, "ldr", 2, 1, []*LogEntry{entry3}, 0))
+	if resp.Term != 1 || resp.Success {
+		t.Fatalf("AppendEntries should have failed: %v/%v", resp.Term, resp.Success)
+	}
+	resp = server.AppendEntries(newAppendEntriesRequest(1, "ldr", 0, 0, []*LogEntry{entry1, entry2}, 0))
+	if resp.Term != 1 || !resp.Success {
+		t.Fatalf("AppendEntries failed: %v/%v", resp.Term, resp.Success)
+	}
+	resp = server.AppendEntries(newAppendEntriesRequest(1, "ldr", 2, 1, []*LogEntry{entry3}, 0))
+	if resp.Term != 1 || !resp.Success {
+		t.Fatalf("AppendEntries failed: %v/%v", resp.Term, resp.Success)
+	}
+
+	// A late copy of the first request and a late heartbeat.
+	resp = server.AppendEntries(newAppendEntriesRequest(1, "ldr", 0, 0, []*LogEntry{entry1, entry2}, 1))
+	if resp.Term != 1 || !resp.Success {
+		t.Fatalf("AppendEntries failed: %v/%v", resp.Term, resp.Success)
+	}
+	resp = server.AppendEntries(newAppendEntriesRequest(1, "ldr", 1, 1, []*LogEntry{}, 1))
+	if resp.Term != 1 || !resp.Success {
+		t.Fatalf("AppendEntries failed: %v/%v", resp.Term, resp.Success)
+	}
+	if !reflect.DeepEqual(server.log.entries, []*LogEntry{entry1, entry2, entry3}) || server.log.commitIndex != 1 {
+		t.Fatalf("Entries were removed: %v", server.log.entries)
+	}
+}
+
 //--------------------------------------
 // Command Execution
 //--------------------------------------
@@ -483,3 +527,344 @@ func TestServerMultiNode(t *testing.T) {
 	}
 
 }
+
+//--------------------------------------
+// Replication
+//--------------------------------------
+
+// Ensure that concurrent commands are replicated to all the servers.
+func TestServerPipelinedReplication(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+	leader.SetMaxAppendEntriesBatchSize(4)
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := leader.Do(&testCommand2{X: i}); err != nil {
+				t.Errorf("Unable to execute command %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 100 commands and the leader's NOP.
+	for _, server := range servers {
+		if index := waitForCommitIndex(server, 101); index != 101 {
+			t.Fatalf("Server %s has not committed all the commands: %d", server.Name(), index)
+		}
+	}
+}
+
+// Waits up to a second for the server to commit the given index and returns
+// the commit index.
+func waitForCommitIndex(server *Server, index uint64) uint64 {
+	for i := 0; i < 100; i++ {
+		if server.log.CommitIndex() >= index {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return server.log.CommitIndex()
+}
+
+// Ensure that the leader falls back to find where a peer's log ends when
+// the peer rejects a request.
+func TestServerPipelineFallsBackOnRejection(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+
+	// The leader has entries the peers have never seen.
+	for i := 0; i < 10; i++ {
+		entry := leader.log.createEntry(1, &testCommand2{X: i})
+		if err := leader.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append: %v", err)
+		}
+	}
+	if err := leader.log.setCommitIndex(10); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+	leader.currentTerm = 1
+
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if _, err := leader.Do(&testCommand2{X: 10}); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+
+	for _, server := range servers {
+		if index := waitForCommitIndex(server, 12); index != 12 {
+			t.Fatalf("Server %s has not caught up: %d", server.Name(), index)
+		}
+	}
+}
+
+//--------------------------------------
+// Reads
+//--------------------------------------
+
+// Ensure that the leader serves a read index covering the committed commands
+// without appending to the log.
+func TestServerReadIndex(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if _, err := leader.Do(&testCommand2{X: 1}); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+	lastIndex := leader.log.currentIndex()
+
+	if index, err := leader.ReadIndex(); err != nil || index != lastIndex {
+		t.Fatalf("Unexpected read index: %d (%v)", index, err)
+	}
+	if leader.log.currentIndex() != lastIndex {
+		t.Fatalf("Read appended to the log: %d", leader.log.currentIndex())
+	}
+	if _, err := servers[1].ReadIndex(); err != NotLeaderError {
+		t.Fatalf("Expected not leader error: %v", err)
+	}
+}
+
+// Ensure that a leader that can't reach a quorum does not serve reads unless
+// its lease is still valid. Nobody can be elected during the partition.
+func TestServerReadIndexWithoutQuorum(t *testing.T) {
+	lookup := map[string]*Server{}
+	transporter := newTestTransporter(lookup, time.Millisecond)
+	var partitioned int32
+	sendAppendEntriesRequest := transporter.sendAppendEntriesRequestFunc
+	transporter.sendAppendEntriesRequestFunc = func(server *Server, peer *Peer, req *AppendEntriesRequest) *AppendEntriesResponse {
+		if atomic.LoadInt32(&partitioned) == 1 {
+			return nil
+		}
+		return sendAppendEntriesRequest(server, peer, req)
+	}
+	sendVoteRequest := transporter.sendVoteRequestFunc
+	transporter.sendVoteRequestFunc = func(server *Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse {
+		if atomic.LoadInt32(&partitioned) == 1 {
+			return nil
+		}
+		return sendVoteRequest(server, peer, req)
+	}
+	servers := newTestCluster([]string{"1", "2", "3"}, transporter, lookup)
+	leader := servers[0]
+	leader.SetLeaseReads(true)
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if _, err := leader.Do(&testCommand2{X: 1}); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+	atomic.StoreInt32(&partitioned, 1)
+
+	if _, err := leader.ReadIndex(); err != nil {
+		t.Fatalf("Expected read within the lease: %v", err)
+	}
+	time.Sleep(2 * testElectionTimeout)
+	if _, err := leader.ReadIndex(); err != ReadTimeoutError {
+		t.Fatalf("Expected read timeout after the lease: %v", err)
+	}
+}
+
+// Ensure that a query runs against the leader's state machine.
+func TestServerQueryLinearizable(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+	stateMachine := &testStateMachine{}
+	leader.stateMachine = stateMachine
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	value, err := leader.QueryLinearizable(func(sm StateMachine) (interface{}, error) {
+		if sm != stateMachine {
+			t.Fatalf("Unexpected state machine: %v", sm)
+		}
+		return "foo", nil
+	})
+	if value != "foo" || err != nil {
+		t.Fatalf("Unexpected query result: %v (%v)", value, err)
+	}
+	if _, err := servers[1].QueryLinearizable(func(sm StateMachine) (interface{}, error) { return nil, nil }); err != NotLeaderError {
+		t.Fatalf("Expected not leader error: %v", err)
+	}
+}
+
+//--------------------------------------
+// Learners
+//--------------------------------------
+
+// Ensure that a learner does not count toward the quorum until it has caught
+// up and the leader has promoted it.
+func TestServerLearner(t *testing.T) {
+	lookup := map[string]*Server{}
+	transporter := newTestTransporter(lookup, time.Millisecond)
+	servers := newTestCluster([]string{"1", "2", "3"}, transporter, lookup)
+	leader := servers[0]
+
+	// The learner can't catch up until it's started.
+	learner := newTestServer("4", transporter)
+	learner.SetElectionTimeout(testElectionTimeout)
+	learner.SetHeartbeatTimeout(testHeartbeatTimeout)
+	for _, server := range servers {
+		learner.AddPeer(server.Name(), false)
+	}
+	learner.AddPeer(learner.Name(), true)
+	learner.Initialize()
+	lookup[learner.Name()] = learner
+
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if _, err := leader.Do(&AddPeerCommand{Name: "4", Learner: true}); err != nil {
+		t.Fatalf("Unable to add learner: %v", err)
+	}
+	if n, q := leader.MemberCount(), leader.QuorumSize(); n != 4 || q != 2 {
+		t.Fatalf("Unexpected membership [MEMBERS=%d, QUORUM=%d]", n, q)
+	}
+	if !leader.Peers()["4"].Learner() {
+		t.Fatalf("Expected peer to be a learner")
+	}
+
+	// Commands are committed without the learner.
+	if _, err := leader.Do(&testCommand2{X: 1}); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+
+	learner.StartFollower()
+	defer learner.Stop()
+
+	for i := 0; i < 100 && (leader.QuorumSize() != 3 || learner.Learner()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leader.Peers()["4"].Learner() || leader.QuorumSize() != 3 {
+		t.Fatalf("Learner was not promoted [QUORUM=%d]", leader.QuorumSize())
+	}
+	if learner.Learner() {
+		t.Fatalf("Learner does not know it was promoted")
+	}
+	if learner.State() != Follower || learner.Term() != leader.Term() {
+		t.Fatalf("Learner started an election [STATE=%s, TERM=%d]", learner.State(), learner.Term())
+	}
+}
+
+//--------------------------------------
+// Leadership Transfer
+//--------------------------------------
+
+// Ensure that the leader can hand leadership over to a peer.
+func TestServerTransferLeadership(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader, target := servers[0], servers[2]
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if _, err := leader.Do(&testCommand2{X: 1}); err != nil {
+		t.Fatalf("Unable to execute command: %v", err)
+	}
+	if err := leader.TransferLeadership(target.Name()); err != nil {
+		t.Fatalf("Unable to transfer leadership: %v", err)
+	}
+
+	if !waitForState(target, Leader) {
+		t.Fatalf("Server %s did not become the leader: %s", target.Name(), target.State())
+	}
+	if !waitForState(leader, Follower) {
+		t.Fatalf("Server %s did not step down: %s", leader.Name(), leader.State())
+	}
+	if _, err := leader.Do(&testCommand2{X: 2}); err != NotLeaderError {
+		t.Fatalf("Expected not leader error: %v", err)
+	}
+	if _, err := target.Do(&testCommand2{X: 3}); err != nil {
+		t.Fatalf("Unable to execute command on the new leader: %v", err)
+	}
+}
+
+// Ensure that leadership is only transferred to a voting peer by the leader.
+func TestServerTransferLeadershipRejected(t *testing.T) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+	leader.AddPeer("4", true)
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+
+	if err := servers[1].TransferLeadership("3"); err != NotLeaderError {
+		t.Fatalf("Expected not leader error: %v", err)
+	}
+	if err := leader.TransferLeadership("5"); err == nil || err.Error() != "raft: Peer not found: 5" {
+		t.Fatalf("Expected peer not found error: %v", err)
+	}
+	if err := leader.TransferLeadership("4"); err == nil || err.Error() != "raft: Cannot transfer leadership to learner: 4" {
+		t.Fatalf("Expected learner error: %v", err)
+	}
+	if leader.State() != Leader {
+		t.Fatalf("Leader should not have stepped down: %s", leader.State())
+	}
+}
+
+// Waits up to a second for the server to reach the given state.
+func waitForState(server *Server, state string) bool {
+	for i := 0; i < 100; i++ {
+		if server.State() == state {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// Measures command throughput on a three server cluster with 1ms latency
+// between the servers.
+func benchmarkServerDo(b *testing.B, inflight int, batchSize int) {
+	lookup := map[string]*Server{}
+	servers := newTestCluster([]string{"1", "2", "3"}, newTestTransporter(lookup, time.Millisecond), lookup)
+	leader := servers[0]
+	leader.SetMaxInflightAppendEntries(inflight)
+	leader.SetMaxAppendEntriesBatchSize(batchSize)
+	startTestCluster(servers)
+	defer stopTestCluster(servers)
+	time.Sleep(50 * time.Millisecond)
+
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	c := make(chan bool)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _ = range c {
+				if _, err := leader.Do(&testCommand2{X: 1}); err != nil {
+					b.Errorf("Unable to execute command: %v", err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < b.N; i++ {
+		c <- true
+	}
+	close(c)
+	wg.Wait()
+
+	b.StopTimer()
+}
+
+// One request in flight with one entry, like the leader used to replicate.
+func BenchmarkServerDoStopAndWait(b *testing.B) {
+	benchmarkServerDo(b, 1, 1)
+}
+
+func BenchmarkServerDoPipelined(b *testing.B) {
+	benchmarkServerDo(b, DefaultMaxInflightAppendEntries, DefaultMaxAppendEntriesBatchSize)
+}
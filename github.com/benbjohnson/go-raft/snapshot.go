@@ -22,8 +22,10 @@ type Snapshot struct {
 	LastTerm  uint64 `json:"lastTerm"`
 	// cluster configuration.
 	Peers []string `json: "peers"`
-	State []byte   `json: "state"`
-	Path  string   `json: "path"`
+	// the peers that are learners
+	Learners []string `json:"learners"`
+	State    []byte   `json: "state"`
+	Path     string   `json: "path"`
 }
 
 // Save the snapshot to a file
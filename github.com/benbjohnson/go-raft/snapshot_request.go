@@ -1,11 +1,16 @@
 package raft
 
+import (
+	"io"
+)
+
 // The request sent to a server to start from the snapshot.
 type SnapshotRequest struct {
 	LeaderName string   `json:"leaderName"`
 	LastIndex  uint64   `json:"lastTerm"`
 	LastTerm   uint64   `json:"lastIndex"`
 	Peers      []string `json:peers`
+	Learners   []string `json:"learners"`
 	State      []byte   `json:"state"`
 }
 
@@ -29,6 +34,7 @@ func newSnapshotRequest(leaderName string, snapshot *Snapshot) *SnapshotRequest
 		LastIndex:  snapshot.LastIndex,
 		LastTerm:   snapshot.LastTerm,
 		Peers:      snapshot.Peers,
+		Learners:   snapshot.Learners,
 		State:      snapshot.State,
 	}
 }
@@ -41,3 +47,57 @@ func newSnapshotResponse(term uint64, success bool, commitIndex uint64) *Snapsho
 		CommitIndex: commitIndex,
 	}
 }
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Encoding
+//--------------------------------------
+
+// Encodes the Snapshot request to a buffer.
+func (req *SnapshotRequest) encode(w io.Writer) error {
+	var e encoder
+	e.string(req.LeaderName)
+	e.uint64(req.LastIndex)
+	e.uint64(req.LastTerm)
+	e.strings(req.Peers)
+	e.bytes(req.State)
+	e.strings(req.Learners)
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the Snapshot request from a buffer.
+func (req *SnapshotRequest) decode(r io.Reader) error {
+	d := newDecoder(r)
+	req.LeaderName = d.string()
+	req.LastIndex = d.uint64()
+	req.LastTerm = d.uint64()
+	req.Peers = d.strings()
+	req.State = d.bytes()
+	req.Learners = d.strings()
+	return d.err
+}
+
+// Encodes the Snapshot response to a buffer.
+func (resp *SnapshotResponse) encode(w io.Writer) error {
+	var e encoder
+	e.uint64(resp.Term)
+	e.bool(resp.Success)
+	e.uint64(resp.CommitIndex)
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// Decodes the Snapshot response from a buffer.
+func (resp *SnapshotResponse) decode(r io.Reader) error {
+	d := newDecoder(r)
+	resp.Term = d.uint64()
+	resp.Success = d.bool()
+	resp.CommitIndex = d.uint64()
+	return d.err
+}
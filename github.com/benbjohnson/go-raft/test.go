@@ -44,6 +44,10 @@ func setupLogFile(content string) string {
 
 func setupLog(content string) (*Log, string) {
 	path := setupLogFile(content)
+	return reopenLog(path), path
+}
+
+func reopenLog(path string) *Log {
 	log := newLog()
 	log.ApplyFunc = func(c Command) (interface{}, error) {
 		return nil, nil
@@ -51,7 +55,7 @@ func setupLog(content string) (*Log, string) {
 	if err := log.open(path); err != nil {
 		panic("Unable to open log")
 	}
-	return log, path
+	return log
 }
 
 //--------------------------------------
@@ -84,13 +88,29 @@ func newTestCluster(names []string, transporter Transporter, lookup map[string]*
 	for _, server := range servers {
 		server.SetHeartbeatTimeout(testHeartbeatTimeout)
 		for _, peer := range servers {
-			server.AddPeer(peer.Name())
+			server.AddPeer(peer.Name(), false)
 		}
 		server.Initialize()
 	}
 	return servers
 }
 
+// Starts the first server of a test cluster as the leader and the others as
+// followers.
+func startTestCluster(servers []*Server) {
+	servers[0].StartLeader()
+	for _, server := range servers[1:] {
+		server.StartFollower()
+	}
+}
+
+// Stops all the servers of a test cluster.
+func stopTestCluster(servers []*Server) {
+	for _, server := range servers {
+		server.Stop()
+	}
+}
+
 //--------------------------------------
 // Transporter
 //--------------------------------------
@@ -113,6 +133,35 @@ func (t *testTransporter) SendSnapshotRequest(server *Server, peer *Peer, req *S
 	return t.sendSnapshotRequestFunc(server, peer, req)
 }
 
+// Creates a transporter that delivers requests straight to the servers in
+// the lookup after the given latency. Requests to unknown servers are lost.
+func newTestTransporter(lookup map[string]*Server, latency time.Duration) *testTransporter {
+	t := &testTransporter{}
+	t.sendVoteRequestFunc = func(server *Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse {
+		time.Sleep(latency)
+		if s := lookup[peer.Name()]; s != nil {
+			return s.RequestVote(req)
+		}
+		return nil
+	}
+	t.sendAppendEntriesRequestFunc = func(server *Server, peer *Peer, req *AppendEntriesRequest) *AppendEntriesResponse {
+		time.Sleep(latency)
+		if s := lookup[peer.Name()]; s != nil {
+			return s.AppendEntries(req)
+		}
+		return nil
+	}
+	t.sendSnapshotRequestFunc = func(server *Server, peer *Peer, req *SnapshotRequest) *SnapshotResponse {
+		time.Sleep(latency)
+		if s := lookup[peer.Name()]; s != nil {
+			resp, _ := s.SnapshotRecovery(req)
+			return resp
+		}
+		return nil
+	}
+	return t
+}
+
 type testStateMachine struct {
 	saveFunc     func() ([]byte, error)
 	recoveryFunc func([]byte) error
@@ -139,7 +188,7 @@ func (c *joinCommand) CommandName() string {
 }
 
 func (c *joinCommand) Apply(server *Server) (interface{}, error) {
-	err := server.AddPeer(c.Name)
+	err := server.AddPeer(c.Name, false)
 	return nil, err
 }
 
@@ -0,0 +1,321 @@
+// This is a utility application to inspect and repair nsqd diskqueue
+// files offline (nsqd must not be running against the data path)
+//
+//    nsq_diskqueue --data-path=/data [--name=<queue>] <dump|verify|truncate>
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/bitly/nsq/nsq"
+	"github.com/bitly/nsq/util"
+	"github.com/bitly/nsq/util/diskqueue"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	showVersion = flag.Bool("version", false, "print version string")
+	dataPath    = flag.String("data-path", "", "path to the nsqd data directory")
+	name        = flag.String("name", "", "name of the diskqueue (<topic> or <topic>:<channel>), defaults to all")
+
+	maxMessageSize = flag.Int64("max-message-size", 1024768, "the --max-message-size of nsqd, larger records are considered corrupt")
+)
+
+var dataFileRegex = regexp.MustCompile(`^(.+)\.diskqueue\.([0-9]{6,})\.dat$`)
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// metaData mirrors the state nsqd's DiskQueue persists in
+// <name>.diskqueue.meta.dat
+type metaData struct {
+	depth        int64
+	readFileNum  int64
+	readPos      int64
+	writeFileNum int64
+	writePos     int64
+}
+
+// maxRecordSize is the largest record nsqd writes, a message body
+// preceded by its timestamp, attempts and ID
+func maxRecordSize() int32 {
+	return int32(*maxMessageSize) + 8 + 2 + nsq.MsgIdLength
+}
+
+// badRecord describes the first record of a data file that could not be read
+type badRecord struct {
+	offset int64
+	err    error
+}
+
+func main() {
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("nsq_diskqueue v%s\n", util.BINARY_VERSION)
+		return
+	}
+
+	if *dataPath == "" {
+		log.Fatalf("ERROR: --data-path is required")
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatalf("ERROR: a single command (dump, verify or truncate) is required")
+	}
+
+	queues, err := findQueues(*dataPath)
+	if err != nil {
+		log.Fatalf("ERROR: failed to list %s - %s", *dataPath, err.Error())
+	}
+
+	if *name != "" {
+		fileNums, ok := queues[*name]
+		if !ok {
+			log.Fatalf("ERROR: no data files found for diskqueue(%s)", *name)
+		}
+		queues = map[string][]int64{*name: fileNums}
+	}
+
+	var names []string
+	for queueName := range queues {
+		names = append(names, queueName)
+	}
+	sort.Strings(names)
+
+	var numBad int
+	for _, queueName := range names {
+		var n int
+		switch flag.Arg(0) {
+		case "dump":
+			err = dump(queueName, queues[queueName])
+		case "verify":
+			n, err = verify(queueName, queues[queueName])
+			numBad += n
+		case "truncate":
+			err = truncate(queueName, queues[queueName])
+		default:
+			log.Fatalf("ERROR: unknown command %s", flag.Arg(0))
+		}
+		if err != nil {
+			log.Fatalf("ERROR: diskqueue(%s) %s failed - %s", queueName, flag.Arg(0), err.Error())
+		}
+	}
+
+	if numBad > 0 {
+		os.Exit(1)
+	}
+}
+
+// findQueues returns the (sorted) data file numbers of every diskqueue in dir
+func findQueues(dir string) (map[string][]int64, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.diskqueue.*.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string][]int64)
+	for _, fn := range matches {
+		parts := dataFileRegex.FindStringSubmatch(filepath.Base(fn))
+		if parts == nil {
+			continue
+		}
+		fileNum, _ := strconv.ParseInt(parts[2], 10, 64)
+		queues[parts[1]] = append(queues[parts[1]], fileNum)
+	}
+
+	for _, fileNums := range queues {
+		sort.Sort(int64Slice(fileNums))
+	}
+
+	return queues, nil
+}
+
+// scanFile calls fn for every record in the data file, in order, stopping at
+// the end of the file or at the first record that cannot be read
+func scanFile(fileName string, fn func(offset int64, data []byte)) (*badRecord, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version, err := diskqueue.ReadHeader(f)
+	if err != nil {
+		return &badRecord{0, err}, nil
+	}
+
+	offset := diskqueue.HeaderLen(version)
+	_, err = f.Seek(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		data, err := diskqueue.ReadRecord(r, version, maxRecordSize())
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return &badRecord{offset, err}, nil
+		}
+		fn(offset, data)
+		offset += diskqueue.RecordLen(version, len(data))
+	}
+}
+
+func dump(queueName string, fileNums []int64) error {
+	for _, fileNum := range fileNums {
+		fileName := diskqueue.FileName(*dataPath, queueName, fileNum)
+		bad, err := scanFile(fileName, func(offset int64, data []byte) {
+			msg, err := nsq.DecodeMessage(data)
+			if err != nil {
+				fmt.Printf("%s:%d undecodable message (%d bytes) - %s\n",
+					fileName, offset, len(data), err.Error())
+				return
+			}
+			fmt.Printf("%s:%d id:%s attempts:%d timestamp:%d body:%q\n",
+				fileName, offset, msg.Id, msg.Attempts, msg.Timestamp, msg.Body)
+		})
+		if err != nil {
+			return err
+		}
+		if bad != nil {
+			fmt.Printf("%s:%d BAD RECORD - %s\n", fileName, bad.offset, bad.err.Error())
+		}
+	}
+	return nil
+}
+
+func verify(queueName string, fileNums []int64) (int, error) {
+	var numBad int
+	for _, fileNum := range fileNums {
+		var count int
+		fileName := diskqueue.FileName(*dataPath, queueName, fileNum)
+		bad, err := scanFile(fileName, func(offset int64, data []byte) { count++ })
+		if err != nil {
+			return numBad, err
+		}
+		if bad != nil {
+			numBad++
+			fmt.Printf("%s: BAD at offset %d after %d records - %s\n",
+				fileName, bad.offset, count, bad.err.Error())
+			continue
+		}
+		fmt.Printf("%s: OK %d records\n", fileName, count)
+	}
+	return numBad, nil
+}
+
+// truncate cuts every data file at its first bad record and then brings the
+// metadata (if any) back in line with what remains on disk
+func truncate(queueName string, fileNums []int64) error {
+	for _, fileNum := range fileNums {
+		fileName := diskqueue.FileName(*dataPath, queueName, fileNum)
+		bad, err := scanFile(fileName, func(offset int64, data []byte) {})
+		if err != nil {
+			return err
+		}
+		if bad == nil {
+			continue
+		}
+		fmt.Printf("%s: truncating at offset %d - %s\n", fileName, bad.offset, bad.err.Error())
+		err = os.Truncate(fileName, bad.offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	metaFileName := diskqueue.MetaDataFileName(*dataPath, queueName)
+	md, err := readMetaData(metaFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if fi, err := os.Stat(diskqueue.FileName(*dataPath, queueName, md.readFileNum)); err == nil && md.readPos > fi.Size() {
+		md.readPos = fi.Size()
+	}
+	if fi, err := os.Stat(diskqueue.FileName(*dataPath, queueName, md.writeFileNum)); err == nil && md.writePos > fi.Size() {
+		md.writePos = fi.Size()
+	}
+
+	// recount the records still pending between the read and write positions
+	var depth int64
+	for fileNum := md.readFileNum; fileNum <= md.writeFileNum; fileNum++ {
+		fileName := diskqueue.FileName(*dataPath, queueName, fileNum)
+		_, err := scanFile(fileName, func(offset int64, data []byte) {
+			if fileNum == md.readFileNum && offset < md.readPos {
+				return
+			}
+			if fileNum == md.writeFileNum && offset >= md.writePos {
+				return
+			}
+			depth++
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if depth != md.depth {
+		fmt.Printf("%s: depth %d -> %d\n", metaFileName, md.depth, depth)
+		md.depth = depth
+	}
+
+	return writeMetaData(metaFileName, md)
+}
+
+func readMetaData(fileName string) (*metaData, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	md := &metaData{}
+	_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+		&md.depth,
+		&md.readFileNum, &md.readPos,
+		&md.writeFileNum, &md.writePos)
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func writeMetaData(fileName string, md *metaData) error {
+	tmpFileName := fileName + ".tmp"
+
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%d\n%d,%d\n%d,%d\n",
+		md.depth,
+		md.readFileNum, md.readPos,
+		md.writeFileNum, md.writePos)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	f.Sync()
+	f.Close()
+
+	return os.Rename(tmpFileName, fileName)
+}
@@ -63,12 +63,22 @@ func statLoop(interval time.Duration, topic string, channel string,
 		}
 
 		if i%25 == 0 {
-			fmt.Printf("-----------depth------------+--------------metadata---------------\n")
-			fmt.Printf("%7s %7s %5s %5s | %7s %7s %12s %7s\n", "mem", "disk", "inflt", "def", "req", "t-o", "msgs", "clients")
+			fmt.Printf("-----------depth------------+--------------metadata---------------")
+			if c.E2eProcessingLatency != nil {
+				fmt.Printf("+---e2e-processing-latency---")
+			}
+			fmt.Printf("\n%7s %7s %5s %5s | %7s %7s %12s %7s", "mem", "disk", "inflt", "def", "req", "t-o", "msgs", "clients")
+			if c.E2eProcessingLatency != nil {
+				fmt.Printf(" |")
+				for _, p := range c.E2eProcessingLatency.Percentiles {
+					fmt.Printf(" %10s", p.Label())
+				}
+			}
+			fmt.Printf("\n")
 		}
 
 		// TODO: paused
-		fmt.Printf("%7d %7d %5d %5d | %7d %7d %12d %7d\n",
+		fmt.Printf("%7d %7d %5d %5d | %7d %7d %12d %7d",
 			c.Depth,
 			c.BackendDepth,
 			c.InFlightCount,
@@ -77,6 +87,13 @@ func statLoop(interval time.Duration, topic string, channel string,
 			c.TimeoutCount,
 			c.MessageCount,
 			c.ClientCount)
+		if c.E2eProcessingLatency != nil {
+			fmt.Printf(" |")
+			for _, p := range c.E2eProcessingLatency.Percentiles {
+				fmt.Printf(" %10s", p.Value)
+			}
+		}
+		fmt.Printf("\n")
 
 		time.Sleep(interval)
 
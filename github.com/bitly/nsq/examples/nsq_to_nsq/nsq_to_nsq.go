@@ -0,0 +1,343 @@
+// This is an NSQ client that reads the specified topic/channel
+// and re-publishes the messages to a destination set of nsqd
+//
+// messages are batched into MPUB commands and are only FINished once the
+// destination nsqd has acknowledged them (at-least-once delivery)
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/bitly/go-hostpool"
+	"github.com/bitly/nsq/nsq"
+	"github.com/bitly/nsq/util"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	ModeRoundRobin = iota
+	ModeHostPool
+)
+
+const (
+	// how often (and for how long) to retry connecting to a destination
+	// whose Writer is still failing the transactions of its last connection
+	connectRetries   = 10
+	connectRetryWait = 50 * time.Millisecond
+)
+
+var (
+	showVersion        = flag.Bool("version", false, "print version string")
+	topic              = flag.String("topic", "", "nsq topic")
+	channel            = flag.String("channel", "nsq_to_nsq", "nsq channel")
+	destTopic          = flag.String("destination-topic", "", "destination nsq topic (defaults to --topic)")
+	maxInFlight        = flag.Int("max-in-flight", 200, "max number of messages to allow in flight")
+	verbose            = flag.Bool("verbose", false, "enable verbose logging")
+	numPublishers      = flag.Int("n", 1, "number of concurrent publishers")
+	mode               = flag.String("mode", "round-robin", "the destination nsqd selection mode options: round-robin, hostpool")
+	batchSize          = flag.Int("batch-size", 50, "max number of messages to publish in a single MPUB (per publisher)")
+	batchTimeout       = flag.Duration("batch-timeout", 100*time.Millisecond, "max time to wait before publishing a partial batch")
+	heartbeatInterval  = flag.Int("destination-heartbeat-interval", 30000, "heartbeat interval (ms) of connections to destination nsqd")
+	statusEvery        = flag.Int("status-every", 250, "the # of batches between logging status (per publisher), 0 disables")
+	maxBackoffDuration = flag.Duration("max-backoff-duration", 120*time.Second, "the maximum backoff duration")
+	nsqdTCPAddrs       = util.StringArray{}
+	lookupdHTTPAddrs   = util.StringArray{}
+	destNsqdTCPAddrs   = util.StringArray{}
+)
+
+func init() {
+	flag.Var(&nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
+	flag.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
+	flag.Var(&destNsqdTCPAddrs, "destination-nsqd-tcp-address", "destination nsqd TCP address (may be given multiple times)")
+}
+
+// Publisher maintains a Writer per destination nsqd
+//
+// a Writer does not reconnect on its own so the connection is
+// (re)established whenever a publish finds it missing
+type Publisher struct {
+	sync.Mutex
+	writers map[string]*nsq.Writer
+}
+
+func NewPublisher(addresses util.StringArray) *Publisher {
+	p := &Publisher{
+		writers: make(map[string]*nsq.Writer),
+	}
+	for _, addr := range addresses {
+		p.writers[addr] = nsq.NewWriter(*heartbeatInterval)
+	}
+	return p
+}
+
+func (p *Publisher) Publish(addr string, topic string, body [][]byte) error {
+	w := p.writers[addr]
+
+	frameType, data, err := w.MultiPublish(topic, body)
+	if err == nsq.ErrNotConnected {
+		p.Lock()
+		// another publisher may have beaten us to it
+		frameType, data, err = w.MultiPublish(topic, body)
+		if err == nsq.ErrNotConnected {
+			err = p.connect(w, addr)
+			if err == nil {
+				frameType, data, err = w.MultiPublish(topic, body)
+			}
+		}
+		p.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to MPUB to %s - %s", addr, err.Error())
+	}
+	if frameType != nsq.FrameTypeResponse || !bytes.Equal(data, []byte("OK")) {
+		return fmt.Errorf("failed to MPUB to %s - %s", addr, data)
+	}
+	return nil
+}
+
+// connect (re)establishes the connection of a Writer, waiting for the
+// transactions of its previous connection to be cleaned up if necessary
+func (p *Publisher) connect(w *nsq.Writer, addr string) error {
+	var err error
+	for i := 0; i < connectRetries; i++ {
+		err = w.ConnectToNSQ(addr)
+		if err != nsq.ErrTransactionsNotCleaned {
+			break
+		}
+		time.Sleep(connectRetryWait)
+	}
+	return err
+}
+
+func (p *Publisher) Stop() {
+	for _, w := range p.writers {
+		w.Stop()
+	}
+}
+
+type Message struct {
+	*nsq.Message
+	responseChannel chan *nsq.FinishedMessage
+}
+
+// PublishHandler accumulates messages and publishes them in batches,
+// FINishing only once the destination has responded OK
+//
+// since messages remain in flight until then, the source's RDY count
+// (--max-in-flight) limits how far ahead of the destination we can get
+type PublishHandler struct {
+	publisher *Publisher
+	addresses util.StringArray
+	counter   uint64
+	mode      int
+	hostPool  hostpool.HostPool
+	id        int
+	msgChan   chan *Message
+
+	numBatches int
+	numMsgs    int
+	numErrors  int
+}
+
+func (ph *PublishHandler) HandleMessage(m *nsq.Message, responseChannel chan *nsq.FinishedMessage) {
+	ph.msgChan <- &Message{m, responseChannel}
+}
+
+func (ph *PublishHandler) router(exitChan chan int, wg *sync.WaitGroup) {
+	batch := make([]*Message, 0, *batchSize)
+	ticker := time.NewTicker(*batchTimeout)
+
+	for {
+		select {
+		case m := <-ph.msgChan:
+			batch = append(batch, m)
+			if len(batch) < *batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-exitChan:
+			// the connections are gone by now so anything left in the
+			// batch is dropped (nsqd requeues it once its timeout expires)
+			if len(batch) > 0 {
+				log.Printf("handler(%d): dropping %d unpublished messages", ph.id, len(batch))
+			}
+			ticker.Stop()
+			wg.Done()
+			return
+		}
+		ph.publishBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+func (ph *PublishHandler) publishBatch(batch []*Message) {
+	var err error
+
+	body := make([][]byte, 0, len(batch))
+	for _, m := range batch {
+		body = append(body, m.Body)
+	}
+
+	switch ph.mode {
+	case ModeRoundRobin:
+		idx := ph.counter % uint64(len(ph.addresses))
+		err = ph.publisher.Publish(ph.addresses[idx], *destTopic, body)
+		ph.counter++
+	case ModeHostPool:
+		hostPoolResponse := ph.hostPool.Get()
+		err = ph.publisher.Publish(hostPoolResponse.Host(), *destTopic, body)
+		hostPoolResponse.Mark(err)
+	}
+
+	if err != nil {
+		log.Printf("ERROR: handler(%d): %s", ph.id, err.Error())
+		ph.numErrors++
+	}
+
+	// a failed batch is requeued (with the Reader's default backoff/requeue behavior)
+	for _, m := range batch {
+		m.responseChannel <- &nsq.FinishedMessage{Id: m.Id, RequeueDelayMs: 0, Success: err == nil}
+	}
+
+	ph.numBatches++
+	ph.numMsgs += len(batch)
+	if *statusEvery > 0 && ph.numBatches >= *statusEvery {
+		log.Printf("handler(%d): published %d batches (%d messages) - %d errors",
+			ph.id, ph.numBatches, ph.numMsgs, ph.numErrors)
+		ph.numBatches = 0
+		ph.numMsgs = 0
+		ph.numErrors = 0
+	}
+}
+
+func main() {
+	var selectedMode int
+
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("nsq_to_nsq v%s\n", util.BINARY_VERSION)
+		return
+	}
+
+	if *topic == "" || *channel == "" {
+		log.Fatalf("--topic and --channel are required")
+	}
+
+	if *destTopic == "" {
+		*destTopic = *topic
+	}
+
+	if !nsq.IsValidTopicName(*destTopic) {
+		log.Fatalf("--destination-topic is invalid")
+	}
+
+	if *maxInFlight <= 0 {
+		log.Fatalf("--max-in-flight must be > 0")
+	}
+
+	if *numPublishers <= 0 {
+		log.Fatalf("--n must be > 0")
+	}
+
+	if *batchSize <= 0 {
+		log.Fatalf("--batch-size must be > 0")
+	}
+
+	// each publisher holds up to --batch-size messages in flight so
+	// anything larger would only ever be flushed by --batch-timeout
+	if *batchSize**numPublishers > *maxInFlight {
+		log.Printf("WARNING: --batch-size * --n (%d) exceeds --max-in-flight (%d)",
+			*batchSize**numPublishers, *maxInFlight)
+	}
+
+	if *batchTimeout <= 0 {
+		log.Fatalf("--batch-timeout must be > 0")
+	}
+
+	if len(nsqdTCPAddrs) == 0 && len(lookupdHTTPAddrs) == 0 {
+		log.Fatalf("--nsqd-tcp-address or --lookupd-http-address required")
+	}
+	if len(nsqdTCPAddrs) > 0 && len(lookupdHTTPAddrs) > 0 {
+		log.Fatalf("use --nsqd-tcp-address or --lookupd-http-address not both")
+	}
+
+	if len(destNsqdTCPAddrs) == 0 {
+		log.Fatalf("--destination-nsqd-tcp-address required")
+	}
+
+	switch *mode {
+	case "round-robin":
+		selectedMode = ModeRoundRobin
+	case "hostpool":
+		selectedMode = ModeHostPool
+	default:
+		log.Fatalf("ERROR: invalid --mode %s", *mode)
+	}
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	publisher := NewPublisher(destNsqdTCPAddrs)
+
+	r, err := nsq.NewReader(*topic, *channel)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	r.SetMaxInFlight(*maxInFlight)
+	r.SetMaxBackoffDuration(*maxBackoffDuration)
+	r.VerboseLogging = *verbose
+
+	wg := &sync.WaitGroup{}
+	exitChan := make(chan int)
+	for i := 0; i < *numPublishers; i++ {
+		handler := &PublishHandler{
+			publisher: publisher,
+			addresses: destNsqdTCPAddrs,
+			mode:      selectedMode,
+			hostPool:  hostpool.New(destNsqdTCPAddrs),
+			id:        i,
+			msgChan:   make(chan *Message),
+		}
+		wg.Add(1)
+		go handler.router(exitChan, wg)
+		r.AddAsyncHandler(handler)
+	}
+
+	for _, addrString := range nsqdTCPAddrs {
+		err := r.ConnectToNSQ(addrString)
+		if err != nil {
+			log.Fatalf("%s", err.Error())
+		}
+	}
+
+	for _, addrString := range lookupdHTTPAddrs {
+		log.Printf("lookupd addr %s", addrString)
+		err := r.ConnectToLookupd(addrString)
+		if err != nil {
+			log.Fatalf("%s", err.Error())
+		}
+	}
+
+	for {
+		select {
+		case <-r.ExitChan:
+			close(exitChan)
+			wg.Wait()
+			publisher.Stop()
+			return
+		case <-termChan:
+			r.Stop()
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
 var byteSpace = []byte(" ")
@@ -123,6 +124,13 @@ func Publish(topic string, body []byte) *Command {
 	return &Command{[]byte("PUB"), params, body}
 }
 
+// DeferredPublish creates a new Command to write a message to a given topic
+// where the message will not be delivered to consumers until the specified delay has elapsed
+func DeferredPublish(topic string, delay time.Duration, body []byte) *Command {
+	var params = [][]byte{[]byte(topic), []byte(strconv.Itoa(int(delay / time.Millisecond)))}
+	return &Command{[]byte("DPUB"), params, body}
+}
+
 // MultiPublish creates a new Command to write more than one message to a given topic.
 // This is useful for high-throughput situations to avoid roundtrips and saturate the pipe.
 func MultiPublish(topic string, bodies [][]byte) (*Command, error) {
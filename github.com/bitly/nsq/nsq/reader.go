@@ -3,9 +3,13 @@ package nsq
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/mreiferson/go-snappystream"
+	"io"
 	"log"
 	"math"
 	"math/rand"
@@ -69,7 +73,11 @@ type incomingMessage struct {
 
 type nsqConn struct {
 	net.Conn
-	r                *bufio.Reader
+	tlsConn          *tls.Conn
+	flateWriter      *flate.Writer
+	r                io.Reader
+	w                io.Writer
+	writeMtx         sync.Mutex
 	addr             string
 	stopFlag         int32
 	finishedMessages chan *FinishedMessage
@@ -97,7 +105,8 @@ func newNSQConn(addr string, readTimeout time.Duration, writeTimeout time.Durati
 
 	nc := &nsqConn{
 		Conn:             conn,
-		r:                bufio.NewReader(conn),
+		r:                conn,
+		w:                conn,
 		addr:             addr,
 		finishedMessages: make(chan *FinishedMessage),
 		readTimeout:      readTimeout,
@@ -130,17 +139,65 @@ func (c *nsqConn) Read(p []byte) (int, error) {
 
 func (c *nsqConn) Write(p []byte) (int, error) {
 	c.SetWriteDeadline(time.Now().Add(c.writeTimeout))
-	return c.Conn.Write(p)
+	return c.w.Write(p)
 }
 
 func (c *nsqConn) sendCommand(buf *bytes.Buffer, cmd *Command) error {
+	// commands are sent from several goroutines and a compressed stream
+	// must not interleave them
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
 	buf.Reset()
 	err := cmd.Write(buf)
 	if err != nil {
 		return err
 	}
 	_, err = buf.WriteTo(c)
-	return err
+	if err != nil {
+		return err
+	}
+	// the DEFLATE stream has its own internal buffer
+	if c.flateWriter != nil {
+		return c.flateWriter.Flush()
+	}
+	return nil
+}
+
+func (c *nsqConn) upgradeTLS(conf *tls.Config) error {
+	c.tlsConn = tls.Client(c.Conn, conf)
+	err := c.tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+	c.r = c.tlsConn
+	c.w = c.tlsConn
+	return readUpgradeResponse(c)
+}
+
+func (c *nsqConn) upgradeDeflate(level int) error {
+	conn := net.Conn(c.Conn)
+	if c.tlsConn != nil {
+		conn = c.tlsConn
+	}
+	fw, err := flate.NewWriter(conn, level)
+	if err != nil {
+		return err
+	}
+	c.flateWriter = fw
+	c.r = flate.NewReader(conn)
+	c.w = fw
+	return readUpgradeResponse(c)
+}
+
+func (c *nsqConn) upgradeSnappy() error {
+	conn := net.Conn(c.Conn)
+	if c.tlsConn != nil {
+		conn = c.tlsConn
+	}
+	c.r = snappystream.NewReader(conn, snappystream.SkipVerifyChecksum)
+	c.w = snappystream.NewWriter(conn)
+	return readUpgradeResponse(c)
 }
 
 // Reader is a high-level type to consume from NSQ.
@@ -171,6 +228,13 @@ type Reader struct {
 	MessagesRequeued    uint64        // an atomic counter - # of messages REQueued
 	ExitChan            chan int      // read from this channel to block your main loop
 
+	TLSv1        bool        // negotiate a TLS upgrade of connections to nsqd
+	TLSConfig    *tls.Config // the client configuration used for TLS (defaults to tls.Config{})
+	Deflate      bool        // negotiate DEFLATE compression of connections to nsqd
+	DeflateLevel int         // the desired DEFLATE compression level (1-9, 0 == nsqd default)
+	Snappy       bool        // negotiate snappy compression of connections to nsqd
+	SampleRate   int32       // the percentage (1-99) of messages nsqd should deliver, 0 == all
+
 	// internal variables
 	maxBackoffDuration time.Duration
 	maxBackoffCount    int32
@@ -418,6 +482,11 @@ func (q *Reader) ConnectToNSQ(addr string) error {
 	ci["short_id"] = q.ShortIdentifier
 	ci["long_id"] = q.LongIdentifier
 	ci["feature_negotiation"] = true
+	ci["tls_v1"] = q.TLSv1
+	ci["deflate"] = q.Deflate
+	ci["deflate_level"] = q.DeflateLevel
+	ci["snappy"] = q.Snappy
+	ci["sample_rate"] = q.SampleRate
 	cmd, err := Identify(ci)
 	if err != nil {
 		connection.Close()
@@ -438,16 +507,21 @@ func (q *Reader) ConnectToNSQ(addr string) error {
 
 	frameType, data, err := UnpackResponse(resp)
 	if err != nil {
+		connection.Close()
 		return fmt.Errorf("[%s] error (%s) unpacking response %d %s", connection, err.Error(), frameType, data)
 	}
 
+	if frameType == FrameTypeError {
+		connection.Close()
+		return fmt.Errorf("[%s] error from nsqd during IDENTIFY - %s", connection, data)
+	}
+
 	// check to see if the server was able to respond w/ capabilities
 	if data[0] == '{' {
-		resp := struct {
-			MaxRdyCount int64 `json:"max_rdy_count"`
-		}{}
+		resp := identifyResponse{}
 		err := json.Unmarshal(data, &resp)
 		if err != nil {
+			connection.Close()
 			return fmt.Errorf("[%s] error (%s) unmarshaling IDENTIFY response %s", connection, err.Error(), data)
 		}
 		connection.maxRdyCount = resp.MaxRdyCount
@@ -455,8 +529,18 @@ func (q *Reader) ConnectToNSQ(addr string) error {
 			log.Printf("[%s] max RDY count %d < reader max in flight %d, truncation possible",
 				connection, resp.MaxRdyCount, q.maxInFlight)
 		}
+
+		err = upgradeConn(connection, &resp, q.TLSConfig)
+		if err != nil {
+			connection.Close()
+			return err
+		}
 	}
 
+	// reads are unbuffered until IDENTIFY is done, nsqd starts sending over
+	// an upgraded stream right after its response
+	connection.r = bufio.NewReader(connection.r)
+
 	cmd = Subscribe(q.TopicName, q.ChannelName)
 	err = connection.sendCommand(&buf, cmd)
 	if err != nil {
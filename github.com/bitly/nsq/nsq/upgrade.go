@@ -0,0 +1,102 @@
+package nsq
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+)
+
+// identifyResponse is the JSON payload nsqd responds with to an IDENTIFY
+// when feature_negotiation is enabled
+type identifyResponse struct {
+	MaxRdyCount  int64 `json:"max_rdy_count"`
+	TLSv1        bool  `json:"tls_v1"`
+	Deflate      bool  `json:"deflate"`
+	DeflateLevel int   `json:"deflate_level"`
+	Snappy       bool  `json:"snappy"`
+}
+
+// upgrader is implemented by connections that can be upgraded in place
+// after negotiating features with nsqd via IDENTIFY
+type upgrader interface {
+	RemoteAddr() net.Addr
+	upgradeTLS(conf *tls.Config) error
+	upgradeDeflate(level int) error
+	upgradeSnappy() error
+}
+
+// upgradeConn performs the upgrades that nsqd agreed to in the IDENTIFY
+// response, in the same order nsqd applies them (TLS first, then compression)
+func upgradeConn(c upgrader, resp *identifyResponse, tlsConfig *tls.Config) error {
+	if resp.TLSv1 {
+		log.Printf("[%s] upgrading to TLS", c.RemoteAddr())
+		err := c.upgradeTLS(clientTLSConfig(c.RemoteAddr(), tlsConfig))
+		if err != nil {
+			return errors.New("TLS upgrade failed - " + err.Error())
+		}
+	}
+
+	if resp.Snappy {
+		log.Printf("[%s] upgrading to snappy", c.RemoteAddr())
+		err := c.upgradeSnappy()
+		if err != nil {
+			return errors.New("snappy upgrade failed - " + err.Error())
+		}
+	}
+
+	if resp.Deflate {
+		log.Printf("[%s] upgrading to deflate", c.RemoteAddr())
+		err := c.upgradeDeflate(resp.DeflateLevel)
+		if err != nil {
+			return errors.New("deflate upgrade failed - " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// readUpgradeResponse reads the acknowledgement nsqd sends over
+// a freshly upgraded stream
+func readUpgradeResponse(r io.Reader) error {
+	resp, err := ReadResponse(r)
+	if err != nil {
+		return err
+	}
+
+	frameType, data, err := UnpackResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	if frameType != FrameTypeResponse || !bytes.Equal(data, []byte("OK")) {
+		return errors.New("invalid response from upgrade")
+	}
+
+	return nil
+}
+
+// clientTLSConfig returns the supplied config (or a default one) ensuring
+// that ServerName is set for certificate verification
+func clientTLSConfig(addr net.Addr, tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig != nil && (tlsConfig.ServerName != "" || tlsConfig.InsecureSkipVerify) {
+		return tlsConfig
+	}
+
+	// copy the fields that matter to a client rather than the whole struct,
+	// the caller's config is left untouched
+	conf := &tls.Config{}
+	if tlsConfig != nil {
+		conf.Rand = tlsConfig.Rand
+		conf.Time = tlsConfig.Time
+		conf.Certificates = tlsConfig.Certificates
+		conf.NameToCertificate = tlsConfig.NameToCertificate
+		conf.RootCAs = tlsConfig.RootCAs
+		conf.NextProtos = tlsConfig.NextProtos
+		conf.CipherSuites = tlsConfig.CipherSuites
+	}
+	conf.ServerName, _, _ = net.SplitHostPort(addr.String())
+	return conf
+}
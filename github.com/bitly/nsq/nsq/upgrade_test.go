@@ -0,0 +1,396 @@
+package nsq
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/mreiferson/go-snappystream"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeNSQD accepts a single connection and speaks just enough of the V2
+// protocol (including the IDENTIFY feature negotiation) to exercise the
+// client side of connection upgrades
+type fakeNSQD struct {
+	t         *testing.T
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	conn        net.Conn
+	r           *bufio.Reader
+	w           io.Writer
+	flateWriter *flate.Writer
+
+	identify map[string]interface{}
+	sent     bool
+	cmds     chan string
+	bodies   chan []byte
+}
+
+func newFakeNSQD(t *testing.T, tlsConfig *tls.Config) *fakeNSQD {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen - %s", err.Error())
+	}
+	n := &fakeNSQD{
+		t:         t,
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		cmds:      make(chan string, 16),
+		bodies:    make(chan []byte, 16),
+	}
+	go n.serve()
+	return n
+}
+
+func (n *fakeNSQD) Addr() string {
+	return n.listener.Addr().String()
+}
+
+func (n *fakeNSQD) Close() {
+	n.listener.Close()
+}
+
+func (n *fakeNSQD) send(frameType int32, data []byte) error {
+	_, err := SendFramedResponse(n.w, frameType, data)
+	if err != nil {
+		return err
+	}
+	if n.flateWriter != nil {
+		return n.flateWriter.Flush()
+	}
+	return nil
+}
+
+func (n *fakeNSQD) readBody() ([]byte, error) {
+	var size int32
+	err := binary.Read(n.r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(n.r, body)
+	return body, err
+}
+
+func (n *fakeNSQD) serve() {
+	defer close(n.cmds)
+
+	conn, err := n.listener.Accept()
+	if err != nil {
+		return
+	}
+	n.conn = conn
+	n.r = bufio.NewReader(conn)
+	n.w = conn
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(n.r, magic)
+	if err != nil || !bytes.Equal(magic, MagicV2) {
+		n.t.Errorf("bad magic %q - %v", magic, err)
+		return
+	}
+
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		params := bytes.Split([]byte(line[:len(line)-1]), []byte(" "))
+		cmd := string(params[0])
+
+		switch cmd {
+		case "IDENTIFY":
+			err = n.negotiate()
+		case "PUB":
+			var body []byte
+			body, err = n.readBody()
+			if err == nil {
+				n.bodies <- body
+				err = n.send(FrameTypeResponse, []byte("OK"))
+			}
+		case "SUB":
+			err = n.send(FrameTypeResponse, []byte("OK"))
+		case "RDY":
+			if n.sent {
+				break
+			}
+			n.sent = true
+			var id MessageID
+			copy(id[:], "0123456789abcdef")
+			msg := NewMessage(id, []byte("upgraded"))
+			var buf bytes.Buffer
+			msg.Write(&buf)
+			err = n.send(FrameTypeMessage, buf.Bytes())
+		case "CLS":
+			err = n.send(FrameTypeResponse, []byte("CLOSE_WAIT"))
+		}
+		if err != nil {
+			n.t.Errorf("failed to handle %s - %s", cmd, err.Error())
+			return
+		}
+
+		n.cmds <- cmd
+	}
+}
+
+// negotiate agrees to every feature the client asks for (TLS only when
+// a certificate is configured) and upgrades in the same order as nsqd
+func (n *fakeNSQD) negotiate() error {
+	body, err := n.readBody()
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, &n.identify)
+	if err != nil {
+		return err
+	}
+
+	resp := identifyResponse{
+		MaxRdyCount:  2500,
+		TLSv1:        n.identify["tls_v1"] == true && n.tlsConfig != nil,
+		Deflate:      n.identify["deflate"] == true,
+		DeflateLevel: 6,
+		Snappy:       n.identify["snappy"] == true,
+	}
+	data, _ := json.Marshal(resp)
+	err = n.send(FrameTypeResponse, data)
+	if err != nil {
+		return err
+	}
+
+	conn := n.conn
+	if resp.TLSv1 {
+		tlsConn := tls.Server(conn, n.tlsConfig)
+		err = tlsConn.Handshake()
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+		n.r = bufio.NewReader(conn)
+		n.w = conn
+		err = n.send(FrameTypeResponse, []byte("OK"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if resp.Snappy {
+		n.r = bufio.NewReader(snappystream.NewReader(conn, snappystream.SkipVerifyChecksum))
+		n.w = snappystream.NewWriter(conn)
+		err = n.send(FrameTypeResponse, []byte("OK"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if resp.Deflate {
+		n.flateWriter, _ = flate.NewWriter(conn, resp.DeflateLevel)
+		n.r = bufio.NewReader(flate.NewReader(conn))
+		n.w = n.flateWriter
+		err = n.send(FrameTypeResponse, []byte("OK"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expectCommand waits for cmd, skipping the RDY updates a reader may send
+// in between
+func (n *fakeNSQD) expectCommand(cmd string) {
+	for {
+		select {
+		case c := <-n.cmds:
+			if c == cmd {
+				return
+			}
+			if c != "RDY" {
+				n.t.Fatalf("expected %s, got %s", cmd, c)
+			}
+		case <-time.After(2 * time.Second):
+			n.t.Fatalf("timed out waiting for %s", cmd)
+		}
+	}
+}
+
+func mustTLSConfig(t *testing.T) *tls.Config {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key - %s", err.Error())
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"nsq"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate - %s", err.Error())
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+	}
+}
+
+func testWriterUpgrade(t *testing.T, w *Writer, serverTLS *tls.Config) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	n := newFakeNSQD(t, serverTLS)
+	defer n.Close()
+
+	err := w.ConnectToNSQ(n.Addr())
+	if err != nil {
+		t.Fatalf("failed to connect - %s", err.Error())
+	}
+	defer w.Stop()
+	n.expectCommand("IDENTIFY")
+
+	frameType, data, err := w.Publish("write_test", []byte("upgraded"))
+	if err != nil {
+		t.Fatalf("failed to publish - %s", err.Error())
+	}
+	if frameType != FrameTypeResponse || string(data) != "OK" {
+		t.Fatalf("unexpected publish response %d %s", frameType, data)
+	}
+	n.expectCommand("PUB")
+	if body := <-n.bodies; string(body) != "upgraded" {
+		t.Fatalf("unexpected body %s", body)
+	}
+}
+
+func TestWriterTLS(t *testing.T) {
+	w := NewWriter(0)
+	w.TLSv1 = true
+	w.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	testWriterUpgrade(t, w, mustTLSConfig(t))
+}
+
+func TestWriterSnappy(t *testing.T) {
+	w := NewWriter(0)
+	w.Snappy = true
+	testWriterUpgrade(t, w, nil)
+}
+
+func TestWriterDeflate(t *testing.T) {
+	w := NewWriter(0)
+	w.Deflate = true
+	testWriterUpgrade(t, w, nil)
+}
+
+func TestWriterTLSDeflate(t *testing.T) {
+	w := NewWriter(0)
+	w.TLSv1 = true
+	w.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	w.Deflate = true
+	testWriterUpgrade(t, w, mustTLSConfig(t))
+}
+
+type upgradeHandler struct {
+	bodies chan string
+}
+
+func (h *upgradeHandler) HandleMessage(message *Message) error {
+	h.bodies <- string(message.Body)
+	return nil
+}
+
+func testReaderUpgrade(t *testing.T, q *Reader, serverTLS *tls.Config) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	n := newFakeNSQD(t, serverTLS)
+	defer n.Close()
+
+	h := &upgradeHandler{bodies: make(chan string, 1)}
+	q.AddHandler(h)
+
+	err := q.ConnectToNSQ(n.Addr())
+	if err != nil {
+		t.Fatalf("failed to connect - %s", err.Error())
+	}
+	n.expectCommand("IDENTIFY")
+	n.expectCommand("SUB")
+	n.expectCommand("RDY")
+
+	select {
+	case body := <-h.bodies:
+		if body != "upgraded" {
+			t.Fatalf("unexpected body %s", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	n.expectCommand("FIN")
+
+	q.Stop()
+	select {
+	case <-q.ExitChan:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for reader to exit")
+	}
+}
+
+func TestReaderTLS(t *testing.T) {
+	q, _ := NewReader("reader_test", "ch")
+	q.TLSv1 = true
+	q.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	testReaderUpgrade(t, q, mustTLSConfig(t))
+}
+
+func TestReaderSnappy(t *testing.T) {
+	q, _ := NewReader("reader_test", "ch")
+	q.Snappy = true
+	testReaderUpgrade(t, q, nil)
+}
+
+func TestReaderDeflate(t *testing.T) {
+	q, _ := NewReader("reader_test", "ch")
+	q.Deflate = true
+	testReaderUpgrade(t, q, nil)
+}
+
+func TestReaderTLSSnappy(t *testing.T) {
+	q, _ := NewReader("reader_test", "ch")
+	q.TLSv1 = true
+	q.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	q.Snappy = true
+	testReaderUpgrade(t, q, mustTLSConfig(t))
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	addr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:4150")
+
+	conf := clientTLSConfig(addr, nil)
+	if conf.ServerName != "127.0.0.1" {
+		t.Fatalf("expected ServerName to default to the host, got %q", conf.ServerName)
+	}
+
+	orig := &tls.Config{NextProtos: []string{"nsq"}}
+	conf = clientTLSConfig(addr, orig)
+	if conf == orig || orig.ServerName != "" {
+		t.Fatalf("expected the supplied config to be copied, not modified")
+	}
+	if conf.ServerName != "127.0.0.1" || len(conf.NextProtos) != 1 {
+		t.Fatalf("unexpected config %+v", conf)
+	}
+}
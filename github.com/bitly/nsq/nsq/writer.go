@@ -3,8 +3,13 @@ package nsq
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/mreiferson/go-snappystream"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -13,8 +18,19 @@ import (
 	"time"
 )
 
+// returned from Writer publishing methods when there is no connection to nsqd
+var ErrNotConnected = errors.New("not connected")
+
+// returned from Writer.ConnectToNSQ while the transactions of a previous
+// connection have not yet been failed, the caller should retry shortly
+var ErrTransactionsNotCleaned = errors.New("writer transactions not cleaned")
+
 type Writer struct {
 	net.Conn
+	tlsConn           *tls.Conn
+	flateWriter       *flate.Writer
+	r                 io.Reader
+	w                 io.Writer
 	transactionChan   chan *writerTransaction
 	dataChan          chan []byte
 	WriteTimeout      time.Duration
@@ -26,6 +42,12 @@ type Writer struct {
 	ShortIdentifier   string
 	LongIdentifier    string
 	exitChan          chan int
+
+	TLSv1        bool        // negotiate a TLS upgrade of the connection to nsqd
+	TLSConfig    *tls.Config // the client configuration used for TLS (defaults to tls.Config{})
+	Deflate      bool        // negotiate DEFLATE compression of the connection to nsqd
+	DeflateLevel int         // the desired DEFLATE compression level (1-9, 0 == nsqd default)
+	Snappy       bool        // negotiate snappy compression of the connection to nsqd
 }
 
 type writerTransaction struct {
@@ -68,6 +90,13 @@ func (this *Writer) Publish(topic string, body []byte) (int32, []byte, error) {
 	return this.sendCommand(cmd)
 }
 
+// DeferredPublish writes a message to the topic that will only be delivered
+// to consumers once the specified delay has elapsed (nsqd 0.2.22+)
+func (this *Writer) DeferredPublish(topic string, delay time.Duration, body []byte) (int32, []byte, error) {
+	cmd := DeferredPublish(topic, delay, body)
+	return this.sendCommand(cmd)
+}
+
 func (this *Writer) MultiPublish(topic string, body [][]byte) (int32, []byte, error) {
 	cmd, err := MultiPublish(topic, body)
 	if err != nil {
@@ -78,7 +107,7 @@ func (this *Writer) MultiPublish(topic string, body [][]byte) (int32, []byte, er
 
 func (this *Writer) sendCommand(cmd *Command) (int32, []byte, error) {
 	if atomic.LoadInt32(&this.state) != StateConnected {
-		return -1, nil, errors.New("not connected")
+		return -1, nil, ErrNotConnected
 	}
 	t := &writerTransaction{
 		cmd:       cmd,
@@ -95,7 +124,7 @@ func (this *Writer) ConnectToNSQ(addr string) error {
 		return errors.New("writer stopped")
 	}
 	if atomic.LoadInt32(&this.transactionStat) != 0 {
-		return errors.New("writer transactions not cleaned")
+		return ErrTransactionsNotCleaned
 	}
 	if !atomic.CompareAndSwapInt32(&this.state, StateDisconnected, StateConnected) {
 		return nil
@@ -111,28 +140,119 @@ func (this *Writer) ConnectToNSQ(addr string) error {
 		this.close()
 		return err
 	}
-	atomic.StoreInt32(&this.state, StateConnected)
+	// reads are unbuffered until IDENTIFY is done, nsqd starts sending over
+	// an upgraded stream right after its response
+	this.r = this.Conn
+	this.w = this.Conn
 	this.exitChan = make(chan int)
 	ci := make(map[string]interface{})
 	ci["short_id"] = this.ShortIdentifier
 	ci["long_id"] = this.LongIdentifier
 	ci["heartbeat_interval"] = this.HeartbeatInterval
 	ci["feature_negotiation"] = true
+	ci["tls_v1"] = this.TLSv1
+	ci["deflate"] = this.Deflate
+	ci["deflate_level"] = this.DeflateLevel
+	ci["snappy"] = this.Snappy
 	cmd, err := Identify(ci)
 	if err != nil {
 		this.close()
 		return fmt.Errorf("[%s] failed to create identify command - %s", this.RemoteAddr(), err.Error())
 	}
+	// IDENTIFY is performed synchronously (before the read loop and router
+	// are started) because the connection may be upgraded in place
+	err = this.identify(cmd)
+	if err != nil {
+		this.close()
+		return err
+	}
+	this.r = bufio.NewReader(this.r)
 	go this.readLoop()
 	go this.messageRouter()
-	frameType, data, err := this.sendCommand(cmd)
+	return nil
+}
+
+func (this *Writer) identify(cmd *Command) error {
+	var buf bytes.Buffer
+	this.SetWriteDeadline(time.Now().Add(this.WriteTimeout))
+	if err := this.writeCommand(&buf, cmd); err != nil {
+		return fmt.Errorf("[%s] failed to identify - %s", this.RemoteAddr(), err.Error())
+	}
+	resp, err := ReadResponse(this.r)
+	if err != nil {
+		return fmt.Errorf("[%s] error reading response %s", this.RemoteAddr(), err.Error())
+	}
+	frameType, data, err := UnpackResponse(resp)
+	if err != nil {
+		return fmt.Errorf("[%s] error (%s) unpacking response %d %s", this.RemoteAddr(), err.Error(), frameType, data)
+	}
 	if frameType == FrameTypeError {
-		if err == nil {
-			this.close()
-			err = fmt.Errorf("[%s] error failed to identify - %s", this.RemoteAddr(), string(data))
+		return fmt.Errorf("[%s] error failed to identify - %s", this.RemoteAddr(), string(data))
+	}
+	// check to see if the server was able to respond w/ capabilities
+	if data[0] == '{' {
+		resp := identifyResponse{}
+		err := json.Unmarshal(data, &resp)
+		if err != nil {
+			return fmt.Errorf("[%s] error (%s) unmarshaling IDENTIFY response %s", this.RemoteAddr(), err.Error(), data)
 		}
+		return upgradeConn(this, &resp, this.TLSConfig)
+	}
+	return nil
+}
+
+func (this *Writer) upgradeTLS(conf *tls.Config) error {
+	this.tlsConn = tls.Client(this.Conn, conf)
+	err := this.tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+	this.r = this.tlsConn
+	this.w = this.tlsConn
+	return readUpgradeResponse(this.r)
+}
+
+func (this *Writer) upgradeDeflate(level int) error {
+	conn := net.Conn(this.Conn)
+	if this.tlsConn != nil {
+		conn = this.tlsConn
+	}
+	fw, err := flate.NewWriter(conn, level)
+	if err != nil {
+		return err
 	}
-	return err
+	this.flateWriter = fw
+	this.r = flate.NewReader(conn)
+	this.w = fw
+	return readUpgradeResponse(this.r)
+}
+
+func (this *Writer) upgradeSnappy() error {
+	conn := net.Conn(this.Conn)
+	if this.tlsConn != nil {
+		conn = this.tlsConn
+	}
+	this.r = snappystream.NewReader(conn, snappystream.SkipVerifyChecksum)
+	this.w = snappystream.NewWriter(conn)
+	return readUpgradeResponse(this.r)
+}
+
+// writeCommand serializes cmd in a single write to the (possibly upgraded) connection
+func (this *Writer) writeCommand(buf *bytes.Buffer, cmd *Command) error {
+	buf.Reset()
+	err := cmd.Write(buf)
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(this.w)
+	if err != nil {
+		return err
+	}
+	// the DEFLATE stream has its own internal buffer
+	if this.flateWriter != nil {
+		return this.flateWriter.Flush()
+	}
+	return nil
 }
 
 func (this *Writer) close() {
@@ -144,6 +264,7 @@ func (this *Writer) close() {
 
 func (this *Writer) messageRouter() {
 	var err error
+	var cmdBuf bytes.Buffer
 	defer this.transactionCleanup()
 	atomic.StoreInt32(&this.transactionStat, 1)
 	for {
@@ -151,7 +272,7 @@ func (this *Writer) messageRouter() {
 		case t := <-this.transactionChan:
 			this.transactions = append(this.transactions, t)
 			this.SetWriteDeadline(time.Now().Add(this.WriteTimeout))
-			if err = t.cmd.Write(this.Conn); err != nil {
+			if err = this.writeCommand(&cmdBuf, t.cmd); err != nil {
 				log.Printf("[%s] error writing %s", this.RemoteAddr(), err.Error())
 				this.close()
 				return
@@ -165,7 +286,7 @@ func (this *Writer) messageRouter() {
 			if frameType == FrameTypeResponse &&
 				bytes.Equal(data, []byte("_heartbeat_")) {
 				log.Printf("[%s] received heartbeat", this.RemoteAddr())
-				if err := this.heartbeat(); err != nil {
+				if err := this.heartbeat(&cmdBuf); err != nil {
 					log.Printf("[%s] error sending heartbeat - %s", this.RemoteAddr(), err.Error())
 					this.close()
 					return
@@ -185,9 +306,9 @@ func (this *Writer) messageRouter() {
 }
 
 //send heartbeat
-func (this *Writer) heartbeat() error {
+func (this *Writer) heartbeat(buf *bytes.Buffer) error {
 	this.SetWriteDeadline(time.Now().Add(this.WriteTimeout))
-	if err := Nop().Write(this.Conn); err != nil {
+	if err := this.writeCommand(buf, Nop()); err != nil {
 		return err
 	}
 	return nil
@@ -196,7 +317,7 @@ func (this *Writer) heartbeat() error {
 // cleanup transactions
 func (this *Writer) transactionCleanup() {
 	for _, t := range this.transactions {
-		t.err = errors.New("not connected")
+		t.err = ErrNotConnected
 		t.doneChan <- 1
 	}
 	this.transactions = this.transactions[:0]
@@ -204,9 +325,8 @@ func (this *Writer) transactionCleanup() {
 }
 
 func (this *Writer) readLoop() {
-	rbuf := bufio.NewReader(this.Conn)
 	for {
-		resp, err := ReadResponse(rbuf)
+		resp, err := ReadResponse(this.r)
 		if err != nil {
 			log.Printf("[%s] error reading response %s", this.RemoteAddr(), err.Error())
 			if !strings.Contains(err.Error(), "use of closed network connection") {
@@ -23,6 +23,7 @@ type Consumer interface {
 	Pause()
 	Close() error
 	TimedOutMessage()
+	Sampling() bool
 	Stats() ClientStats
 	Empty()
 }
@@ -73,6 +74,9 @@ type Channel struct {
 	messageCount  uint64
 	timeoutCount  uint64
 	bufferedCount int32
+
+	// end-to-end (PUB to FIN) latency, nil unless percentiles are configured
+	e2eProcessingLatencyStream *util.Quantile
 }
 
 type inFlightMessage struct {
@@ -101,12 +105,18 @@ func NewChannel(topicName string, channelName string, options *nsqdOptions,
 
 	c.initPQ()
 
+	if len(options.e2eProcessingLatencyPercentiles) > 0 {
+		c.e2eProcessingLatencyStream = util.NewQuantile(
+			options.e2eProcessingLatencyWindowTime,
+			options.e2eProcessingLatencyPercentiles)
+	}
+
 	if strings.HasSuffix(channelName, "#ephemeral") {
 		c.ephemeralChannel = true
 		c.backend = NewDummyBackendQueue()
 	} else {
 		c.backend = NewDiskQueue(backendName, options.dataPath, options.maxBytesPerFile,
-			options.syncEvery, options.syncTimeout)
+			options.maxDiskQueueMsgSize(), options.syncEvery, options.syncTimeout)
 	}
 
 	go c.messagePump()
@@ -300,6 +310,34 @@ func (c *Channel) PutMessage(msg *nsq.Message) error {
 	return nil
 }
 
+// ReturnMessage hands back a message that a sampling client did not choose
+// so that another client on the channel receives it instead
+//
+// an error is returned (and the message should be dropped) when every other
+// client is sampling too, otherwise the message would be passed around
+// until one of them happens to choose it
+func (c *Channel) ReturnMessage(client Consumer, msg *nsq.Message) error {
+	c.RLock()
+	defer c.RUnlock()
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	found := false
+	for _, cli := range c.clients {
+		if cli != client && !cli.Sampling() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("no other clients to receive message")
+	}
+	// it was never sent, so this was not an attempt
+	msg.Attempts--
+	c.incomingMsgChan <- msg
+	return nil
+}
+
 // TouchMessage resets the timeout for an in-flight message
 func (c *Channel) TouchMessage(client Consumer, id nsq.MessageID) error {
 	item, err := c.popInFlightMessage(client, id)
@@ -332,6 +370,11 @@ func (c *Channel) FinishMessage(client Consumer, id nsq.MessageID) error {
 		return err
 	}
 	c.removeFromInFlightPQ(item)
+
+	if c.e2eProcessingLatencyStream != nil {
+		msg := item.Value.(*inFlightMessage).msg
+		c.e2eProcessingLatencyStream.Insert(timestampNano(msg.Timestamp))
+	}
 	return nil
 }
 
@@ -638,3 +681,14 @@ exit:
 	log.Printf("CHANNEL(%s): closing ... pqueue worker", c.name)
 	ticker.Stop()
 }
+
+// timestampNano returns a message timestamp in nanoseconds
+//
+// messages written to disk before timestamps were switched to nanoseconds
+// carry a timestamp in seconds (a nanosecond timestamp is always > 1e12)
+func timestampNano(ts int64) int64 {
+	if ts < 1e12 {
+		return ts * int64(time.Second)
+	}
+	return ts
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/bitly/nsq/nsq"
+	"github.com/bitly/nsq/util"
 	"github.com/bmizerany/assert"
 	"io/ioutil"
 	"log"
@@ -155,3 +156,42 @@ func TestChannelEmptyConsumer(t *testing.T) {
 	}
 
 }
+
+func TestChannelE2eProcessingLatency(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewNsqdOptions()
+	options.e2eProcessingLatencyPercentiles = []float64{0.5, 0.99}
+	nsqd = NewNSQd(1, options)
+	defer nsqd.Exit()
+
+	topicName := "test_e2e_latency" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("channel")
+	client := NewClientV2(nil)
+
+	for i := 0; i < 100; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test"))
+		// pretend every message was published a second ago
+		msg.Timestamp = time.Now().Add(-time.Second).UnixNano()
+		if i%2 == 0 {
+			// as persisted by an nsqd that wrote timestamps in seconds
+			msg.Timestamp = time.Now().Add(-time.Second).Unix()
+		}
+		channel.StartInFlightTimeout(msg, client)
+		channel.FinishMessage(client, msg.Id)
+	}
+
+	stats := nsqd.getStats()
+	assert.Equal(t, len(stats), 1)
+
+	for _, result := range []*util.PercentileResult{stats[0].E2eProcessingLatency, stats[0].Channels[0].E2eProcessingLatency} {
+		assert.Equal(t, result.Count, 100)
+		assert.Equal(t, len(result.Percentiles), 2)
+		for _, p := range result.Percentiles {
+			assert.Equal(t, p.Value >= float64(time.Second), true)
+			assert.Equal(t, p.Value < float64(2*time.Second), true)
+		}
+	}
+}
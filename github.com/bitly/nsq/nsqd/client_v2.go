@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"compress/flate"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/bitly/nsq/nsq"
+	"github.com/mreiferson/go-snappystream"
 	"log"
+	"math/rand"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -19,18 +23,34 @@ type IdentifyDataV2 struct {
 	OutputBufferSize    int    `json:"output_buffer_size"`
 	OutputBufferTimeout int    `json:"output_buffer_timeout"`
 	FeatureNegotiation  bool   `json:"feature_negotiation"`
+	TLSv1               bool   `json:"tls_v1"`
+	Deflate             bool   `json:"deflate"`
+	DeflateLevel        int    `json:"deflate_level"`
+	Snappy              bool   `json:"snappy"`
+	SampleRate          int32  `json:"sample_rate"`
 }
 
+const defaultBufferSize = 16 * 1024
+
 type ClientV2 struct {
 	net.Conn
 	sync.Mutex
 
+	// connection state after an upgrade negotiated via IDENTIFY
+	tlsConn     *tls.Conn
+	flateWriter *flate.Writer
+
 	// buffered IO
 	Reader                        *bufio.Reader
 	Writer                        *bufio.Writer
+	OutputBufferSize              int
 	OutputBufferTimeout           *time.Ticker
 	OutputBufferTimeoutUpdateChan chan time.Duration
 
+	TLS     int32
+	Snappy  int32
+	Deflate int32
+
 	State           int32
 	ReadyCount      int64
 	LastReadyCount  int64
@@ -38,6 +58,7 @@ type ClientV2 struct {
 	MessageCount    uint64
 	FinishCount     uint64
 	RequeueCount    uint64
+	SampledCount    uint64
 	ConnectTime     time.Time
 	Channel         *Channel
 	ReadyStateChan  chan int
@@ -50,6 +71,9 @@ type ClientV2 struct {
 	lenBuf   [4]byte
 	lenSlice []byte
 
+	// the percentage (1-99) of messages to deliver, 0 delivers everything
+	SampleRate int32
+
 	// heartbeats are client configurable via IDENTIFY
 	Heartbeat           *time.Ticker
 	HeartbeatInterval   time.Duration
@@ -65,8 +89,9 @@ func NewClientV2(conn net.Conn) *ClientV2 {
 	c := &ClientV2{
 		Conn: conn,
 
-		Reader:                        bufio.NewReaderSize(conn, 16*1024),
-		Writer:                        bufio.NewWriterSize(conn, 16*1024),
+		Reader:                        bufio.NewReaderSize(conn, defaultBufferSize),
+		Writer:                        bufio.NewWriterSize(conn, defaultBufferSize),
+		OutputBufferSize:              defaultBufferSize,
 		OutputBufferTimeout:           time.NewTicker(5 * time.Millisecond),
 		OutputBufferTimeoutUpdateChan: make(chan time.Duration, 1),
 
@@ -104,7 +129,11 @@ func (c *ClientV2) Identify(data IdentifyDataV2) error {
 	if err != nil {
 		return err
 	}
-	return c.SetOutputBufferTimeout(data.OutputBufferTimeout)
+	err = c.SetOutputBufferTimeout(data.OutputBufferTimeout)
+	if err != nil {
+		return err
+	}
+	return c.SetSampleRate(data.SampleRate)
 }
 
 func (c *ClientV2) Stats() ClientStats {
@@ -118,7 +147,12 @@ func (c *ClientV2) Stats() ClientStats {
 		MessageCount:  atomic.LoadUint64(&c.MessageCount),
 		FinishCount:   atomic.LoadUint64(&c.FinishCount),
 		RequeueCount:  atomic.LoadUint64(&c.RequeueCount),
+		SampleRate:    atomic.LoadInt32(&c.SampleRate),
+		SampledCount:  atomic.LoadUint64(&c.SampledCount),
 		ConnectTime:   c.ConnectTime.Unix(),
+		TLS:           atomic.LoadInt32(&c.TLS) == 1,
+		Deflate:       atomic.LoadInt32(&c.Deflate) == 1,
+		Snappy:        atomic.LoadInt32(&c.Snappy) == 1,
 	}
 }
 
@@ -254,6 +288,7 @@ func (c *ClientV2) SetOutputBufferSize(desiredSize int) error {
 		if err != nil {
 			return err
 		}
+		c.OutputBufferSize = size
 		c.Writer = bufio.NewWriterSize(c.Conn, size)
 	}
 
@@ -284,3 +319,113 @@ func (c *ClientV2) SetOutputBufferTimeout(desiredTimeout int) error {
 
 	return nil
 }
+
+// UpgradeTLS performs the server side of a TLS handshake on the underlying
+// connection and swaps the buffered reader/writer to go through it
+func (c *ClientV2) UpgradeTLS() error {
+	c.Lock()
+	defer c.Unlock()
+
+	tlsConn := tls.Server(c.Conn, nsqd.tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+	err := tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+	// clear the handshake deadline, the IO loops manage their own
+	tlsConn.SetDeadline(time.Time{})
+	c.tlsConn = tlsConn
+
+	c.Reader = bufio.NewReaderSize(c.tlsConn, defaultBufferSize)
+	c.Writer = bufio.NewWriterSize(c.tlsConn, c.OutputBufferSize)
+
+	atomic.StoreInt32(&c.TLS, 1)
+
+	return nil
+}
+
+// UpgradeDeflate wraps the (possibly TLS) connection in a DEFLATE stream
+// compressed at the specified level
+func (c *ClientV2) UpgradeDeflate(level int) error {
+	c.Lock()
+	defer c.Unlock()
+
+	conn := c.rawConn()
+
+	fw, err := flate.NewWriter(conn, level)
+	if err != nil {
+		return err
+	}
+	c.flateWriter = fw
+
+	c.Reader = bufio.NewReaderSize(flate.NewReader(conn), defaultBufferSize)
+	c.Writer = bufio.NewWriterSize(fw, c.OutputBufferSize)
+
+	atomic.StoreInt32(&c.Deflate, 1)
+
+	return nil
+}
+
+// UpgradeSnappy wraps the (possibly TLS) connection in a snappy framed stream
+func (c *ClientV2) UpgradeSnappy() error {
+	c.Lock()
+	defer c.Unlock()
+
+	conn := c.rawConn()
+
+	c.Reader = bufio.NewReaderSize(snappystream.NewReader(conn, snappystream.SkipVerifyChecksum), defaultBufferSize)
+	c.Writer = bufio.NewWriterSize(snappystream.NewWriter(conn), c.OutputBufferSize)
+
+	atomic.StoreInt32(&c.Snappy, 1)
+
+	return nil
+}
+
+// Flush writes any buffered data through to the wire
+//
+// NOTE: this expects the caller to handle locking
+func (c *ClientV2) Flush() error {
+	err := c.Writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	// the DEFLATE stream has its own internal buffer
+	if c.flateWriter != nil {
+		return c.flateWriter.Flush()
+	}
+
+	return nil
+}
+
+// rawConn returns the connection that compression should be layered on top of
+func (c *ClientV2) rawConn() net.Conn {
+	if c.tlsConn != nil {
+		return c.tlsConn
+	}
+	return c.Conn
+}
+
+func (c *ClientV2) SetSampleRate(sampleRate int32) error {
+	if sampleRate < 0 || sampleRate > 99 {
+		return errors.New(fmt.Sprintf("sample rate (%d) is invalid", sampleRate))
+	}
+	atomic.StoreInt32(&c.SampleRate, sampleRate)
+	return nil
+}
+
+// Sampling returns whether this client only receives a sample of messages
+func (c *ClientV2) Sampling() bool {
+	return atomic.LoadInt32(&c.SampleRate) > 0
+}
+
+// Sample decides whether a message should be delivered to this client,
+// every message is counted but only SampleRate percent are chosen
+func (c *ClientV2) Sample() bool {
+	sampleRate := atomic.LoadInt32(&c.SampleRate)
+	if sampleRate == 0 {
+		return true
+	}
+	atomic.AddUint64(&c.SampledCount, 1)
+	return rand.Int31n(100) < sampleRate
+}
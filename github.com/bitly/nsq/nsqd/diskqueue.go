@@ -3,13 +3,11 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
+	"github.com/bitly/nsq/util/diskqueue"
 	"log"
 	"os"
-	"path"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,6 +22,7 @@ type DiskQueue struct {
 	name            string
 	dataPath        string
 	maxBytesPerFile int64         // currently this cannot change once created
+	maxMsgSize      int32         // records larger than this are considered corrupt
 	syncEvery       int64         // number of writes per fsync
 	syncTimeout     time.Duration // duration of time per fsync
 	exitFlag        int32
@@ -46,6 +45,10 @@ type DiskQueue struct {
 	reader    *bufio.Reader
 	writeBuf  bytes.Buffer
 
+	// the on-disk format version of the files currently open
+	readFileVersion  int32
+	writeFileVersion int32
+
 	// exposed via ReadChan()
 	readChan chan []byte
 
@@ -60,11 +63,13 @@ type DiskQueue struct {
 
 // NewDiskQueue instantiates a new instance of DiskQueue, retrieving metadata
 // from the filesystem and starting the read ahead goroutine
-func NewDiskQueue(name string, dataPath string, maxBytesPerFile int64, syncEvery int64, syncTimeout time.Duration) BackendQueue {
+func NewDiskQueue(name string, dataPath string, maxBytesPerFile int64, maxMsgSize int32,
+	syncEvery int64, syncTimeout time.Duration) BackendQueue {
 	d := DiskQueue{
 		name:              name,
 		dataPath:          dataPath,
 		maxBytesPerFile:   maxBytesPerFile,
+		maxMsgSize:        maxMsgSize,
 		readChan:          make(chan []byte),
 		writeChan:         make(chan []byte),
 		writeResponseChan: make(chan error),
@@ -217,7 +222,6 @@ func (d *DiskQueue) skipToNextRWFile() error {
 // while advancing read positions and rolling files, if necessary
 func (d *DiskQueue) readOne() ([]byte, error) {
 	var err error
-	var msgSize int32
 
 	if d.readFile == nil {
 		curFileName := d.fileName(d.readFileNum)
@@ -228,6 +232,19 @@ func (d *DiskQueue) readOne() ([]byte, error) {
 
 		log.Printf("DISKQUEUE(%s): readOne() opened %s", d.name, curFileName)
 
+		d.readFileVersion, err = diskqueue.ReadHeader(d.readFile)
+		if err != nil {
+			d.readFile.Close()
+			d.readFile = nil
+			return nil, err
+		}
+
+		// nothing has been read from this file yet, skip over its header
+		if d.readPos < diskqueue.HeaderLen(d.readFileVersion) {
+			d.readPos = diskqueue.HeaderLen(d.readFileVersion)
+			d.nextReadPos = d.readPos
+		}
+
 		if d.readPos > 0 {
 			_, err = d.readFile.Seek(d.readPos, 0)
 			if err != nil {
@@ -240,22 +257,14 @@ func (d *DiskQueue) readOne() ([]byte, error) {
 		d.reader = bufio.NewReader(d.readFile)
 	}
 
-	err = binary.Read(d.reader, binary.BigEndian, &msgSize)
+	readBuf, err := diskqueue.ReadRecord(d.reader, d.readFileVersion, d.maxMsgSize)
 	if err != nil {
 		d.readFile.Close()
 		d.readFile = nil
 		return nil, err
 	}
 
-	readBuf := make([]byte, msgSize)
-	_, err = io.ReadFull(d.reader, readBuf)
-	if err != nil {
-		d.readFile.Close()
-		d.readFile = nil
-		return nil, err
-	}
-
-	totalBytes := int64(4 + msgSize)
+	totalBytes := diskqueue.RecordLen(d.readFileVersion, len(readBuf))
 
 	// we only advance next* because we have not yet sent this to consumers
 	// (where readFileNum, readPos will actually be advanced)
@@ -292,7 +301,17 @@ func (d *DiskQueue) writeOne(data []byte) error {
 
 		log.Printf("DISKQUEUE(%s): writeOne() opened %s", d.name, curFileName)
 
+		// new files are always written in the current format but we
+		// keep appending to an existing file in whatever format it has
+		d.writeFileVersion = diskqueue.CurrentVersion
 		if d.writePos > 0 {
+			d.writeFileVersion, err = diskqueue.ReadHeader(d.writeFile)
+			if err != nil {
+				d.writeFile.Close()
+				d.writeFile = nil
+				return err
+			}
+
 			_, err = d.writeFile.Seek(d.writePos, 0)
 			if err != nil {
 				d.writeFile.Close()
@@ -302,15 +321,15 @@ func (d *DiskQueue) writeOne(data []byte) error {
 		}
 	}
 
-	dataLen := len(data)
-
 	d.writeBuf.Reset()
-	err = binary.Write(&d.writeBuf, binary.BigEndian, int32(dataLen))
-	if err != nil {
-		return err
+	if d.writePos == 0 {
+		err = diskqueue.WriteHeader(&d.writeBuf, d.writeFileVersion)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = d.writeBuf.Write(data)
+	err = diskqueue.WriteRecord(&d.writeBuf, d.writeFileVersion, data)
 	if err != nil {
 		return err
 	}
@@ -323,7 +342,7 @@ func (d *DiskQueue) writeOne(data []byte) error {
 		return err
 	}
 
-	totalBytes := int64(4 + dataLen)
+	totalBytes := int64(d.writeBuf.Len())
 	d.writePos += totalBytes
 	atomic.AddInt64(&d.depth, 1)
 
@@ -423,11 +442,11 @@ func (d *DiskQueue) persistMetaData() error {
 }
 
 func (d *DiskQueue) metaDataFileName() string {
-	return fmt.Sprintf(path.Join(d.dataPath, "%s.diskqueue.meta.dat"), d.name)
+	return diskqueue.MetaDataFileName(d.dataPath, d.name)
 }
 
 func (d *DiskQueue) fileName(fileNum int64) string {
-	return fmt.Sprintf(path.Join(d.dataPath, "%s.diskqueue.%06d.dat"), d.name, fileNum)
+	return diskqueue.FileName(d.dataPath, d.name, fileNum)
 }
 
 func (d *DiskQueue) checkTailCorruption(depth int64) {
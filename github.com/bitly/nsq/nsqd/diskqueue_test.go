@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/bitly/nsq/util/diskqueue"
 	"github.com/bmizerany/assert"
 	"io/ioutil"
 	"log"
@@ -17,7 +20,7 @@ func TestDiskQueue(t *testing.T) {
 	defer log.SetOutput(os.Stdout)
 
 	dqName := "test_disk_queue" + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 1024, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 1024, 1024768, 2500, 2*time.Second)
 	assert.NotEqual(t, dq, nil)
 	assert.Equal(t, dq.Depth(), int64(0))
 
@@ -35,7 +38,7 @@ func TestDiskQueueRoll(t *testing.T) {
 	defer log.SetOutput(os.Stdout)
 
 	dqName := "test_disk_queue_roll" + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 100, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 100, 1024768, 2500, 2*time.Second)
 	assert.NotEqual(t, dq, nil)
 	assert.Equal(t, dq.Depth(), int64(0))
 
@@ -47,7 +50,7 @@ func TestDiskQueueRoll(t *testing.T) {
 	}
 
 	assert.Equal(t, dq.(*DiskQueue).writeFileNum, int64(1))
-	assert.Equal(t, dq.(*DiskQueue).writePos, int64(28))
+	assert.Equal(t, dq.(*DiskQueue).writePos, int64(80))
 }
 
 func assertFileNotExist(t *testing.T, fn string) {
@@ -61,7 +64,7 @@ func TestDiskQueueEmpty(t *testing.T) {
 	defer log.SetOutput(os.Stdout)
 
 	dqName := "test_disk_queue_empty" + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 100, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 100, 1024768, 2500, 2*time.Second)
 	assert.NotEqual(t, dq, nil)
 	assert.Equal(t, dq.Depth(), int64(0))
 
@@ -126,7 +129,7 @@ func TestDiskQueueCorruption(t *testing.T) {
 	defer log.SetOutput(os.Stdout)
 
 	dqName := "test_disk_queue_corruption" + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 1000, 5, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 1000, 1024768, 5, 2*time.Second)
 
 	msg := make([]byte, 123)
 	for i := 0; i < 25; i++ {
@@ -152,6 +155,73 @@ func TestDiskQueueCorruption(t *testing.T) {
 	assert.Equal(t, <-dq.ReadChan(), msg)
 }
 
+func TestDiskQueueChecksum(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dqName := "test_disk_queue_checksum" + strconv.Itoa(int(time.Now().Unix()))
+	dq := NewDiskQueue(dqName, os.TempDir(), 1000, 1024768, 5, 2*time.Second)
+
+	msg := make([]byte, 123)
+	for i := 0; i < 10; i++ {
+		dq.Put(msg)
+	}
+
+	// flip a bit in the data of the 3rd record of the 1st file
+	// (a torn write that leaves the length prefix intact)
+	dqFn := dq.(*DiskQueue).fileName(0)
+	f, err := os.OpenFile(dqFn, os.O_RDWR, 0600)
+	assert.Equal(t, err, nil)
+	_, err = f.WriteAt([]byte{1}, diskqueue.HeaderSize+2*131+8+10)
+	assert.Equal(t, err, nil)
+	f.Close()
+
+	// the two good records are delivered, the rest of the 1st file is
+	// skipped rather than yielding garbage, then the 2nd file is read
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, <-dq.ReadChan(), msg)
+	}
+
+	_, err = os.Stat(dqFn + ".bad")
+	assert.Equal(t, err, nil)
+	os.Remove(dqFn + ".bad")
+}
+
+func TestDiskQueueVersion0(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	dqName := "test_disk_queue_version0" + strconv.Itoa(int(time.Now().Unix()))
+	msg := []byte("aaaaaaaaaa")
+
+	// lay down a file and metadata as written prior to checksums
+	var buf bytes.Buffer
+	for i := 0; i < 5; i++ {
+		diskqueue.WriteRecord(&buf, diskqueue.Version0, msg)
+	}
+	err := ioutil.WriteFile(diskqueue.FileName(os.TempDir(), dqName, 0), buf.Bytes(), 0600)
+	assert.Equal(t, err, nil)
+	meta := fmt.Sprintf("%d\n%d,%d\n%d,%d\n", 5, 0, 0, 0, buf.Len())
+	err = ioutil.WriteFile(diskqueue.MetaDataFileName(os.TempDir(), dqName), []byte(meta), 0600)
+	assert.Equal(t, err, nil)
+
+	dq := NewDiskQueue(dqName, os.TempDir(), 1024, 1024768, 2500, 2*time.Second)
+	assert.Equal(t, dq.Depth(), int64(5))
+
+	// appends to the existing file stay in its format
+	err = dq.Put(msg)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dq.(*DiskQueue).writeFileVersion, diskqueue.Version0)
+	assert.Equal(t, dq.(*DiskQueue).writePos, int64(6*14))
+
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, <-dq.ReadChan(), msg)
+	}
+
+	dq.Empty()
+	dq.Close()
+}
+
 func TestDiskQueueTorture(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
@@ -159,7 +229,7 @@ func TestDiskQueueTorture(t *testing.T) {
 	var wg sync.WaitGroup
 
 	dqName := "test_disk_queue_torture" + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 262144, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 262144, 1024768, 2500, 2*time.Second)
 	assert.NotEqual(t, dq, nil)
 	assert.Equal(t, dq.Depth(), int64(0))
 
@@ -199,7 +269,7 @@ func TestDiskQueueTorture(t *testing.T) {
 	wg.Wait()
 
 	log.Printf("restarting diskqueue")
-	dq = NewDiskQueue(dqName, os.TempDir(), 262144, 2500, 2*time.Second)
+	dq = NewDiskQueue(dqName, os.TempDir(), 262144, 1024768, 2500, 2*time.Second)
 	assert.NotEqual(t, dq, nil)
 	assert.Equal(t, dq.Depth(), depth)
 
@@ -243,7 +313,7 @@ func BenchmarkDiskQueuePut(b *testing.B) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
 	dqName := "bench_disk_queue_put" + strconv.Itoa(b.N) + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 1024, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 1024, 1024768, 2500, 2*time.Second)
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -259,7 +329,7 @@ func BenchmarkDiskQueueGet(b *testing.B) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
 	dqName := "bench_disk_queue_get" + strconv.Itoa(b.N) + strconv.Itoa(int(time.Now().Unix()))
-	dq := NewDiskQueue(dqName, os.TempDir(), 1024768, 2500, 2*time.Second)
+	dq := NewDiskQueue(dqName, os.TempDir(), 1024768, 1024768, 2500, 2*time.Second)
 	for i := 0; i < b.N; i++ {
 		dq.Put([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaa"))
 	}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -106,9 +107,27 @@ func putHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	var deferred time.Duration
+	if ds, err := reqParams.Get("defer"); err == nil {
+		di, err := strconv.ParseInt(ds, 10, 64)
+		if err != nil {
+			util.ApiResponse(w, 500, "INVALID_DEFER", nil)
+			return
+		}
+		deferred = time.Duration(di) * time.Millisecond
+		if deferred < 0 || deferred > maxTimeout {
+			util.ApiResponse(w, 500, "INVALID_DEFER", nil)
+			return
+		}
+	}
+
 	topic := nsqd.GetTopic(topicName)
 	msg := nsq.NewMessage(<-nsqd.idChan, reqParams.Body)
-	err = topic.PutMessage(msg)
+	if deferred > 0 {
+		err = topic.PutDeferredMessage(msg, deferred)
+	} else {
+		err = topic.PutMessage(msg)
+	}
 	if err != nil {
 		util.ApiResponse(w, 500, "NOK", nil)
 		return
@@ -399,6 +418,9 @@ func statsHandler(w http.ResponseWriter, req *http.Request) {
 				t.Depth,
 				t.BackendDepth,
 				t.MessageCount))
+			if t.E2eProcessingLatency != nil {
+				io.WriteString(w, fmt.Sprintf("    e2e-processing-latency: %s\n", t.E2eProcessingLatency))
+			}
 			for _, c := range t.Channels {
 				var pausedPrefix string
 				if c.Paused {
@@ -417,6 +439,9 @@ func statsHandler(w http.ResponseWriter, req *http.Request) {
 						c.RequeueCount,
 						c.TimeoutCount,
 						c.MessageCount))
+				if c.E2eProcessingLatency != nil {
+					io.WriteString(w, fmt.Sprintf("        e2e-processing-latency: %s\n", c.E2eProcessingLatency))
+				}
 				for _, client := range c.Clients {
 					connectTime := time.Unix(client.ConnectTime, 0)
 					// truncate to the second
@@ -433,6 +458,12 @@ func statsHandler(w http.ResponseWriter, req *http.Request) {
 						client.MessageCount,
 						duration,
 					))
+					if client.SampleRate > 0 {
+						io.WriteString(w, fmt.Sprintf("            sample-rate: %d%% sampled: %d delivered: %d\n",
+							client.SampleRate,
+							client.SampledCount,
+							client.MessageCount))
+					}
 				}
 			}
 		}
@@ -36,6 +36,44 @@ func TestHTTPput(t *testing.T) {
 	assert.Equal(t, topic.Depth(), int64(1))
 }
 
+func TestHTTPputDeferred(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	// deferred messages are held independently of the in-memory queue
+	options := NewNsqdOptions()
+	options.memQueueSize = 0
+	_, httpAddr := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_http_put_deferred" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	buf := bytes.NewBuffer([]byte("test message"))
+	url := fmt.Sprintf("http://%s/put?topic=%s&defer=10000", httpAddr, topicName)
+	resp, err := http.Post(url, "application/octet-stream", buf)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(body), "OK")
+
+	time.Sleep(50 * time.Millisecond)
+
+	topic.deferredMutex.Lock()
+	numDef := len(topic.deferredPQ)
+	topic.deferredMutex.Unlock()
+	assert.Equal(t, numDef, 1)
+	assert.Equal(t, channel.Depth(), int64(0))
+
+	buf = bytes.NewBuffer([]byte("test message"))
+	url = fmt.Sprintf("http://%s/put?topic=%s&defer=-1", httpAddr, topicName)
+	resp, err = http.Post(url, "application/octet-stream", buf)
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, 500)
+}
+
 func TestHTTPmput(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
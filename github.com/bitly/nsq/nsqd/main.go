@@ -9,6 +9,7 @@ import (
 	"hash/crc32"
 	"io"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
@@ -42,12 +43,26 @@ var (
 	maxMessageSize = flag.Int64("max-message-size", 1024768, "maximum size of a single message in bytes")
 	maxBodySize    = flag.Int64("max-body-size", 5*1024768, "maximum size of a single command body")
 
+	// ephemeral topic options
+	ephemeralTopicTimeout = flag.Duration("ephemeral-topic-timeout", 60*time.Second, "duration an #ephemeral topic without channels is kept before being deleted")
+
 	// client overridable configuration options
 	maxHeartbeatInterval   = flag.Duration("max-heartbeat-interval", 60*time.Second, "maximum client configurable duration of time between client heartbeats")
 	maxRdyCount            = flag.Int64("max-rdy-count", 2500, "maximum RDY count for a client")
 	maxOutputBufferSize    = flag.Int64("max-output-buffer-size", 64*1024, "maximum client configurable size (in bytes) for a client output buffer")
 	maxOutputBufferTimeout = flag.Duration("max-output-buffer-timeout", 1*time.Second, "maximum client configurable duration of time between flushing to a client")
 
+	// TLS and compression options (negotiated by clients via IDENTIFY)
+	tlsCert         = flag.String("tls-cert", "", "path to certificate file")
+	tlsKey          = flag.String("tls-key", "", "path to private key file")
+	deflateEnabled  = flag.Bool("deflate", true, "enable deflate feature negotiation (client compression)")
+	maxDeflateLevel = flag.Int("max-deflate-level", 6, "max deflate compression level a client can negotiate (> values == > nsqd CPU usage)")
+	snappyEnabled   = flag.Bool("snappy", true, "enable snappy feature negotiation (client compression)")
+
+	// end-to-end (PUB to FIN) processing latency options
+	e2eProcessingLatencyWindowTime  = flag.Duration("e2e-processing-latency-window-time", 10*time.Minute, "calculate end to end latency quantiles for this duration of time (ie: 60s would only show quantile calculations from the past 60 seconds)")
+	e2eProcessingLatencyPercentiles = util.FloatArray{}
+
 	// statsd integration options
 	statsdAddress  = flag.String("statsd-address", "", "UDP <addr>:<port> of a statsd daemon for pushing stats")
 	statsdInterval = flag.String("statsd-interval", "60s", "duration between pushing to statsd")
@@ -55,6 +70,7 @@ var (
 
 func init() {
 	flag.Var(&lookupdTCPAddrs, "lookupd-tcp-address", "lookupd TCP address (may be given multiple times)")
+	flag.Var(&e2eProcessingLatencyPercentiles, "e2e-processing-latency-percentile", "message processing time percentiles to keep track of (can be specified multiple times or comma separated, default none)")
 }
 
 var nsqd *NSQd
@@ -73,6 +89,9 @@ func main() {
 		return
 	}
 
+	// used to sample messages for clients that IDENTIFY with a sample_rate
+	rand.Seed(time.Now().UTC().UnixNano())
+
 	if *workerId == 0 {
 		h := md5.New()
 		io.WriteString(h, hostname)
@@ -112,6 +131,12 @@ func main() {
 		go statsdLoop(*statsdAddress, prefix, statsdInterval)
 	}
 
+	for _, p := range e2eProcessingLatencyPercentiles {
+		if p <= 0 || p >= 1 {
+			log.Fatalf("ERROR: --e2e-processing-latency-percentile %v must be between 0 and 1 (exclusive)", p)
+		}
+	}
+
 	// flagToDuration will fatally error if it is invalid
 	msgTimeoutDuration := flagToDuration(*msgTimeout, time.Millisecond, "--msg-timeout")
 
@@ -126,10 +151,18 @@ func main() {
 	options.syncTimeout = *syncTimeout
 	options.msgTimeout = msgTimeoutDuration
 	options.maxMsgTimeout = *maxMsgTimeout
+	options.ephemeralTopicTimeout = *ephemeralTopicTimeout
 	options.broadcastAddress = *broadcastAddress
 	options.maxHeartbeatInterval = *maxHeartbeatInterval
 	options.maxOutputBufferSize = *maxOutputBufferSize
 	options.maxOutputBufferTimeout = *maxOutputBufferTimeout
+	options.tlsCert = *tlsCert
+	options.tlsKey = *tlsKey
+	options.deflateEnabled = *deflateEnabled
+	options.maxDeflateLevel = *maxDeflateLevel
+	options.snappyEnabled = *snappyEnabled
+	options.e2eProcessingLatencyWindowTime = *e2eProcessingLatencyWindowTime
+	options.e2eProcessingLatencyPercentiles = e2eProcessingLatencyPercentiles
 
 	nsqd = NewNSQd(*workerId, options)
 	nsqd.tcpAddr = tcpAddr
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
@@ -37,6 +39,7 @@ type NSQd struct {
 	waitGroup       util.WaitGroupWrapper
 	lookupPeers     []*nsq.LookupPeer
 	notifyChan      chan interface{}
+	tlsConfig       *tls.Config
 }
 
 type nsqdOptions struct {
@@ -54,8 +57,21 @@ type nsqdOptions struct {
 	maxHeartbeatInterval time.Duration
 	broadcastAddress     string
 
+	// how long an #ephemeral topic may exist without any channels
+	ephemeralTopicTimeout time.Duration
+
 	maxOutputBufferSize    int64
 	maxOutputBufferTimeout time.Duration
+
+	tlsCert string
+	tlsKey  string
+
+	deflateEnabled  bool
+	maxDeflateLevel int
+	snappyEnabled   bool
+
+	e2eProcessingLatencyWindowTime  time.Duration
+	e2eProcessingLatencyPercentiles []float64
 }
 
 func NewNsqdOptions() *nsqdOptions {
@@ -74,11 +90,29 @@ func NewNsqdOptions() *nsqdOptions {
 		maxHeartbeatInterval: 60 * time.Second,
 		broadcastAddress:     "",
 
+		ephemeralTopicTimeout: 60 * time.Second,
+
 		maxOutputBufferSize:    64 * 1024,
 		maxOutputBufferTimeout: 1 * time.Second,
+
+		tlsCert: "",
+		tlsKey:  "",
+
+		deflateEnabled:  true,
+		maxDeflateLevel: 6,
+		snappyEnabled:   true,
+
+		e2eProcessingLatencyWindowTime:  10 * time.Minute,
+		e2eProcessingLatencyPercentiles: []float64{},
 	}
 }
 
+// maxDiskQueueMsgSize is the largest record a DiskQueue should expect,
+// an encoded message is its body preceded by the timestamp, attempts and ID
+func (o *nsqdOptions) maxDiskQueueMsgSize() int32 {
+	return int32(o.maxMessageSize) + 8 + 2 + nsq.MsgIdLength
+}
+
 func NewNSQd(workerId int64, options *nsqdOptions) *NSQd {
 	n := &NSQd{
 		workerId:   workerId,
@@ -89,6 +123,17 @@ func NewNSQd(workerId int64, options *nsqdOptions) *NSQd {
 		notifyChan: make(chan interface{}),
 	}
 
+	if options.tlsCert != "" || options.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(options.tlsCert, options.tlsKey)
+		if err != nil {
+			log.Fatalf("ERROR: failed to LoadX509KeyPair - %s", err.Error())
+		}
+		n.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		}
+	}
+
 	n.waitGroup.Wrap(func() { n.idPump() })
 
 	return n
@@ -185,6 +230,9 @@ func (n *NSQd) PersistMetadata() error {
 	js := make(map[string]interface{})
 	topics := make([]interface{}, 0)
 	for _, topic := range n.topicMap {
+		if topic.ephemeralTopic {
+			continue
+		}
 		topicData := make(map[string]interface{})
 		topicData["name"] = topic.name
 		channels := make([]interface{}, 0)
@@ -268,7 +316,10 @@ func (n *NSQd) GetTopic(topicName string) *Topic {
 		n.Unlock()
 		return t
 	} else {
-		t = NewTopic(topicName, n.options, n)
+		deleteCallback := func(t *Topic) {
+			n.deleteTopic(t)
+		}
+		t = NewTopic(topicName, n.options, n, deleteCallback)
 		n.topicMap[topicName] = t
 		log.Printf("TOPIC(%s): created", t.name)
 
@@ -281,6 +332,10 @@ func (n *NSQd) GetTopic(topicName string) *Topic {
 		if len(n.lookupPeers) > 0 {
 			channelNames, _ := lookupd.GetLookupdTopicChannels(t.name, n.lookupHttpAddrs())
 			for _, channelName := range channelNames {
+				// ephemeral channels only exist while they have clients
+				if strings.HasSuffix(channelName, "#ephemeral") {
+					continue
+				}
 				t.getOrCreateChannel(channelName)
 			}
 		}
@@ -321,6 +376,22 @@ func (n *NSQd) DeleteExistingTopic(topicName string) error {
 	}
 	n.RUnlock()
 
+	n.deleteTopic(topic)
+
+	return nil
+}
+
+// deleteTopic deletes the topic if it is still the one registered under its
+// name, it may have been deleted and recreated by the time a topic deletes
+// itself
+func (n *NSQd) deleteTopic(topic *Topic) {
+	n.RLock()
+	current := n.topicMap[topic.name] == topic
+	n.RUnlock()
+	if !current {
+		return
+	}
+
 	// delete empties all channels and the topic itself before closing
 	// (so that we dont leave any messages around)
 	//
@@ -330,10 +401,10 @@ func (n *NSQd) DeleteExistingTopic(topicName string) error {
 	topic.Delete()
 
 	n.Lock()
-	delete(n.topicMap, topicName)
+	if n.topicMap[topic.name] == topic {
+		delete(n.topicMap, topic.name)
+	}
 	n.Unlock()
-
-	return nil
 }
 
 func (n *NSQd) idPump() {
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"github.com/bitly/nsq/nsq"
 	"github.com/bmizerany/assert"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -139,3 +142,91 @@ func TestEphemeralChannel(t *testing.T) {
 	exitChan <- 1
 	<-doneExitChan
 }
+
+func TestEphemeralTopic(t *testing.T) {
+	// an ephemeral topic is never backed by disk, is left out of the
+	// persisted metadata and is removed along with its last channel
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewNsqdOptions()
+	options.memQueueSize = 1
+	mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "ephemeral_topic" + strconv.Itoa(int(time.Now().Unix())) + "#ephemeral"
+	topic := nsqd.GetTopic(topicName)
+	assert.Equal(t, topic.ephemeralTopic, true)
+	_, ok := topic.backend.(*DummyBackendQueue)
+	assert.Equal(t, ok, true)
+
+	ephemeralChannel := topic.GetChannel("ch1#ephemeral")
+
+	err := nsqd.PersistMetadata()
+	assert.Equal(t, err, nil)
+	fn := fmt.Sprintf(path.Join(options.dataPath, "nsqd.%d.dat"), nsqd.workerId)
+	data, err := ioutil.ReadFile(fn)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(string(data), topicName), false)
+
+	body := []byte("an_ephemeral_message")
+	topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, body))
+	msg := <-ephemeralChannel.clientMsgChan
+	assert.Equal(t, msg.Body, body)
+
+	ephemeralChannel.RemoveClient(nil)
+
+	time.Sleep(50 * time.Millisecond)
+
+	nsqd.RLock()
+	_, ok = nsqd.topicMap[topicName]
+	nsqd.RUnlock()
+	assert.Equal(t, ok, false)
+	assert.Equal(t, topic.Exiting(), true)
+}
+
+func TestEphemeralTopicWithoutChannels(t *testing.T) {
+	// an ephemeral topic that never gets a channel is removed after a while
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewNsqdOptions()
+	options.ephemeralTopicTimeout = 50 * time.Millisecond
+	mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "ephemeral_topic_idle" + strconv.Itoa(int(time.Now().Unix())) + "#ephemeral"
+	topic := nsqd.GetTopic(topicName)
+	topic.PutMessage(nsq.NewMessage(<-nsqd.idChan, []byte("nobody_listening")))
+
+	time.Sleep(150 * time.Millisecond)
+
+	nsqd.RLock()
+	_, ok := nsqd.topicMap[topicName]
+	nsqd.RUnlock()
+	assert.Equal(t, ok, false)
+	assert.Equal(t, topic.Exiting(), true)
+}
+
+func TestDeleteCallbackOfRecreatedTopic(t *testing.T) {
+	// a topic deleting itself must not take down a newer topic of the same name
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	mustStartNSQd(NewNsqdOptions())
+	defer nsqd.Exit()
+
+	topicName := "recreated_topic" + strconv.Itoa(int(time.Now().Unix())) + "#ephemeral"
+	oldTopic := nsqd.GetTopic(topicName)
+	nsqd.DeleteExistingTopic(topicName)
+	newTopic := nsqd.GetTopic(topicName)
+
+	oldTopic.deleteCallback(oldTopic)
+
+	nsqd.RLock()
+	topic, ok := nsqd.topicMap[topicName]
+	nsqd.RUnlock()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, topic, newTopic)
+	assert.Equal(t, newTopic.Exiting(), false)
+}
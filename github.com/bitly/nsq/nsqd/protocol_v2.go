@@ -9,6 +9,7 @@ import (
 	"github.com/bitly/nsq/util"
 	"io"
 	"log"
+	"math"
 	"net"
 	"sync/atomic"
 	"time"
@@ -132,7 +133,7 @@ func (p *ProtocolV2) Send(client *ClientV2, frameType int32, data []byte) error
 	}
 
 	if frameType != nsq.FrameTypeMessage {
-		err = client.Writer.Flush()
+		err = client.Flush()
 	}
 
 	return err
@@ -144,7 +145,7 @@ func (p *ProtocolV2) Flush(client *ClientV2) error {
 
 	if client.Writer.Buffered() > 0 {
 		client.SetWriteDeadline(time.Now().Add(time.Second))
-		return client.Writer.Flush()
+		return client.Flush()
 	}
 
 	return nil
@@ -170,6 +171,8 @@ func (p *ProtocolV2) Exec(client *ClientV2, params [][]byte) ([]byte, error) {
 		return p.PUB(client, params)
 	case bytes.Equal(params[0], []byte("MPUB")):
 		return p.MPUB(client, params)
+	case bytes.Equal(params[0], []byte("DPUB")):
+		return p.DPUB(client, params)
 	case bytes.Equal(params[0], []byte("TOUCH")):
 		return p.TOUCH(client, params)
 	}
@@ -257,6 +260,12 @@ func (p *ProtocolV2) messagePump(client *ClientV2) {
 			if !ok {
 				goto exit
 			}
+			// a message that is not chosen goes back to the channel for
+			// its other clients, unless they are all sampling as well
+			if !client.Sample() {
+				subChannel.ReturnMessage(client, msg)
+				continue
+			}
 			err = p.SendMessage(client, msg, &buf)
 			if err != nil {
 				goto exit
@@ -314,25 +323,101 @@ func (p *ProtocolV2) IDENTIFY(client *ClientV2, params [][]byte) ([]byte, error)
 		return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY "+err.Error())
 	}
 
-	resp := okBytes
-	if identifyData.FeatureNegotiation {
-		resp, err = json.Marshal(struct {
-			MaxRdyCount   int64  `json:"max_rdy_count"`
-			Version       string `json:"version"`
-			MaxMsgTimeout int64  `json:"max_msg_timeout"`
-			MsgTimeout    int64  `json:"msg_timeout"`
-		}{
-			MaxRdyCount:   nsqd.options.maxRdyCount,
-			Version:       util.BINARY_VERSION,
-			MaxMsgTimeout: int64(nsqd.options.maxMsgTimeout / time.Millisecond),
-			MsgTimeout:    int64(nsqd.options.msgTimeout / time.Millisecond),
-		})
+	if !identifyData.FeatureNegotiation {
+		return okBytes, nil
+	}
+
+	tlsv1 := nsqd.tlsConfig != nil && identifyData.TLSv1
+	deflate := nsqd.options.deflateEnabled && identifyData.Deflate
+	deflateLevel := 0
+	if deflate {
+		deflateLevel = identifyData.DeflateLevel
+		if deflateLevel <= 0 {
+			deflateLevel = 6
+		}
+		deflateLevel = int(math.Min(float64(deflateLevel), float64(nsqd.options.maxDeflateLevel)))
+	}
+	snappy := nsqd.options.snappyEnabled && identifyData.Snappy
+
+	if deflate && snappy {
+		return nil, nsq.NewFatalClientErr(nil, "E_BAD_BODY", "IDENTIFY cannot enable both deflate and snappy compression")
+	}
+
+	resp, err := json.Marshal(struct {
+		MaxRdyCount     int64  `json:"max_rdy_count"`
+		Version         string `json:"version"`
+		MaxMsgTimeout   int64  `json:"max_msg_timeout"`
+		MsgTimeout      int64  `json:"msg_timeout"`
+		TLSv1           bool   `json:"tls_v1"`
+		Deflate         bool   `json:"deflate"`
+		DeflateLevel    int    `json:"deflate_level"`
+		MaxDeflateLevel int    `json:"max_deflate_level"`
+		Snappy          bool   `json:"snappy"`
+		SampleRate      int32  `json:"sample_rate"`
+	}{
+		MaxRdyCount:     nsqd.options.maxRdyCount,
+		Version:         util.BINARY_VERSION,
+		MaxMsgTimeout:   int64(nsqd.options.maxMsgTimeout / time.Millisecond),
+		MsgTimeout:      int64(nsqd.options.msgTimeout / time.Millisecond),
+		TLSv1:           tlsv1,
+		Deflate:         deflate,
+		DeflateLevel:    deflateLevel,
+		MaxDeflateLevel: nsqd.options.maxDeflateLevel,
+		Snappy:          snappy,
+		SampleRate:      atomic.LoadInt32(&client.SampleRate),
+	})
+	if err != nil {
+		panic("should never happen")
+	}
+
+	// the feature negotiation response is always sent in plaintext, once the
+	// client receives it the connection is upgraded in place (TLS first, then
+	// compression) and each upgrade is acknowledged over the *new* stream
+	err = p.Send(client, nsq.FrameTypeResponse, resp)
+	if err != nil {
+		return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+	}
+
+	if tlsv1 {
+		log.Printf("PROTOCOL(V2): [%s] upgrading connection to TLS", client)
+		err = client.UpgradeTLS()
 		if err != nil {
-			panic("should never happen")
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+		}
+
+		err = p.Send(client, nsq.FrameTypeResponse, okBytes)
+		if err != nil {
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
 		}
 	}
 
-	return resp, nil
+	if snappy {
+		log.Printf("PROTOCOL(V2): [%s] upgrading connection to snappy", client)
+		err = client.UpgradeSnappy()
+		if err != nil {
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+		}
+
+		err = p.Send(client, nsq.FrameTypeResponse, okBytes)
+		if err != nil {
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+		}
+	}
+
+	if deflate {
+		log.Printf("PROTOCOL(V2): [%s] upgrading connection to deflate", client)
+		err = client.UpgradeDeflate(deflateLevel)
+		if err != nil {
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+		}
+
+		err = p.Send(client, nsq.FrameTypeResponse, okBytes)
+		if err != nil {
+			return nil, nsq.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed "+err.Error())
+		}
+	}
+
+	return nil, nil
 }
 
 func (p *ProtocolV2) SUB(client *ClientV2, params [][]byte) ([]byte, error) {
@@ -579,6 +664,61 @@ func (p *ProtocolV2) MPUB(client *ClientV2, params [][]byte) ([]byte, error) {
 	return okBytes, nil
 }
 
+func (p *ProtocolV2) DPUB(client *ClientV2, params [][]byte) ([]byte, error) {
+	var err error
+
+	if len(params) < 3 {
+		return nil, nsq.NewFatalClientErr(nil, "E_INVALID", "DPUB insufficient number of parameters")
+	}
+
+	topicName := string(params[1])
+	if !nsq.IsValidTopicName(topicName) {
+		return nil, nsq.NewFatalClientErr(nil, "E_BAD_TOPIC",
+			fmt.Sprintf("DPUB topic name '%s' is not valid", topicName))
+	}
+
+	timeoutMs, err := util.ByteToBase10(params[2])
+	if err != nil {
+		return nil, nsq.NewFatalClientErr(err, "E_INVALID",
+			fmt.Sprintf("DPUB could not parse timeout %s", params[2]))
+	}
+	timeoutDuration := time.Duration(timeoutMs) * time.Millisecond
+
+	if timeoutDuration < 0 || timeoutDuration > maxTimeout {
+		return nil, nsq.NewFatalClientErr(nil, "E_INVALID",
+			fmt.Sprintf("DPUB timeout %d out of range 0-%d", timeoutDuration, maxTimeout))
+	}
+
+	bodyLen, err := p.readLen(client)
+	if err != nil {
+		return nil, nsq.NewFatalClientErr(err, "E_BAD_MESSAGE", "DPUB failed to read message body size")
+	}
+
+	if int64(bodyLen) > nsqd.options.maxMessageSize {
+		return nil, nsq.NewFatalClientErr(nil, "E_BAD_MESSAGE",
+			fmt.Sprintf("DPUB message too big %d > %d", bodyLen, nsqd.options.maxMessageSize))
+	}
+
+	messageBody := make([]byte, bodyLen)
+	_, err = io.ReadFull(client.Reader, messageBody)
+	if err != nil {
+		return nil, nsq.NewFatalClientErr(err, "E_BAD_MESSAGE", "DPUB failed to read message body")
+	}
+
+	topic := nsqd.GetTopic(topicName)
+	msg := nsq.NewMessage(<-nsqd.idChan, messageBody)
+	if timeoutDuration > 0 {
+		err = topic.PutDeferredMessage(msg, timeoutDuration)
+	} else {
+		err = topic.PutMessage(msg)
+	}
+	if err != nil {
+		return nil, nsq.NewFatalClientErr(err, "E_DPUB_FAILED", "DPUB failed "+err.Error())
+	}
+
+	return okBytes, nil
+}
+
 func (p *ProtocolV2) TOUCH(client *ClientV2, params [][]byte) ([]byte, error) {
 	state := atomic.LoadInt32(&client.State)
 	if state != nsq.StateSubscribed && state != nsq.StateClosing {
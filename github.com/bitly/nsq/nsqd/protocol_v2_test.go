@@ -3,15 +3,26 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"github.com/bitly/nsq/nsq"
 	"github.com/bmizerany/assert"
+	"github.com/mreiferson/go-snappystream"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"math/big"
 	"net"
 	"os"
+	"path"
 	"runtime"
 	"strconv"
 	"sync"
@@ -87,7 +98,7 @@ func identifyOutputBuffering(t *testing.T, conn net.Conn, size int, timeout int,
 	readValidate(t, conn, f, d)
 }
 
-func sub(t *testing.T, conn net.Conn, topicName string, channelName string) {
+func sub(t *testing.T, conn io.ReadWriter, topicName string, channelName string) {
 	err := nsq.Subscribe(topicName, channelName).Write(conn)
 	assert.Equal(t, err, nil)
 	readValidate(t, conn, nsq.FrameTypeResponse, "OK")
@@ -101,7 +112,7 @@ func subFail(t *testing.T, conn net.Conn, topicName string, channelName string)
 	assert.Equal(t, frameType, nsq.FrameTypeError)
 }
 
-func readValidate(t *testing.T, conn net.Conn, f int32, d string) {
+func readValidate(t *testing.T, conn io.Reader, f int32, d string) {
 	resp, err := nsq.ReadResponse(conn)
 	assert.Equal(t, err, nil)
 	frameType, data, err := nsq.UnpackResponse(resp)
@@ -117,7 +128,7 @@ func TestChannelTopicNames(t *testing.T) {
 	assert.Equal(t, nsq.IsValidChannelName("test#ephemeral"), true)
 	assert.Equal(t, nsq.IsValidTopicName("test"), true)
 	assert.Equal(t, nsq.IsValidTopicName("test-with_period."), true)
-	assert.Equal(t, nsq.IsValidTopicName("test#ephemeral"), false)
+	assert.Equal(t, nsq.IsValidTopicName("test#ephemeral"), true)
 	assert.Equal(t, nsq.IsValidTopicName("test:ephemeral"), false)
 }
 
@@ -343,6 +354,56 @@ func TestMaxHeartbeatIntervalInvalid(t *testing.T) {
 	identifyHeartbeatInterval(t, conn, hbi, nsq.FrameTypeError, "E_BAD_BODY IDENTIFY heartbeat interval (300001) is invalid")
 }
 
+func TestDPUB(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	tcpAddr, _ := mustStartNSQd(NewNsqdOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_dpub_v2" + strconv.Itoa(int(time.Now().Unix()))
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	identify(t, conn)
+	sub(t, conn, topicName, "ch")
+
+	// valid
+	start := time.Now()
+	err = nsq.DeferredPublish(topicName, 500*time.Millisecond, []byte("test body")).Write(conn)
+	assert.Equal(t, err, nil)
+	readValidate(t, conn, nsq.FrameTypeResponse, "OK")
+
+	time.Sleep(50 * time.Millisecond)
+
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	topic.deferredMutex.Lock()
+	numDef := len(topic.deferredPQ)
+	topic.deferredMutex.Unlock()
+	assert.Equal(t, numDef, 1)
+	assert.Equal(t, channel.Depth(), int64(0))
+
+	err = nsq.Ready(1).Write(conn)
+	assert.Equal(t, err, nil)
+
+	resp, err := nsq.ReadResponse(conn)
+	assert.Equal(t, err, nil)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	msgOut, _ := nsq.DecodeMessage(data)
+	assert.Equal(t, frameType, nsq.FrameTypeMessage)
+	assert.Equal(t, msgOut.Body, []byte("test body"))
+	assert.Equal(t, time.Now().Sub(start) >= 500*time.Millisecond, true)
+	assert.Equal(t, channel.requeueCount, uint64(0))
+
+	// duration out of range
+	err = nsq.DeferredPublish(topicName, maxTimeout+100*time.Millisecond, []byte("test body")).Write(conn)
+	assert.Equal(t, err, nil)
+	readValidate(t, conn, nsq.FrameTypeError,
+		fmt.Sprintf("E_INVALID DPUB timeout %d out of range 0-%d", maxTimeout+100*time.Millisecond, maxTimeout))
+}
+
 func TestPausing(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
@@ -689,6 +750,284 @@ func TestOutputBufferingValidity(t *testing.T) {
 	identifyOutputBuffering(t, conn, 0, 1001, nsq.FrameTypeError, "E_BAD_BODY IDENTIFY output buffer timeout (1001) is invalid")
 }
 
+func identifyFeatureNegotiationUpgrade(t *testing.T, conn net.Conn, features map[string]interface{}) *identifyResponse {
+	ci := make(map[string]interface{})
+	ci["short_id"] = "test"
+	ci["long_id"] = "test"
+	ci["feature_negotiation"] = true
+	for k, v := range features {
+		ci[k] = v
+	}
+	cmd, _ := nsq.Identify(ci)
+	err := cmd.Write(conn)
+	assert.Equal(t, err, nil)
+	resp, err := nsq.ReadResponse(conn)
+	assert.Equal(t, err, nil)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, frameType, nsq.FrameTypeResponse)
+	r := &identifyResponse{}
+	err = json.Unmarshal(data, r)
+	assert.Equal(t, err, nil)
+	return r
+}
+
+type identifyResponse struct {
+	TLSv1        bool  `json:"tls_v1"`
+	Deflate      bool  `json:"deflate"`
+	DeflateLevel int   `json:"deflate_level"`
+	Snappy       bool  `json:"snappy"`
+	SampleRate   int32 `json:"sample_rate"`
+}
+
+// mustGenerateCert writes a self-signed certificate/key pair for 127.0.0.1
+// to a temp dir and returns their paths
+func mustGenerateCert(t *testing.T) (string, string) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Equal(t, err, nil)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"nsq test"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	assert.Equal(t, err, nil)
+
+	dir, err := ioutil.TempDir("", "nsqd-test-certs")
+	assert.Equal(t, err, nil)
+
+	certFile := path.Join(dir, "cert.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.Equal(t, err, nil)
+
+	keyFile := path.Join(dir, "key.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600)
+	assert.Equal(t, err, nil)
+
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	*verbose = true
+	options := NewNsqdOptions()
+	options.tlsCert, options.tlsKey = mustGenerateCert(t)
+	defer os.RemoveAll(path.Dir(options.tlsCert))
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_tls" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	msg := nsq.NewMessage(<-nsqd.idChan, []byte("test body"))
+	topic.PutMessage(msg)
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{"tls_v1": true})
+	assert.Equal(t, r.TLSv1, true)
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	err = tlsConn.Handshake()
+	assert.Equal(t, err, nil)
+	readValidate(t, tlsConn, nsq.FrameTypeResponse, "OK")
+
+	sub(t, tlsConn, topicName, "ch")
+	err = nsq.Ready(1).Write(tlsConn)
+	assert.Equal(t, err, nil)
+
+	resp, err := nsq.ReadResponse(tlsConn)
+	assert.Equal(t, err, nil)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	msgOut, _ := nsq.DecodeMessage(data)
+	assert.Equal(t, frameType, nsq.FrameTypeMessage)
+	assert.Equal(t, msgOut.Id, msg.Id)
+	assert.Equal(t, msgOut.Body, msg.Body)
+}
+
+func TestTLSNotConfigured(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	tcpAddr, _ := mustStartNSQd(NewNsqdOptions())
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	// without a certificate nsqd declines the upgrade and stays in plaintext
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{"tls_v1": true})
+	assert.Equal(t, r.TLSv1, false)
+
+	err = nsq.Nop().Write(conn)
+	assert.Equal(t, err, nil)
+	err = nsq.Subscribe("test_tls_not_configured", "ch").Write(conn)
+	assert.Equal(t, err, nil)
+	readValidate(t, conn, nsq.FrameTypeResponse, "OK")
+}
+
+func TestDeflate(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	*verbose = true
+	options := NewNsqdOptions()
+	options.maxDeflateLevel = 6
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_deflate" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	msg := nsq.NewMessage(<-nsqd.idChan, bytes.Repeat([]byte("test body "), 100))
+	topic.PutMessage(msg)
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{
+		"deflate":       true,
+		"deflate_level": 9,
+	})
+	assert.Equal(t, r.Deflate, true)
+	// bounded by --max-deflate-level
+	assert.Equal(t, r.DeflateLevel, 6)
+
+	fw, _ := flate.NewWriter(conn, r.DeflateLevel)
+	compressedConn := &readWriter{flate.NewReader(conn), fw}
+	readValidate(t, compressedConn, nsq.FrameTypeResponse, "OK")
+
+	sub(t, compressedConn, topicName, "ch")
+	err = nsq.Ready(1).Write(compressedConn)
+	assert.Equal(t, err, nil)
+
+	resp, err := nsq.ReadResponse(compressedConn)
+	assert.Equal(t, err, nil)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	msgOut, _ := nsq.DecodeMessage(data)
+	assert.Equal(t, frameType, nsq.FrameTypeMessage)
+	assert.Equal(t, msgOut.Id, msg.Id)
+	assert.Equal(t, msgOut.Body, msg.Body)
+}
+
+func TestSnappy(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	*verbose = true
+	tcpAddr, _ := mustStartNSQd(NewNsqdOptions())
+	defer nsqd.Exit()
+
+	topicName := "test_snappy" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	msg := nsq.NewMessage(<-nsqd.idChan, bytes.Repeat([]byte("test body "), 100))
+	topic.PutMessage(msg)
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{"snappy": true})
+	assert.Equal(t, r.Snappy, true)
+
+	compressedConn := &readWriter{
+		snappystream.NewReader(conn, snappystream.SkipVerifyChecksum),
+		snappystream.NewWriter(conn),
+	}
+	readValidate(t, compressedConn, nsq.FrameTypeResponse, "OK")
+
+	sub(t, compressedConn, topicName, "ch")
+	err = nsq.Ready(1).Write(compressedConn)
+	assert.Equal(t, err, nil)
+
+	resp, err := nsq.ReadResponse(compressedConn)
+	assert.Equal(t, err, nil)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	msgOut, _ := nsq.DecodeMessage(data)
+	assert.Equal(t, frameType, nsq.FrameTypeMessage)
+	assert.Equal(t, msgOut.Id, msg.Id)
+	assert.Equal(t, msgOut.Body, msg.Body)
+}
+
+func TestTLSSnappy(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	options := NewNsqdOptions()
+	options.tlsCert, options.tlsKey = mustGenerateCert(t)
+	defer os.RemoveAll(path.Dir(options.tlsCert))
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{
+		"tls_v1": true,
+		"snappy": true,
+	})
+	assert.Equal(t, r.TLSv1, true)
+	assert.Equal(t, r.Snappy, true)
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	err = tlsConn.Handshake()
+	assert.Equal(t, err, nil)
+	readValidate(t, tlsConn, nsq.FrameTypeResponse, "OK")
+
+	compressedConn := &readWriter{
+		snappystream.NewReader(tlsConn, snappystream.SkipVerifyChecksum),
+		snappystream.NewWriter(tlsConn),
+	}
+	readValidate(t, compressedConn, nsq.FrameTypeResponse, "OK")
+
+	sub(t, compressedConn, "test_tls_snappy", "ch")
+}
+
+func TestDeflateAndSnappy(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	tcpAddr, _ := mustStartNSQd(NewNsqdOptions())
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	ci := make(map[string]interface{})
+	ci["feature_negotiation"] = true
+	ci["deflate"] = true
+	ci["snappy"] = true
+	cmd, _ := nsq.Identify(ci)
+	err = cmd.Write(conn)
+	assert.Equal(t, err, nil)
+	readValidate(t, conn, nsq.FrameTypeError,
+		"E_BAD_BODY IDENTIFY cannot enable both deflate and snappy compression")
+}
+
+// readWriter pairs the two halves of a compressed stream
+type readWriter struct {
+	io.Reader
+	w io.Writer
+}
+
+func (rw *readWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if fw, ok := rw.w.(*flate.Writer); ok {
+		err = fw.Flush()
+	}
+	return n, err
+}
+
 func BenchmarkProtocolV2Exec(b *testing.B) {
 	b.StopTimer()
 	log.SetOutput(ioutil.Discard)
@@ -918,3 +1257,155 @@ func BenchmarkProtocolV2MultiSub2(b *testing.B)  { benchmarkProtocolV2MultiSub(b
 func BenchmarkProtocolV2MultiSub4(b *testing.B)  { benchmarkProtocolV2MultiSub(b, 4) }
 func BenchmarkProtocolV2MultiSub8(b *testing.B)  { benchmarkProtocolV2MultiSub(b, 8) }
 func BenchmarkProtocolV2MultiSub16(b *testing.B) { benchmarkProtocolV2MultiSub(b, 16) }
+
+func TestSampling(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	num := 2000
+	sampleRate := 42
+
+	*verbose = true
+	options := NewNsqdOptions()
+	options.maxRdyCount = int64(num)
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_sampling" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	for i := 0; i < num; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test body"))
+		topic.PutMessage(msg)
+	}
+	channel := topic.GetChannel("ch")
+
+	conn, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+
+	r := identifyFeatureNegotiationUpgrade(t, conn, map[string]interface{}{
+		"sample_rate": sampleRate,
+	})
+	assert.Equal(t, r.SampleRate, int32(sampleRate))
+
+	sub(t, conn, topicName, "ch")
+	err = nsq.Ready(num).Write(conn)
+	assert.Equal(t, err, nil)
+
+	// messages that are not chosen never consume RDY so the
+	// channel is drained regardless of the sample rate
+	for i := 0; i < 100 && channel.Depth() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, channel.Depth(), int64(0))
+
+	var count int
+	for {
+		conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		resp, err := nsq.ReadResponse(conn)
+		if err != nil {
+			break
+		}
+		frameType, _, _ := nsq.UnpackResponse(resp)
+		assert.Equal(t, frameType, nsq.FrameTypeMessage)
+		count++
+	}
+
+	actualSampleRate := float64(count) / float64(num)
+	assert.T(t, actualSampleRate > float64(sampleRate-5)/100.0 &&
+		actualSampleRate < float64(sampleRate+5)/100.0)
+
+	client := nsqd.getStats()[0].Channels[0].Clients[0]
+	assert.Equal(t, client.SampleRate, int32(sampleRate))
+	assert.Equal(t, client.SampledCount, uint64(num))
+	assert.Equal(t, client.MessageCount, uint64(count))
+}
+
+func TestSamplingWithOtherClients(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	num := 500
+
+	*verbose = true
+	options := NewNsqdOptions()
+	options.maxRdyCount = int64(num)
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	topicName := "test_sampling_others" + strconv.Itoa(int(time.Now().Unix()))
+
+	sampled, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+	identifyFeatureNegotiationUpgrade(t, sampled, map[string]interface{}{
+		"sample_rate": 10,
+	})
+	sub(t, sampled, topicName, "ch")
+
+	unsampled, err := mustConnectNSQd(tcpAddr)
+	assert.Equal(t, err, nil)
+	identify(t, unsampled)
+	sub(t, unsampled, topicName, "ch")
+
+	for _, conn := range []net.Conn{sampled, unsampled} {
+		err = nsq.Ready(num).Write(conn)
+		assert.Equal(t, err, nil)
+	}
+	time.Sleep(25 * time.Millisecond)
+
+	topic := nsqd.GetTopic(topicName)
+	for i := 0; i < num; i++ {
+		msg := nsq.NewMessage(<-nsqd.idChan, []byte("test body"))
+		topic.PutMessage(msg)
+	}
+
+	// the messages the sampling client does not choose are
+	// delivered to the other client instead of being lost
+	counts := make(chan int)
+	for _, conn := range []net.Conn{sampled, unsampled} {
+		go func(conn net.Conn) {
+			var count int
+			for {
+				conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+				resp, err := nsq.ReadResponse(conn)
+				if err != nil {
+					break
+				}
+				frameType, data, _ := nsq.UnpackResponse(resp)
+				if frameType == nsq.FrameTypeMessage {
+					msg, _ := nsq.DecodeMessage(data)
+					if msg.Attempts == 1 {
+						count++
+					}
+				}
+			}
+			counts <- count
+		}(conn)
+	}
+	count := <-counts + <-counts
+	assert.Equal(t, count, num)
+}
+
+func TestSamplingValidity(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	*verbose = true
+	options := NewNsqdOptions()
+	tcpAddr, _ := mustStartNSQd(options)
+	defer nsqd.Exit()
+
+	for _, sampleRate := range []int{-1, 100} {
+		conn, err := mustConnectNSQd(tcpAddr)
+		assert.Equal(t, err, nil)
+
+		ci := make(map[string]interface{})
+		ci["short_id"] = "test"
+		ci["long_id"] = "test"
+		ci["sample_rate"] = sampleRate
+		cmd, _ := nsq.Identify(ci)
+		err = cmd.Write(conn)
+		assert.Equal(t, err, nil)
+		readValidate(t, conn, nsq.FrameTypeError,
+			fmt.Sprintf("E_BAD_BODY IDENTIFY sample rate (%d) is invalid", sampleRate))
+	}
+}
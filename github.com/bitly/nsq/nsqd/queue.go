@@ -16,6 +16,9 @@ type BackendQueue interface {
 	Empty() error
 }
 
+// DummyBackendQueue is the memory-only backend used by ephemeral
+// topics and channels, anything that overflows the in-memory
+// queue is dropped
 type DummyBackendQueue struct {
 	readChan chan []byte
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"github.com/bitly/nsq/util"
 	"sort"
+	"sync/atomic"
 )
 
 type TopicStats struct {
@@ -10,16 +12,22 @@ type TopicStats struct {
 	Depth        int64          `json:"depth"`
 	BackendDepth int64          `json:"backend_depth"`
 	MessageCount uint64         `json:"message_count"`
+
+	E2eProcessingLatency *util.PercentileResult `json:"e2e_processing_latency,omitempty"`
 }
 
-func NewTopicStats(t *Topic, channels []ChannelStats) TopicStats {
-	return TopicStats{
+func NewTopicStats(t *Topic, channels []ChannelStats, e2eProcessingLatency *util.Quantile) TopicStats {
+	ts := TopicStats{
 		TopicName:    t.name,
 		Channels:     channels,
 		Depth:        t.Depth(),
 		BackendDepth: t.backend.Depth(),
-		MessageCount: t.messageCount,
+		MessageCount: atomic.LoadUint64(&t.messageCount),
+	}
+	if e2eProcessingLatency != nil {
+		ts.E2eProcessingLatency = e2eProcessingLatency.Result()
 	}
+	return ts
 }
 
 type ChannelStats struct {
@@ -33,21 +41,27 @@ type ChannelStats struct {
 	TimeoutCount  uint64        `json:"timeout_count"`
 	Clients       []ClientStats `json:"clients"`
 	Paused        bool          `json:"paused"`
+
+	E2eProcessingLatency *util.PercentileResult `json:"e2e_processing_latency,omitempty"`
 }
 
 func NewChannelStats(c *Channel, clients []ClientStats) ChannelStats {
-	return ChannelStats{
+	cs := ChannelStats{
 		ChannelName:   c.name,
 		Depth:         c.Depth(),
 		BackendDepth:  c.backend.Depth(),
 		InFlightCount: len(c.inFlightMessages),
 		DeferredCount: len(c.deferredMessages),
-		MessageCount:  c.messageCount,
-		RequeueCount:  c.requeueCount,
-		TimeoutCount:  c.timeoutCount,
+		MessageCount:  atomic.LoadUint64(&c.messageCount),
+		RequeueCount:  atomic.LoadUint64(&c.requeueCount),
+		TimeoutCount:  atomic.LoadUint64(&c.timeoutCount),
 		Clients:       clients,
 		Paused:        c.IsPaused(),
 	}
+	if c.e2eProcessingLatencyStream != nil {
+		cs.E2eProcessingLatency = c.e2eProcessingLatencyStream.Result()
+	}
+	return cs
 }
 
 type ClientStats struct {
@@ -60,7 +74,12 @@ type ClientStats struct {
 	MessageCount  uint64 `json:"message_count"`
 	FinishCount   uint64 `json:"finish_count"`
 	RequeueCount  uint64 `json:"requeue_count"`
+	SampleRate    int32  `json:"sample_rate"`
+	SampledCount  uint64 `json:"sampled_count"`
 	ConnectTime   int64  `json:"connect_ts"`
+	TLS           bool   `json:"tls"`
+	Deflate       bool   `json:"deflate"`
+	Snappy        bool   `json:"snappy"`
 }
 
 type Topics []*Topic
@@ -120,9 +139,9 @@ func (n *NSQd) getStats() []TopicStats {
 			c.RUnlock()
 		}
 
-		topics[topic_index] = NewTopicStats(t, channels)
-
 		t.RUnlock()
+
+		topics[topic_index] = NewTopicStats(t, channels, t.AggregateChannelE2eProcessingLatency())
 	}
 
 	return topics
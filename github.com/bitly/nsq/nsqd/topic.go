@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"container/heap"
 	"errors"
 	"github.com/bitly/nsq/nsq"
 	"github.com/bitly/nsq/util"
+	"github.com/bitly/nsq/util/pqueue"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Topic struct {
@@ -24,24 +29,41 @@ type Topic struct {
 	messageCount      uint64
 	notifier          Notifier
 	options           *nsqdOptions
+	ephemeralTopic    bool
+	deleteCallback    func(*Topic)
+	deleter           sync.Once
+
+	// messages published with a delay, routed once their timeout elapses
+	deferredPQ    pqueue.PriorityQueue
+	deferredMutex sync.Mutex
 }
 
 // Topic constructor
-func NewTopic(topicName string, options *nsqdOptions, notifier Notifier) *Topic {
+func NewTopic(topicName string, options *nsqdOptions, notifier Notifier, deleteCallback func(*Topic)) *Topic {
 	topic := &Topic{
 		name:              topicName,
 		channelMap:        make(map[string]*Channel),
-		backend:           NewDiskQueue(topicName, options.dataPath, options.maxBytesPerFile, options.syncEvery, options.syncTimeout),
 		incomingMsgChan:   make(chan *nsq.Message, 1),
 		memoryMsgChan:     make(chan *nsq.Message, options.memQueueSize),
+		deferredPQ:        pqueue.New(int(math.Max(1, float64(options.memQueueSize)/10))),
 		notifier:          notifier,
 		options:           options,
 		exitChan:          make(chan int),
 		channelUpdateChan: make(chan int),
+		deleteCallback:    deleteCallback,
+	}
+
+	if strings.HasSuffix(topicName, "#ephemeral") {
+		topic.ephemeralTopic = true
+		topic.backend = NewDummyBackendQueue()
+	} else {
+		topic.backend = NewDiskQueue(topicName, options.dataPath, options.maxBytesPerFile,
+			options.maxDiskQueueMsgSize(), options.syncEvery, options.syncTimeout)
 	}
 
 	topic.waitGroup.Wrap(func() { topic.router() })
 	topic.waitGroup.Wrap(func() { topic.messagePump() })
+	topic.waitGroup.Wrap(func() { topic.deferredWorker() })
 
 	go notifier.Notify(topic)
 
@@ -106,6 +128,7 @@ func (t *Topic) DeleteExistingChannel(channelName string) error {
 		return errors.New("channel does not exist")
 	}
 	delete(t.channelMap, channelName)
+	numChannels := len(t.channelMap)
 	// not defered so that we can continue while the channel async closes
 	t.Unlock()
 
@@ -121,6 +144,11 @@ func (t *Topic) DeleteExistingChannel(channelName string) error {
 	case <-t.exitChan:
 	}
 
+	// ephemeral topics go away along with their last channel
+	if numChannels == 0 && t.ephemeralTopic == true {
+		go t.deleter.Do(func() { t.deleteCallback(t) })
+	}
+
 	return nil
 }
 
@@ -149,6 +177,44 @@ func (t *Topic) PutMessages(messages []*nsq.Message) error {
 	return nil
 }
 
+// PutDeferredMessage holds a message in the topic's deferred priority queue
+// and routes it to every channel once the specified timeout has elapsed
+//
+// NOTE: deferred messages are only held in memory, if the topic is closed
+// before the timeout elapses the message is persisted without its delay
+func (t *Topic) PutDeferredMessage(msg *nsq.Message, timeout time.Duration) error {
+	t.RLock()
+	defer t.RUnlock()
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	item := &pqueue.Item{Value: msg, Priority: time.Now().Add(timeout).UnixNano()}
+	t.deferredMutex.Lock()
+	heap.Push(&t.deferredPQ, item)
+	t.deferredMutex.Unlock()
+	atomic.AddUint64(&t.messageCount, 1)
+	return nil
+}
+
+// AggregateChannelE2eProcessingLatency merges the end-to-end latency of all
+// of the topic's channels, it returns nil if latency is not being tracked
+func (t *Topic) AggregateChannelE2eProcessingLatency() *util.Quantile {
+	if len(t.options.e2eProcessingLatencyPercentiles) == 0 {
+		return nil
+	}
+
+	q := util.NewQuantile(t.options.e2eProcessingLatencyWindowTime,
+		t.options.e2eProcessingLatencyPercentiles)
+	t.RLock()
+	for _, c := range t.channelMap {
+		if c.e2eProcessingLatencyStream != nil {
+			q.Merge(c.e2eProcessingLatencyStream)
+		}
+	}
+	t.RUnlock()
+	return q
+}
+
 func (t *Topic) Depth() int64 {
 	return int64(len(t.memoryMsgChan)) + t.backend.Depth()
 }
@@ -162,6 +228,7 @@ func (t *Topic) messagePump() {
 	var chans []*Channel
 	var memoryMsgChan chan *nsq.Message
 	var backendChan chan []byte
+	var idleChan <-chan time.Time
 
 	t.RLock()
 	for _, c := range t.channelMap {
@@ -172,6 +239,8 @@ func (t *Topic) messagePump() {
 	if len(chans) > 0 {
 		memoryMsgChan = t.memoryMsgChan
 		backendChan = t.backend.ReadChan()
+	} else if t.ephemeralTopic {
+		idleChan = time.After(t.options.ephemeralTopicTimeout)
 	}
 
 	for {
@@ -193,11 +262,22 @@ func (t *Topic) messagePump() {
 			if len(chans) == 0 {
 				memoryMsgChan = nil
 				backendChan = nil
+				if t.ephemeralTopic {
+					idleChan = time.After(t.options.ephemeralTopicTimeout)
+				}
 			} else {
 				memoryMsgChan = t.memoryMsgChan
 				backendChan = t.backend.ReadChan()
+				idleChan = nil
 			}
 			continue
+		case <-idleChan:
+			// an ephemeral topic that never gets (or never gets back) a
+			// channel would otherwise live forever
+			log.Printf("TOPIC(%s): no channels for %s, deleting", t.name, t.options.ephemeralTopicTimeout)
+			go t.deleter.Do(func() { t.deleteCallback(t) })
+			idleChan = nil
+			continue
 		case <-t.exitChan:
 			goto exit
 		}
@@ -212,7 +292,7 @@ func (t *Topic) messagePump() {
 				chanMsg = nsq.NewMessage(msg.Id, msg.Body)
 				chanMsg.Timestamp = msg.Timestamp
 			}
-			err := channel.PutMessage(chanMsg)
+			err = channel.PutMessage(chanMsg)
 			if err != nil {
 				log.Printf("TOPIC(%s) ERROR: failed to put msg(%s) to channel(%s) - %s", t.name, msg.Id, channel.name, err.Error())
 			}
@@ -223,6 +303,50 @@ exit:
 	log.Printf("TOPIC(%s): closing ... messagePump", t.name)
 }
 
+// deferredWorker periodically wakes up to route the deferred messages
+// whose timeout has elapsed as if they had just been published
+func (t *Topic) deferredWorker() {
+	ticker := time.NewTicker(defaultWorkerWait)
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.exitChan:
+			goto exit
+		}
+		now := time.Now().UnixNano()
+		for {
+			t.deferredMutex.Lock()
+			item, _ := t.deferredPQ.PeekAndShift(now)
+			t.deferredMutex.Unlock()
+
+			if item == nil {
+				break
+			}
+
+			err := t.route(item.Value.(*nsq.Message))
+			if err != nil {
+				goto exit
+			}
+		}
+	}
+
+exit:
+	log.Printf("TOPIC(%s): closing ... deferredWorker", t.name)
+	ticker.Stop()
+}
+
+// route writes to the incoming message channel without counting the
+// message again (it was counted when it was deferred)
+func (t *Topic) route(msg *nsq.Message) error {
+	t.RLock()
+	defer t.RUnlock()
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	t.incomingMsgChan <- msg
+	return nil
+}
+
 // router handles muxing of Topic messages including
 // proxying messages to memory or backend
 func (t *Topic) router() {
@@ -314,6 +438,9 @@ func (t *Topic) Empty() error {
 	}
 
 finish:
+	t.deferredMutex.Lock()
+	t.deferredPQ = t.deferredPQ[:0]
+	t.deferredMutex.Unlock()
 	return t.backend.Empty()
 }
 
@@ -337,5 +464,21 @@ func (t *Topic) flush() error {
 	}
 
 finish:
+	// the backend has no notion of a delay, these messages will be
+	// delivered as soon as the topic is loaded again
+	t.deferredMutex.Lock()
+	defer t.deferredMutex.Unlock()
+	if len(t.deferredPQ) > 0 {
+		log.Printf("TOPIC(%s): WARNING: flushing %d deferred messages to backend, their remaining delay is lost",
+			t.name, len(t.deferredPQ))
+	}
+	for _, item := range t.deferredPQ {
+		msg := item.Value.(*nsq.Message)
+		err := WriteMessageToBackend(&msgBuf, msg, t.backend)
+		if err != nil {
+			log.Printf("ERROR: failed to write message to backend - %s", err.Error())
+		}
+	}
+
 	return nil
 }
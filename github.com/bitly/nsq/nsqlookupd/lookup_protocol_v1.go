@@ -170,17 +170,26 @@ func (p *LookupProtocolV1) UNREGISTER(client *ClientV1, reader *bufio.Reader, pa
 		// if anything is actually removed
 		registrations := lookupd.DB.FindRegistrations("channel", topic, "*")
 		for _, r := range registrations {
-			if removed, _ := lookupd.DB.RemoveProducer(r, client.peerInfo.id); removed {
+			removed, left := lookupd.DB.RemoveProducer(r, client.peerInfo.id)
+			if removed {
 				log.Printf("WARNING: client(%s) unexpected UNREGISTER category:%s key:%s subkey:%s",
 					client, "channel", topic, r.SubKey)
 			}
+			if left == 0 && (strings.HasSuffix(topic, "#ephemeral") || strings.HasSuffix(r.SubKey, "#ephemeral")) {
+				lookupd.DB.RemoveRegistration(r)
+			}
 		}
 
 		key := Registration{"topic", topic, ""}
-		if removed, _ := lookupd.DB.RemoveProducer(key, client.peerInfo.id); removed {
+		removed, left := lookupd.DB.RemoveProducer(key, client.peerInfo.id)
+		if removed {
 			log.Printf("DB: client(%s) UNREGISTER category:%s key:%s subkey:%s",
 				client, "topic", topic, "")
 		}
+		// for ephemeral topics, remove the topic as well if it has no producers
+		if left == 0 && strings.HasSuffix(topic, "#ephemeral") {
+			lookupd.DB.RemoveRegistration(key)
+		}
 	}
 
 	return []byte("OK"), nil
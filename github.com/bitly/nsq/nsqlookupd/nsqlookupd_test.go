@@ -194,6 +194,39 @@ func TestChannelUnregister(t *testing.T) {
 	assert.Equal(t, len(returnedProducers), 1)
 }
 
+func TestEphemeralTopicUnregister(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stdout)
+
+	tcpAddr, _ := mustStartLookupd()
+	defer lookupd.Exit()
+
+	topicName := "ephemeral_unregister#ephemeral"
+
+	conn := mustConnectLookupd(t, tcpAddr)
+	identify(t, conn, "ip.address", 5000, 5555, "fake-version")
+
+	nsq.Register(topicName, "ch1").Write(conn)
+	v, err := nsq.ReadResponse(conn)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, v, []byte("OK"))
+
+	topics := lookupd.DB.FindRegistrations("topic", topicName, "")
+	assert.Equal(t, len(topics), 1)
+
+	nsq.UnRegister(topicName, "").Write(conn)
+	v, err = nsq.ReadResponse(conn)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, v, []byte("OK"))
+
+	// unlike a normal topic, nothing about an ephemeral topic outlives its producers
+	topics = lookupd.DB.FindRegistrations("topic", topicName, "")
+	assert.Equal(t, len(topics), 0)
+
+	channels := lookupd.DB.FindRegistrations("channel", topicName, "*")
+	assert.Equal(t, len(channels), 0)
+}
+
 func TestTombstoneRecover(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 	defer log.SetOutput(os.Stdout)
@@ -0,0 +1,165 @@
+// Package diskqueue describes the on-disk layout of the data files written
+// by nsqd's DiskQueue so that it can be shared with offline tooling
+//
+// version 0 files (written before checksums were introduced) have no header
+// and consist of length prefixed records:
+//
+//    [ 4-byte size ][ N-byte data ]
+//
+// version 1 files begin with an 8 byte header (4-byte magic, 4-byte version)
+// and every record carries a CRC32 (IEEE) of its data:
+//
+//    [ 4-byte size ][ 4-byte crc32 ][ N-byte data ]
+//
+// all integers are big endian
+package diskqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+)
+
+const (
+	Version0       int32 = 0
+	Version1       int32 = 1
+	CurrentVersion       = Version1
+)
+
+const HeaderSize = 8
+
+var magic = []byte("NSDQ")
+
+var ErrChecksum = errors.New("checksum mismatch")
+
+// HeaderLen returns the number of bytes that precede the first
+// record of a file of the given version
+func HeaderLen(version int32) int64 {
+	if version == Version0 {
+		return 0
+	}
+	return HeaderSize
+}
+
+// RecordLen returns the number of bytes a record of dataLen bytes
+// occupies in a file of the given version
+func RecordLen(version int32, dataLen int) int64 {
+	if version == Version0 {
+		return int64(4 + dataLen)
+	}
+	return int64(8 + dataLen)
+}
+
+// ReadHeader determines the version of a data file
+//
+// files that do not start with the magic bytes are considered version 0
+// (a version 0 file would need a first record ~1.3GB in size to collide)
+func ReadHeader(r io.ReaderAt) (int32, error) {
+	var header [HeaderSize]byte
+
+	n, err := r.ReadAt(header[:], 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if n < HeaderSize || !bytes.Equal(header[:4], magic) {
+		return Version0, nil
+	}
+
+	version := int32(binary.BigEndian.Uint32(header[4:]))
+	if version <= Version0 || version > CurrentVersion {
+		return 0, fmt.Errorf("unsupported diskqueue file version %d", version)
+	}
+	return version, nil
+}
+
+// WriteHeader writes the header for a new data file of the given version
+func WriteHeader(w io.Writer, version int32) error {
+	if version == Version0 {
+		return nil
+	}
+	_, err := w.Write(magic)
+	if err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, version)
+}
+
+// WriteRecord writes a single record in the format of the given version
+func WriteRecord(w io.Writer, version int32, data []byte) error {
+	err := binary.Write(w, binary.BigEndian, int32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	if version != Version0 {
+		err = binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadRecord reads a single record in the format of the given version,
+// returning ErrChecksum if the data does not match its checksum
+//
+// the size prefix is validated against maxSize before anything is allocated
+// so that a corrupt prefix cannot trigger an arbitrarily large allocation
+//
+// a record that is cut short returns io.ErrUnexpectedEOF whereas
+// io.EOF is only returned when there are no more records
+func ReadRecord(r io.Reader, version int32, maxSize int32) ([]byte, error) {
+	var msgSize int32
+	var checksum uint32
+
+	err := binary.Read(r, binary.BigEndian, &msgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgSize < 0 || msgSize > maxSize {
+		return nil, fmt.Errorf("invalid record size %d (max %d)", msgSize, maxSize)
+	}
+
+	if version != Version0 {
+		err = binary.Read(r, binary.BigEndian, &checksum)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+	}
+
+	data := make([]byte, msgSize)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+
+	if version != Version0 && crc32.ChecksumIEEE(data) != checksum {
+		return nil, ErrChecksum
+	}
+
+	return data, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// FileName returns the path of the data file fileNum for the named queue
+func FileName(dataPath string, name string, fileNum int64) string {
+	return fmt.Sprintf(path.Join(dataPath, "%s.diskqueue.%06d.dat"), name, fileNum)
+}
+
+// MetaDataFileName returns the path of the metadata file for the named queue
+func MetaDataFileName(dataPath string, name string) string {
+	return fmt.Sprintf(path.Join(dataPath, "%s.diskqueue.meta.dat"), name)
+}
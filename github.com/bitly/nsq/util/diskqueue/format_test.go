@@ -0,0 +1,63 @@
+package diskqueue
+
+import (
+	"bytes"
+	"github.com/bmizerany/assert"
+	"io"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	for _, version := range []int32{Version0, Version1} {
+		var buf bytes.Buffer
+		err := WriteHeader(&buf, version)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, int64(buf.Len()), HeaderLen(version))
+
+		data := []byte("test message")
+		err = WriteRecord(&buf, version, data)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, int64(buf.Len()), HeaderLen(version)+RecordLen(version, len(data)))
+
+		r := bytes.NewReader(buf.Bytes())
+		v, err := ReadHeader(r)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, v, version)
+
+		r.Seek(HeaderLen(version), 0)
+		out, err := ReadRecord(r, version, 1024)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, out, data)
+
+		_, err = ReadRecord(r, version, 1024)
+		assert.Equal(t, err, io.EOF)
+	}
+}
+
+func TestRecordCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	WriteRecord(&buf, Version1, []byte("test message"))
+	b := buf.Bytes()
+
+	b[len(b)-1] ^= 0xff
+	_, err := ReadRecord(bytes.NewReader(b), Version1, 1024)
+	assert.Equal(t, err, ErrChecksum)
+
+	_, err = ReadRecord(bytes.NewReader(b[:len(b)-3]), Version1, 1024)
+	assert.Equal(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRecordTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	WriteRecord(&buf, Version1, []byte("test message"))
+
+	_, err := ReadRecord(bytes.NewReader(buf.Bytes()), Version1, 4)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	var buf bytes.Buffer
+	WriteHeader(&buf, CurrentVersion+1)
+	_, err := ReadHeader(bytes.NewReader(buf.Bytes()))
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FloatArray is a flag.Value accepting a comma separated list of
+// floats (and/or being given multiple times)
+type FloatArray []float64
+
+func (a *FloatArray) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return err
+		}
+		*a = append(*a, f)
+	}
+	return nil
+}
+
+func (a *FloatArray) String() string {
+	return fmt.Sprint(*a)
+}
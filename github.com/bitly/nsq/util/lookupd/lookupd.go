@@ -355,6 +355,8 @@ func GetNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicStats,
 					MessageCount: int64(topicInfo["message_count"].(float64)),
 					ChannelCount: len(topicInfo["channels"].([]interface{})),
 					Topic:        topicName,
+
+					E2eProcessingLatency: parseE2eProcessingLatency(topicInfo["e2e_processing_latency"]),
 				}
 				topicStats = append(topicStats, h)
 
@@ -391,6 +393,7 @@ func GetNSQDStats(nsqdHTTPAddrs []string, selectedTopic string) ([]*TopicStats,
 					h.MessageCount = int64(c["message_count"].(float64))
 					h.RequeueCount = int64(c["requeue_count"].(float64))
 					h.TimeoutCount = int64(c["timeout_count"].(float64))
+					h.E2eProcessingLatency = parseE2eProcessingLatency(c["e2e_processing_latency"])
 					clients := c["clients"].([]interface{})
 					// TODO: this is sort of wrong; clients should be de-duped
 					// client A that connects to NSQD-a and NSQD-b should only be counted once. right?
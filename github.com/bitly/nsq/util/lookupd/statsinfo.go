@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/bitly/nsq/util/semver"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -56,6 +57,8 @@ type TopicStats struct {
 	ChannelCount int
 	Topic        string
 	Aggregate    bool
+
+	E2eProcessingLatency *E2eProcessingLatency
 }
 
 func (t *TopicStats) AddHostStats(a *TopicStats) {
@@ -68,6 +71,7 @@ func (t *TopicStats) AddHostStats(a *TopicStats) {
 	if a.ChannelCount > t.ChannelCount {
 		t.ChannelCount = a.ChannelCount
 	}
+	t.E2eProcessingLatency = addE2eProcessingLatency(t.E2eProcessingLatency, a.E2eProcessingLatency)
 }
 
 func (t *TopicStats) Target(key string) (string, string) {
@@ -100,6 +104,8 @@ type ChannelStats struct {
 	HostStats     []*ChannelStats
 	Clients       []*ClientInfo
 	Paused        bool
+
+	E2eProcessingLatency *E2eProcessingLatency
 }
 
 func (c *ChannelStats) AddHostStats(a *ChannelStats) {
@@ -115,6 +121,7 @@ func (c *ChannelStats) AddHostStats(a *ChannelStats) {
 	if a.Paused {
 		c.Paused = a.Paused
 	}
+	c.E2eProcessingLatency = addE2eProcessingLatency(c.E2eProcessingLatency, a.E2eProcessingLatency)
 	c.HostStats = append(c.HostStats, a)
 	sort.Sort(ChannelStatsByHost{c.HostStats})
 }
@@ -190,3 +197,80 @@ func graphiteHostKey(h string) string {
 	s := strings.Replace(h, ".", "_", -1)
 	return strings.Replace(s, ":", "_", -1)
 }
+
+// E2eProcessingLatency is the end-to-end (PUB to FIN) processing latency
+// nsqd reports for a topic or channel
+//
+// percentiles cannot be combined exactly across hosts so an aggregate
+// reports the worst (highest) value of any of its hosts
+type E2eProcessingLatency struct {
+	Count       int
+	Percentiles []*E2ePercentile
+}
+
+type E2ePercentile struct {
+	Quantile float64
+	Value    time.Duration
+}
+
+func (p *E2ePercentile) Label() string {
+	return "p" + strconv.FormatFloat(p.Quantile*100, 'g', 6, 64)
+}
+
+func (e *E2eProcessingLatency) Add(a *E2eProcessingLatency) {
+	e.Count += a.Count
+	for _, ap := range a.Percentiles {
+		var found bool
+		for _, p := range e.Percentiles {
+			if p.Quantile == ap.Quantile {
+				if ap.Value > p.Value {
+					p.Value = ap.Value
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.Percentiles = append(e.Percentiles, &E2ePercentile{ap.Quantile, ap.Value})
+		}
+	}
+}
+
+// addE2eProcessingLatency merges a (possibly nil) host value into an aggregate
+func addE2eProcessingLatency(e *E2eProcessingLatency, a *E2eProcessingLatency) *E2eProcessingLatency {
+	if a == nil {
+		return e
+	}
+	if e == nil {
+		e = &E2eProcessingLatency{}
+	}
+	e.Add(a)
+	return e
+}
+
+// parseE2eProcessingLatency converts the "e2e_processing_latency" value of
+// nsqd's /stats JSON, it returns nil if nsqd is not tracking latency
+func parseE2eProcessingLatency(v interface{}) *E2eProcessingLatency {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	e := &E2eProcessingLatency{}
+	if count, ok := m["count"].(float64); ok {
+		e.Count = int(count)
+	}
+	percentiles, _ := m["percentiles"].([]interface{})
+	for _, p := range percentiles {
+		p, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		q, _ := p["quantile"].(float64)
+		value, _ := p["value"].(float64)
+		// round to the microsecond for display
+		d := time.Duration(value)
+		d -= d % time.Microsecond
+		e.Percentiles = append(e.Percentiles, &E2ePercentile{q, d})
+	}
+	return e
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"fmt"
+	"github.com/bmizerany/perks/quantile"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Percentile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Label returns the conventional short name of the percentile (ie. p99.9)
+func (p Percentile) Label() string {
+	return "p" + strconv.FormatFloat(p.Quantile*100, 'g', 6, 64)
+}
+
+type PercentileResult struct {
+	Count       int          `json:"count"`
+	Percentiles []Percentile `json:"percentiles"`
+}
+
+func (r *PercentileResult) String() string {
+	parts := []string{fmt.Sprintf("count: %d", r.Count)}
+	for _, p := range r.Percentiles {
+		parts = append(parts, fmt.Sprintf("%s: %s", p.Label(), time.Duration(p.Value)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Quantile is a streaming estimator of a fixed set of percentiles
+// over a sliding window of time
+//
+// it rotates between two streams, each covering half of the window,
+// so results reflect between windowTime/2 and windowTime of samples
+type Quantile struct {
+	sync.Mutex
+	streams        [2]*quantile.Stream
+	currentIndex   int
+	lastMoveWindow time.Time
+	percentiles    []float64
+	moveWindowTime time.Duration
+}
+
+func NewQuantile(windowTime time.Duration, percentiles []float64) *Quantile {
+	q := &Quantile{
+		percentiles:    percentiles,
+		moveWindowTime: windowTime / 2,
+		lastMoveWindow: time.Now(),
+	}
+	for i := range q.streams {
+		q.streams[i] = quantile.NewTargeted(percentiles...)
+	}
+	return q
+}
+
+// Insert records the time elapsed since msgStartTime (in nanoseconds)
+func (q *Quantile) Insert(msgStartTime int64) {
+	now := time.Now()
+	q.Lock()
+	q.moveWindow(now)
+	q.streams[q.currentIndex].Insert(float64(now.UnixNano() - msgStartTime))
+	q.Unlock()
+}
+
+// Merge adds the samples of another Quantile (tracking the
+// same percentiles) to the current window of this one
+func (q *Quantile) Merge(them *Quantile) {
+	them.Lock()
+	var samples quantile.Samples
+	for _, s := range them.streams {
+		samples = append(samples, s.Samples()...)
+	}
+	them.Unlock()
+
+	q.Lock()
+	q.streams[q.currentIndex].Merge(samples)
+	q.Unlock()
+}
+
+// Result returns the current value of each percentile (in nanoseconds)
+func (q *Quantile) Result() *PercentileResult {
+	q.Lock()
+	defer q.Unlock()
+
+	q.moveWindow(time.Now())
+
+	merged := quantile.NewTargeted(q.percentiles...)
+	for _, s := range q.streams {
+		samples := append(quantile.Samples(nil), s.Samples()...)
+		merged.Merge(samples)
+	}
+
+	result := &PercentileResult{
+		Count:       q.streams[0].Count() + q.streams[1].Count(),
+		Percentiles: make([]Percentile, 0, len(q.percentiles)),
+	}
+	for _, p := range q.percentiles {
+		result.Percentiles = append(result.Percentiles, Percentile{p, merged.Query(p)})
+	}
+	return result
+}
+
+// moveWindow discards the older half of the window once it has expired,
+// this expects the caller to handle locking
+func (q *Quantile) moveWindow(now time.Time) {
+	if now.Sub(q.lastMoveWindow) < q.moveWindowTime {
+		return
+	}
+
+	// if both halves have expired there is nothing left worth keeping
+	if now.Sub(q.lastMoveWindow) >= 2*q.moveWindowTime {
+		q.streams[q.currentIndex].Reset()
+	}
+
+	q.currentIndex = (q.currentIndex + 1) % len(q.streams)
+	q.streams[q.currentIndex].Reset()
+	q.lastMoveWindow = now
+}
@@ -31,6 +31,14 @@ func (r *AccessLogRecord) FormatRequestHeader(k string) (v string) {
 	return
 }
 
+func (r *AccessLogRecord) FormatTlsVersion() string {
+	if r.Request.TLS == nil {
+		return "-"
+	}
+
+	return tlsVersionName(r.Request.TLS)
+}
+
 func (r *AccessLogRecord) ResponseTime() float64 {
 	return float64(r.FinishedAt.UnixNano()-r.StartedAt.UnixNano()) / float64(time.Second)
 }
@@ -46,7 +54,8 @@ func (r *AccessLogRecord) WriteTo(w io.Writer) (int64, error) {
 	fmt.Fprintf(b, `"%s" `, r.FormatRequestHeader("User-Agent"))
 	fmt.Fprintf(b, `%s `, r.Request.RemoteAddr)
 	fmt.Fprintf(b, `response_time:%.9f `, r.ResponseTime())
-	fmt.Fprintf(b, `app_id:%s`, r.Backend.ApplicationId)
+	fmt.Fprintf(b, `app_id:%s `, r.Backend.ApplicationId)
+	fmt.Fprintf(b, `tls_version:%s`, r.FormatTlsVersion())
 	fmt.Fprint(b, "\n")
 	return b.WriteTo(w)
 }
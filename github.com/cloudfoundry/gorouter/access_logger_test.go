@@ -68,7 +68,8 @@ func (s *AccessLoggerSuite) TestAccessLogRecordEncode(c *C) {
 		regexp.QuoteMeta(`"user-agent" `) +
 		regexp.QuoteMeta(`1.2.3.4:5678 `) +
 		regexp.QuoteMeta(`response_time:0.200000000 `) +
-		regexp.QuoteMeta(`app_id:my_awesome_id`)
+		regexp.QuoteMeta(`app_id:my_awesome_id `) +
+		regexp.QuoteMeta(`tls_version:-`)
 
 	b := &bytes.Buffer{}
 	_, err := r.WriteTo(b)
@@ -43,10 +43,25 @@ var defaultLoggingConfig = LoggingConfig{
 	Level: "debug",
 }
 
+type TlsCertificateConfig struct {
+	CertFile string "cert_file"
+	KeyFile  string "key_file"
+}
+
+type TlsConfig struct {
+	Port         uint16                 "port"
+	Certificates []TlsCertificateConfig "certificates"
+}
+
+var defaultTlsConfig = TlsConfig{
+	Port: 0,
+}
+
 type Config struct {
 	Status  StatusConfig  "status"
 	Nats    NatsConfig    "nats"
 	Logging LoggingConfig "logging"
+	Tls     TlsConfig     "tls"
 
 	Port       uint16 "port"
 	Index      uint   "index"
@@ -55,17 +70,32 @@ type Config struct {
 	TraceKey   string "trace_key"
 	AccessLog  string "access_log"
 
+	MaxIdleConnsPerBackend int    "max_idle_conns_per_backend"
+	MaxRetries             int    "max_retries"
+	LoadBalance            string "load_balance"
+	BackendMaxFailures     int    "backend_max_failures"
+
 	PublishStartMessageIntervalInSeconds int "publish_start_message_interval"
 	PruneStaleDropletsIntervalInSeconds  int "prune_stale_droplets_interval"
 	DropletStaleThresholdInSeconds       int "droplet_stale_threshold"
 	PublishActiveAppsIntervalInSeconds   int "publish_active_apps_interval"
 	StartResponseDelayIntervalInSeconds  int "start_response_delay_interval"
+	BackendDialTimeoutInSeconds          int "backend_dial_timeout"
+	BackendResponseTimeoutInSeconds      int "backend_response_timeout"
+	BackendIdleTimeoutInSeconds          int "backend_idle_timeout"
+	BackendCoolDownInSeconds             int "backend_cool_down"
+	TlsHandshakeTimeoutInSeconds         int "tls_handshake_timeout"
 
 	// These fields are populated by the `Process` function.
 	PruneStaleDropletsInterval time.Duration
 	DropletStaleThreshold      time.Duration
 	PublishActiveAppsInterval  time.Duration
 	StartResponseDelayInterval time.Duration
+	BackendDialTimeout         time.Duration
+	BackendResponseTimeout     time.Duration
+	BackendIdleTimeout         time.Duration
+	BackendCoolDown            time.Duration
+	TlsHandshakeTimeout        time.Duration
 
 	Ip string
 }
@@ -74,17 +104,28 @@ var defaultConfig = Config{
 	Status:  defaultStatusConfig,
 	Nats:    defaultNatsConfig,
 	Logging: defaultLoggingConfig,
+	Tls:     defaultTlsConfig,
 
 	Port:       8081,
 	Index:      0,
 	Pidfile:    "",
 	GoMaxProcs: 8,
 
+	MaxIdleConnsPerBackend: 8,
+	MaxRetries:             2,
+	LoadBalance:            LoadBalanceRandom,
+	BackendMaxFailures:     5,
+
 	PublishStartMessageIntervalInSeconds: 30,
 	PruneStaleDropletsIntervalInSeconds:  30,
 	DropletStaleThresholdInSeconds:       120,
 	PublishActiveAppsIntervalInSeconds:   0,
 	StartResponseDelayIntervalInSeconds:  5,
+	BackendDialTimeoutInSeconds:          5,
+	BackendResponseTimeoutInSeconds:      60,
+	BackendIdleTimeoutInSeconds:          90,
+	BackendCoolDownInSeconds:             30,
+	TlsHandshakeTimeoutInSeconds:         10,
 }
 
 func DefaultConfig() *Config {
@@ -102,6 +143,11 @@ func (c *Config) Process() {
 	c.DropletStaleThreshold = time.Duration(c.DropletStaleThresholdInSeconds) * time.Second
 	c.PublishActiveAppsInterval = time.Duration(c.PublishActiveAppsIntervalInSeconds) * time.Second
 	c.StartResponseDelayInterval = time.Duration(c.StartResponseDelayIntervalInSeconds) * time.Second
+	c.BackendDialTimeout = time.Duration(c.BackendDialTimeoutInSeconds) * time.Second
+	c.BackendResponseTimeout = time.Duration(c.BackendResponseTimeoutInSeconds) * time.Second
+	c.BackendIdleTimeout = time.Duration(c.BackendIdleTimeoutInSeconds) * time.Second
+	c.BackendCoolDown = time.Duration(c.BackendCoolDownInSeconds) * time.Second
+	c.TlsHandshakeTimeout = time.Duration(c.TlsHandshakeTimeoutInSeconds) * time.Second
 
 	c.Ip, err = vcap.LocalIP()
 	if err != nil {
@@ -109,6 +155,15 @@ func (c *Config) Process() {
 	}
 }
 
+// Validate reports the first setting that the router can't run with.
+func (c *Config) Validate() error {
+	if _, err := NewLoadBalancer(c.LoadBalance); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func InitConfigFromFile(path string) *Config {
 	var c *Config = DefaultConfig()
 	var e error
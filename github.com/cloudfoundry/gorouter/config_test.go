@@ -57,6 +57,30 @@ nats:
 	c.Check(s.Nats.Pass, Equals, "pass")
 }
 
+func (s *ConfigSuite) TestTls(c *C) {
+	var b = []byte(`
+tls:
+  port: 8443
+  certificates:
+    - cert_file: /tmp/foo.crt
+      key_file: /tmp/foo.key
+    - cert_file: /tmp/bar.crt
+      key_file: /tmp/bar.key
+`)
+
+	c.Check(s.Tls.Port, Equals, uint16(0))
+	c.Check(s.Tls.Certificates, HasLen, 0)
+
+	goyaml.Unmarshal(b, &s.Config)
+
+	c.Check(s.Tls.Port, Equals, uint16(8443))
+	c.Assert(s.Tls.Certificates, HasLen, 2)
+	c.Check(s.Tls.Certificates[0].CertFile, Equals, "/tmp/foo.crt")
+	c.Check(s.Tls.Certificates[0].KeyFile, Equals, "/tmp/foo.key")
+	c.Check(s.Tls.Certificates[1].CertFile, Equals, "/tmp/bar.crt")
+	c.Check(s.Tls.Certificates[1].KeyFile, Equals, "/tmp/bar.key")
+}
+
 func (s *ConfigSuite) TestLogging(c *C) {
 	var b = []byte(`
 logging:
@@ -90,6 +114,16 @@ prune_stale_droplets_interval: 2
 droplet_stale_threshold: 3
 publish_active_apps_interval: 4
 start_response_delay_interval: 15
+
+max_idle_conns_per_backend: 4
+max_retries: 3
+backend_dial_timeout: 6
+backend_response_timeout: 7
+backend_idle_timeout: 8
+load_balance: least-outstanding
+backend_max_failures: 3
+backend_cool_down: 9
+tls_handshake_timeout: 11
 `)
 
 	c.Check(s.Port, Equals, uint16(8081))
@@ -105,6 +139,16 @@ start_response_delay_interval: 15
 	c.Check(s.PublishActiveAppsInterval, Equals, 0*time.Second)
 	c.Check(s.StartResponseDelayInterval, Equals, 5*time.Second)
 
+	c.Check(s.MaxIdleConnsPerBackend, Equals, 8)
+	c.Check(s.MaxRetries, Equals, 2)
+	c.Check(s.BackendDialTimeout, Equals, 5*time.Second)
+	c.Check(s.BackendResponseTimeout, Equals, 60*time.Second)
+	c.Check(s.BackendIdleTimeout, Equals, 90*time.Second)
+	c.Check(s.LoadBalance, Equals, "random")
+	c.Check(s.BackendMaxFailures, Equals, 5)
+	c.Check(s.BackendCoolDown, Equals, 30*time.Second)
+	c.Check(s.TlsHandshakeTimeout, Equals, 10*time.Second)
+
 	goyaml.Unmarshal(b, &s.Config)
 
 	s.Config.Process()
@@ -121,4 +165,22 @@ start_response_delay_interval: 15
 	c.Check(s.DropletStaleThreshold, Equals, 3*time.Second)
 	c.Check(s.PublishActiveAppsInterval, Equals, 4*time.Second)
 	c.Check(s.StartResponseDelayInterval, Equals, 15*time.Second)
+
+	c.Check(s.MaxIdleConnsPerBackend, Equals, 4)
+	c.Check(s.MaxRetries, Equals, 3)
+	c.Check(s.BackendDialTimeout, Equals, 6*time.Second)
+	c.Check(s.BackendResponseTimeout, Equals, 7*time.Second)
+	c.Check(s.BackendIdleTimeout, Equals, 8*time.Second)
+	c.Check(s.LoadBalance, Equals, "least-outstanding")
+	c.Check(s.BackendMaxFailures, Equals, 3)
+	c.Check(s.BackendCoolDown, Equals, 9*time.Second)
+	c.Check(s.TlsHandshakeTimeout, Equals, 11*time.Second)
+}
+
+func (s *ConfigSuite) TestValidate(c *C) {
+	c.Check(s.Validate(), IsNil)
+
+	goyaml.Unmarshal([]byte(`load_balance: fastest`), &s.Config)
+
+	c.Check(s.Validate(), ErrorMatches, "unknown load balancing strategy: fastest")
 }
@@ -0,0 +1,216 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	LoadBalanceRandom           = "random"
+	LoadBalanceRoundRobin       = "round-robin"
+	LoadBalanceLeastOutstanding = "least-outstanding"
+	LoadBalanceLatencyWeighted  = "latency-weighted"
+)
+
+// A LoadBalancer chooses which of the backends of a uri gets a request.
+type LoadBalancer interface {
+	// Choose one of the backends registered for the uri. There is always
+	// at least one.
+	Choose(u Uri, bs []*Backend) *Backend
+
+	// Forget drops whatever is kept about a uri that no longer has any
+	// backends.
+	Forget(u Uri)
+}
+
+func NewLoadBalancer(name string) (LoadBalancer, error) {
+	switch name {
+	case LoadBalanceRandom:
+		return randomLoadBalancer{}, nil
+	case LoadBalanceRoundRobin:
+		return newRoundRobinLoadBalancer(), nil
+	case LoadBalanceLeastOutstanding:
+		return leastOutstandingLoadBalancer{}, nil
+	case LoadBalanceLatencyWeighted:
+		return latencyWeightedLoadBalancer{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown load balancing strategy: %s", name)
+}
+
+type randomLoadBalancer struct{}
+
+func (_ randomLoadBalancer) Choose(u Uri, bs []*Backend) *Backend {
+	return bs[rand.Intn(len(bs))]
+}
+
+func (_ randomLoadBalancer) Forget(u Uri) {}
+
+type roundRobinLoadBalancer struct {
+	sync.Mutex
+	next map[Uri]int
+}
+
+func newRoundRobinLoadBalancer() *roundRobinLoadBalancer {
+	return &roundRobinLoadBalancer{
+		next: make(map[Uri]int),
+	}
+}
+
+func (x *roundRobinLoadBalancer) Choose(u Uri, bs []*Backend) *Backend {
+	x.Lock()
+	defer x.Unlock()
+
+	i := x.next[u] % len(bs)
+	x.next[u] = i + 1
+
+	return bs[i]
+}
+
+func (x *roundRobinLoadBalancer) Forget(u Uri) {
+	x.Lock()
+	defer x.Unlock()
+
+	delete(x.next, u)
+}
+
+// Choose the backend with the fewest requests in flight. Ties are broken
+// at random so that idle backends share the load.
+type leastOutstandingLoadBalancer struct{}
+
+func (_ leastOutstandingLoadBalancer) Choose(u Uri, bs []*Backend) *Backend {
+	var y *Backend
+	var min int
+
+	o := rand.Intn(len(bs))
+	for i := range bs {
+		b := bs[(o+i)%len(bs)]
+
+		n := b.Outstanding()
+		if y == nil || n < min {
+			y = b
+			min = n
+		}
+	}
+
+	return y
+}
+
+func (_ leastOutstandingLoadBalancer) Forget(u Uri) {}
+
+// Choose a backend at random with a probability inversely proportional to
+// its average latency. Backends that haven't served a request yet are
+// weighted as if they were as fast as the average of the others.
+type latencyWeightedLoadBalancer struct{}
+
+func (_ latencyWeightedLoadBalancer) Choose(u Uri, bs []*Backend) *Backend {
+	ws := make([]float64, len(bs))
+
+	var sum float64
+	var known int
+
+	for i, b := range bs {
+		l := b.Latency()
+		if l > 0 {
+			ws[i] = 1 / float64(l)
+			sum += ws[i]
+			known++
+		}
+	}
+
+	if known == 0 {
+		return bs[rand.Intn(len(bs))]
+	}
+
+	mean := sum / float64(known)
+	for i := range ws {
+		if ws[i] == 0 {
+			ws[i] = mean
+			sum += mean
+		}
+	}
+
+	r := rand.Float64() * sum
+	for i, w := range ws {
+		r -= w
+		if r < 0 {
+			return bs[i]
+		}
+	}
+
+	return bs[len(bs)-1]
+}
+
+func (_ latencyWeightedLoadBalancer) Forget(u Uri) {}
+
+// Return the number of requests in flight to the backend.
+func (b *Backend) Outstanding() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.outstanding
+}
+
+// Return the moving average of the time the backend takes to respond, or
+// zero if it hasn't responded yet.
+func (b *Backend) Latency() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.latency
+}
+
+func (b *Backend) begin() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.outstanding++
+}
+
+func (b *Backend) end() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.outstanding--
+}
+
+// Record the outcome of a request to the backend. It returns true when the
+// backend has failed max times in a row and is ejected until the cool-down
+// has passed; max of zero never ejects it.
+func (b *Backend) capture(failed bool, d time.Duration, max int, coolDown time.Duration) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if !failed {
+		b.failures = 0
+
+		// Weigh the latest response like TCP weighs round trip times
+		if b.latency == 0 {
+			b.latency = d
+		} else {
+			b.latency += (d - b.latency) / 8
+		}
+
+		return false
+	}
+
+	b.failures++
+	if max == 0 || b.failures < max {
+		return false
+	}
+
+	b.failures = 0
+	b.ejectedUntil = time.Now().Add(coolDown)
+
+	return true
+}
+
+// Return whether the backend is ejected at the given time.
+func (b *Backend) isEjected(t time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return t.Before(b.ejectedUntil)
+}
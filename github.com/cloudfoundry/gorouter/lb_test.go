@@ -0,0 +1,116 @@
+package router
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+type LoadBalancerSuite struct {
+	bs []*Backend
+}
+
+var _ = Suite(&LoadBalancerSuite{})
+
+func (s *LoadBalancerSuite) SetUpTest(c *C) {
+	s.bs = []*Backend{
+		&Backend{BackendId: "192.168.1.1:1234"},
+		&Backend{BackendId: "192.168.1.2:1234"},
+		&Backend{BackendId: "192.168.1.3:1234"},
+	}
+}
+
+func (s *LoadBalancerSuite) count(lb LoadBalancer, n int) map[*Backend]int {
+	x := make(map[*Backend]int)
+	for i := 0; i < n; i++ {
+		x[lb.Choose("foo.vcap.me", s.bs)]++
+	}
+
+	return x
+}
+
+func (s *LoadBalancerSuite) TestUnknownStrategy(c *C) {
+	_, err := NewLoadBalancer("fastest")
+	c.Check(err, NotNil)
+}
+
+func (s *LoadBalancerSuite) TestRandom(c *C) {
+	lb, err := NewLoadBalancer(LoadBalanceRandom)
+	c.Assert(err, IsNil)
+
+	x := s.count(lb, 300)
+	c.Check(len(x), Equals, 3)
+}
+
+func (s *LoadBalancerSuite) TestRoundRobin(c *C) {
+	lb, err := NewLoadBalancer(LoadBalanceRoundRobin)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 6; i++ {
+		c.Check(lb.Choose("foo.vcap.me", s.bs), Equals, s.bs[i%3])
+	}
+
+	// Every uri goes round on its own
+	c.Check(lb.Choose("bar.vcap.me", s.bs), Equals, s.bs[0])
+	c.Check(lb.Choose("foo.vcap.me", s.bs), Equals, s.bs[0])
+
+	lb.Forget("foo.vcap.me")
+	c.Check(lb.Choose("foo.vcap.me", s.bs), Equals, s.bs[0])
+
+	// The backends of a uri can change between calls
+	c.Check(lb.Choose("foo.vcap.me", s.bs[:1]), Equals, s.bs[0])
+}
+
+func (s *LoadBalancerSuite) TestLeastOutstanding(c *C) {
+	lb, err := NewLoadBalancer(LoadBalanceLeastOutstanding)
+	c.Assert(err, IsNil)
+
+	s.bs[0].begin()
+	s.bs[0].begin()
+	s.bs[2].begin()
+
+	for i := 0; i < 10; i++ {
+		c.Check(lb.Choose("foo.vcap.me", s.bs), Equals, s.bs[1])
+	}
+
+	s.bs[0].end()
+	s.bs[0].end()
+	s.bs[1].begin()
+
+	x := s.count(lb, 100)
+	c.Check(x[s.bs[0]] > 0, Equals, true)
+	c.Check(x[s.bs[1]], Equals, 0)
+	c.Check(x[s.bs[2]], Equals, 0)
+}
+
+func (s *LoadBalancerSuite) TestLatencyWeighted(c *C) {
+	lb, err := NewLoadBalancer(LoadBalanceLatencyWeighted)
+	c.Assert(err, IsNil)
+
+	// Without latencies every backend has a chance
+	x := s.count(lb, 300)
+	c.Check(len(x), Equals, 3)
+
+	s.bs[0].capture(false, 1*time.Millisecond, 0, 0)
+	s.bs[1].capture(false, 100*time.Millisecond, 0, 0)
+
+	x = s.count(lb, 1000)
+	c.Check(x[s.bs[0]] > 10*x[s.bs[1]], Equals, true)
+
+	// The new backend is weighted as the average of the others
+	c.Check(x[s.bs[2]] > x[s.bs[1]], Equals, true)
+	c.Check(x[s.bs[2]] < x[s.bs[0]], Equals, true)
+}
+
+func (s *LoadBalancerSuite) TestLatencyMovingAverage(c *C) {
+	b := s.bs[0]
+
+	b.capture(false, 80*time.Millisecond, 0, 0)
+	c.Check(b.Latency(), Equals, 80*time.Millisecond)
+
+	b.capture(false, 160*time.Millisecond, 0, 0)
+	c.Check(b.Latency(), Equals, 90*time.Millisecond)
+
+	// Failures don't count
+	b.capture(true, time.Second, 0, 0)
+	c.Check(b.Latency(), Equals, 90*time.Millisecond)
+}
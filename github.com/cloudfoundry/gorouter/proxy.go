@@ -29,6 +29,8 @@ type Proxy struct {
 	*Registry
 	Varz
 	*AccessLogger
+
+	transport *BackendTransport
 }
 
 type responseWriter struct {
@@ -70,8 +72,12 @@ func NewProxy(c *Config, r *Registry, v Varz) *Proxy {
 		Logger:   steno.NewLogger("router.proxy"),
 		Registry: r,
 		Varz:     v,
+
+		transport: NewBackendTransport(c, v),
 	}
 
+	p.transport.StartPruningCycle()
+
 	if c.AccessLog != "" {
 		f, err := os.OpenFile(c.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 		if err != nil {
@@ -97,21 +103,27 @@ func hostWithoutPort(req *http.Request) string {
 	return host
 }
 
+// Return the host of the request followed by its path, to look up the
+// route in the registry.
+func requestUri(req *http.Request) string {
+	return hostWithoutPort(req) + req.URL.Path
+}
+
 func (p *Proxy) Lookup(req *http.Request) (*Backend, bool) {
-	h := hostWithoutPort(req)
+	u := requestUri(req)
 
 	// Try choosing a backend using sticky session
 	if _, err := req.Cookie(StickyCookieKey); err == nil {
 		if sticky, err := req.Cookie(VcapCookieId); err == nil {
-			b, ok := p.Registry.LookupByPrivateInstanceId(h, sticky.Value)
+			b, ok := p.Registry.LookupByPrivateInstanceId(u, sticky.Value)
 			if ok {
 				return b, ok
 			}
 		}
 	}
 
-	// Choose backend using host alone
-	return p.Registry.Lookup(h)
+	// Choose backend using host and path alone
+	return p.Registry.Lookup(u)
 }
 
 func (p *Proxy) ServeHTTP(hrw http.ResponseWriter, req *http.Request) {
@@ -180,18 +192,64 @@ func (p *Proxy) ServeHTTP(hrw http.ResponseWriter, req *http.Request) {
 		req.Header.Set("X-Forwarded-For", strings.Join(xff, ", "))
 	}
 
+	// Tell the backend how the client connected, replacing whatever the
+	// client claimed
+	if req.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+
 	// Check if the connection is going to be upgraded to a WebSocket connection
 	if p.CheckWebSocket(rw, req) {
 		p.ServeWebSocket(rw, req)
 		return
 	}
 
-	// Use a new connection for every request
-	// Keep-alive can be bolted on later, if we want to
-	req.Close = true
+	// Keep the connection to the backend alive, whatever the client asked for
+	req.Close = false
 	req.Header.Del("Connection")
 
-	res, err := http.DefaultTransport.RoundTrip(req)
+	if req.ContentLength != 0 {
+		req.Body = retryableBody{req.Body}
+	}
+
+	var res *http.Response
+	var err error
+
+	tried := make([]*Backend, 0, 1)
+	for i := 0; ; i++ {
+		t := time.Now()
+
+		x.begin()
+		res, err = p.transport.RoundTrip(x, req)
+		p.Registry.CaptureBackendOutcome(x, res, err, time.Since(t))
+
+		if err != nil {
+			x.end()
+		}
+
+		if err == nil || !isDialError(err) || i == p.Config.MaxRetries {
+			break
+		}
+
+		// Nothing was sent to the backend, so try another one
+		tried = append(tried, x)
+
+		y, ok := p.Registry.LookupExcept(requestUri(req), tried)
+		if !ok {
+			break
+		}
+
+		rw.Warnf("Retrying on another backend: %s", err)
+		p.Varz.CaptureBackendRetry(x)
+
+		x = y
+		rw.Set("Backend", x.ToLogData())
+		a.Backend = x
+
+		req.URL.Host = x.CanonicalAddr()
+	}
 
 	latency := time.Since(start)
 
@@ -205,6 +263,10 @@ func (p *Proxy) ServeHTTP(hrw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The connection only goes back to the pool once the body is closed
+	defer res.Body.Close()
+	defer x.end()
+
 	p.Varz.CaptureBackendResponse(x, res, latency)
 
 	for k, vv := range res.Header {
@@ -36,6 +36,7 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -104,12 +105,13 @@ type Hijacker interface {
 
 // A conn represents the server side of an HTTP connection.
 type conn struct {
-	remoteAddr string            // network address of remote side
-	server     *Server           // the Server on which the connection arrived
-	rwc        net.Conn          // i/o connection
-	lr         *io.LimitedReader // io.LimitReader(rwc)
-	buf        *bufio.ReadWriter // buffered(lr,rwc), reading from bufio->limitReader->rwc
-	hijacked   bool              // connection has been hijacked by handler
+	remoteAddr string               // network address of remote side
+	server     *Server              // the Server on which the connection arrived
+	rwc        net.Conn             // i/o connection
+	lr         *io.LimitedReader    // io.LimitReader(rwc)
+	buf        *bufio.ReadWriter    // buffered(lr,rwc), reading from bufio->limitReader->rwc
+	hijacked   bool                 // connection has been hijacked by handler
+	tlsState   *tls.ConnectionState // or nil when not using TLS
 }
 
 type request struct {
@@ -273,6 +275,7 @@ func (c *conn) readRequest() (r *request, w *response, err error) {
 	c.lr.N = noLimit
 
 	req.RemoteAddr = c.remoteAddr
+	req.TLS = c.tlsState
 
 	w = new(response)
 	w.conn = c
@@ -569,6 +572,23 @@ func (c *conn) serve() {
 		}
 	}()
 
+	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
+		// A client that never completes the handshake would otherwise
+		// hold on to the connection forever.
+		if d := c.server.HandshakeTimeout; d != 0 {
+			tlsConn.SetDeadline(time.Now().Add(d))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			c.close()
+			return
+		}
+		if c.server.HandshakeTimeout != 0 {
+			c.server.setDeadlines(tlsConn)
+		}
+		c.tlsState = new(tls.ConnectionState)
+		*c.tlsState = tlsConn.ConnectionState()
+	}
+
 	for {
 		req, w, err := c.readRequest()
 		if err != nil {
@@ -664,6 +684,8 @@ type Server struct {
 	ReadTimeout    time.Duration // maximum duration before timing out read of the request
 	WriteTimeout   time.Duration // maximum duration before timing out write of the response
 	MaxHeaderBytes int           // maximum size of request headers, DefaultMaxHeaderBytes if 0
+
+	HandshakeTimeout time.Duration // maximum duration before timing out the TLS handshake
 }
 
 // Serve accepts incoming connections on the Listener l, creating a
@@ -691,12 +713,7 @@ func (srv *Server) Serve(l net.Listener) error {
 			return e
 		}
 		tempDelay = 0
-		if srv.ReadTimeout != 0 {
-			rw.SetReadDeadline(time.Now().Add(srv.ReadTimeout))
-		}
-		if srv.WriteTimeout != 0 {
-			rw.SetWriteDeadline(time.Now().Add(srv.WriteTimeout))
-		}
+		srv.setDeadlines(rw)
 		c, err := srv.newConn(rw)
 		if err != nil {
 			continue
@@ -706,6 +723,22 @@ func (srv *Server) Serve(l net.Listener) error {
 	panic("not reached")
 }
 
+// setDeadlines sets the read and write deadlines of a connection from
+// ReadTimeout and WriteTimeout, clearing those that are not set.
+func (srv *Server) setDeadlines(rw net.Conn) {
+	var t time.Time
+	if srv.ReadTimeout != 0 {
+		t = time.Now().Add(srv.ReadTimeout)
+	}
+	rw.SetReadDeadline(t)
+
+	t = time.Time{}
+	if srv.WriteTimeout != 0 {
+		t = time.Now().Add(srv.WriteTimeout)
+	}
+	rw.SetWriteDeadline(t)
+}
+
 // hasToken returns whether token appears with v, ASCII
 // case-insensitive, with space or comma boundaries.
 // token must be all lowercase.
@@ -9,6 +9,8 @@ import (
 	. "launchpad.net/gocheck"
 	"net"
 	"net/http"
+	"crypto/tls"
+	"github.com/cloudfoundry/gorouter/proxy"
 	"github.com/cloudfoundry/gorouter/test"
 	"strconv"
 	"strings"
@@ -24,6 +26,9 @@ func (_ nullVarz) MarshalJSON() ([]byte, error) { return json.Marshal(nil) }
 func (_ nullVarz) CaptureBadRequest(req *http.Request)                                    {}
 func (_ nullVarz) CaptureBackendRequest(b *Backend, req *http.Request)                    {}
 func (_ nullVarz) CaptureBackendResponse(b *Backend, res *http.Response, d time.Duration) {}
+func (_ nullVarz) CaptureBackendRetry(b *Backend)                                         {}
+func (_ nullVarz) CaptureBackendConnOpen()                                                {}
+func (_ nullVarz) CaptureBackendConnClose()                                               {}
 
 type conn struct {
 	net.Conn
@@ -146,6 +151,10 @@ func (s *ProxySuite) TearDownTest(c *C) {
 }
 
 func (s *ProxySuite) registerAddr(u string, a net.Addr) {
+	s.registerInstance(u, a, "")
+}
+
+func (s *ProxySuite) registerInstance(u string, a net.Addr, id string) {
 	h, p, err := net.SplitHostPort(a.String())
 	if err != nil {
 		panic(err)
@@ -160,23 +169,36 @@ func (s *ProxySuite) registerAddr(u string, a net.Addr) {
 		Host: h,
 		Port: uint16(x),
 		Uris: []Uri{Uri(u)},
+
+		PrivateInstanceId: id,
 	}
 
 	s.r.Register(&m)
 }
 
 func (s *ProxySuite) RegisterHandler(u string, h connHandler) net.Listener {
+	ln := s.Listen(h)
+
+	s.registerAddr(u, ln.Addr())
+
+	return ln
+}
+
+func (s *ProxySuite) Listen(h connHandler) net.Listener {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		panic(err)
 	}
 
 	// Close listener when test is done
+	done := s.done
 	go func() {
-		<-s.done
+		<-done
 		ln.Close()
 	}()
 
+	c := s.C
+
 	go func() {
 		for {
 			conn, err := ln.Accept()
@@ -184,12 +206,10 @@ func (s *ProxySuite) RegisterHandler(u string, h connHandler) net.Listener {
 				break
 			}
 
-			go h(newConn(conn, s.C))
+			go h(newConn(conn, c))
 		}
 	}()
 
-	s.registerAddr(u, ln.Addr())
-
 	return ln
 }
 
@@ -200,18 +220,56 @@ func (s *ProxySuite) StartProxy() net.Addr {
 	}
 
 	// Close listener when test is done
+	done := s.done
 	go func() {
-		<-s.done
+		<-done
 		ln.Close()
 	}()
 
+	p := s.p
+
 	go func() {
-		http.Serve(ln, s.p)
+		http.Serve(ln, p)
 	}()
 
 	return ln.Addr()
 }
 
+func (s *ProxySuite) DeadAddr() net.Addr {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	ln.Close()
+
+	return ln.Addr()
+}
+
+func (s *ProxySuite) StartTlsProxy(cs ...tls.Certificate) net.Addr {
+	x, err := NewTlsConfig(cs)
+	if err != nil {
+		panic(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	// Close listener when test is done
+	done := s.done
+	go func() {
+		<-done
+		ln.Close()
+	}()
+
+	server := proxy.Server{Handler: s.p, HandshakeTimeout: s.p.TlsHandshakeTimeout}
+	go server.Serve(tls.NewListener(ln, x))
+
+	return ln.Addr()
+}
+
 func (s *ProxySuite) DialProxy() *conn {
 	y := s.StartProxy()
 
@@ -329,6 +387,8 @@ func (s *ProxySuite) TestTraceHeadersAddedOnCorrectTraceKey(c *C) {
 	s.C = c
 
 	ln := s.RegisterHandler("trace-test", func(x *conn) {
+		x.ReadRequest()
+
 		resp := newResponse(http.StatusOK)
 		x.WriteResponse(resp)
 		x.Close()
@@ -350,6 +410,8 @@ func (s *ProxySuite) TestTraceHeadersNotAddedOnIncorrectTraceKey(c *C) {
 	s.C = c
 
 	s.RegisterHandler("trace-test", func(x *conn) {
+		x.ReadRequest()
+
 		resp := newResponse(http.StatusOK)
 		x.WriteResponse(resp)
 		x.Close()
@@ -490,3 +552,253 @@ func (s *ProxySuite) TestTransferEncodingChunked(c *C) {
 		c.Check(string(b[0:n]), Equals, "hello")
 	}
 }
+
+func (s *ProxySuite) TestKeepsBackendConnectionsAlive(c *C) {
+	s.C = c
+
+	accepted := make(chan bool, 2)
+
+	s.RegisterHandler("keep-alive", func(x *conn) {
+		accepted <- true
+
+		for i := 0; i < 2; i++ {
+			x.ReadRequest()
+			x.WriteResponse(newResponse(http.StatusOK))
+		}
+	})
+
+	y := s.StartProxy()
+
+	for i := 0; i < 2; i++ {
+		z, err := net.Dial("tcp", y.String())
+		c.Assert(err, IsNil)
+
+		x := newConn(z, c)
+
+		// The client closing its connection doesn't close the backend's
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "keep-alive"
+		req.Close = true
+		x.WriteRequest(req)
+
+		resp, _ := x.ReadResponse()
+		c.Check(resp.StatusCode, Equals, http.StatusOK)
+
+		x.Close()
+	}
+
+	c.Check(len(accepted), Equals, 1)
+}
+
+func (s *ProxySuite) TestRetriesOnDialFailure(c *C) {
+	s.C = c
+
+	v := NewVarz(s.r).(*RealVarz)
+	s.p = NewProxy(DefaultConfig(), s.r, v)
+
+	s.RegisterHandler("retry", func(x *conn) {
+		req, body := x.ReadRequest()
+		x.c.Check(req.Method, Equals, "POST")
+		x.c.Check(body, Equals, "hello")
+
+		x.WriteResponse(newResponse(http.StatusOK))
+		x.Close()
+	})
+
+	// The sticky session makes the proxy try the dead backend first
+	s.registerInstance("retry", s.DeadAddr(), "dead")
+
+	x := s.DialProxy()
+
+	req := x.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Host = "retry"
+	req.AddCookie(&http.Cookie{Name: StickyCookieKey, Value: "session"})
+	req.AddCookie(&http.Cookie{Name: VcapCookieId, Value: "dead"})
+	x.WriteRequest(req)
+
+	resp, _ := x.ReadResponse()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+
+	v.Lock()
+	c.Check(v.Retries, Equals, 1)
+	c.Check(v.BackendConnectionsOpened, Equals, 1)
+	v.Unlock()
+}
+
+func (s *ProxySuite) TestRespondsWith502WhenAllBackendsAreDead(c *C) {
+	s.C = c
+
+	s.registerAddr("dead", s.DeadAddr())
+	s.registerAddr("dead", s.DeadAddr())
+
+	x := s.DialProxy()
+
+	req := x.NewRequest("GET", "/", nil)
+	req.Host = "dead"
+	x.WriteRequest(req)
+
+	resp, body := x.ReadResponse()
+	s.Check(resp.StatusCode, Equals, http.StatusBadGateway)
+	s.Check(body, Equals, "502 Bad Gateway\n")
+}
+
+func (s *ProxySuite) TestDoesNotRetryAfterSendingRequest(c *C) {
+	s.C = c
+
+	v := NewVarz(s.r).(*RealVarz)
+	s.p = NewProxy(DefaultConfig(), s.r, v)
+
+	ln := s.Listen(func(x *conn) {
+		x.ReadRequest()
+		x.Close()
+	})
+
+	s.registerInstance("no-retry", ln.Addr(), "first")
+
+	s.RegisterHandler("no-retry", func(x *conn) {
+		x.c.Error("request was retried after it was sent")
+		x.Close()
+	})
+
+	x := s.DialProxy()
+
+	req := x.NewRequest("GET", "/", nil)
+	req.Host = "no-retry"
+	req.AddCookie(&http.Cookie{Name: StickyCookieKey, Value: "session"})
+	req.AddCookie(&http.Cookie{Name: VcapCookieId, Value: "first"})
+	x.WriteRequest(req)
+
+	resp, _ := x.ReadResponse()
+	c.Check(resp.StatusCode, Equals, http.StatusBadGateway)
+
+	v.Lock()
+	c.Check(v.Retries, Equals, 0)
+	v.Unlock()
+}
+
+func (s *ProxySuite) TestXFPIsAdded(c *C) {
+	s.C = c
+
+	done := make(chan bool)
+
+	s.RegisterHandler("app", func(x *conn) {
+		req, _ := x.ReadRequest()
+		c.Check(req.Header.Get("X-Forwarded-Proto"), Equals, "http")
+		done <- true
+	})
+
+	x := s.DialProxy()
+
+	req := x.NewRequest("GET", "/", nil)
+	req.Host = "app"
+	x.WriteRequest(req)
+
+	<-done
+}
+
+func (s *ProxySuite) TestXFPIsOverwritten(c *C) {
+	s.C = c
+
+	done := make(chan bool)
+
+	s.RegisterHandler("app", func(x *conn) {
+		req, _ := x.ReadRequest()
+		c.Check(req.Header.Get("X-Forwarded-Proto"), Equals, "http")
+		done <- true
+	})
+
+	x := s.DialProxy()
+
+	// Clients on the plain listener can't claim to have used TLS
+	req := x.NewRequest("GET", "/", nil)
+	req.Host = "app"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	x.WriteRequest(req)
+
+	<-done
+}
+
+func (s *ProxySuite) TestTls(c *C) {
+	s.C = c
+
+	s.RegisterHandler("foo.example.com", func(x *conn) {
+		req, _ := x.ReadRequest()
+		x.c.Check(req.Header.Get("X-Forwarded-Proto"), Equals, "https")
+
+		x.WriteResponse(newResponse(http.StatusOK))
+		x.Close()
+	})
+
+	y := s.StartTlsProxy(
+		newTestCertificate("default"),
+		newTestCertificate("wildcard", "*.example.com"),
+	)
+
+	z, err := tls.Dial("tcp", y.String(), &tls.Config{
+		ServerName:         "foo.example.com",
+		InsecureSkipVerify: true,
+	})
+	c.Assert(err, IsNil)
+
+	cert := z.ConnectionState().PeerCertificates[0]
+	c.Check(cert.Subject.CommonName, Equals, "wildcard")
+
+	x := newConn(z, c)
+
+	// Forged headers don't survive TLS termination
+	req := x.NewRequest("GET", "/", nil)
+	req.Host = "foo.example.com"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	x.WriteRequest(req)
+
+	resp, _ := x.ReadResponse()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+}
+
+func (s *ProxySuite) TestTlsHandshakeTimeout(c *C) {
+	s.p.TlsHandshakeTimeout = 100 * time.Millisecond
+
+	y := s.StartTlsProxy(newTestCertificate("default"))
+
+	z, err := net.Dial("tcp", y.String())
+	c.Assert(err, IsNil)
+	defer z.Close()
+
+	// The client never sends a ClientHello
+	z.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = z.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+
+	neterr, ok := err.(net.Error)
+	c.Check(ok && neterr.Timeout(), Equals, false)
+}
+
+func (s *ProxySuite) TestPathRoutes(c *C) {
+	s.C = c
+
+	handler := func(name string) connHandler {
+		return func(x *conn) {
+			req, _ := x.ReadRequest()
+
+			// The path is passed on as it is
+			x.c.Check(req.URL.Path, Equals, "/v2/apps")
+
+			resp := newResponse(http.StatusOK)
+			resp.Header.Set("X-App", name)
+			x.WriteResponse(resp)
+			x.Close()
+		}
+	}
+
+	s.RegisterHandler("paths", handler("root"))
+	s.RegisterHandler("paths/v2", handler("v2"))
+
+	x := s.DialProxy()
+
+	req := x.NewRequest("GET", "/v2/apps", nil)
+	req.Host = "paths"
+	x.WriteRequest(req)
+
+	resp, _ := x.ReadResponse()
+	c.Check(resp.Header.Get("X-App"), Equals, "v2")
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	mbus "github.com/cloudfoundry/go_cfmessagebus"
 	steno "github.com/cloudfoundry/gosteno"
-	"math/rand"
+	"net/http"
 	"github.com/cloudfoundry/gorouter/stats"
 	"github.com/cloudfoundry/gorouter/util"
 	"strings"
@@ -13,6 +13,9 @@ import (
 	"time"
 )
 
+// A Uri is a host name, optionally followed by a path prefix, like
+// "api.example.com/v2". Requests are routed by the longest registered uri
+// that matches their host and the leading segments of their path.
 type Uri string
 type Uris []Uri
 
@@ -20,6 +23,29 @@ func (u Uri) ToLower() Uri {
 	return Uri(strings.ToLower(string(u)))
 }
 
+// Return the uri the way it is registered: with its host in lower case and
+// without trailing slashes. Paths are case sensitive.
+func (u Uri) Canonical() Uri {
+	s := strings.TrimRight(string(u), "/")
+
+	if i := strings.Index(s, "/"); i >= 0 {
+		return Uri(strings.ToLower(s[:i]) + s[i:])
+	}
+
+	return Uri(strings.ToLower(s))
+}
+
+// Return the uri with its last path segment removed, or false if it is
+// only a host.
+func (u Uri) Parent() (Uri, bool) {
+	i := strings.LastIndex(string(u), "/")
+	if i < 0 {
+		return u, false
+	}
+
+	return u[:i], true
+}
+
 func (ms Uris) Sub(ns Uris) Uris {
 	var rs Uris
 
@@ -79,6 +105,11 @@ type Backend struct {
 
 	U          Uris
 	updated_at time.Time
+
+	outstanding  int
+	latency      time.Duration
+	failures     int
+	ejectedUntil time.Time
 }
 
 func (b *Backend) MarshalJSON() ([]byte, error) {
@@ -178,6 +209,10 @@ type Registry struct {
 	pruneStaleDropletsInterval time.Duration
 	dropletStaleThreshold      time.Duration
 
+	lb                 LoadBalancer
+	backendMaxFailures int
+	backendCoolDown    time.Duration
+
 	messageBus mbus.CFMessageBus
 }
 
@@ -199,6 +234,17 @@ func NewRegistry(c *Config, messageBusClient mbus.CFMessageBus) *Registry {
 	r.pruneStaleDropletsInterval = c.PruneStaleDropletsInterval
 	r.dropletStaleThreshold = c.DropletStaleThreshold
 
+	lb, err := NewLoadBalancer(c.LoadBalance)
+	if err != nil {
+		// Config.Validate rejects this before the router starts.
+		r.Warnf("%s, using %s", err, LoadBalanceRandom)
+		lb = randomLoadBalancer{}
+	}
+
+	r.lb = lb
+	r.backendMaxFailures = c.BackendMaxFailures
+	r.backendCoolDown = c.BackendCoolDown
+
 	return r
 }
 
@@ -225,7 +271,7 @@ func (r *Registry) NumBackends() int {
 }
 
 func (r *Registry) registerUri(b *Backend, u Uri) {
-	u = u.ToLower()
+	u = u.Canonical()
 
 	ok := b.register(u)
 	if ok {
@@ -259,7 +305,7 @@ func (registry *Registry) Register(message *registryMessage) {
 }
 
 func (r *Registry) unregisterUri(backend *Backend, uri Uri) {
-	uri = uri.ToLower()
+	uri = uri.Canonical()
 
 	ok := backend.unregister(uri)
 	if ok {
@@ -275,6 +321,7 @@ func (r *Registry) unregisterUri(backend *Backend, uri Uri) {
 
 		if len(backends) == 0 {
 			delete(r.byUri, uri)
+			r.lb.Forget(uri)
 		} else {
 			r.byUri[uri] = backends
 		}
@@ -316,7 +363,11 @@ func (registry *Registry) pruneStaleDroplets() {
 
 		log.Infof("Pruning stale droplet: %v ", backend.BackendId)
 
-		for _, uri := range backend.U {
+		// Unregistering a uri removes it from the backend's uris
+		uris := make(Uris, len(backend.U))
+		copy(uris, backend.U)
+
+		for _, uri := range uris {
 			registry.unregisterUri(backend, uri)
 		}
 	}
@@ -356,30 +407,82 @@ func (r *Registry) checkAndPrune() {
 	}
 }
 
-func (r *Registry) Lookup(host string) (*Backend, bool) {
+// Find the longest registered uri that is a prefix of the given one,
+// matching whole path segments, and return it with its backends. The
+// registry must be locked.
+func (r *Registry) lookup(u Uri) (Uri, []*Backend) {
+	u = u.Canonical()
+
+	for {
+		if x, ok := r.byUri[u]; ok {
+			return u, x
+		}
+
+		var ok bool
+		if u, ok = u.Parent(); !ok {
+			return "", nil
+		}
+	}
+}
+
+// Lookup a backend for the uri, a host followed by the path of a request.
+func (r *Registry) Lookup(uri string) (*Backend, bool) {
+	return r.LookupExcept(uri, nil)
+}
+
+// Lookup a backend for the uri other than the given ones, to retry a
+// request that couldn't be sent to any of them.
+//
+// Ejected backends are only chosen when all the others are ejected too;
+// a backend that might be back up is better than none.
+func (r *Registry) LookupExcept(uri string, xs []*Backend) (*Backend, bool) {
 	r.RLock()
 	defer r.RUnlock()
 
-	x, ok := r.byUri[Uri(host).ToLower()]
-	if !ok {
+	u, bs := r.lookup(Uri(uri))
+
+	var ys, zs []*Backend
+
+	now := time.Now()
+	for _, b := range bs {
+		found := false
+		for _, x := range xs {
+			if b == x {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		if b.isEjected(now) {
+			zs = append(zs, b)
+		} else {
+			ys = append(ys, b)
+		}
+	}
+
+	if len(ys) == 0 {
+		ys = zs
+	}
+
+	if len(ys) == 0 {
 		return nil, false
 	}
 
-	// Return random backend from slice of backends for the specified uri
-	return x[rand.Intn(len(x))], true
+	return r.lb.Choose(u, ys), true
 }
 
-func (r *Registry) LookupByPrivateInstanceId(host string, p string) (*Backend, bool) {
+func (r *Registry) LookupByPrivateInstanceId(uri string, p string) (*Backend, bool) {
 	r.RLock()
 	defer r.RUnlock()
 
-	x, ok := r.byUri[Uri(host).ToLower()]
-	if !ok {
-		return nil, false
-	}
+	_, x := r.lookup(Uri(uri))
 
 	for _, b := range x {
-		if b.PrivateInstanceId == p {
+		if b.PrivateInstanceId == p && !b.isEjected(time.Now()) {
 			return b, true
 		}
 	}
@@ -394,6 +497,17 @@ func (r *Registry) CaptureBackendRequest(x *Backend, t time.Time) {
 	}
 }
 
+// Record the outcome of a request to a backend, ejecting it when it fails
+// too many times in a row. Both connection errors and 5xx responses count
+// as failures.
+func (r *Registry) CaptureBackendOutcome(x *Backend, res *http.Response, err error, d time.Duration) {
+	failed := err != nil || res.StatusCode >= 500
+
+	if x.capture(failed, d, r.backendMaxFailures, r.backendCoolDown) {
+		r.Warnf("Ejecting %s for %s after %d failures", x.BackendId, r.backendCoolDown, r.backendMaxFailures)
+	}
+}
+
 func (r *Registry) MarshalJSON() ([]byte, error) {
 	r.RLock()
 	defer r.RUnlock()
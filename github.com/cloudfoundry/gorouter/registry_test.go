@@ -3,7 +3,9 @@ package router
 import (
 	"code.google.com/p/gomock/gomock"
 	"encoding/json"
+	"errors"
 	. "launchpad.net/gocheck"
+	"net/http"
 	"github.com/cloudfoundry/gorouter/test"
 	"time"
 )
@@ -243,6 +245,258 @@ func (s *RegistrySuite) TestLookupDoubleRegister(c *C) {
 	c.Check(s.NumBackends(), Equals, 2)
 }
 
+var apiReg = &registryMessage{
+	Host: "192.168.1.1",
+	Port: 1234,
+	Uris: []Uri{"api.vcap.me"},
+}
+
+var apiV2Reg = &registryMessage{
+	Host: "192.168.1.2",
+	Port: 1234,
+	Uris: []Uri{"api.vcap.me/v2"},
+}
+
+var apiV2AdminReg = &registryMessage{
+	Host: "192.168.1.3",
+	Port: 1234,
+	Uris: []Uri{"api.vcap.me/v2/admin"},
+}
+
+func (s *RegistrySuite) checkLookup(c *C, uri string, id BackendId) {
+	b, ok := s.Lookup(uri)
+	if id == "" {
+		c.Check(ok, Equals, false, Commentf("uri: %s", uri))
+		return
+	}
+
+	if c.Check(ok, Equals, true, Commentf("uri: %s", uri)) {
+		c.Check(b.BackendId, Equals, id, Commentf("uri: %s", uri))
+	}
+}
+
+func (s *RegistrySuite) TestLookupPathRoutes(c *C) {
+	s.Register(apiReg)
+	s.Register(apiV2Reg)
+	s.Register(apiV2AdminReg)
+
+	c.Check(s.NumUris(), Equals, 3)
+
+	s.checkLookup(c, "api.vcap.me", "192.168.1.1:1234")
+	s.checkLookup(c, "api.vcap.me/", "192.168.1.1:1234")
+	s.checkLookup(c, "api.vcap.me/v1/apps", "192.168.1.1:1234")
+	s.checkLookup(c, "api.vcap.me/v2", "192.168.1.2:1234")
+	s.checkLookup(c, "api.vcap.me/v2/", "192.168.1.2:1234")
+	s.checkLookup(c, "api.vcap.me/v2/apps", "192.168.1.2:1234")
+	s.checkLookup(c, "API.VCAP.ME/v2/apps", "192.168.1.2:1234")
+	s.checkLookup(c, "api.vcap.me/v2/admin", "192.168.1.3:1234")
+	s.checkLookup(c, "api.vcap.me/v2/admin/users/1", "192.168.1.3:1234")
+
+	// Paths are case sensitive
+	s.checkLookup(c, "api.vcap.me/V2/apps", "192.168.1.1:1234")
+
+	// Prefixes only match whole path segments
+	s.checkLookup(c, "api.vcap.me/v2beta", "192.168.1.1:1234")
+	s.checkLookup(c, "api.vcap.me/v2/administrators", "192.168.1.2:1234")
+
+	// Hosts don't match as prefixes
+	s.checkLookup(c, "api.vcap.me.evil.com", "")
+	s.checkLookup(c, "vcap.me/api.vcap.me", "")
+}
+
+func (s *RegistrySuite) TestLookupPathRouteWithoutHostRoute(c *C) {
+	s.Register(apiV2Reg)
+
+	s.checkLookup(c, "api.vcap.me/v2/apps", "192.168.1.2:1234")
+	s.checkLookup(c, "api.vcap.me/v3", "")
+	s.checkLookup(c, "api.vcap.me", "")
+}
+
+func (s *RegistrySuite) TestUnregisterPathRoute(c *C) {
+	s.Register(apiReg)
+	s.Register(apiV2Reg)
+	s.Register(apiV2AdminReg)
+
+	s.Unregister(apiV2Reg)
+
+	c.Check(s.NumUris(), Equals, 2)
+	c.Check(s.NumBackends(), Equals, 2)
+
+	s.checkLookup(c, "api.vcap.me/v2/apps", "192.168.1.1:1234")
+	s.checkLookup(c, "api.vcap.me/v2/admin", "192.168.1.3:1234")
+
+	s.Unregister(apiReg)
+
+	s.checkLookup(c, "api.vcap.me/v2/apps", "")
+	s.checkLookup(c, "api.vcap.me/v2/admin", "192.168.1.3:1234")
+}
+
+func (s *RegistrySuite) TestRegisterPathRouteCanonically(c *C) {
+	m := &registryMessage{
+		Host: "192.168.1.1",
+		Port: 1234,
+		Uris: []Uri{"API.vcap.me/v2/", "api.vcap.me/v2"},
+	}
+
+	s.Register(m)
+	c.Check(s.NumUris(), Equals, 1)
+
+	s.checkLookup(c, "api.vcap.me/v2/apps", "192.168.1.1:1234")
+
+	s.Unregister(&registryMessage{
+		Host: "192.168.1.1",
+		Port: 1234,
+		Uris: []Uri{"api.vcap.me/v2/"},
+	})
+
+	c.Check(s.NumUris(), Equals, 0)
+	c.Check(s.NumBackends(), Equals, 0)
+}
+
+func (s *RegistrySuite) TestPruneStalePathRoutes(c *C) {
+	m := &registryMessage{
+		Host: "192.168.1.1",
+		Port: 1234,
+		Uris: []Uri{"api.vcap.me/v2", "api.vcap.me/v2/admin", "api.vcap.me/v3", "admin.vcap.me/v2"},
+	}
+
+	s.Register(m)
+	s.Register(apiReg)
+	c.Check(s.NumUris(), Equals, 5)
+
+	time.Sleep(s.dropletStaleThreshold + 1*time.Millisecond)
+	s.PruneStaleDroplets()
+
+	s.Register(apiV2AdminReg)
+
+	c.Check(s.NumUris(), Equals, 1)
+	c.Check(s.NumBackends(), Equals, 1)
+
+	s.checkLookup(c, "api.vcap.me/v2/admin", "192.168.1.3:1234")
+	s.checkLookup(c, "api.vcap.me/v2", "")
+}
+
+func (s *RegistrySuite) TestPathRoutesMarshalling(c *C) {
+	s.Register(apiReg)
+	s.Register(apiV2Reg)
+
+	marshalled, err := json.Marshal(s)
+	c.Check(err, IsNil)
+	c.Check(string(marshalled), Equals, `{"api.vcap.me":["192.168.1.1:1234"],"api.vcap.me/v2":["192.168.1.2:1234"]}`)
+}
+
+func (s *RegistrySuite) TestLookupExcept(c *C) {
+	s.Register(barReg)
+	s.Register(bar2Reg)
+
+	b1, ok := s.Lookup("bar.vcap.me")
+	c.Assert(ok, Equals, true)
+
+	for i := 0; i < 10; i++ {
+		b2, ok := s.LookupExcept("bar.vcap.me", []*Backend{b1})
+		c.Assert(ok, Equals, true)
+		c.Check(b2, Not(Equals), b1)
+	}
+
+	b2, _ := s.LookupExcept("bar.vcap.me", []*Backend{b1})
+
+	_, ok = s.LookupExcept("bar.vcap.me", []*Backend{b1, b2})
+	c.Check(ok, Equals, false)
+
+	_, ok = s.LookupExcept("foo.vcap.me", nil)
+	c.Check(ok, Equals, false)
+}
+
+func (s *RegistrySuite) failBackend(b *Backend, n int) {
+	for i := 0; i < n; i++ {
+		s.CaptureBackendOutcome(b, &http.Response{StatusCode: 503}, nil, time.Millisecond)
+	}
+}
+
+func (s *RegistrySuite) TestLookupSkipsEjectedBackends(c *C) {
+	m1 := &registryMessage{
+		Host: "192.168.1.1",
+		Port: 1234,
+		Uris: []Uri{"bar.vcap.me"},
+
+		PrivateInstanceId: "instance-1",
+	}
+
+	m2 := &registryMessage{
+		Host: "192.168.1.2",
+		Port: 1234,
+		Uris: []Uri{"bar.vcap.me"},
+
+		PrivateInstanceId: "instance-2",
+	}
+
+	s.Register(m1)
+	s.Register(m2)
+
+	b1, _ := s.LookupByPrivateInstanceId("bar.vcap.me", "instance-1")
+	b2, _ := s.LookupByPrivateInstanceId("bar.vcap.me", "instance-2")
+
+	// Failures only count when they happen in a row
+	s.failBackend(b1, 4)
+	s.CaptureBackendOutcome(b1, &http.Response{StatusCode: 200}, nil, time.Millisecond)
+	s.failBackend(b1, 4)
+	c.Check(b1.isEjected(time.Now()), Equals, false)
+
+	s.CaptureBackendOutcome(b1, nil, errors.New("connection refused"), time.Millisecond)
+	c.Check(b1.isEjected(time.Now()), Equals, true)
+
+	for i := 0; i < 10; i++ {
+		b, ok := s.Lookup("bar.vcap.me")
+		c.Assert(ok, Equals, true)
+		c.Check(b, Equals, b2)
+	}
+
+	_, ok := s.LookupByPrivateInstanceId("bar.vcap.me", "instance-1")
+	c.Check(ok, Equals, false)
+}
+
+func (s *RegistrySuite) TestLookupFallsBackToEjectedBackends(c *C) {
+	s.Register(barReg)
+	s.Register(bar2Reg)
+
+	b1, _ := s.Lookup("bar.vcap.me")
+	b2, _ := s.LookupExcept("bar.vcap.me", []*Backend{b1})
+
+	s.failBackend(b1, 5)
+	s.failBackend(b2, 5)
+
+	_, ok := s.Lookup("bar.vcap.me")
+	c.Check(ok, Equals, true)
+
+	_, ok = s.LookupExcept("bar.vcap.me", []*Backend{b1, b2})
+	c.Check(ok, Equals, false)
+}
+
+func (s *RegistrySuite) TestReinstateAfterCoolDown(c *C) {
+	s.backendCoolDown = 10 * time.Millisecond
+
+	s.Register(fooReg)
+
+	b, _ := s.Lookup("foo.vcap.me")
+
+	s.failBackend(b, 5)
+	c.Check(b.isEjected(time.Now()), Equals, true)
+
+	time.Sleep(20 * time.Millisecond)
+	c.Check(b.isEjected(time.Now()), Equals, false)
+}
+
+func (s *RegistrySuite) TestNoEjectionWithoutMaxFailures(c *C) {
+	s.backendMaxFailures = 0
+
+	s.Register(fooReg)
+
+	b, _ := s.Lookup("foo.vcap.me")
+
+	s.failBackend(b, 100)
+	c.Check(b.isEjected(time.Now()), Equals, false)
+}
+
 func (s *RegistrySuite) TestTracker(c *C) {
 	s.Register(fooReg)
 	s.Register(barReg)
@@ -3,6 +3,7 @@ package router
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	mbus "github.com/cloudfoundry/go_cfmessagebus"
@@ -228,11 +229,33 @@ func (router *Router) Run() {
 		log.Fatalf("net.Listen: %s", err)
 	}
 
+	var listenTls net.Listener
+	if router.config.Tls.Port != 0 {
+		listenTls, err = router.listenTls()
+		if err != nil {
+			log.Fatalf("listenTls: %s", err)
+		}
+	}
+
 	util.WritePidFile(router.config.Pidfile)
 
-	log.Infof("Listening on %s", listen.Addr())
+	server := proxy.Server{
+		Handler:          router.proxy,
+		HandshakeTimeout: router.config.TlsHandshakeTimeout,
+	}
 
-	server := proxy.Server{Handler: router.proxy}
+	if listenTls != nil {
+		log.Infof("Listening on %s (TLS)", listenTls.Addr())
+
+		go func() {
+			err := server.Serve(listenTls)
+			if err != nil {
+				log.Fatalf("proxy.Serve: %s", err)
+			}
+		}()
+	}
+
+	log.Infof("Listening on %s", listen.Addr())
 
 	err = server.Serve(listen)
 	if err != nil {
@@ -240,6 +263,20 @@ func (router *Router) Run() {
 	}
 }
 
+func (router *Router) listenTls() (net.Listener, error) {
+	x, err := LoadTlsConfig(&router.config.Tls)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", router.config.Tls.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(l, x), nil
+}
+
 func (r *Router) establishMBus() {
 	mbusClient, err := mbus.NewCFMessageBus("NATS")
 	r.mbusClient = mbusClient
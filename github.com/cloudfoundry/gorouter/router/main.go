@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/cloudfoundry/gorouter"
+	"os"
 )
 
 var configFile string
@@ -19,6 +21,11 @@ func main() {
 		c = router.InitConfigFromFile(configFile)
 	}
 
+	if err := c.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s\n", err)
+		os.Exit(1)
+	}
+
 	router.SetupLoggerFromConfig(c)
 
 	router.NewRouter(c).Run()
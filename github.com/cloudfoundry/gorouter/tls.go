@@ -0,0 +1,41 @@
+package router
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// Return a TLS configuration that chooses between the certificates by the
+// server name the client asks for with SNI. Names may have a wildcard as
+// their first label, like "*.example.com". Clients that don't send a known
+// name get the first certificate.
+func NewTlsConfig(cs []tls.Certificate) (*tls.Config, error) {
+	if len(cs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+
+	x := &tls.Config{
+		Certificates: cs,
+	}
+
+	x.BuildNameToCertificate()
+
+	return x, nil
+}
+
+// Load the certificates in the configuration into a new TLS configuration.
+func LoadTlsConfig(c *TlsConfig) (*tls.Config, error) {
+	var cs []tls.Certificate
+
+	for _, x := range c.Certificates {
+		y, err := tls.LoadX509KeyPair(x.CertFile, x.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %s", x.CertFile, err)
+		}
+
+		cs = append(cs, y)
+	}
+
+	return NewTlsConfig(cs)
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type TlsSuite struct{}
+
+var _ = Suite(&TlsSuite{})
+
+// Create a self-signed certificate for the given names. The first name is
+// also its common name.
+func newTestCertificate(names ...string) tls.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	t := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names[1:],
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, t, t, &k.PublicKey, k)
+	if err != nil {
+		panic(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{b},
+		PrivateKey:  k,
+	}
+}
+
+// Return the certificate the client gets when it asks for the server name.
+func handshake(x *tls.Config, name string) string {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	go tls.Server(b, x).Handshake()
+
+	y := tls.Client(a, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+	if err := y.Handshake(); err != nil {
+		panic(err)
+	}
+
+	return y.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func (s *TlsSuite) TestNewTlsConfig(c *C) {
+	x, err := NewTlsConfig([]tls.Certificate{
+		newTestCertificate("default", "default.example.com"),
+		newTestCertificate("exact", "foo.example.com", "bar.example.com"),
+		newTestCertificate("wildcard", "*.example.com"),
+		newTestCertificate("cn.example.org"),
+	})
+	c.Assert(err, IsNil)
+
+	c.Check(handshake(x, "foo.example.com"), Equals, "exact")
+	c.Check(handshake(x, "BAR.example.com"), Equals, "exact")
+	c.Check(handshake(x, "baz.example.com"), Equals, "wildcard")
+	c.Check(handshake(x, "cn.example.org"), Equals, "cn.example.org")
+
+	// A wildcard only matches a single label
+	c.Check(handshake(x, "a.baz.example.com"), Equals, "default")
+	c.Check(handshake(x, "example.com"), Equals, "default")
+
+	// Clients without SNI get the first certificate
+	c.Check(handshake(x, ""), Equals, "default")
+}
+
+func (s *TlsSuite) TestNoCertificates(c *C) {
+	_, err := NewTlsConfig(nil)
+	c.Check(err, NotNil)
+}
+
+func (s *TlsSuite) TestLoadTlsConfig(c *C) {
+	d, err := ioutil.TempDir("", "gorouter")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(d)
+
+	x := newTestCertificate("foo.example.com")
+
+	k, err := x509.MarshalECPrivateKey(x.PrivateKey.(*ecdsa.PrivateKey))
+	c.Assert(err, IsNil)
+
+	y := TlsCertificateConfig{
+		CertFile: filepath.Join(d, "cert.pem"),
+		KeyFile:  filepath.Join(d, "key.pem"),
+	}
+
+	err = ioutil.WriteFile(y.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Certificate[0]}), 0600)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(y.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k}), 0600)
+	c.Assert(err, IsNil)
+
+	z, err := LoadTlsConfig(&TlsConfig{Certificates: []TlsCertificateConfig{y}})
+	c.Assert(err, IsNil)
+	c.Check(handshake(z, "foo.example.com"), Equals, "foo.example.com")
+
+	y.KeyFile = filepath.Join(d, "missing.pem")
+
+	_, err = LoadTlsConfig(&TlsConfig{Certificates: []TlsCertificateConfig{y}})
+	c.Check(err, NotNil)
+}
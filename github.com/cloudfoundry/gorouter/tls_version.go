@@ -0,0 +1,24 @@
+// +build go1.3
+
+package router
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// Return the name of the negotiated TLS version for the access log.
+func tlsVersionName(s *tls.ConnectionState) string {
+	switch s.Version {
+	case tls.VersionSSL30:
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	}
+
+	return fmt.Sprintf("0x%04x", s.Version)
+}
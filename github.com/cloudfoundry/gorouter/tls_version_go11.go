@@ -0,0 +1,12 @@
+// +build !go1.3
+
+package router
+
+import (
+	"crypto/tls"
+)
+
+// The negotiated version is only part of the connection state from Go 1.3.
+func tlsVersionName(s *tls.ConnectionState) string {
+	return "unknown"
+}
@@ -0,0 +1,25 @@
+// +build go1.3
+
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	. "launchpad.net/gocheck"
+)
+
+func (s *TlsSuite) TestTlsVersionName(c *C) {
+	c.Check(tlsVersionName(&tls.ConnectionState{Version: tls.VersionTLS12}), Equals, "TLSv1.2")
+	c.Check(tlsVersionName(&tls.ConnectionState{Version: 0x0999}), Equals, "0x0999")
+}
+
+func (s *AccessLoggerSuite) TestAccessLogRecordEncodeTlsVersion(c *C) {
+	r := s.CreateAccessLogRecord()
+	r.Request.TLS = &tls.ConnectionState{Version: tls.VersionTLS12}
+
+	b := &bytes.Buffer{}
+	_, err := r.WriteTo(b)
+	c.Assert(err, IsNil)
+
+	c.Check(b.String(), Matches, `.* tls_version:TLSv1\.2\n`)
+}
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A DialError is returned by BackendTransport when no connection could be
+// made to a backend. Nothing of the request has been sent when it is
+// returned, so the request can safely be retried against another backend.
+type DialError struct {
+	Backend *Backend
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("dial %s: %s", e.Backend.CanonicalAddr(), e.Err)
+}
+
+func isDialError(err error) bool {
+	_, ok := err.(*DialError)
+	return ok
+}
+
+// BackendTransport round trips requests to backends over keep-alive
+// connections. Every backend gets a pool of its own, so that the idle
+// connections of a backend that is no longer used can be dropped along
+// with its pool.
+type BackendTransport struct {
+	sync.Mutex
+
+	Varz
+
+	maxIdle         int
+	dialTimeout     time.Duration
+	responseTimeout time.Duration
+	idleTimeout     time.Duration
+
+	pools map[BackendId]*backendPool
+}
+
+type backendPool struct {
+	*http.Transport
+	usedAt time.Time
+}
+
+func NewBackendTransport(c *Config, v Varz) *BackendTransport {
+	return &BackendTransport{
+		Varz: v,
+
+		maxIdle:         c.MaxIdleConnsPerBackend,
+		dialTimeout:     c.BackendDialTimeout,
+		responseTimeout: c.BackendResponseTimeout,
+		idleTimeout:     c.BackendIdleTimeout,
+
+		pools: make(map[BackendId]*backendPool),
+	}
+}
+
+func (t *BackendTransport) StartPruningCycle() {
+	go t.checkAndPrune()
+}
+
+func (t *BackendTransport) RoundTrip(b *Backend, req *http.Request) (*http.Response, error) {
+	return t.pool(b).RoundTrip(req)
+}
+
+func (t *BackendTransport) NumPools() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.pools)
+}
+
+func (t *BackendTransport) pool(b *Backend) *http.Transport {
+	t.Lock()
+	defer t.Unlock()
+
+	x, ok := t.pools[b.BackendId]
+	if !ok {
+		x = &backendPool{
+			Transport: &http.Transport{
+				Dial:                  t.dialer(b),
+				MaxIdleConnsPerHost:   t.maxIdle,
+				ResponseHeaderTimeout: t.responseTimeout,
+			},
+		}
+
+		t.pools[b.BackendId] = x
+	}
+
+	x.usedAt = time.Now()
+
+	return x.Transport
+}
+
+func (t *BackendTransport) dialer(b *Backend) func(string, string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		c, err := net.DialTimeout(network, addr, t.dialTimeout)
+		if err != nil {
+			return nil, &DialError{Backend: b, Err: err}
+		}
+
+		t.Varz.CaptureBackendConnOpen()
+
+		return &backendConn{Conn: c, t: t}, nil
+	}
+}
+
+// Drop the pools that haven't been used for the idle timeout, closing
+// their idle connections.
+func (t *BackendTransport) pruneIdlePools() {
+	t.Lock()
+	defer t.Unlock()
+
+	for i, x := range t.pools {
+		if x.usedAt.Add(t.idleTimeout).After(time.Now()) {
+			continue
+		}
+
+		x.CloseIdleConnections()
+		delete(t.pools, i)
+	}
+}
+
+func (t *BackendTransport) checkAndPrune() {
+	if t.idleTimeout == 0 {
+		return
+	}
+
+	tick := time.Tick(t.idleTimeout)
+	for {
+		select {
+		case <-tick:
+			t.pruneIdlePools()
+		}
+	}
+}
+
+// A backendConn reports to varz when it is closed.
+type backendConn struct {
+	net.Conn
+	t *BackendTransport
+
+	closeOnce sync.Once
+}
+
+func (c *backendConn) Close() error {
+	c.closeOnce.Do(c.t.Varz.CaptureBackendConnClose)
+	return c.Conn.Close()
+}
+
+// A retryableBody keeps the body of a client's request open when the
+// transport closes it after failing to dial a backend, so that it can
+// still be sent to another one. The server closes the real body once the
+// request has been served.
+type retryableBody struct {
+	io.Reader
+}
+
+func (b retryableBody) Close() error {
+	return nil
+}
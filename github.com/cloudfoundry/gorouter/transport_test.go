@@ -0,0 +1,63 @@
+package router
+
+import (
+	. "launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"time"
+)
+
+type TransportSuite struct {
+	*BackendTransport
+}
+
+var _ = Suite(&TransportSuite{})
+
+func (s *TransportSuite) SetUpTest(c *C) {
+	x := DefaultConfig()
+	x.BackendDialTimeout = 1 * time.Second
+	x.BackendIdleTimeout = 10 * time.Millisecond
+
+	s.BackendTransport = NewBackendTransport(x, nullVarz{})
+}
+
+func (s *TransportSuite) TestPoolPerBackend(c *C) {
+	b1 := &Backend{BackendId: "192.168.1.1:1234"}
+	b2 := &Backend{BackendId: "192.168.1.2:1234"}
+
+	c.Check(s.pool(b1), Equals, s.pool(b1))
+	c.Check(s.pool(b1), Not(Equals), s.pool(b2))
+	c.Check(s.NumPools(), Equals, 2)
+}
+
+func (s *TransportSuite) TestPruneIdlePools(c *C) {
+	b1 := &Backend{BackendId: "192.168.1.1:1234"}
+	b2 := &Backend{BackendId: "192.168.1.2:1234"}
+
+	s.pool(b1)
+	time.Sleep(20 * time.Millisecond)
+	s.pool(b2)
+
+	s.pruneIdlePools()
+	c.Check(s.NumPools(), Equals, 1)
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.pruneIdlePools()
+	c.Check(s.NumPools(), Equals, 0)
+}
+
+func (s *TransportSuite) TestDialError(c *C) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	ln.Close()
+
+	b := &Backend{BackendId: BackendId(ln.Addr().String())}
+
+	req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	c.Assert(err, IsNil)
+
+	_, err = s.RoundTrip(b, req)
+	c.Assert(err, NotNil)
+	c.Check(isDialError(err), Equals, true)
+}
@@ -28,6 +28,10 @@ type varz struct {
 	BadRequests    int     `json:"bad_requests"`
 	RequestsPerSec float64 `json:"requests_per_sec"`
 
+	Retries                  int `json:"retries"`
+	BackendConnections       int `json:"backend_connections"`
+	BackendConnectionsOpened int `json:"backend_connections_opened"`
+
 	TopApps []topAppsEntry `json:"top10_app_requests"`
 }
 
@@ -157,6 +161,9 @@ type Varz interface {
 	CaptureBadRequest(req *http.Request)
 	CaptureBackendRequest(b *Backend, req *http.Request)
 	CaptureBackendResponse(b *Backend, res *http.Response, d time.Duration)
+	CaptureBackendRetry(b *Backend)
+	CaptureBackendConnOpen()
+	CaptureBackendConnClose()
 }
 
 type RealVarz struct {
@@ -244,6 +251,28 @@ func (x *RealVarz) CaptureBackendResponse(backend *Backend, response *http.Respo
 	x.varz.All.CaptureResponse(response, duration)
 }
 
+func (x *RealVarz) CaptureBackendRetry(b *Backend) {
+	x.Lock()
+	defer x.Unlock()
+
+	x.Retries++
+}
+
+func (x *RealVarz) CaptureBackendConnOpen() {
+	x.Lock()
+	defer x.Unlock()
+
+	x.BackendConnections++
+	x.BackendConnectionsOpened++
+}
+
+func (x *RealVarz) CaptureBackendConnClose() {
+	x.Lock()
+	defer x.Unlock()
+
+	x.BackendConnections--
+}
+
 func transform(x interface{}, y map[string]interface{}) error {
 	var b []byte
 	var err error
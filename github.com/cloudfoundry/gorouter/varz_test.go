@@ -78,6 +78,9 @@ func (s *VarzSuite) TestMembersOfUniqueVarz(c *C) {
 		"bad_requests",
 		"requests_per_sec",
 		"top10_app_requests",
+		"retries",
+		"backend_connections",
+		"backend_connections_opened",
 	}
 
 	b, e := json.Marshal(v)
@@ -120,6 +123,27 @@ func (s *VarzSuite) TestUpdateBadRequests(c *C) {
 	c.Check(s.findValue("bad_requests"), Equals, float64(2))
 }
 
+func (s *VarzSuite) TestUpdateRetries(c *C) {
+	b := &Backend{}
+
+	s.CaptureBackendRetry(b)
+	c.Check(s.findValue("retries"), Equals, float64(1))
+
+	s.CaptureBackendRetry(b)
+	c.Check(s.findValue("retries"), Equals, float64(2))
+}
+
+func (s *VarzSuite) TestUpdateBackendConnections(c *C) {
+	s.CaptureBackendConnOpen()
+	s.CaptureBackendConnOpen()
+	c.Check(s.findValue("backend_connections"), Equals, float64(2))
+	c.Check(s.findValue("backend_connections_opened"), Equals, float64(2))
+
+	s.CaptureBackendConnClose()
+	c.Check(s.findValue("backend_connections"), Equals, float64(1))
+	c.Check(s.findValue("backend_connections_opened"), Equals, float64(2))
+}
+
 func (s *VarzSuite) TestUpdateRequests(c *C) {
 	b := &Backend{}
 	r := http.Request{}
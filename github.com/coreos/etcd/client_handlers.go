@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/coreos/etcd/store"
 	"net/http"
 	"strconv"
@@ -37,6 +38,11 @@ func SetHttpHandler(w *http.ResponseWriter, req *http.Request) {
 
 	debug("[recv] POST http://%v/v1/keys/%s", raftServer.Name(), key)
 
+	if req.FormValue("dir") == "true" {
+		SetDirHttpHandler(w, req, key)
+		return
+	}
+
 	value := req.FormValue("value")
 
 	if len(value) == 0 {
@@ -57,9 +63,38 @@ func SetHttpHandler(w *http.ResponseWriter, req *http.Request) {
 		(*w).WriteHeader(http.StatusBadRequest)
 
 		(*w).Write(newJsonError(202, "Set"))
+		return
+	}
+
+	prevIndex, prevExist, ok := conditions(w, req)
+
+	if !ok {
+		return
 	}
 
-	if len(prevValue) != 0 {
+	if prevExist == "false" {
+		if len(prevValue) != 0 || prevIndex != 0 {
+			(*w).WriteHeader(http.StatusBadRequest)
+			(*w).Write(newJsonError(206, "Set"))
+			return
+		}
+
+		command := &CreateCommand{}
+		command.Key = key
+		command.Value = value
+		command.ExpireTime = expireTime
+		dispatch(command, w, req, true)
+
+	} else if prevIndex != 0 || prevExist == "true" {
+		command := &CompareAndSetCommand{}
+		command.Key = key
+		command.Value = value
+		command.PrevValue = prevValue
+		command.PrevIndex = prevIndex
+		command.ExpireTime = expireTime
+		dispatch(command, w, req, true)
+
+	} else if len(prevValue) != 0 {
 		command := &TestAndSetCommand{}
 		command.Key = key
 		command.Value = value
@@ -77,18 +112,89 @@ func SetHttpHandler(w *http.ResponseWriter, req *http.Request) {
 
 }
 
+// Set Directory Handler
+func SetDirHttpHandler(w *http.ResponseWriter, req *http.Request, key string) {
+	strDuration := req.FormValue("ttl")
+
+	expireTime, err := durationToExpireTime(strDuration)
+
+	if err != nil {
+
+		(*w).WriteHeader(http.StatusBadRequest)
+
+		(*w).Write(newJsonError(202, "Set"))
+		return
+	}
+
+	command := &SetDirCommand{}
+	command.Key = key
+	command.ExpireTime = expireTime
+	dispatch(command, w, req, true)
+}
+
 // Delete Handler
 func DeleteHttpHandler(w *http.ResponseWriter, req *http.Request) {
 	key := req.URL.Path[len("/v1/keys/"):]
 
 	debug("[recv] DELETE http://%v/v1/keys/%s", raftServer.Name(), key)
 
+	prevValue := req.FormValue("prevValue")
+
+	prevIndex, prevExist, ok := conditions(w, req)
+
+	if !ok {
+		return
+	}
+
+	// a key that does not exist cannot be deleted
+	if prevExist == "false" {
+		(*w).WriteHeader(http.StatusBadRequest)
+		(*w).Write(newJsonError(206, "Delete"))
+		return
+	}
+
+	if len(prevValue) != 0 || prevIndex != 0 {
+		command := &CompareAndDeleteCommand{}
+		command.Key = key
+		command.PrevValue = prevValue
+		command.PrevIndex = prevIndex
+		dispatch(command, w, req, true)
+		return
+	}
+
 	command := &DeleteCommand{}
 	command.Key = key
+	command.Recursive = req.FormValue("recursive") == "true"
 
 	dispatch(command, w, req, true)
 }
 
+// Parse the prevIndex and prevExist conditions of a set or delete request
+// If they are not valid, the error is written and ok is false
+func conditions(w *http.ResponseWriter, req *http.Request) (prevIndex uint64, prevExist string, ok bool) {
+	if strIndex := req.FormValue("prevIndex"); strIndex != "" {
+		var err error
+
+		prevIndex, err = strconv.ParseUint(strIndex, 10, 64)
+
+		if err != nil || prevIndex == 0 {
+			(*w).WriteHeader(http.StatusBadRequest)
+			(*w).Write(newJsonError(204, strIndex))
+			return 0, "", false
+		}
+	}
+
+	prevExist = req.FormValue("prevExist")
+
+	if prevExist != "" && prevExist != "true" && prevExist != "false" {
+		(*w).WriteHeader(http.StatusBadRequest)
+		(*w).Write(newJsonError(205, prevExist))
+		return 0, "", false
+	}
+
+	return prevIndex, prevExist, true
+}
+
 // Dispatch the command to leader
 func dispatch(c Command, w *http.ResponseWriter, req *http.Request, client bool) {
 	if raftServer.State() == "leader" {
@@ -109,6 +215,24 @@ func dispatch(c Command, w *http.ResponseWriter, req *http.Request, client bool)
 				(*w).Write(newJsonError(102, err.Error()))
 				return
 			}
+
+			if _, ok := err.(store.TestIndexFail); ok {
+				(*w).WriteHeader(http.StatusBadRequest)
+				(*w).Write(newJsonError(104, err.Error()))
+				return
+			}
+
+			if _, ok := err.(store.KeyExists); ok {
+				(*w).WriteHeader(http.StatusBadRequest)
+				(*w).Write(newJsonError(105, err.Error()))
+				return
+			}
+
+			if _, ok := err.(store.NotDir); ok {
+				(*w).WriteHeader(http.StatusBadRequest)
+				(*w).Write(newJsonError(103, err.Error()))
+				return
+			}
 			(*w).WriteHeader(http.StatusInternalServerError)
 			(*w).Write(newJsonError(300, err.Error()))
 			return
@@ -217,6 +341,7 @@ func GetHttpHandler(w *http.ResponseWriter, req *http.Request) {
 
 	command := &GetCommand{}
 	command.Key = key
+	command.Recursive = req.FormValue("recursive") == "true"
 
 	if body, err := command.Apply(raftServer); err != nil {
 
@@ -243,6 +368,8 @@ func GetHttpHandler(w *http.ResponseWriter, req *http.Request) {
 }
 
 // Watch handler
+// With stream=true, all the events under the key are sent in one
+// chunked response until the client goes away
 func WatchHttpHandler(w http.ResponseWriter, req *http.Request) {
 	key := req.URL.Path[len("/v1/watch/"):]
 
@@ -263,6 +390,7 @@ func WatchHttpHandler(w http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(newJsonError(203, "Watch From Index"))
+			return
 		}
 		command.SinceIndex = sinceIndex
 
@@ -271,7 +399,18 @@ func WatchHttpHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if req.FormValue("stream") == "true" {
+		streamWatch(w, key, command.SinceIndex)
+		return
+	}
+
 	if body, err := command.Apply(raftServer); err != nil {
+		if _, ok := err.(store.IndexCleared); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(newJsonError(401, err.Error()))
+			return
+		}
+
 		warn("Unable to do watch command: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -289,6 +428,72 @@ func WatchHttpHandler(w http.ResponseWriter, req *http.Request) {
 
 }
 
+// Send the events under the key to the client, one json object per line
+// If the client is too slow to keep up, the response ends and the client
+// should watch again since the index of the last event it got
+func streamWatch(w http.ResponseWriter, key string, sinceIndex uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// without a CloseNotifier the watch only ends with the store's events
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	watcher := store.CreateStreamWatcher()
+
+	events, err := etcdStore.AddStreamWatcher(key, watcher, sinceIndex)
+
+	if err != nil {
+		if _, ok := err.(store.IndexCleared); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(newJsonError(401, err.Error()))
+			return
+		}
+
+		warn("Unable to do watch command: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	defer etcdStore.RemoveWatcher(key, watcher)
+
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+
+	for _, resp := range events {
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	for {
+		select {
+		case resp, ok := <-watcher.C:
+			if !ok {
+				debug("stream watcher of %s falls behind", key)
+				return
+			}
+
+			if err := encoder.Encode(resp); err != nil {
+				return
+			}
+
+			flusher.Flush()
+
+		case <-closed:
+			return
+		}
+	}
+}
+
 // Convert string duration to time format
 func durationToExpireTime(strDuration string) (time.Time, error) {
 	if strDuration != "" {
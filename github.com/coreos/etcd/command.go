@@ -31,6 +31,22 @@ func (c *SetCommand) Apply(server *raft.Server) (interface{}, error) {
 	return etcdStore.Set(c.Key, c.Value, c.ExpireTime, server.CommitIndex())
 }
 
+// SetDir command
+type SetDirCommand struct {
+	Key        string    `json:"key"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// The name of the setDir command in the log
+func (c *SetDirCommand) CommandName() string {
+	return "etcd:setDir"
+}
+
+// Create the directory or update its expire time
+func (c *SetDirCommand) Apply(server *raft.Server) (interface{}, error) {
+	return etcdStore.SetDir(c.Key, c.ExpireTime, server.CommitIndex())
+}
+
 // TestAndSet command
 type TestAndSetCommand struct {
 	Key        string    `json:"key"`
@@ -49,9 +65,47 @@ func (c *TestAndSetCommand) Apply(server *raft.Server) (interface{}, error) {
 	return etcdStore.TestAndSet(c.Key, c.PrevValue, c.Value, c.ExpireTime, server.CommitIndex())
 }
 
+// CompareAndSet command
+type CompareAndSetCommand struct {
+	Key        string    `json:"key"`
+	Value      string    `json:"value"`
+	PrevValue  string    `json:"prevValue"`
+	PrevIndex  uint64    `json:"prevIndex"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// The name of the compareAndSet command in the log
+func (c *CompareAndSetCommand) CommandName() string {
+	return "etcd:compareAndSet"
+}
+
+// Set the key-value pair if the key exists and its current value and index
+// equal to the given prevValue and prevIndex
+func (c *CompareAndSetCommand) Apply(server *raft.Server) (interface{}, error) {
+	return etcdStore.CompareAndSet(c.Key, c.PrevValue, c.PrevIndex, c.Value, c.ExpireTime, server.CommitIndex())
+}
+
+// Create command
+type CreateCommand struct {
+	Key        string    `json:"key"`
+	Value      string    `json:"value"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// The name of the create command in the log
+func (c *CreateCommand) CommandName() string {
+	return "etcd:create"
+}
+
+// Set the key-value pair if the key does not exist
+func (c *CreateCommand) Apply(server *raft.Server) (interface{}, error) {
+	return etcdStore.Create(c.Key, c.Value, c.ExpireTime, server.CommitIndex())
+}
+
 // Get command
 type GetCommand struct {
-	Key string `json:"key"`
+	Key       string `json:"key"`
+	Recursive bool   `json:"recursive"`
 }
 
 // The name of the get command in the log
@@ -61,12 +115,16 @@ func (c *GetCommand) CommandName() string {
 
 // Get the value of key
 func (c *GetCommand) Apply(server *raft.Server) (interface{}, error) {
+	if c.Recursive {
+		return etcdStore.RecursiveGet(c.Key)
+	}
 	return etcdStore.Get(c.Key)
 }
 
 // Delete command
 type DeleteCommand struct {
-	Key string `json:"key"`
+	Key       string `json:"key"`
+	Recursive bool   `json:"recursive"`
 }
 
 // The name of the delete command in the log
@@ -76,9 +134,30 @@ func (c *DeleteCommand) CommandName() string {
 
 // Delete the key
 func (c *DeleteCommand) Apply(server *raft.Server) (interface{}, error) {
+	if c.Recursive {
+		return etcdStore.RecursiveDelete(c.Key, server.CommitIndex())
+	}
 	return etcdStore.Delete(c.Key, server.CommitIndex())
 }
 
+// CompareAndDelete command
+type CompareAndDeleteCommand struct {
+	Key       string `json:"key"`
+	PrevValue string `json:"prevValue"`
+	PrevIndex uint64 `json:"prevIndex"`
+}
+
+// The name of the compareAndDelete command in the log
+func (c *CompareAndDeleteCommand) CommandName() string {
+	return "etcd:compareAndDelete"
+}
+
+// Delete the key if its current value and index equal to the given
+// prevValue and prevIndex
+func (c *CompareAndDeleteCommand) Apply(server *raft.Server) (interface{}, error) {
+	return etcdStore.CompareAndDelete(c.Key, c.PrevValue, c.PrevIndex, server.CommitIndex())
+}
+
 // Watch command
 type WatchCommand struct {
 	Key        string `json:"key"`
@@ -95,7 +174,11 @@ func (c *WatchCommand) Apply(server *raft.Server) (interface{}, error) {
 	watcher := store.CreateWatcher()
 
 	// add to the watchers list
-	etcdStore.AddWatcher(c.Key, watcher, c.SinceIndex)
+	err := etcdStore.AddWatcher(c.Key, watcher, c.SinceIndex)
+
+	if err != nil {
+		return nil, err
+	}
 
 	// wait for the notification for any changing
 	res := <-watcher.C
@@ -13,14 +13,22 @@ func init() {
 	errors[100] = "Key Not Found"
 	errors[101] = "The given PrevValue is not equal to the value of the key"
 	errors[102] = "Not A File"
+	errors[103] = "Not A Directory"
+	errors[104] = "The given PrevIndex is not equal to the index of the key"
+	errors[105] = "Key Already Exists"
 	// Post form related errors
 	errors[200] = "Value is Required in POST form"
 	errors[201] = "PrevValue is Required in POST form"
 	errors[202] = "The given TTL in POST form is not a number"
 	errors[203] = "The given index in POST form is not a number"
+	errors[204] = "The given PrevIndex is not a number"
+	errors[205] = "The given PrevExist is not true or false"
+	errors[206] = "PrevExist=false conflicts with the other conditions or the action"
 	// raft related errors
 	errors[300] = "Raft Internal Error"
 	errors[301] = "During Leader Election"
+	// watch related errors
+	errors[401] = "The event in requested index is outdated and cleared"
 }
 
 type jsonError struct {
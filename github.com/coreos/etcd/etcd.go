@@ -83,7 +83,7 @@ func init() {
 
 	flag.BoolVar(&snapshot, "snapshot", false, "open or close snapshot")
 
-	flag.IntVar(&maxSize, "m", 1024, "the max size of result buffer")
+	flag.IntVar(&maxSize, "m", 1024, "the max number of events kept for watchers")
 
 	flag.IntVar(&retryTimes, "r", 3, "the max retry attempts when trying to join a cluster")
 }
@@ -615,8 +615,12 @@ func joinCluster(s *raft.Server, serverName string) error {
 func registerCommands() {
 	raft.RegisterCommand(&JoinCommand{})
 	raft.RegisterCommand(&SetCommand{})
+	raft.RegisterCommand(&SetDirCommand{})
 	raft.RegisterCommand(&GetCommand{})
 	raft.RegisterCommand(&DeleteCommand{})
 	raft.RegisterCommand(&WatchCommand{})
 	raft.RegisterCommand(&TestAndSetCommand{})
+	raft.RegisterCommand(&CompareAndSetCommand{})
+	raft.RegisterCommand(&CreateCommand{})
+	raft.RegisterCommand(&CompareAndDeleteCommand{})
 }
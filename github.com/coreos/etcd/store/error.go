@@ -12,12 +12,36 @@ func (e NotFile) Error() string {
 	return string(e)
 }
 
+type NotDir string
+
+func (e NotDir) Error() string {
+	return string(e)
+}
+
 type TestFail string
 
 func (e TestFail) Error() string {
 	return string(e)
 }
 
+type TestIndexFail string
+
+func (e TestIndexFail) Error() string {
+	return string(e)
+}
+
+type KeyExists string
+
+func (e KeyExists) Error() string {
+	return string(e)
+}
+
+type IndexCleared string
+
+func (e IndexCleared) Error() string {
+	return string(e)
+}
+
 type Keyword string
 
 func (e Keyword) Error() string {
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// EventHistory keeps the most recent responses (events) of the store in
+// the order they happened, so that a watcher can catch up from a past index.
+// It is a ring buffer: when it is full, the oldest event is dropped.
+type EventHistory struct {
+	// the ring buffer, only used when the capacity is positive
+	queue []Response
+
+	// the position of the oldest event in the queue
+	front int
+
+	// the number of events in the history
+	size int
+
+	// The max number of events we can record
+	// zero means no history and a negative one means unlimited
+	capacity int
+
+	// The index of the newest event that has been dropped
+	// Any event with an index greater than it is still in the history
+	clearedIndex uint64
+}
+
+// The format of the history in a snapshot
+type eventHistoryState struct {
+	Events       []Response `json:"events"`
+	ClearedIndex uint64     `json:"clearedIndex"`
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// Create a new event history that records at most capacity events
+func createEventHistory(capacity int) *EventHistory {
+	h := &EventHistory{capacity: capacity}
+
+	if capacity > 0 {
+		h.queue = make([]Response, capacity)
+	}
+
+	return h
+}
+
+// Add an event to the history, drop the oldest one if the history is full
+func (h *EventHistory) add(resp Response) {
+
+	// zero case
+	if h.capacity == 0 {
+		h.clearedIndex = resp.Index
+		return
+	}
+
+	// unlimited
+	if h.capacity < 0 {
+		h.queue = append(h.queue, resp)
+		h.size++
+		return
+	}
+
+	if h.size == h.capacity {
+		h.clearedIndex = h.queue[h.front].Index
+		h.queue[h.front] = resp
+		h.front = (h.front + 1) % h.capacity
+		return
+	}
+
+	h.queue[(h.front+h.size)%h.capacity] = resp
+	h.size++
+}
+
+// Get the i-th oldest event in the history
+func (h *EventHistory) get(i int) Response {
+	return h.queue[(h.front+i)%len(h.queue)]
+}
+
+// Return the events under the prefix since the given index in order
+// If limit is positive, at most limit events are returned
+// An IndexCleared error is returned if some of the events may have
+// been dropped
+func (h *EventHistory) scan(prefix string, sinceIndex uint64, limit int) ([]Response, error) {
+	if sinceIndex <= h.clearedIndex {
+		err := IndexCleared(fmt.Sprintf("the requested index %d is cleared, the oldest index we have is %d",
+			sinceIndex, h.clearedIndex+1))
+		return nil, err
+	}
+
+	events := make([]Response, 0)
+
+	for i := 0; i < h.size; i++ {
+		resp := h.get(i)
+
+		if resp.Index < sinceIndex || !checkResponse(prefix, resp) {
+			continue
+		}
+
+		events = append(events, resp)
+
+		if limit > 0 && len(events) == limit {
+			break
+		}
+	}
+
+	return events, nil
+}
+
+// The events are saved in order, so that the history can be recovered
+// with a different capacity
+func (h *EventHistory) MarshalJSON() ([]byte, error) {
+	state := eventHistoryState{
+		Events:       make([]Response, 0, h.size),
+		ClearedIndex: h.clearedIndex,
+	}
+
+	for i := 0; i < h.size; i++ {
+		state.Events = append(state.Events, h.get(i))
+	}
+
+	return json.Marshal(state)
+}
+
+// Replace the history with the saved one
+func (h *EventHistory) UnmarshalJSON(b []byte) error {
+	var state eventHistoryState
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+
+	*h = *createEventHistory(h.capacity)
+	h.clearedIndex = state.ClearedIndex
+
+	for _, resp := range state.Events {
+		h.add(resp)
+	}
+
+	return nil
+}
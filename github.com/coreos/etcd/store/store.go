@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
-	"strconv"
+	"sort"
 	"time"
 )
 
@@ -28,17 +28,9 @@ type Store struct {
 	// Now we use it to send changes to the hub of the web service
 	messager *chan string
 
-	// A map to keep the recent response to the clients
-	ResponseMap map[string]Response
-
-	// The max number of the recent responses we can record
-	ResponseMaxSize int
-
-	// The current number of the recent responses we have recorded
-	ResponseCurrSize uint
-
-	// The index of the first recent responses we have
-	ResponseStartIndex uint64
+	// The recent events of the store, for the watchers
+	// to catch up from a past index
+	History *EventHistory
 
 	// Current index of the raft machine
 	Index uint64
@@ -56,6 +48,9 @@ type Node struct {
 	// Otherwise after the expireTime, the node will be deleted
 	ExpireTime time.Time `json:"expireTime"`
 
+	// The index of the command that last set the node
+	Index uint64 `json:"index"`
+
 	// A channel to update the expireTime of the node
 	update chan time.Time `json:"-"`
 }
@@ -79,6 +74,28 @@ type Response struct {
 
 	// The command index of the raft machine when the command is executed
 	Index uint64 `json:"index"`
+
+	// The index of the command that last set the key (returned by a get)
+	// It can be given as prevIndex to set or delete the key conditionally
+	ModifiedIndex uint64 `json:"modifiedIndex,omitempty"`
+
+	// The content of a directory returned by a recursive get
+	KVPairs []KeyValuePair `json:"kvs,omitempty"`
+}
+
+// A KeyValuePair is a node of the tree returned by a recursive get
+// A directory has the nodes under it in its KVPairs
+type KeyValuePair struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Dir   bool   `json:"dir,omitempty"`
+
+	ModifiedIndex uint64 `json:"modifiedIndex,omitempty"`
+
+	Expiration *time.Time `json:"expiration,omitempty"`
+	TTL        int64      `json:"ttl,omitempty"`
+
+	KVPairs []KeyValuePair `json:"kvs,omitempty"`
 }
 
 // A listNode represent the simplest Key-Value pair with its type
@@ -100,22 +117,21 @@ var PERMANENT = time.Unix(0, 0)
 //------------------------------------------------------------------------------
 
 // Create a new stroe
-// Arguement max is the max number of response we want to record
+// Arguement max is the max number of events we want to record
+// for the watchers, a negative one means unlimited
 func CreateStore(max int) *Store {
 	s := new(Store)
 
 	s.messager = nil
 
-	s.ResponseMap = make(map[string]Response)
-	s.ResponseStartIndex = 0
-	s.ResponseMaxSize = max
-	s.ResponseCurrSize = 0
+	s.History = createEventHistory(max)
 
 	s.Tree = &tree{
 		&treeNode{
 			Node{
 				"/",
 				time.Unix(0, 0),
+				0,
 				nil,
 			},
 			true,
@@ -123,7 +139,7 @@ func CreateStore(max int) *Store {
 		},
 	}
 
-	s.watcher = createWatcherHub()
+	s.watcher = createWatcherHub(s.History)
 
 	return s
 }
@@ -192,7 +208,7 @@ func (s *Store) Set(key string, value string, expireTime time.Time, index uint64
 		}
 
 		// Update the information of the node
-		s.Tree.set(key, Node{value, expireTime, node.update})
+		s.Tree.set(key, Node{value, expireTime, index, node.update})
 
 		resp.PrevValue = node.Value
 
@@ -206,8 +222,6 @@ func (s *Store) Set(key string, value string, expireTime time.Time, index uint64
 			*s.messager <- string(msg)
 		}
 
-		s.addToResponseMap(index, &resp)
-
 		return msg, err
 
 		// Add new node
@@ -215,7 +229,7 @@ func (s *Store) Set(key string, value string, expireTime time.Time, index uint64
 
 		update := make(chan time.Time)
 
-		ok := s.Tree.set(key, Node{value, expireTime, update})
+		ok := s.Tree.set(key, Node{value, expireTime, index, update})
 
 		if !ok {
 			err := NotFile(key)
@@ -238,8 +252,6 @@ func (s *Store) Set(key string, value string, expireTime time.Time, index uint64
 
 			*s.messager <- string(msg)
 		}
-
-		s.addToResponseMap(index, &resp)
 		return msg, err
 	}
 
@@ -259,10 +271,11 @@ func (s *Store) internalGet(key string) *Response {
 		isExpire = !node.ExpireTime.Equal(PERMANENT)
 
 		resp := &Response{
-			Action: "GET",
-			Key:    key,
-			Value:  node.Value,
-			Index:  s.Index,
+			Action:        "GET",
+			Key:           key,
+			Value:         node.Value,
+			Index:         s.Index,
+			ModifiedIndex: node.Index,
 		}
 
 		// Update ttl
@@ -306,6 +319,7 @@ func (s *Store) Get(key string) ([]byte, error) {
 
 			if !dirs[i] {
 				resps[i].Value = nodes[i].Value
+				resps[i].ModifiedIndex = nodes[i].Index
 			} else {
 				resps[i].Dir = true
 			}
@@ -328,55 +342,214 @@ func (s *Store) Get(key string) ([]byte, error) {
 	return nil, err
 }
 
-// Delete the key
-func (s *Store) Delete(key string, index uint64) ([]byte, error) {
+// Get the key and, if it is a directory, all the items under it
+// The directory is returned as a nested document of KeyValuePairs
+func (s *Store) RecursiveGet(key string) ([]byte, error) {
 
 	key = path.Clean("/" + key)
 
+	tn, ok := s.Tree.internalGet(key)
+
+	if !ok {
+		err := NotFoundError(key)
+		return nil, err
+	}
+
+	if !tn.Dir {
+		return json.Marshal(s.internalGet(key))
+	}
+
+	resp := Response{
+		Action:  "GET",
+		Key:     key,
+		Dir:     true,
+		Index:   s.Index,
+		KVPairs: kvPairs(key, tn),
+	}
+
+	resp.Expiration, resp.TTL = expiration(&tn.InternalNode)
+
+	return json.Marshal(resp)
+}
+
+// Build the KeyValuePairs of all the items under the directory
+// sorted by key
+func kvPairs(key string, tn *treeNode) []KeyValuePair {
+	names := make([]string, 0, len(tn.NodeMap))
+	for name := range tn.NodeMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]KeyValuePair, len(names))
+
+	for i, name := range names {
+		child := tn.NodeMap[name]
+
+		pairs[i].Key = path.Join(key, name)
+
+		if child.Dir {
+			pairs[i].Dir = true
+			pairs[i].KVPairs = kvPairs(pairs[i].Key, child)
+		} else {
+			pairs[i].Value = child.InternalNode.Value
+			pairs[i].ModifiedIndex = child.InternalNode.Index
+		}
+
+		pairs[i].Expiration, pairs[i].TTL = expiration(&child.InternalNode)
+	}
+
+	return pairs
+}
+
+// Create the directory (and any missing parent directories) or update
+// the expire time of an existing one
+// When a directory expires, all the items under it are deleted with it
+func (s *Store) SetDir(key string, expireTime time.Time, index uint64) ([]byte, error) {
+
 	//Update index
 	s.Index = index
 
-	node, ok := s.Tree.get(key)
+	key = path.Clean("/" + key)
 
-	if ok {
+	isExpire := !expireTime.Equal(PERMANENT)
 
-		resp := Response{
-			Action:    "DELETE",
-			Key:       key,
-			PrevValue: node.Value,
-			Index:     index,
-		}
+	// the directory may already be expired when a slow follower
+	// receives the command
+	if isExpire && expireTime.Sub(time.Now()) < 0 {
+		return s.RecursiveDelete(key, index)
+	}
 
-		if node.ExpireTime.Equal(PERMANENT) {
+	_, exist := s.Tree.internalGet(key)
 
-			s.Tree.delete(key)
+	tn, ok := s.Tree.setDir(key)
 
-		} else {
-			resp.Expiration = &node.ExpireTime
-			// Kill the expire go routine
-			node.update <- PERMANENT
-			s.Tree.delete(key)
+	if !ok {
+		err := NotDir(key)
+		return nil, err
+	}
 
-		}
+	node := &tn.InternalNode
 
-		msg, err := json.Marshal(resp)
+	// replace the expire go routine of the directory
+	if !node.ExpireTime.Equal(PERMANENT) {
+		stopExpiration(node.update)
+	}
 
-		s.watcher.notify(resp)
+	node.ExpireTime = expireTime
+	node.Index = index
+	node.update = nil
 
-		// notify the messager
-		if s.messager != nil && err == nil {
+	if isExpire {
+		node.update = make(chan time.Time)
+		go s.monitorExpiration(key, node.update, expireTime)
+	}
 
-			*s.messager <- string(msg)
-		}
+	resp := Response{
+		Action: "SET",
+		Key:    key,
+		Dir:    true,
+		NewKey: !exist,
+		Index:  index,
+	}
 
-		s.addToResponseMap(index, &resp)
+	resp.Expiration, resp.TTL = expiration(node)
 
-		return msg, err
+	msg, err := json.Marshal(resp)
 
-	} else {
+	// Nofity the watcher
+	s.watcher.notify(resp)
+
+	// Send to the messager
+	if s.messager != nil && err == nil {
+
+		*s.messager <- string(msg)
+	}
+
+	return msg, err
+}
+
+// Delete the key
+// A directory can only be deleted by RecursiveDelete
+func (s *Store) Delete(key string, index uint64) ([]byte, error) {
+
+	key = path.Clean("/" + key)
+
+	tn, ok := s.Tree.internalGet(key)
+
+	if ok && tn.Dir {
+		err := NotFile(key)
+		return nil, err
+	}
+
+	return s.RecursiveDelete(key, index)
+}
+
+// Delete the key, if it is a directory all the items under it are deleted
+func (s *Store) RecursiveDelete(key string, index uint64) ([]byte, error) {
+
+	key = path.Clean("/" + key)
+
+	//Update index
+	s.Index = index
+
+	tn, ok := s.Tree.internalGet(key)
+
+	// the root directory cannot be deleted
+	if !ok || tn == s.Tree.Root {
 		err := NotFoundError(key)
 		return nil, err
 	}
+
+	// Kill the expire go routine
+	stopExpiration(tn.InternalNode.update)
+
+	resp := s.deleteNode(key, tn, index)
+
+	msg, err := json.Marshal(resp)
+
+	// notify the messager
+	if s.messager != nil && err == nil {
+
+		*s.messager <- string(msg)
+	}
+
+	return msg, err
+}
+
+// Remove the tree node of the key from the tree and notify the watchers
+// The items under a directory are removed first (and the watchers are notified
+// of each of them) and their expire go routines are stopped.
+// It is up to the caller to stop the expire go routine of the node itself
+func (s *Store) deleteNode(key string, tn *treeNode, index uint64) Response {
+	if tn.Dir {
+		for name, child := range tn.NodeMap {
+			childKey := path.Join(key, name)
+			stopExpiration(child.InternalNode.update)
+			s.deleteNode(childKey, child, index)
+		}
+	}
+
+	s.Tree.remove(key)
+
+	resp := Response{
+		Action: "DELETE",
+		Key:    key,
+		Dir:    tn.Dir,
+		Index:  index,
+	}
+
+	if !tn.Dir {
+		resp.PrevValue = tn.InternalNode.Value
+	}
+
+	if !tn.InternalNode.ExpireTime.Equal(PERMANENT) {
+		resp.Expiration = &tn.InternalNode.ExpireTime
+	}
+
+	s.watcher.notify(resp)
+
+	return resp
 }
 
 // Set the value of the key to the value if the given prevValue is equal to the value of the key
@@ -401,11 +574,100 @@ func (s *Store) TestAndSet(key string, prevValue string, value string, expireTim
 
 }
 
+// Set the value of the key if the key exists and its current value and index
+// are equal to the given prevValue and prevIndex
+// An empty prevValue or a zero prevIndex is not compared
+func (s *Store) CompareAndSet(key string, prevValue string, prevIndex uint64,
+	value string, expireTime time.Time, index uint64) ([]byte, error) {
+
+	key = path.Clean("/" + key)
+
+	node, ok := s.Tree.get(key)
+
+	if !ok {
+		err := NotFoundError(key)
+		return nil, err
+	}
+
+	if err := compare("CompareAndSet", node, prevValue, prevIndex); err != nil {
+		return nil, err
+	}
+
+	return s.Set(key, value, expireTime, index)
+}
+
+// Set the value of the key only if the key does not exist
+func (s *Store) Create(key string, value string, expireTime time.Time, index uint64) ([]byte, error) {
+
+	key = path.Clean("/" + key)
+
+	if _, ok := s.Tree.internalGet(key); ok {
+		err := KeyExists(key)
+		return nil, err
+	}
+
+	return s.Set(key, value, expireTime, index)
+}
+
+// Delete the key if its current value and index are equal to the
+// given prevValue and prevIndex
+// An empty prevValue or a zero prevIndex is not compared
+func (s *Store) CompareAndDelete(key string, prevValue string, prevIndex uint64, index uint64) ([]byte, error) {
+
+	key = path.Clean("/" + key)
+
+	tn, ok := s.Tree.internalGet(key)
+
+	if !ok {
+		err := NotFoundError(key)
+		return nil, err
+	}
+
+	if tn.Dir {
+		err := NotFile(key)
+		return nil, err
+	}
+
+	if err := compare("CompareAndDelete", tn.InternalNode, prevValue, prevIndex); err != nil {
+		return nil, err
+	}
+
+	return s.Delete(key, index)
+}
+
+// Check the node against the conditions of a compare-and-swap operation
+func compare(op string, node Node, prevValue string, prevIndex uint64) error {
+	if prevValue != "" && node.Value != prevValue {
+		return TestFail(fmt.Sprintf("%s: %s!=%s", op, node.Value, prevValue))
+	}
+
+	if prevIndex != 0 && node.Index != prevIndex {
+		return TestIndexFail(fmt.Sprintf("%s: %d!=%d", op, node.Index, prevIndex))
+	}
+
+	return nil
+}
+
 // Add a channel to the watchHub.
 // The watchHub will send response to the channel when any key under the prefix
 // changes [since the sinceIndex if given]
+// An IndexCleared error is returned if the events since the sinceIndex
+// are no longer in the history
 func (s *Store) AddWatcher(prefix string, watcher *Watcher, sinceIndex uint64) error {
-	return s.watcher.addWatcher(prefix, watcher, sinceIndex, s.ResponseStartIndex, s.Index, &s.ResponseMap)
+	_, err := s.watcher.addWatcher(prefix, watcher, sinceIndex)
+	return err
+}
+
+// Add a stream watcher to the watchHub.
+// The events under the prefix since the sinceIndex (if given) are returned,
+// the following ones will be sent to the channel of the watcher
+func (s *Store) AddStreamWatcher(prefix string, watcher *Watcher, sinceIndex uint64) ([]Response, error) {
+	return s.watcher.addWatcher(prefix, watcher, sinceIndex)
+}
+
+// Remove a watcher from the watchHub
+func (s *Store) RemoveWatcher(prefix string, watcher *Watcher) {
+	s.watcher.removeWatcher(prefix, watcher)
 }
 
 // This function should be created as a go routine to delete the key-value pair
@@ -420,27 +682,18 @@ func (s *Store) monitorExpiration(key string, update chan time.Time, expireTime
 
 		// Timeout delete the node
 		case <-time.After(duration):
-			node, ok := s.Tree.get(key)
+			tn, ok := s.Tree.internalGet(key)
 
-			if !ok {
+			// the node has been deleted or replaced by another one
+			if !ok || tn.InternalNode.update != update {
 				return
 
 			} else {
 
-				s.Tree.delete(key)
-
-				resp := Response{
-					Action:     "DELETE",
-					Key:        key,
-					PrevValue:  node.Value,
-					Expiration: &node.ExpireTime,
-					Index:      s.Index,
-				}
+				resp := s.deleteNode(key, tn, s.Index)
 
 				msg, err := json.Marshal(resp)
 
-				s.watcher.notify(resp)
-
 				// notify the messager
 				if s.messager != nil && err == nil {
 
@@ -465,38 +718,36 @@ func (s *Store) monitorExpiration(key string, update chan time.Time, expireTime
 	}
 }
 
-// When we receive a command that will change the state of the key-value store
-// We will add the result of it to the ResponseMap for the use of watch command
-// Also we may remove the oldest response when we add new one
-func (s *Store) addToResponseMap(index uint64, resp *Response) {
-
-	// zero case
-	if s.ResponseMaxSize == 0 {
+// Stop the expire go routine of a node (if there is one)
+// The go routine may be about to delete the node itself, so we do not
+// wait for it. It will find the node is gone and return
+func stopExpiration(update chan time.Time) {
+	if update == nil {
 		return
 	}
 
-	strIndex := strconv.FormatUint(index, 10)
-	s.ResponseMap[strIndex] = *resp
-
-	// unlimited
-	if s.ResponseMaxSize < 0 {
-		s.ResponseCurrSize++
-		return
+	select {
+	case update <- PERMANENT:
+	default:
 	}
+}
 
-	// if we reach the max point, we need to delete the most latest
-	// response and update the startIndex
-	if s.ResponseCurrSize == uint(s.ResponseMaxSize) {
-		s.ResponseStartIndex++
-		delete(s.ResponseMap, strconv.FormatUint(s.ResponseStartIndex, 10))
-	} else {
-		s.ResponseCurrSize++
+// Get the expiration and ttl of a node, nil and 0 for a permanent one
+func expiration(node *Node) (*time.Time, int64) {
+	if node.ExpireTime.Equal(PERMANENT) {
+		return nil, 0
 	}
+
+	expireTime := node.ExpireTime
+	return &expireTime, int64(expireTime.Sub(time.Now()) / time.Second)
 }
 
 // Save the current state of the storage system
 func (s *Store) Save() ([]byte, error) {
+	s.watcher.mutex.Lock()
 	b, err := json.Marshal(s)
+	s.watcher.mutex.Unlock()
+
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
@@ -506,7 +757,9 @@ func (s *Store) Save() ([]byte, error) {
 
 // Recovery the state of the stroage system from a previous state
 func (s *Store) Recovery(state []byte) error {
+	s.watcher.mutex.Lock()
 	err := json.Unmarshal(state, s)
+	s.watcher.mutex.Unlock()
 
 	// The only thing need to change after the recovery is the
 	// node with expiration time, we need to delete all the node
@@ -520,23 +773,34 @@ func (s *Store) Recovery(state []byte) error {
 // Clean the expired nodes
 // Set up go routines to mon
 func (s *Store) checkExpiration() {
-	s.Tree.traverse(s.checkNode, false)
+	s.checkDir("/", s.Tree.Root)
 }
 
-// Check each node
-func (s *Store) checkNode(key string, node *Node) {
+// Check each node under the directory
+func (s *Store) checkDir(key string, tn *treeNode) {
+	for name, child := range tn.NodeMap {
+		s.checkNode(path.Join(key, name), child)
+	}
+}
 
-	if node.ExpireTime.Equal(PERMANENT) {
-		return
-	} else {
+// Check the node (and the items under it if it is a directory)
+func (s *Store) checkNode(key string, tn *treeNode) {
+	node := &tn.InternalNode
+
+	if !node.ExpireTime.Equal(PERMANENT) {
 		if node.ExpireTime.Sub(time.Now()) >= time.Second {
 
 			node.update = make(chan time.Time)
 			go s.monitorExpiration(key, node.update, node.ExpireTime)
 
 		} else {
-			// we should delete this node
-			s.Tree.delete(key)
+			// we should delete this node (and everything under it)
+			s.Tree.remove(key)
+			return
 		}
 	}
+
+	if tn.Dir {
+		s.checkDir(key, tn)
+	}
 }
@@ -3,6 +3,7 @@ package store
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -134,3 +135,613 @@ func TestExpire(t *testing.T) {
 	}
 
 }
+
+func TestRecursiveGet(t *testing.T) {
+	s := CreateStore(100)
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 1)
+	s.Set("/foo/dir/baz", "2", time.Unix(0, 0), 2)
+	s.Set("/foo/dir/qux", "3", time.Now().Add(time.Second*100), 3)
+
+	res, err := s.RecursiveGet("/foo")
+
+	if err != nil {
+		t.Fatalf("Cannot get directory: %v", err)
+	}
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if !result.Dir || result.Key != "/foo" || len(result.KVPairs) != 2 {
+		t.Fatalf("Wrong directory: %s", res)
+	}
+
+	bar := result.KVPairs[0]
+	if bar.Key != "/foo/bar" || bar.Value != "1" || bar.Dir {
+		t.Fatalf("Wrong file: %v", bar)
+	}
+
+	dir := result.KVPairs[1]
+	if dir.Key != "/foo/dir" || !dir.Dir || len(dir.KVPairs) != 2 {
+		t.Fatalf("Wrong sub directory: %v", dir)
+	}
+
+	if dir.KVPairs[0].Key != "/foo/dir/baz" || dir.KVPairs[0].Value != "2" {
+		t.Fatalf("Wrong file: %v", dir.KVPairs[0])
+	}
+
+	if dir.KVPairs[1].Key != "/foo/dir/qux" || dir.KVPairs[1].TTL <= 0 {
+		t.Fatalf("Wrong expiring file: %v", dir.KVPairs[1])
+	}
+
+	// a file is returned as it is by a plain get
+	res, err = s.RecursiveGet("/foo/bar")
+
+	if err != nil {
+		t.Fatalf("Cannot get file: %v", err)
+	}
+
+	result = Response{}
+	json.Unmarshal(res, &result)
+
+	if result.Key != "/foo/bar" || result.Value != "1" || result.KVPairs != nil {
+		t.Fatalf("Wrong file: %s", res)
+	}
+
+	// the whole tree
+	res, err = s.RecursiveGet("/")
+
+	if err != nil {
+		t.Fatalf("Cannot get root: %v", err)
+	}
+
+	result = Response{}
+	json.Unmarshal(res, &result)
+
+	if len(result.KVPairs) != 1 || result.KVPairs[0].Key != "/foo" {
+		t.Fatalf("Wrong root: %s", res)
+	}
+
+	_, err = s.RecursiveGet("/foo/none")
+
+	if _, ok := err.(NotFoundError); !ok {
+		t.Fatalf("Expect NotFoundError, but got %v", err)
+	}
+}
+
+func TestRecursiveDelete(t *testing.T) {
+	s := CreateStore(100)
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 1)
+	s.Set("/foo/dir/baz", "2", time.Unix(0, 0), 2)
+	s.Set("/foo/dir/qux", "3", time.Now().Add(time.Second*100), 3)
+	s.Set("/other", "4", time.Unix(0, 0), 4)
+
+	_, err := s.Delete("/foo", 5)
+
+	if _, ok := err.(NotFile); !ok {
+		t.Fatalf("Expect NotFile when deleting a directory, but got %v", err)
+	}
+
+	watcher := CreateWatcher()
+	s.AddWatcher("/foo/dir/qux", watcher, 0)
+
+	res, err := s.RecursiveDelete("/foo", 5)
+
+	if err != nil {
+		t.Fatalf("Cannot delete directory: %v", err)
+	}
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if result.Action != "DELETE" || result.Key != "/foo" || !result.Dir || result.Index != 5 {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Action != "DELETE" || resp.Key != "/foo/dir/qux" || resp.PrevValue != "3" {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher under the directory is not notified")
+	}
+
+	for _, key := range []string{"/foo", "/foo/bar", "/foo/dir", "/foo/dir/baz", "/foo/dir/qux"} {
+		if _, err := s.Get(key); err == nil {
+			t.Fatalf("Got deleted key %s", key)
+		}
+	}
+
+	if _, err := s.Get("/other"); err != nil {
+		t.Fatalf("Deleted a key outside of the directory")
+	}
+
+	// a watcher of a key under the directory resuming from before
+	// the delete sees the key deleted
+	watcher = CreateWatcher()
+	s.AddWatcher("/foo/dir/baz", watcher, 5)
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Key != "/foo/dir/baz" || resp.Action != "DELETE" || resp.Index != 5 {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher did not get the delete")
+	}
+
+	_, err = s.RecursiveDelete("/", 6)
+
+	if err == nil {
+		t.Fatalf("Deleted the root directory")
+	}
+}
+
+func TestDirExpire(t *testing.T) {
+	s := CreateStore(100)
+
+	s.Set("/file", "bar", time.Unix(0, 0), 1)
+	_, err := s.SetDir("/file/dir", time.Unix(0, 0), 2)
+
+	if _, ok := err.(NotDir); !ok {
+		t.Fatalf("Expect NotDir, but got %v", err)
+	}
+
+	res, err := s.SetDir("/foo", time.Now().Add(time.Second*1), 3)
+
+	if err != nil {
+		t.Fatalf("Cannot set directory: %v", err)
+	}
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if !result.Dir || !result.NewKey || result.Expiration == nil {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 4)
+	s.Set("/foo/dir/baz", "2", time.Now().Add(time.Second*100), 5)
+
+	watcher := CreateWatcher()
+	s.AddWatcher("/foo/dir", watcher, 0)
+
+	time.Sleep(2 * time.Second)
+
+	for _, key := range []string{"/foo", "/foo/bar", "/foo/dir/baz"} {
+		if _, err := s.RecursiveGet(key); err == nil {
+			t.Fatalf("Got expired key %s", key)
+		}
+	}
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Action != "DELETE" || resp.Key != "/foo/dir/baz" {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher under the directory is not notified")
+	}
+
+	// the ttl of a directory can be removed
+	s.SetDir("/foo", time.Now().Add(time.Second*1), 6)
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 7)
+	res, err = s.SetDir("/foo", time.Unix(0, 0), 8)
+
+	result = Response{}
+	json.Unmarshal(res, &result)
+
+	if result.NewKey || result.Expiration != nil {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if _, err := s.Get("/foo/bar"); err != nil {
+		t.Fatalf("Permanent directory expired")
+	}
+
+	// expiration survives recovery
+	s.SetDir("/foo", time.Now().Add(time.Second*1), 9)
+	state, err := s.Save()
+
+	if err != nil {
+		t.Fatalf("Cannot Save %s", err)
+	}
+
+	newStore := CreateStore(100)
+	newStore.Recovery(state)
+
+	time.Sleep(2 * time.Second)
+
+	if _, err := newStore.Get("/foo/bar"); err == nil {
+		t.Fatalf("Got expired value after recovery")
+	}
+}
+
+func TestWatchDescendant(t *testing.T) {
+	s := CreateStore(100)
+
+	watcher := CreateWatcher()
+	s.AddWatcher("/foo", watcher, 0)
+
+	rootWatcher := CreateWatcher()
+	s.AddWatcher("/", rootWatcher, 0)
+
+	s.Set("/foo/bar/baz", "1", time.Unix(0, 0), 1)
+
+	for _, w := range []*Watcher{watcher, rootWatcher} {
+		select {
+		case resp := <-w.C:
+			if resp.Key != "/foo/bar/baz" || resp.Value != "1" {
+				t.Fatalf("Wrong notification: %v", resp)
+			}
+		default:
+			t.Fatalf("Watcher is not notified")
+		}
+	}
+
+	// from the history
+	watcher = CreateWatcher()
+	s.AddWatcher("/", watcher, 1)
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Key != "/foo/bar/baz" {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher is not notified")
+	}
+}
+
+func TestWatchUnderDeletedDir(t *testing.T) {
+	s := CreateStore(100)
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 1)
+
+	// nothing exists at the watched key, only the directory
+	// delete tells the watcher that it changed
+	watcher := CreateWatcher()
+	s.AddWatcher("/foo/baz", watcher, 0)
+
+	s.RecursiveDelete("/foo", 2)
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Action != "DELETE" || resp.Key != "/foo" || !resp.Dir {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher under the directory is not notified")
+	}
+
+	// from the history
+	watcher = CreateWatcher()
+	s.AddWatcher("/foo/baz", watcher, 2)
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Key != "/foo" || resp.Index != 2 {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher under the directory is not notified")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	s := CreateStore(100)
+
+	_, err := s.CompareAndSet("foo", "", 1, "bar", time.Unix(0, 0), 1)
+
+	if _, ok := err.(NotFoundError); !ok {
+		t.Fatalf("Expect NotFoundError, but got %v", err)
+	}
+
+	s.Set("foo", "bar", time.Unix(0, 0), 2)
+
+	// the index of a key is returned by get
+	res, _ := s.Get("foo")
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if result.ModifiedIndex != 2 {
+		t.Fatalf("Expect modified index 2, but got %v", result.ModifiedIndex)
+	}
+
+	// the same value set again has a different index
+	s.Set("foo", "bar", time.Unix(0, 0), 3)
+
+	_, err = s.CompareAndSet("foo", "", 2, "barbar", time.Unix(0, 0), 4)
+
+	if _, ok := err.(TestIndexFail); !ok {
+		t.Fatalf("Expect TestIndexFail, but got %v", err)
+	}
+
+	_, err = s.CompareAndSet("foo", "baz", 3, "barbar", time.Unix(0, 0), 4)
+
+	if _, ok := err.(TestFail); !ok {
+		t.Fatalf("Expect TestFail, but got %v", err)
+	}
+
+	res, err = s.CompareAndSet("foo", "bar", 3, "barbar", time.Unix(0, 0), 4)
+
+	if err != nil {
+		t.Fatalf("CompareAndSet failed: %v", err)
+	}
+
+	result = Response{}
+	json.Unmarshal(res, &result)
+
+	if result.Value != "barbar" || result.PrevValue != "bar" || result.Index != 4 {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	// without a prevValue or prevIndex the key only has to exist
+	_, err = s.CompareAndSet("foo", "", 0, "barbarbar", time.Unix(0, 0), 5)
+
+	if err != nil {
+		t.Fatalf("CompareAndSet failed: %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s := CreateStore(100)
+
+	res, err := s.Create("foo", "bar", time.Unix(0, 0), 1)
+
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if !result.NewKey || result.Value != "bar" {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	_, err = s.Create("foo", "barbar", time.Unix(0, 0), 2)
+
+	if _, ok := err.(KeyExists); !ok {
+		t.Fatalf("Expect KeyExists, but got %v", err)
+	}
+
+	s.Set("dir/foo", "bar", time.Unix(0, 0), 3)
+
+	_, err = s.Create("dir", "bar", time.Unix(0, 0), 4)
+
+	if _, ok := err.(KeyExists); !ok {
+		t.Fatalf("Expect KeyExists for a directory, but got %v", err)
+	}
+
+	// the key can be created again once it expires
+	s.Create("lock", "a", time.Now().Add(time.Second*1), 5)
+
+	time.Sleep(2 * time.Second)
+
+	_, err = s.Create("lock", "b", time.Unix(0, 0), 6)
+
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	s := CreateStore(100)
+
+	_, err := s.CompareAndDelete("foo", "bar", 0, 1)
+
+	if _, ok := err.(NotFoundError); !ok {
+		t.Fatalf("Expect NotFoundError, but got %v", err)
+	}
+
+	s.Set("foo", "bar", time.Unix(0, 0), 2)
+
+	_, err = s.CompareAndDelete("foo", "baz", 0, 3)
+
+	if _, ok := err.(TestFail); !ok {
+		t.Fatalf("Expect TestFail, but got %v", err)
+	}
+
+	_, err = s.CompareAndDelete("foo", "bar", 1, 3)
+
+	if _, ok := err.(TestIndexFail); !ok {
+		t.Fatalf("Expect TestIndexFail, but got %v", err)
+	}
+
+	res, err := s.CompareAndDelete("foo", "bar", 2, 3)
+
+	if err != nil {
+		t.Fatalf("CompareAndDelete failed: %v", err)
+	}
+
+	var result Response
+	json.Unmarshal(res, &result)
+
+	if result.Action != "DELETE" || result.PrevValue != "bar" || result.Index != 3 {
+		t.Fatalf("Wrong response: %s", res)
+	}
+
+	if _, err := s.Get("foo"); err == nil {
+		t.Fatalf("Got deleted value")
+	}
+
+	s.Set("dir/foo", "bar", time.Unix(0, 0), 4)
+
+	_, err = s.CompareAndDelete("dir", "", 4, 5)
+
+	if _, ok := err.(NotFile); !ok {
+		t.Fatalf("Expect NotFile, but got %v", err)
+	}
+}
+
+func TestIndexSurvivesRecovery(t *testing.T) {
+	s := CreateStore(100)
+	s.Set("foo", "bar", time.Unix(0, 0), 7)
+
+	state, err := s.Save()
+
+	if err != nil {
+		t.Fatalf("Cannot Save %s", err)
+	}
+
+	newStore := CreateStore(100)
+	newStore.Recovery(state)
+
+	_, err = newStore.CompareAndSet("foo", "", 7, "barbar", time.Unix(0, 0), 8)
+
+	if err != nil {
+		t.Fatalf("CompareAndSet after recovery failed: %v", err)
+	}
+}
+
+func TestWatchHistory(t *testing.T) {
+	s := CreateStore(3)
+
+	for i := 1; i <= 5; i++ {
+		s.Set("foo", strconv.Itoa(i), time.Unix(0, 0), uint64(i))
+	}
+
+	// events 1 and 2 have been dropped
+	for _, index := range []uint64{1, 2} {
+		err := s.AddWatcher("foo", CreateWatcher(), index)
+
+		if _, ok := err.(IndexCleared); !ok {
+			t.Fatalf("Expect IndexCleared for index %d, but got %v", index, err)
+		}
+	}
+
+	watcher := CreateWatcher()
+	err := s.AddWatcher("foo", watcher, 3)
+
+	if err != nil {
+		t.Fatalf("Cannot watch from index 3: %v", err)
+	}
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Index != 3 || resp.Value != "3" {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher is not notified")
+	}
+
+	// the whole history in order
+	events, err := s.AddStreamWatcher("/", CreateStreamWatcher(), 3)
+
+	if err != nil {
+		t.Fatalf("Cannot stream from index 3: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expect 3 events, but got %v", events)
+	}
+
+	for i, resp := range events {
+		if resp.Index != uint64(i+3) {
+			t.Fatalf("Events are not in order: %v", events)
+		}
+	}
+
+	// a watcher from the future waits for it
+	watcher = CreateWatcher()
+	s.AddWatcher("foo", watcher, 6)
+	s.Set("foo", "6", time.Unix(0, 0), 6)
+
+	select {
+	case resp := <-watcher.C:
+		if resp.Index != 6 {
+			t.Fatalf("Wrong notification: %v", resp)
+		}
+	default:
+		t.Fatalf("Watcher is not notified")
+	}
+}
+
+func TestStreamWatch(t *testing.T) {
+	s := CreateStore(100)
+	s.Set("/foo/bar", "1", time.Unix(0, 0), 1)
+	s.Set("/other", "2", time.Unix(0, 0), 2)
+
+	watcher := CreateStreamWatcher()
+	events, err := s.AddStreamWatcher("/foo", watcher, 1)
+
+	if err != nil {
+		t.Fatalf("Cannot add stream watcher: %v", err)
+	}
+
+	if len(events) != 1 || events[0].Key != "/foo/bar" {
+		t.Fatalf("Wrong past events: %v", events)
+	}
+
+	s.Set("/foo/bar", "3", time.Unix(0, 0), 3)
+	s.Set("/other", "4", time.Unix(0, 0), 4)
+	s.Set("/foo/baz", "5", time.Unix(0, 0), 5)
+
+	for _, index := range []uint64{3, 5} {
+		select {
+		case resp := <-watcher.C:
+			if resp.Index != index {
+				t.Fatalf("Expect event %d, but got %v", index, resp)
+			}
+		default:
+			t.Fatalf("Stream watcher did not get event %d", index)
+		}
+	}
+
+	s.RemoveWatcher("/foo", watcher)
+	s.Set("/foo/bar", "6", time.Unix(0, 0), 6)
+
+	select {
+	case resp := <-watcher.C:
+		t.Fatalf("Removed watcher got %v", resp)
+	default:
+	}
+
+	// a watcher that falls too far behind is closed
+	watcher = CreateStreamWatcher()
+	s.AddStreamWatcher("/", watcher, 0)
+
+	for i := 0; i <= streamBufferSize; i++ {
+		s.Set("/foo/bar", "7", time.Unix(0, 0), uint64(7+i))
+	}
+
+	for i := 0; i < streamBufferSize; i++ {
+		<-watcher.C
+	}
+
+	if _, ok := <-watcher.C; ok {
+		t.Fatalf("Slow stream watcher is not closed")
+	}
+}
+
+func TestHistorySurvivesRecovery(t *testing.T) {
+	s := CreateStore(100)
+
+	for i := 1; i <= 5; i++ {
+		s.Set("foo", strconv.Itoa(i), time.Unix(0, 0), uint64(i))
+	}
+
+	state, err := s.Save()
+
+	if err != nil {
+		t.Fatalf("Cannot Save %s", err)
+	}
+
+	// recover with a smaller history
+	newStore := CreateStore(2)
+	newStore.Recovery(state)
+
+	if err := newStore.AddWatcher("foo", CreateWatcher(), 3); err == nil {
+		t.Fatalf("Expect IndexCleared after recovery")
+	}
+
+	events, err := newStore.AddStreamWatcher("foo", CreateStreamWatcher(), 4)
+
+	if err != nil || len(events) != 2 || events[0].Index != 4 || events[1].Index != 5 {
+		t.Fatalf("Wrong history after recovery: %v %v", events, err)
+	}
+}
@@ -41,7 +41,7 @@ func (s tnWithKeySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 // CONSTANT VARIABLE
 
 // Represent an empty node
-var emptyNode = Node{".", PERMANENT, nil}
+var emptyNode = Node{".", PERMANENT, 0, nil}
 
 //------------------------------------------------------------------------------
 //
@@ -116,6 +116,10 @@ func (t *tree) set(key string, value Node) bool {
 
 // Get the tree node of the key
 func (t *tree) internalGet(key string) (*treeNode, bool) {
+	if path.Clean("/"+key) == "/" {
+		return t.Root, true
+	}
+
 	nodesName := split(key)
 
 	nodeMap := t.Root.NodeMap
@@ -209,6 +213,59 @@ func (t *tree) delete(key string) bool {
 	return false
 }
 
+// Get the directory tree node of the key, creating it and any missing
+// intermediate directories. If any node on the path is not a directory
+// it will fail
+func (t *tree) setDir(key string) (*treeNode, bool) {
+	nodesName := split(key)
+
+	tn := t.Root
+
+	for _, name := range nodesName {
+		if name == "" {
+			continue
+		}
+
+		child, ok := tn.NodeMap[name]
+
+		if !ok {
+			child = &treeNode{emptyNode, true, make(map[string]*treeNode)}
+			tn.NodeMap[name] = child
+		} else if !child.Dir {
+			return nil, false
+		}
+
+		tn = child
+	}
+
+	return tn, true
+}
+
+// remove the node of the key whether it is a file or a directory, a
+// directory is removed together with everything under it.
+// return true if success
+func (t *tree) remove(key string) bool {
+	nodesName := split(key)
+
+	nodeMap := t.Root.NodeMap
+
+	var i int
+
+	for i = 0; i < len(nodesName)-1; i++ {
+		node, ok := nodeMap[nodesName[i]]
+		if !ok || !node.Dir {
+			return false
+		}
+		nodeMap = node.NodeMap
+	}
+
+	_, ok := nodeMap[nodesName[i]]
+	if ok {
+		delete(nodeMap, nodesName[i])
+	}
+	return ok
+}
+
 // traverse wrapper
 func (t *tree) traverse(f func(string, *Node), sort bool) {
 	if sort {
@@ -99,10 +99,57 @@ func TestStoreGet(t *testing.T) {
 	ts.traverse(f, true)
 }
 
+func TestTreeDir(t *testing.T) {
+
+	ts := &tree{
+		&treeNode{
+			CreateTestNode("/"),
+			true,
+			make(map[string]*treeNode),
+		},
+	}
+
+	// create intermediate directories
+	_, ok := ts.setDir("/hello/world")
+	if !ok {
+		t.Fatalf("cannot create directory")
+	}
+
+	tn, ok := ts.internalGet("/hello")
+	if !ok || !tn.Dir {
+		t.Fatalf("intermediate directory is not created")
+	}
+
+	// cannot create a directory under a file
+	ts.set("/foo", CreateTestNode("bar"))
+	_, ok = ts.setDir("/foo/dir")
+	if ok {
+		t.Fatalf("should not add directory under a file")
+	}
+
+	ts.set("/hello/world/foo", CreateTestNode("bar"))
+
+	// remove a directory and everything under it
+	ok = ts.remove("/hello")
+	if !ok {
+		t.Fatalf("cannot remove directory")
+	}
+
+	_, ok = ts.get("/hello/world/foo")
+	if ok {
+		t.Fatalf("got removed key")
+	}
+
+	tn, ok = ts.internalGet("/")
+	if !ok || tn != ts.Root {
+		t.Fatalf("cannot get root")
+	}
+}
+
 func f(key string, n *Node) {
 	fmt.Println(key, "=", n.Value)
 }
 
 func CreateTestNode(value string) Node {
-	return Node{value, time.Unix(0, 0), nil}
+	return Node{value, time.Unix(0, 0), 0, nil}
 }
@@ -2,8 +2,8 @@ package store
 
 import (
 	"path"
-	"strconv"
 	"strings"
+	"sync"
 )
 
 //------------------------------------------------------------------------------
@@ -13,19 +13,34 @@ import (
 //------------------------------------------------------------------------------
 
 // WatcherHub is where the client register its watcher
+// It also keeps the history of the events, so that a watcher can
+// catch up from a past index without missing any event
 type WatcherHub struct {
+	// protects the watchers and the history
+	mutex sync.Mutex
+
 	watchers map[string][]*Watcher
+
+	history *EventHistory
 }
 
-// Currently watcher only contains a response channel
+// A watcher contains a response channel
+// A normal watcher receives one event and is removed from the hub, while
+// a stream watcher receives all the events until it is removed
 type Watcher struct {
 	C chan Response
+
+	stream bool
 }
 
+// The number of events a stream watcher can fall behind before it is removed
+const streamBufferSize = 128
+
 // Create a new watcherHub
-func createWatcherHub() *WatcherHub {
+func createWatcherHub(history *EventHistory) *WatcherHub {
 	w := new(WatcherHub)
 	w.watchers = make(map[string][]*Watcher)
+	w.history = history
 	return w
 }
 
@@ -34,89 +49,180 @@ func CreateWatcher() *Watcher {
 	return &Watcher{C: make(chan Response, 1)}
 }
 
+// Create a new stream watcher
+// The channel is closed if the watcher falls too far behind, the client
+// can then add a new one since the index of the last event it received
+func CreateStreamWatcher() *Watcher {
+	return &Watcher{
+		C:      make(chan Response, streamBufferSize),
+		stream: true,
+	}
+}
+
 // Add a watcher to the watcherHub
-func (w *WatcherHub) addWatcher(prefix string, watcher *Watcher, sinceIndex uint64,
-	responseStartIndex uint64, currentIndex uint64, resMap *map[string]Response) error {
+// If sinceIndex is given, the past events are searched in the history first.
+// A normal watcher that finds one gets it in its channel and is not added.
+// The past events for a stream watcher are returned, they happened before
+// any event that will be sent to its channel
+func (w *WatcherHub) addWatcher(prefix string, watcher *Watcher, sinceIndex uint64) ([]Response, error) {
 
 	prefix = path.Clean("/" + prefix)
 
-	if sinceIndex != 0 && sinceIndex >= responseStartIndex {
-		for i := sinceIndex; i <= currentIndex; i++ {
-			if checkResponse(prefix, i, resMap) {
-				watcher.C <- (*resMap)[strconv.FormatUint(i, 10)]
-				return nil
-			}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var events []Response
+
+	if sinceIndex != 0 {
+		limit := 0
+		if !watcher.stream {
+			limit = 1
+		}
+
+		var err error
+		events, err = w.history.scan(prefix, sinceIndex, limit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !watcher.stream && len(events) != 0 {
+			watcher.C <- events[0]
+			return events, nil
 		}
 	}
 
-	_, ok := w.watchers[prefix]
+	w.watchers[prefix] = append(w.watchers[prefix], watcher)
 
-	if !ok {
+	return events, nil
+}
 
-		w.watchers[prefix] = make([]*Watcher, 0)
+// Remove a watcher from the watcherHub
+// It is fine to remove a watcher that has already been removed
+func (w *WatcherHub) removeWatcher(prefix string, watcher *Watcher) {
 
-		w.watchers[prefix] = append(w.watchers[prefix], watcher)
-	} else {
+	prefix = path.Clean("/" + prefix)
 
-		w.watchers[prefix] = append(w.watchers[prefix], watcher)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	watchers := w.watchers[prefix]
+
+	for i, other := range watchers {
+		if other == watcher {
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
 	}
 
-	return nil
+	if len(watchers) == 0 {
+		delete(w.watchers, prefix)
+	} else {
+		w.watchers[prefix] = watchers
+	}
 }
 
 // Check if the response has what we are watching
-func checkResponse(prefix string, index uint64, resMap *map[string]Response) bool {
+func checkResponse(prefix string, resp Response) bool {
+	if isUnder(resp.Key, prefix) {
+		return true
+	}
 
-	resp, ok := (*resMap)[strconv.FormatUint(index, 10)]
+	// a directory operation changes everything under the directory
+	if resp.Dir && isUnder(prefix, resp.Key) {
+		return true
+	}
 
-	if !ok {
-		// not storage system command
-		return false
-	} else {
-		path := resp.Key
-		if strings.HasPrefix(path, prefix) {
-			prefixLen := len(prefix)
-			if len(path) == prefixLen || path[prefixLen] == '/' {
-				return true
-			}
+	return false
+}
 
+// Check if the path is the prefix itself or under the prefix
+func isUnder(path string, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+
+	if strings.HasPrefix(path, prefix) {
+		prefixLen := len(prefix)
+		if len(path) == prefixLen || path[prefixLen] == '/' {
+			return true
 		}
 	}
 
 	return false
 }
 
-// Notify the watcher a action happened
+// Record the event in the history and notify the watchers
 func (w *WatcherHub) notify(resp Response) error {
 	resp.Key = path.Clean(resp.Key)
 
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.history.add(resp)
+
 	segments := strings.Split(resp.Key, "/")
 	currPath := "/"
 
 	// walk through all the pathes
 	for _, segment := range segments {
 		currPath = path.Join(currPath, segment)
+		w.notifyWatchers(currPath, resp)
+	}
 
-		watchers, ok := w.watchers[currPath]
+	// a directory operation changes everything under the directory
+	if resp.Dir {
+		for prefix := range w.watchers {
+			if prefix != resp.Key && isUnder(prefix, resp.Key) {
+				w.notifyWatchers(prefix, resp)
+			}
+		}
+	}
 
-		if ok {
+	return nil
+}
 
-			newWatchers := make([]*Watcher, 0)
-			// notify all the watchers
-			for _, watcher := range watchers {
-				watcher.C <- resp
-			}
+// Notify the watchers of the prefix and keep the ones that want more events
+// The caller should hold the mutex
+func (w *WatcherHub) notifyWatchers(prefix string, resp Response) {
+	watchers, ok := w.watchers[prefix]
 
-			if len(newWatchers) == 0 {
-				// we have notified all the watchers at this path
-				// delete the map
-				delete(w.watchers, currPath)
-			} else {
-				w.watchers[currPath] = newWatchers
-			}
+	if !ok {
+		return
+	}
+
+	newWatchers := make([]*Watcher, 0)
+	// notify all the watchers
+	for _, watcher := range watchers {
+		if watcher.notify(resp) {
+			newWatchers = append(newWatchers, watcher)
 		}
+	}
+
+	if len(newWatchers) == 0 {
+		// we have notified all the watchers at this path
+		// delete the map
+		delete(w.watchers, prefix)
+	} else {
+		w.watchers[prefix] = newWatchers
+	}
+}
 
+// Send the event to the watcher
+// Return true if the watcher wants more events
+func (w *Watcher) notify(resp Response) bool {
+	if !w.stream {
+		w.C <- resp
+		return false
 	}
 
-	return nil
+	select {
+	case w.C <- resp:
+		return true
+
+	default:
+		// the client is too slow, we do not block the store for it
+		close(w.C)
+		return false
+	}
 }
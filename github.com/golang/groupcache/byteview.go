@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"time"
 )
 
 // A ByteView holds an immutable view of bytes.
@@ -33,6 +34,20 @@ type ByteView struct {
 	// If b is non-nil, b is used, else s is used.
 	b []byte
 	s string
+
+	// e is the time after which the view is no longer served from
+	// a cache. The zero time means it never expires.
+	e time.Time
+}
+
+// Expire returns the time after which the view is no longer served
+// from a cache, or the zero time if it never expires.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && now.After(v.e)
 }
 
 // Len returns the view's length.
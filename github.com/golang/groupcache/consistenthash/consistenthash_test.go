@@ -0,0 +1,156 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consistenthash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestHashing(t *testing.T) {
+
+	// Override the hash function to return easier to reason about values.
+	// Assumes the keys can be converted to an integer.
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// Given the above hash function, this will give replicas with "hashes":
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	// Adds 8, 18, 28
+	hash.Add("8")
+
+	// 27 should now map to 8.
+	testCases["27"] = "8"
+
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	hash := New(1, nil)
+	if !hash.IsEmpty() {
+		t.Errorf("new map should be empty")
+	}
+	if got := hash.Get("foo"); got != "" {
+		t.Errorf("Get on an empty map = %q; want the empty string", got)
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	hash1 := New(1, nil)
+	hash2 := New(1, nil)
+
+	hash1.Add("Bill", "Bob", "Bonny")
+	hash2.Add("Bob", "Bonny", "Bill")
+
+	if hash1.Get("Ben") != hash2.Get("Ben") {
+		t.Errorf("Fetching 'Ben' from both hashes should be the same")
+	}
+
+	hash2.Add("Becky", "Ben", "Bobby")
+
+	// A key that still belongs to one of the original entries belongs to
+	// the same one.
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if got := hash2.Get(key); got == "Bill" || got == "Bob" || got == "Bonny" {
+			if want := hash1.Get(key); got != want {
+				t.Errorf("Fetching %q yielded %s, was %s", key, got, want)
+			}
+		}
+	}
+}
+
+// Adding a peer to N peers should only move the keys it takes over, about
+// 1/(N+1) of them.
+func TestRemap(t *testing.T) {
+	const numPeers = 10
+	const numKeys = 10000
+
+	var peers []string
+	for i := 0; i < numPeers; i++ {
+		peers = append(peers, fmt.Sprintf("http://10.0.0.%d:8000", i))
+	}
+	before := New(50, nil)
+	before.Add(peers...)
+	after := New(50, nil)
+	after.Add(append(peers, "http://10.0.0.100:8000")...)
+
+	moved := 0
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if from, to := before.Get(key), after.Get(key); from != to {
+			if to != "http://10.0.0.100:8000" {
+				t.Fatalf("key %q moved from %s to %s instead of the new peer", key, from, to)
+			}
+			moved++
+		}
+	}
+
+	// Expect about numKeys/(numPeers+1), allow for an uneven ring.
+	if want := numKeys / (numPeers + 1); moved == 0 || moved > 2*want {
+		t.Errorf("%d of %d keys moved; want about %d", moved, numKeys, want)
+	}
+	t.Logf("%d of %d keys moved", moved, numKeys)
+}
+
+func BenchmarkGet8(b *testing.B)   { benchmarkGet(b, 8) }
+func BenchmarkGet32(b *testing.B)  { benchmarkGet(b, 32) }
+func BenchmarkGet128(b *testing.B) { benchmarkGet(b, 128) }
+func BenchmarkGet512(b *testing.B) { benchmarkGet(b, 512) }
+
+func benchmarkGet(b *testing.B, shards int) {
+
+	hash := New(50, nil)
+
+	var buckets []string
+	for i := 0; i < shards; i++ {
+		buckets = append(buckets, fmt.Sprintf("shard-%d", i))
+	}
+
+	hash.Add(buckets...)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		hash.Get(buckets[i&(shards-1)])
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pb "github.com/golang/groupcache/groupcachepb"
 	"github.com/golang/groupcache/lru"
@@ -40,10 +41,11 @@ import (
 type Getter interface {
 	// Get returns the value identified by key, populating dest.
 	//
-	// The returned data must be unversioned. That is, key must
+	// The returned data should be unversioned. That is, key should
 	// uniquely describe the loaded data, without an implicit
-	// current time, and without relying on cache expiration
-	// mechanisms.
+	// current time. Data that changes must either be given an
+	// expiry with dest.SetExpire or be invalidated with
+	// Group.Remove.
 	Get(ctx Context, key string, dest Sink) error
 }
 
@@ -180,6 +182,8 @@ type Stats struct {
 	LocalLoads     AtomicInt // total good local loads
 	LocalLoadErrs  AtomicInt // total bad local loads
 	ServerRequests AtomicInt // gets that came over the network from peers
+	Removes        AtomicInt // any Remove request, including from peers
+	PeerRemoveErrs AtomicInt // peers that could not be told about a Remove
 }
 
 // Name returns the name of the group.
@@ -275,6 +279,9 @@ func (g *Group) getFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView
 		return ByteView{}, err
 	}
 	value := ByteView{b: res.Value}
+	if res.Expire != nil {
+		value.e = time.Unix(0, res.GetExpire())
+	}
 	// TODO(bradfitz): use res.MinuteQps or something smart to
 	// conditionally populate hotCache.  For now just do it some
 	// percentage of the time.
@@ -284,6 +291,63 @@ func (g *Group) getFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView
 	return value, nil
 }
 
+// Remove removes key from the caches of the group across its peers:
+// from the mainCache of the key's owner and from the hotCache of every
+// peer. The next Get of key loads it again.
+//
+// Remove tells the peers concurrently and returns the first error, if
+// any; the key is still removed from the peers that succeeded. Loads of
+// key that are already in flight are not cancelled, so a concurrent Get
+// may still return, and cache, the old value.
+func (g *Group) Remove(ctx Context, key string) error {
+	g.peersOnce.Do(g.initPeers)
+	g.removeLocally(key)
+
+	var peers []ProtoGetter
+	if pl, ok := g.peers.(PeerLister); ok {
+		peers = pl.Peers()
+	} else if peer, ok := g.peers.PickPeer(key); ok {
+		peers = []ProtoGetter{peer}
+	}
+
+	errc := make(chan error, len(peers))
+	for _, peer := range peers {
+		go func(peer ProtoGetter) {
+			errc <- g.removeFromPeer(ctx, peer, key)
+		}(peer)
+	}
+	var err error
+	for _ = range peers {
+		if perr := <-errc; perr != nil {
+			g.Stats.PeerRemoveErrs.Add(1)
+			if err == nil {
+				err = perr
+			}
+		}
+	}
+	return err
+}
+
+// removeLocally removes key from this process's caches only. It is
+// called by Remove and when a peer asks for key to be removed.
+func (g *Group) removeLocally(key string) {
+	g.Stats.Removes.Add(1)
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+func (g *Group) removeFromPeer(ctx Context, peer ProtoGetter, key string) error {
+	pr, ok := peer.(ProtoRemover)
+	if !ok {
+		return errors.New("groupcache: peer does not support Remove")
+	}
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &key,
+	}
+	return pr.Remove(ctx, req)
+}
+
 func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
 	if g.cacheBytes <= 0 {
 		return
@@ -356,6 +420,7 @@ type cache struct {
 	lru        *lru.Cache
 	nhit, nget int64
 	nevict     int64 // number of evictions
+	nremove    int64 // number of removals, explicit or on expiry
 }
 
 func (c *cache) stats() CacheStats {
@@ -367,6 +432,7 @@ func (c *cache) stats() CacheStats {
 		Gets:      c.nget,
 		Hits:      c.nhit,
 		Evictions: c.nevict,
+		Removals:  c.nremove,
 	}
 }
 
@@ -378,7 +444,6 @@ func (c *cache) add(key string, value ByteView) {
 			OnEvicted: func(key lru.Key, value interface{}) {
 				val := value.(ByteView)
 				c.nbytes -= int64(len(key.(string))) + int64(val.Len())
-				c.nevict++
 			},
 		}
 	}
@@ -397,15 +462,35 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	if !ok {
 		return
 	}
+	value = vi.(ByteView)
+	if value.expired(time.Now()) {
+		c.lru.Remove(key)
+		c.nremove++
+		return ByteView{}, false
+	}
 	c.nhit++
-	return vi.(ByteView), true
+	return value, true
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	n := c.lru.Len()
+	c.lru.Remove(key)
+	if c.lru.Len() < n {
+		c.nremove++
+	}
 }
 
 func (c *cache) removeOldest() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru != nil {
+	if c.lru != nil && c.lru.Len() > 0 {
 		c.lru.RemoveOldest()
+		c.nevict++
 	}
 }
 
@@ -452,4 +537,5 @@ type CacheStats struct {
 	Gets      int64
 	Hits      int64
 	Evictions int64
+	Removals  int64
 }
@@ -225,8 +225,9 @@ func TestCacheEviction(t *testing.T) {
 }
 
 type fakePeer struct {
-	hits int
-	fail bool
+	hits    int
+	removes int
+	fail    bool
 }
 
 func (p *fakePeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
@@ -238,6 +239,14 @@ func (p *fakePeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error
 	return nil
 }
 
+func (p *fakePeer) Remove(_ Context, in *pb.GetRequest) error {
+	p.removes++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	return nil
+}
+
 type fakePeers []ProtoGetter
 
 func (p fakePeers) PickPeer(key string) (peer ProtoGetter, ok bool) {
@@ -248,6 +257,16 @@ func (p fakePeers) PickPeer(key string) (peer ProtoGetter, ok bool) {
 	return p[n], p[n] != nil
 }
 
+func (p fakePeers) Peers() []ProtoGetter {
+	var peers []ProtoGetter
+	for _, peer := range p {
+		if peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
 // tests that peers (virtual, in-process) are hit, and how much.
 func TestPeers(t *testing.T) {
 	once.Do(testSetup)
@@ -320,6 +339,103 @@ func TestPeers(t *testing.T) {
 	run("peer0_failing", 200, "localHits = 100, peers = 51 49 51")
 }
 
+func TestRemove(t *testing.T) {
+	peer0 := &fakePeer{}
+	peer1 := &fakePeer{}
+	peerList := fakePeers([]ProtoGetter{peer0, peer1, nil})
+	localHits := 0
+	getter := func(_ Context, key string, dest Sink) error {
+		localHits++
+		return dest.SetString("got:" + key)
+	}
+	testGroup := newGroup("TestRemove-group", 1<<20, GetterFunc(getter), peerList)
+
+	// Find a key that this process owns.
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key-%d", i)
+		if _, ok := peerList.PickPeer(key); !ok {
+			break
+		}
+	}
+
+	var got string
+	for i := 0; i < 2; i++ {
+		if err := testGroup.Get(dummyCtx, key, StringSink(&got)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if localHits != 1 {
+		t.Fatalf("localHits = %d before Remove; want 1", localHits)
+	}
+
+	if err := testGroup.Remove(dummyCtx, key); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if peer0.removes != 1 || peer1.removes != 1 {
+		t.Errorf("peer removes = %d %d; want 1 1", peer0.removes, peer1.removes)
+	}
+	if got := testGroup.CacheStats(MainCache).Removals; got != 1 {
+		t.Errorf("main cache removals = %d; want 1", got)
+	}
+	if err := testGroup.Get(dummyCtx, key, StringSink(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if localHits != 2 {
+		t.Errorf("localHits = %d after Remove; want 2", localHits)
+	}
+
+	// A failing peer is reported, but the others still remove the key.
+	peer1.fail = true
+	if err := testGroup.Remove(dummyCtx, key); err == nil {
+		t.Error("Remove with a failing peer succeeded")
+	}
+	if peer0.removes != 2 {
+		t.Errorf("peer0 removes = %d; want 2", peer0.removes)
+	}
+	if got := testGroup.Stats.Removes.Get(); got != 2 {
+		t.Errorf("Stats.Removes = %d; want 2", got)
+	}
+	if got := testGroup.Stats.PeerRemoveErrs.Get(); got != 1 {
+		t.Errorf("Stats.PeerRemoveErrs = %d; want 1", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	expire := map[string]time.Time{
+		"fresh":   time.Now().Add(time.Hour),
+		"expired": time.Now().Add(-time.Second),
+	}
+	fills := map[string]int{}
+	getter := func(_ Context, key string, dest Sink) error {
+		fills[key]++
+		dest.SetExpire(expire[key])
+		return dest.SetString("got:" + key)
+	}
+	testGroup := newGroup("TestExpire-group", 1<<20, GetterFunc(getter), NoPeers{})
+
+	for _, key := range []string{"fresh", "expired"} {
+		for i := 0; i < 3; i++ {
+			var v ByteView
+			if err := testGroup.Get(dummyCtx, key, ByteViewSink(&v)); err != nil {
+				t.Fatal(err)
+			}
+			if !v.Expire().Equal(expire[key]) {
+				t.Errorf("%s: Expire() = %v; want %v", key, v.Expire(), expire[key])
+			}
+		}
+	}
+	if fills["fresh"] != 1 {
+		t.Errorf("fresh key filled %d times; want 1", fills["fresh"])
+	}
+	if fills["expired"] != 3 {
+		t.Errorf("expired key filled %d times; want 3", fills["expired"])
+	}
+	if got := testGroup.CacheStats(MainCache).Removals; got != 2 {
+		t.Errorf("main cache removals = %d; want 2", got)
+	}
+}
+
 func TestTruncatingByteSliceTarget(t *testing.T) {
 	var buf [100]byte
 	s := buf[:]
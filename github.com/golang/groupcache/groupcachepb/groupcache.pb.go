@@ -40,6 +40,7 @@ func (m *GetRequest) GetKey() string {
 type GetResponse struct {
 	Value            []byte   `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
 	MinuteQps        *float64 `protobuf:"fixed64,2,opt,name=minute_qps" json:"minute_qps,omitempty"`
+	Expire           *int64   `protobuf:"varint,3,opt,name=expire" json:"expire,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -61,5 +62,12 @@ func (m *GetResponse) GetMinuteQps() float64 {
 	return 0
 }
 
+func (m *GetResponse) GetExpire() int64 {
+	if m != nil && m.Expire != nil {
+		return *m.Expire
+	}
+	return 0
+}
+
 func init() {
 }
@@ -18,7 +18,6 @@ package groupcache
 
 import (
 	"fmt"
-	"hash/crc32"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -27,13 +26,19 @@ import (
 
 	"code.google.com/p/goprotobuf/proto"
 
+	"github.com/golang/groupcache/consistenthash"
 	pb "github.com/golang/groupcache/groupcachepb"
 )
 
 // TODO: make this configurable?
 const defaultBasePath = "/_groupcache/"
 
-// HTTPPool implements PeerPicker for a pool of HTTP peers.
+const defaultReplicas = 50
+
+// HTTPPool implements PeerPicker and PeerLister for a pool of HTTP peers.
+//
+// A peer serves Gets at basePath + group + "/" + key and removes the
+// key from its caches when the same path is requested with DELETE.
 type HTTPPool struct {
 	// Context optionally specifies a context for the server to use when it
 	// receives a request.
@@ -51,8 +56,23 @@ type HTTPPool struct {
 	// this peer's base URL, e.g. "https://example.net:8000"
 	self string
 
-	mu    sync.Mutex
-	peers []string
+	// opts specifies the options.
+	opts HTTPPoolOptions
+
+	mu          sync.Mutex
+	peers       *consistenthash.Map
+	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+}
+
+// HTTPPoolOptions are the configurations of a HTTPPool.
+type HTTPPoolOptions struct {
+	// Replicas specifies the number of key replicas on the consistent hash.
+	// If blank, it defaults to 50.
+	Replicas int
+
+	// HashFn specifies the hash function of the consistent hash.
+	// If blank, it defaults to crc32.ChecksumIEEE.
+	HashFn consistenthash.Hash
 }
 
 var httpPoolMade bool
@@ -63,11 +83,24 @@ var httpPoolMade bool
 // The self argument be a valid base URL that points to the current server,
 // for example "http://example.net:8000".
 func NewHTTPPool(self string) *HTTPPool {
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts is like NewHTTPPool but lets the caller configure how
+// keys are spread over the peers. A nil o uses the defaults.
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	if httpPoolMade {
 		panic("groupcache: NewHTTPPool must be called only once")
 	}
 	httpPoolMade = true
 	p := &HTTPPool{basePath: defaultBasePath, self: self}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
 	RegisterPeerPicker(func() PeerPicker { return p })
 	http.Handle(defaultBasePath, p)
 	return p
@@ -76,28 +109,44 @@ func NewHTTPPool(self string) *HTTPPool {
 // Set updates the pool's list of peers.
 // Each peer value should be a valid base URL,
 // for example "http://example.net:8000".
+// The peers are placed on a consistent hash so that changing them only
+// moves the keys of the peers that were added or removed.
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = append([]string{}, peers...)
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	p.peers.Add(peers...)
+	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	for _, peer := range peers {
+		p.httpGetters[peer] = &httpGetter{transport: p.Transport, baseURL: peer + p.basePath}
+	}
 }
 
 func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
-	// TODO: make checksum implementation pluggable
-	h := crc32.Checksum([]byte(key), crc32.IEEETable)
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if len(p.peers) == 0 {
+	if p.peers.IsEmpty() {
 		return nil, false
 	}
-	if peer := p.peers[int(h)%len(p.peers)]; peer != p.self {
-		// TODO: pre-build a slice of *httpGetter when Set()
-		// is called to avoid these two allocations.
-		return &httpGetter{p.Transport, peer + p.basePath}, true
+	if peer := p.peers.Get(key); peer != p.self {
+		return p.httpGetters[peer], true
 	}
 	return nil, false
 }
 
+// Peers returns all of the peers in the pool except self.
+func (p *HTTPPool) Peers() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peers []ProtoGetter
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse request.
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
@@ -119,25 +168,36 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch the value for this group/key.
 	group := GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
+
+	// Remove the key from this peer's caches.
+	if r.Method == "DELETE" {
+		group.removeLocally(key)
+		return
+	}
+
+	// Fetch the value for this group/key.
 	var ctx Context
 	if p.Context != nil {
 		ctx = p.Context(r)
 	}
-	var value []byte
-	err = group.Get(ctx, key, AllocatingByteSliceSink(&value))
+	var value ByteView
+	err = group.Get(ctx, key, ByteViewSink(&value))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.GetResponse{Value: value})
+	res := &pb.GetResponse{Value: value.ByteSlice()}
+	if e := value.Expire(); !e.IsZero() {
+		res.Expire = proto.Int64(e.UnixNano())
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -151,22 +211,38 @@ type httpGetter struct {
 	baseURL   string
 }
 
-func (h *httpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error {
+func (h *httpGetter) roundTrip(context Context, method string, in *pb.GetRequest) (*http.Response, error) {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequest(method, u, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	tr := http.DefaultTransport
 	if h.transport != nil {
 		tr = h.transport(context)
 	}
-	res, err := tr.RoundTrip(req)
+	return tr.RoundTrip(req)
+}
+
+func (h *httpGetter) Remove(context Context, in *pb.GetRequest) error {
+	res, err := h.roundTrip(context, "DELETE", in)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+func (h *httpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	res, err := h.roundTrip(context, "GET", in)
 	if err != nil {
 		return err
 	}
@@ -100,6 +100,15 @@ func TestHTTPPool(t *testing.T) {
 		}
 		t.Logf("Get key=%q, value=%q (peer:key)", key, value)
 	}
+
+	for _, key := range testKeys(10) {
+		if err := g.Remove(nil, key); err != nil {
+			t.Errorf("Remove(%q): %v", key, err)
+		}
+	}
+	if got := g.Stats.Removes.Get(); got != 10 {
+		t.Errorf("Stats.Removes = %d; want 10", got)
+	}
 }
 
 func testKeys(n int) (keys []string) {
@@ -32,6 +32,13 @@ type ProtoGetter interface {
 	Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error
 }
 
+// ProtoRemover is implemented by peers that can be told to drop a key
+// from their caches. The request names the group and key to remove.
+// Peers must implement it to take part in Group.Remove.
+type ProtoRemover interface {
+	Remove(context Context, in *pb.GetRequest) error
+}
+
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
 type PeerPicker interface {
@@ -41,6 +48,17 @@ type PeerPicker interface {
 	PickPeer(key string) (peer ProtoGetter, ok bool)
 }
 
+// PeerLister is implemented by a PeerPicker that can list all of its
+// remote peers, so that Group.Remove can invalidate the copies of a key
+// they keep in their hot caches. If the PeerPicker is not a PeerLister,
+// only the owner of the key is told about a Remove.
+type PeerLister interface {
+	PeerPicker
+
+	// Peers returns every peer except the current one.
+	Peers() []ProtoGetter
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
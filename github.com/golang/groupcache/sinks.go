@@ -18,6 +18,7 @@ package groupcache
 
 import (
 	"errors"
+	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 )
@@ -25,7 +26,7 @@ import (
 // A Sink receives data from a Get call.
 //
 // Implementation of Getter must call exactly one of the Set methods
-// on success.
+// that set the value on success. Calling SetExpire is optional.
 type Sink interface {
 	// SetString sets the value to s.
 	SetString(s string) error
@@ -38,6 +39,12 @@ type Sink interface {
 	// The caller retains ownership of m.
 	SetProto(m proto.Message) error
 
+	// SetExpire sets the time after which the value is no longer
+	// served from the group's caches, on this peer or any other.
+	// It may be called before or after the value is set. The zero
+	// time, the default, means the value never expires.
+	SetExpire(t time.Time)
+
 	// view returns a frozen view of the bytes for caching.
 	view() (ByteView, error)
 }
@@ -88,6 +95,10 @@ func (s *stringSink) SetString(v string) error {
 	return nil
 }
 
+func (s *stringSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 func (s *stringSink) SetBytes(v []byte) error {
 	return s.SetString(string(v))
 }
@@ -112,6 +123,7 @@ func ByteViewSink(dst *ByteView) Sink {
 
 type byteViewSink struct {
 	dst *ByteView
+	e   time.Time
 
 	// if this code ever ends up tracking that at least one set*
 	// method was called, don't make it an error to call set
@@ -137,20 +149,25 @@ func (s *byteViewSink) SetProto(m proto.Message) error {
 	if err != nil {
 		return err
 	}
-	*s.dst = ByteView{b: b}
+	*s.dst = ByteView{b: b, e: s.e}
 	return nil
 }
 
 func (s *byteViewSink) SetBytes(b []byte) error {
-	*s.dst = ByteView{b: cloneBytes(b)}
+	*s.dst = ByteView{b: cloneBytes(b), e: s.e}
 	return nil
 }
 
 func (s *byteViewSink) SetString(v string) error {
-	*s.dst = ByteView{s: v}
+	*s.dst = ByteView{s: v, e: s.e}
 	return nil
 }
 
+func (s *byteViewSink) SetExpire(t time.Time) {
+	s.e = t
+	s.dst.e = t
+}
+
 // ProtoSink returns a sink that unmarshals binary proto values into m.
 func ProtoSink(m proto.Message) Sink {
 	return &protoSink{
@@ -169,6 +186,10 @@ func (s *protoSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *protoSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 func (s *protoSink) SetBytes(b []byte) error {
 	err := proto.Unmarshal(b, s.dst)
 	if err != nil {
@@ -234,6 +255,10 @@ func (s *allocBytesSink) setView(v ByteView) error {
 	return nil
 }
 
+func (s *allocBytesSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 func (s *allocBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -283,6 +308,10 @@ func (s *truncBytesSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *truncBytesSink) SetExpire(t time.Time) {
+	s.v.e = t
+}
+
 func (s *truncBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -0,0 +1,560 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+// Peers in a TCPPool exchange frames over persistent connections. Each
+// frame is a 4 byte length of the rest of the frame, an 8 byte request
+// ID, a 1 byte op and a payload, all big-endian. Requests carry an
+// encoded GetRequest; responses echo the ID of their request and carry
+// an encoded GetResponse or an error message. Many requests may be
+// outstanding on a connection at once and are answered in any order.
+const (
+	tcpOpGet byte = iota + 1
+	tcpOpRemove
+	tcpOpOK
+	tcpOpError
+)
+
+const (
+	tcpHeaderSize = 13
+
+	// Requests only carry a group name and a key, responses carry a
+	// cached value. Frames announcing a larger size are rejected before
+	// anything is allocated for them.
+	maxTCPRequestSize  = 1 << 16
+	maxTCPResponseSize = 64 << 20
+
+	// The number of requests from a single connection that are handled
+	// at once. Further requests wait on the connection.
+	maxTCPConnRequests = 64
+
+	defaultConnsPerPeer = 2
+	defaultDialTimeout  = 5 * time.Second
+)
+
+var errTCPPoolClosed = errors.New("groupcache: peer was removed from the pool")
+
+// A deadliner is a Context that has a deadline, such as a
+// golang.org/x/net/context.Context.
+type deadliner interface {
+	Deadline() (deadline time.Time, ok bool)
+}
+
+// TCPPool implements PeerPicker and PeerLister for a pool of peers that
+// talk to each other over persistent TCP connections instead of HTTP.
+// Requests from concurrent Gets share the connections.
+//
+// If the Context passed to Get has a method
+//
+//	Deadline() (deadline time.Time, ok bool)
+//
+// the request to the peer fails once the deadline passes.
+type TCPPool struct {
+	// Context optionally specifies a context for the server to use when it
+	// receives a request.
+	// If nil, the server uses a nil Context.
+	Context func(net.Conn) Context
+
+	// Dial optionally specifies how the client connects to a peer.
+	// If nil, the client dials TCP with a timeout of 5 seconds.
+	Dial func(addr string) (net.Conn, error)
+
+	// this peer's address, e.g. "10.0.0.1:8008"
+	self string
+
+	// opts specifies the options.
+	opts TCPPoolOptions
+
+	mu         sync.Mutex
+	peers      *consistenthash.Map
+	tcpGetters map[string]*tcpGetter // keyed by e.g. "10.0.0.2:8008"
+}
+
+// TCPPoolOptions are the configurations of a TCPPool.
+type TCPPoolOptions struct {
+	// Replicas specifies the number of key replicas on the consistent hash.
+	// If blank, it defaults to 50.
+	Replicas int
+
+	// HashFn specifies the hash function of the consistent hash.
+	// If blank, it defaults to crc32.ChecksumIEEE.
+	HashFn consistenthash.Hash
+
+	// ConnsPerPeer specifies the number of connections kept open to
+	// each peer. If blank, it defaults to 2.
+	ConnsPerPeer int
+
+	// Timeout specifies how long a request to a peer may take when
+	// its Context has no deadline. If blank, there is no limit.
+	Timeout time.Duration
+}
+
+// NewTCPPool initializes a TCP pool of peers and registers it as the
+// PeerPicker, in place of an HTTPPool. The caller must serve the other
+// peers' requests with Serve or ListenAndServe.
+// The self argument should be the address that the other peers reach the
+// current server at, for example "10.0.0.1:8008".
+func NewTCPPool(self string) *TCPPool {
+	return NewTCPPoolOpts(self, nil)
+}
+
+// NewTCPPoolOpts is like NewTCPPool but lets the caller configure the
+// pool. A nil o uses the defaults.
+func NewTCPPoolOpts(self string, o *TCPPoolOptions) *TCPPool {
+	p := newTCPPool(self, o)
+	RegisterPeerPicker(func() PeerPicker { return p })
+	return p
+}
+
+func newTCPPool(self string, o *TCPPoolOptions) *TCPPool {
+	p := &TCPPool{self: self}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.ConnsPerPeer == 0 {
+		p.opts.ConnsPerPeer = defaultConnsPerPeer
+	}
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	return p
+}
+
+// Set updates the pool's list of peers.
+// Each peer value should be a TCP address, for example "10.0.0.2:8008".
+// Connections to peers that remain in the pool are kept.
+func (p *TCPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	p.peers.Add(peers...)
+	getters := make(map[string]*tcpGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := p.tcpGetters[peer]; ok {
+			getters[peer] = g
+			continue
+		}
+		getters[peer] = &tcpGetter{
+			addr:    peer,
+			dial:    p.dial,
+			timeout: p.opts.Timeout,
+			conns:   make([]*tcpConn, p.opts.ConnsPerPeer),
+		}
+	}
+	for peer, g := range p.tcpGetters {
+		if _, ok := getters[peer]; !ok {
+			g.close()
+		}
+	}
+	p.tcpGetters = getters
+}
+
+func (p *TCPPool) dial(addr string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial(addr)
+	}
+	return net.DialTimeout("tcp", addr, defaultDialTimeout)
+}
+
+func (p *TCPPool) PickPeer(key string) (ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != p.self {
+		return p.tcpGetters[peer], true
+	}
+	return nil, false
+}
+
+// Peers returns all of the peers in the pool except self.
+func (p *TCPPool) Peers() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peers []ProtoGetter
+	for peer, getter := range p.tcpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// ListenAndServe listens on the TCP address addr and then calls Serve.
+func (p *TCPPool) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts connections from peers on l and answers their requests.
+// It returns when l fails to accept a connection.
+func (p *TCPPool) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go p.serveConn(nc)
+	}
+}
+
+func (p *TCPPool) serveConn(nc net.Conn) {
+	defer nc.Close()
+	var ctx Context
+	if p.Context != nil {
+		ctx = p.Context(nc)
+	}
+	var wmu sync.Mutex
+	sem := make(chan bool, maxTCPConnRequests)
+	br := bufio.NewReader(nc)
+	for {
+		req, err := readTCPFrame(br, maxTCPRequestSize)
+		if err != nil {
+			return
+		}
+		sem <- true
+		go func() {
+			defer func() { <-sem }()
+			res := p.handle(ctx, req)
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err := writeTCPFrame(nc, res); err != nil {
+				nc.Close()
+			}
+		}()
+	}
+}
+
+// handle answers a single request from a peer.
+func (p *TCPPool) handle(ctx Context, req tcpFrame) tcpFrame {
+	res := tcpFrame{id: req.id, op: tcpOpOK}
+	fail := func(err error) tcpFrame {
+		res.op = tcpOpError
+		res.payload = []byte(err.Error())
+		return res
+	}
+
+	in := &pb.GetRequest{}
+	if err := proto.Unmarshal(req.payload, in); err != nil {
+		return fail(fmt.Errorf("decoding request: %v", err))
+	}
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return fail(fmt.Errorf("no such group: %s", in.GetGroup()))
+	}
+
+	switch req.op {
+	case tcpOpGet:
+		var value ByteView
+		if err := group.Get(ctx, in.GetKey(), ByteViewSink(&value)); err != nil {
+			return fail(err)
+		}
+		out := &pb.GetResponse{Value: value.ByteSlice()}
+		if e := value.Expire(); !e.IsZero() {
+			out.Expire = proto.Int64(e.UnixNano())
+		}
+		b, err := proto.Marshal(out)
+		if err != nil {
+			return fail(err)
+		}
+		if len(b)+tcpHeaderSize-4 > maxTCPResponseSize {
+			return fail(fmt.Errorf("value of %d bytes is too large", len(b)))
+		}
+		res.payload = b
+	case tcpOpRemove:
+		group.removeLocally(in.GetKey())
+	default:
+		return fail(fmt.Errorf("unknown op: %d", req.op))
+	}
+	return res
+}
+
+// tcpGetter is the ProtoGetter for a single peer. It keeps a fixed
+// number of connections to the peer and spreads requests over them,
+// redialing a connection once it fails.
+type tcpGetter struct {
+	addr    string
+	dial    func(addr string) (net.Conn, error)
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conns  []*tcpConn
+	next   int
+	closed bool
+}
+
+func (g *tcpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	b, err := g.roundTrip(context, tcpOpGet, in)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decoding response: %v", err)
+	}
+	return nil
+}
+
+func (g *tcpGetter) Remove(context Context, in *pb.GetRequest) error {
+	_, err := g.roundTrip(context, tcpOpRemove, in)
+	return err
+}
+
+func (g *tcpGetter) roundTrip(context Context, op byte, in *pb.GetRequest) ([]byte, error) {
+	var deadline time.Time
+	if d, ok := context.(deadliner); ok {
+		deadline, _ = d.Deadline()
+	}
+	if deadline.IsZero() && g.timeout > 0 {
+		deadline = time.Now().Add(g.timeout)
+	}
+	b, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	c, err := g.conn()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.roundTrip(deadline, tcpFrame{op: op, payload: b})
+	if err != nil {
+		return nil, fmt.Errorf("groupcache: request to %s: %v", g.addr, err)
+	}
+	if res.op == tcpOpError {
+		return nil, fmt.Errorf("server returned: %s", res.payload)
+	}
+	return res.payload, nil
+}
+
+// conn returns the next connection to use, dialing it if needed. The
+// dial happens without holding g.mu so that requests using the other
+// connections don't wait for it.
+func (g *tcpGetter) conn() (*tcpConn, error) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, errTCPPoolClosed
+	}
+	i := g.next
+	g.next = (g.next + 1) % len(g.conns)
+	if c := g.conns[i]; c != nil && c.broken() == nil {
+		g.mu.Unlock()
+		return c, nil
+	}
+	g.mu.Unlock()
+
+	nc, err := g.dial(g.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		nc.Close()
+		return nil, errTCPPoolClosed
+	}
+	// Another request may have redialed the same slot meanwhile.
+	if c := g.conns[i]; c != nil && c.broken() == nil {
+		nc.Close()
+		return c, nil
+	}
+	c := newTCPConn(nc)
+	g.conns[i] = c
+	return c, nil
+}
+
+func (g *tcpGetter) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	for _, c := range g.conns {
+		if c != nil {
+			c.fail(errTCPPoolClosed)
+		}
+	}
+}
+
+// tcpConn is a client connection to a peer. Requests are written by
+// their callers and a single goroutine reads the responses and hands
+// each one to the caller waiting on its ID.
+type tcpConn struct {
+	nc  net.Conn
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan tcpFrame
+	err     error // set once the connection has failed
+}
+
+func newTCPConn(nc net.Conn) *tcpConn {
+	c := &tcpConn{
+		nc:      nc,
+		pending: make(map[uint64]chan tcpFrame),
+	}
+	go c.readLoop()
+	return c
+}
+
+// roundTrip sends req with a fresh ID and waits for its response until
+// deadline, if it is not zero.
+func (c *tcpConn) roundTrip(deadline time.Time, req tcpFrame) (tcpFrame, error) {
+	ch := make(chan tcpFrame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return tcpFrame{}, c.err
+	}
+	c.nextID++
+	req.id = c.nextID
+	c.pending[req.id] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	c.nc.SetWriteDeadline(deadline)
+	err := writeTCPFrame(c.nc, req)
+	c.wmu.Unlock()
+	if err != nil {
+		// A partly written frame leaves the stream unusable.
+		c.fail(err)
+		return tcpFrame{}, err
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(deadline.Sub(time.Now()))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return tcpFrame{}, c.broken()
+		}
+		return res, nil
+	case <-timeout:
+		c.mu.Lock()
+		delete(c.pending, req.id)
+		c.mu.Unlock()
+		return tcpFrame{}, errors.New("deadline exceeded")
+	}
+}
+
+func (c *tcpConn) readLoop() {
+	br := bufio.NewReader(c.nc)
+	for {
+		res, err := readTCPFrame(br, maxTCPResponseSize)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[res.id]
+		delete(c.pending, res.id)
+		c.mu.Unlock()
+		// The caller may have given up on the request already.
+		if ok {
+			ch <- res
+		}
+	}
+}
+
+// broken returns the error that the connection failed with, if any.
+func (c *tcpConn) broken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fail closes the connection and fails all of its outstanding requests.
+func (c *tcpConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.nc.Close()
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		close(ch)
+	}
+}
+
+type tcpFrame struct {
+	id      uint64
+	op      byte
+	payload []byte
+}
+
+func writeTCPFrame(w io.Writer, f tcpFrame) error {
+	b := make([]byte, tcpHeaderSize+len(f.payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-4))
+	binary.BigEndian.PutUint64(b[4:12], f.id)
+	b[12] = f.op
+	copy(b[tcpHeaderSize:], f.payload)
+	_, err := w.Write(b)
+	return err
+}
+
+// readTCPFrame reads a frame whose size, not counting the 4 byte length,
+// is at most max.
+func readTCPFrame(r io.Reader, max uint32) (tcpFrame, error) {
+	var h [tcpHeaderSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return tcpFrame{}, err
+	}
+	n := binary.BigEndian.Uint32(h[0:4])
+	if n < tcpHeaderSize-4 || n > max {
+		return tcpFrame{}, fmt.Errorf("invalid frame size %d", n)
+	}
+	f := tcpFrame{
+		id:      binary.BigEndian.Uint64(h[4:12]),
+		op:      h[12],
+		payload: make([]byte, n-(tcpHeaderSize-4)),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return tcpFrame{}, err
+	}
+	return f, nil
+}
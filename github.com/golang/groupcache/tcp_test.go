@@ -0,0 +1,182 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+type deadlineCtx time.Time
+
+func (c deadlineCtx) Deadline() (time.Time, bool) { return time.Time(c), true }
+
+// startTCPPool serves a TCPPool on a free port and returns a client
+// pool whose only peer is that server.
+func startTCPPool(t *testing.T, o *TCPPoolOptions) (client *TCPPool, l net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTCPPool(l.Addr().String(), nil)
+	go server.Serve(l)
+
+	client = newTCPPool("should-be-ignored", o)
+	client.Set(l.Addr().String())
+	return client, l
+}
+
+func tcpGet(client *TCPPool, ctx Context, group, key string) (string, error) {
+	peer, ok := client.PickPeer(key)
+	if !ok {
+		return "", fmt.Errorf("no peer for %q", key)
+	}
+	res := &pb.GetResponse{}
+	err := peer.Get(ctx, &pb.GetRequest{Group: &group, Key: &key}, res)
+	return string(res.GetValue()), err
+}
+
+func TestTCPPool(t *testing.T) {
+	const nGets = 100
+	getter := GetterFunc(func(ctx Context, key string, dest Sink) error {
+		return dest.SetString("tcp:" + key)
+	})
+	g := newGroup("tcpPoolTest", 1<<20, getter, NoPeers{})
+
+	client, l := startTCPPool(t, &TCPPoolOptions{ConnsPerPeer: 2})
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for _, key := range testKeys(nGets) {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := tcpGet(client, nil, "tcpPoolTest", key)
+			if err != nil {
+				t.Errorf("Get(%q): %v", key, err)
+				return
+			}
+			if want := "tcp:" + key; value != want {
+				t.Errorf("Get(%q) = %q, want %q", key, value, want)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	peer := client.Peers()[0].(*tcpGetter)
+	var conns int
+	for _, c := range peer.conns {
+		if c != nil {
+			conns++
+		}
+	}
+	if conns != 2 {
+		t.Errorf("%d connections were dialed; want 2", conns)
+	}
+
+	group, key := "tcpPoolTest", "0"
+	if err := peer.Remove(nil, &pb.GetRequest{Group: &group, Key: &key}); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+	if got := g.Stats.Removes.Get(); got != 1 {
+		t.Errorf("Stats.Removes = %d; want 1", got)
+	}
+
+	_, err := tcpGet(client, nil, "no-such-group", "0")
+	if err == nil || !strings.Contains(err.Error(), "no such group") {
+		t.Errorf("Get from unknown group: err = %v", err)
+	}
+}
+
+func TestTCPPoolDeadline(t *testing.T) {
+	release := make(chan bool)
+	getter := GetterFunc(func(ctx Context, key string, dest Sink) error {
+		if key == "slow" {
+			<-release
+		}
+		return dest.SetString("tcp:" + key)
+	})
+	newGroup("tcpPoolDeadlineTest", 0, getter, NoPeers{})
+
+	client, l := startTCPPool(t, &TCPPoolOptions{ConnsPerPeer: 1})
+	defer l.Close()
+
+	ctx := deadlineCtx(time.Now().Add(50 * time.Millisecond))
+	if _, err := tcpGet(client, ctx, "tcpPoolDeadlineTest", "slow"); err == nil {
+		t.Fatal("Get past its deadline succeeded")
+	}
+	close(release)
+
+	// The late response is dropped and the connection keeps working.
+	value, err := tcpGet(client, nil, "tcpPoolDeadlineTest", "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "tcp:fast" {
+		t.Errorf("Get(fast) = %q, want %q", value, "tcp:fast")
+	}
+}
+
+func TestTCPPoolRedial(t *testing.T) {
+	getter := GetterFunc(func(ctx Context, key string, dest Sink) error {
+		return dest.SetString("tcp:" + key)
+	})
+	newGroup("tcpPoolRedialTest", 0, getter, NoPeers{})
+
+	client, l := startTCPPool(t, &TCPPoolOptions{ConnsPerPeer: 1})
+	defer l.Close()
+
+	if _, err := tcpGet(client, nil, "tcpPoolRedialTest", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the connection under the client; the next request after the
+	// failure is noticed dials a new one.
+	peer := client.Peers()[0].(*tcpGetter)
+	peer.conns[0].nc.Close()
+	for peer.conns[0].broken() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	value, err := tcpGet(client, nil, "tcpPoolRedialTest", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "tcp:b" {
+		t.Errorf("Get(b) = %q, want %q", value, "tcp:b")
+	}
+}
+
+func TestReadTCPFrameTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTCPFrame(&buf, tcpFrame{id: 1, op: tcpOpGet, payload: make([]byte, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if _, err := readTCPFrame(bytes.NewReader(b), 100); err == nil {
+		t.Error("frame larger than the maximum was read")
+	}
+	if f, err := readTCPFrame(bytes.NewReader(b), 200); err != nil || len(f.payload) != 100 {
+		t.Errorf("readTCPFrame = %d bytes, %v; want 100 bytes", len(f.payload), err)
+	}
+}
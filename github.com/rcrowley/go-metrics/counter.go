@@ -27,6 +27,15 @@ func NewCounter() *StandardCounter {
 	return &StandardCounter{0}
 }
 
+// Get an existing or register and return a new counter by the given name.  A
+// nil registry means the default registry.
+func GetOrRegisterCounter(name string, r Registry) Counter {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewCounter).(Counter)
+}
+
 // Clear the counter: set it to zero.
 func (c *StandardCounter) Clear() {
 	atomic.StoreInt64(&c.count, 0)
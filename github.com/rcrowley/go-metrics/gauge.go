@@ -25,6 +25,15 @@ func NewGauge() *StandardGauge {
 	return &StandardGauge{0}
 }
 
+// Get an existing or register and return a new gauge by the given name.  A
+// nil registry means the default registry.
+func GetOrRegisterGauge(name string, r Registry) Gauge {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewGauge).(Gauge)
+}
+
 // Update the gauge's value.
 func (g *StandardGauge) Update(v int64) {
 	atomic.StoreInt64(&g.value, v)
@@ -3,61 +3,75 @@ package metrics
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"net"
 	"time"
 )
 
+// Output each metric in the given registry periodically to Graphite.  The
+// tags of a tagged metric follow the path of each of its series as
+// ";key=value" pairs, the way Graphite names tagged series.
 func Graphite(r Registry, d time.Duration, prefix string, addr *net.TCPAddr) {
 	for {
-		now := time.Now().Unix()
-		conn, err := net.DialTCP("tcp", nil, addr)
-		if nil != err {
-			continue
+		if err := graphite(r, prefix, addr); nil != err {
+			log.Println(err)
 		}
-		w := bufio.NewWriter(conn)
-		r.Each(func(name string, i interface{}) {
-			switch m := i.(type) {
-			case Counter:
-				fmt.Fprintf(w, "%s.%s.count %d %d\n", prefix, name, m.Count(), now)
-			case Gauge:
-				fmt.Fprintf(w, "%s.%s.value %d %d\n", prefix, name, m.Value(), now)
-			case Histogram:
-				ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-				fmt.Fprintf(w, "%s.%s.count %d %d\n", prefix, name, m.Count(), now)
-				fmt.Fprintf(w, "%s.%s.min %d %d\n", prefix, name, m.Min(), now)
-				fmt.Fprintf(w, "%s.%s.max %d %d\n", prefix, name, m.Max(), now)
-				fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", prefix, name, m.Mean(), now)
-				fmt.Fprintf(w, "%s.%s.std-dev %.2f %d\n", prefix, name, m.StdDev(), now)
-				fmt.Fprintf(w, "%s.%s.50-percentile %.2f %d\n", prefix, name, ps[0], now)
-				fmt.Fprintf(w, "%s.%s.75-percentile %.2f %d\n", prefix, name, ps[1], now)
-				fmt.Fprintf(w, "%s.%s.95-percentile %.2f %d\n", prefix, name, ps[2], now)
-				fmt.Fprintf(w, "%s.%s.99-percentile %.2f %d\n", prefix, name, ps[3], now)
-				fmt.Fprintf(w, "%s.%s.999-percentile %.2f %d\n", prefix, name, ps[4], now)
-			case Meter:
-				fmt.Fprintf(w, "%s.%s.count %d %d\n", prefix, name, m.Count(), now)
-				fmt.Fprintf(w, "%s.%s.one-minute %.2f %d\n", prefix, name, m.Rate1(), now)
-				fmt.Fprintf(w, "%s.%s.five-minute %.2f %d\n", prefix, name, m.Rate5(), now)
-				fmt.Fprintf(w, "%s.%s.fifteen-minute %.2f %d\n", prefix, name, m.Rate15(), now)
-				fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", prefix, name, m.RateMean(), now)
-			case Timer:
-				ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-				fmt.Fprintf(w, "%s.%s.count %d %d\n", prefix, name, m.Count(), now)
-				fmt.Fprintf(w, "%s.%s.min %d %d\n", prefix, name, m.Min(), now)
-				fmt.Fprintf(w, "%s.%s.max %d %d\n", prefix, name, m.Max(), now)
-				fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", prefix, name, m.Mean(), now)
-				fmt.Fprintf(w, "%s.%s.std-dev %.2f %d\n", prefix, name, m.StdDev(), now)
-				fmt.Fprintf(w, "%s.%s.50-percentile %.2f %d\n", prefix, name, ps[0], now)
-				fmt.Fprintf(w, "%s.%s.75-percentile %.2f %d\n", prefix, name, ps[1], now)
-				fmt.Fprintf(w, "%s.%s.95-percentile %.2f %d\n", prefix, name, ps[2], now)
-				fmt.Fprintf(w, "%s.%s.99-percentile %.2f %d\n", prefix, name, ps[3], now)
-				fmt.Fprintf(w, "%s.%s.999-percentile %.2f %d\n", prefix, name, ps[4], now)
-				fmt.Fprintf(w, "%s.%s.one-minute %.2f %d\n", prefix, name, m.Rate1(), now)
-				fmt.Fprintf(w, "%s.%s.five-minute %.2f %d\n", prefix, name, m.Rate5(), now)
-				fmt.Fprintf(w, "%s.%s.fifteen-minute %.2f %d\n", prefix, name, m.Rate15(), now)
-				fmt.Fprintf(w, "%s.%s.mean %.2f %d\n", prefix, name, m.RateMean(), now)
-			}
-			w.Flush()
-		})
 		time.Sleep(d)
 	}
 }
+
+func graphite(r Registry, prefix string, addr *net.TCPAddr) error {
+	now := time.Now().Unix()
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		path := func(key string) string {
+			return taggedName(fmt.Sprintf("%s.%s.%s", prefix, name, key), tags)
+		}
+		switch m := i.(type) {
+		case Counter:
+			fmt.Fprintf(w, "%s %d %d\n", path("count"), m.Count(), now)
+		case Gauge:
+			fmt.Fprintf(w, "%s %d %d\n", path("value"), m.Value(), now)
+		case Histogram:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			fmt.Fprintf(w, "%s %d %d\n", path("count"), m.Count(), now)
+			fmt.Fprintf(w, "%s %d %d\n", path("min"), m.Min(), now)
+			fmt.Fprintf(w, "%s %d %d\n", path("max"), m.Max(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("mean"), m.Mean(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("std-dev"), m.StdDev(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("50-percentile"), ps[0], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("75-percentile"), ps[1], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("95-percentile"), ps[2], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("99-percentile"), ps[3], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("999-percentile"), ps[4], now)
+		case Meter:
+			fmt.Fprintf(w, "%s %d %d\n", path("count"), m.Count(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("one-minute"), m.Rate1(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("five-minute"), m.Rate5(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("fifteen-minute"), m.Rate15(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("mean"), m.RateMean(), now)
+		case Timer:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			fmt.Fprintf(w, "%s %d %d\n", path("count"), m.Count(), now)
+			fmt.Fprintf(w, "%s %d %d\n", path("min"), m.Min(), now)
+			fmt.Fprintf(w, "%s %d %d\n", path("max"), m.Max(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("mean"), m.Mean(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("std-dev"), m.StdDev(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("50-percentile"), ps[0], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("75-percentile"), ps[1], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("95-percentile"), ps[2], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("99-percentile"), ps[3], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("999-percentile"), ps[4], now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("one-minute"), m.Rate1(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("five-minute"), m.Rate5(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("fifteen-minute"), m.Rate15(), now)
+			fmt.Fprintf(w, "%s %.2f %d\n", path("mean"), m.RateMean(), now)
+		}
+	})
+	return w.Flush()
+}
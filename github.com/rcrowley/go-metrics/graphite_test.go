@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphite(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(47)
+	r.Register("foo", c)
+	g := NewGauge()
+	g.Update(12)
+	r.Child("bar", Tags{"host": "a", "dc": "x"}).Register("baz", g)
+
+	addr, out := listenOnce(t)
+	if err := graphite(r, "test", addr); nil != err {
+		t.Fatal(err)
+	}
+	s := <-out
+	for _, want := range []string{
+		"test.foo.count 47 ",
+		"test.bar.baz.value;dc=x;host=a 12 ",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("missing %q in:\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// An HDR sample counts every value in a bucket of logarithmically
+// increasing width, after Gil Tene's HdrHistogram.  Unlike a reservoir it
+// keeps every value, with a bounded relative error: values are
+// indistinguishable from others within 10^-precision of them.  Its memory
+// use depends only on the highest trackable value and the precision.
+//
+// <http://hdrhistogram.github.io/HdrHistogram/>
+//
+// Values from zero up to twice 10^precision each have a bucket of their
+// own.  Above that, every power of two is split into the same number of
+// buckets.
+type HDRSample struct {
+	mutex *sync.Mutex
+	hdr   hdrCounts
+}
+
+// Force the compiler to check that HDRSample implements Sample.
+var _ Sample = &HDRSample{}
+
+// An HDRSnapshot is an immutable copy of the counts of an HDR sample.
+// Snapshots of samples with the same precision can be merged, even when
+// they were taken in different processes.
+type HDRSnapshot struct {
+	hdr hdrCounts
+}
+
+// The counts of an HDR sample and the parameters that lay them out.
+type hdrCounts struct {
+	highest   int64
+	precision int
+	magnitude uint // log2 of the number of buckets per power of two
+	counts    []int64
+	total     int64
+}
+
+// Create a new HDR sample that tracks values from zero to highest with the
+// given number of significant decimal digits, from 1 to 5.  Values outside
+// of that range are recorded as zero or highest.
+func NewHDRSample(highest int64, precision int) *HDRSample {
+	if precision < 1 || precision > 5 {
+		panic("metrics: HDR precision must be from 1 to 5 significant figures")
+	}
+	if highest < 1 {
+		panic("metrics: HDR highest trackable value must be positive")
+	}
+	h := hdrCounts{highest: highest, precision: precision}
+	largest := 2 * math.Pow10(precision)
+	for float64(int64(2)<<h.magnitude) < largest {
+		h.magnitude++
+	}
+	h.counts = make([]int64, h.index(highest)+1)
+	return &HDRSample{&sync.Mutex{}, h}
+}
+
+// Create a new histogram that uses an HDR sample.
+func NewHDRHistogram(highest int64, precision int) *StandardHistogram {
+	return NewHistogram(NewHDRSample(highest, precision))
+}
+
+// Create a new timer whose histogram uses an HDR sample that tracks
+// durations up to highest.  To take snapshots of the sample, create it
+// with NewHDRSample and the timer with NewCustomTimer instead.
+func NewHDRTimer(highest time.Duration, precision int) *StandardTimer {
+	return NewCustomTimer(NewHDRHistogram(int64(highest), precision), NewMeter())
+}
+
+// Clear all counts.
+func (s *HDRSample) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hdr.counts = make([]int64, len(s.hdr.counts))
+	s.hdr.total = 0
+}
+
+// Return the percentiles of the counted values.
+func (s *HDRSample) Percentiles(ps []float64) []float64 {
+	return s.Snapshot().Percentiles(ps)
+}
+
+// Return the number of counted values.
+func (s *HDRSample) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return int(s.hdr.total)
+}
+
+// Return an immutable copy of the sample.
+func (s *HDRSample) Snapshot() *HDRSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	h := s.hdr
+	h.counts = make([]int64, len(s.hdr.counts))
+	copy(h.counts, s.hdr.counts)
+	return &HDRSnapshot{h}
+}
+
+// Count a new value.
+func (s *HDRSample) Update(v int64) {
+	if v < 0 {
+		v = 0
+	} else if v > s.hdr.highest {
+		v = s.hdr.highest
+	}
+	i := s.hdr.index(v)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hdr.counts[i]++
+	s.hdr.total++
+}
+
+// Return every counted value as the lowest value of its bucket.  This
+// allocates a slice as long as the number of values counted; Histograms use
+// Percentiles instead.
+func (s *HDRSample) Values() []int64 {
+	return s.Snapshot().Values()
+}
+
+// Return the number of values in the snapshot.
+func (s *HDRSnapshot) Count() int64 {
+	return s.hdr.total
+}
+
+// Return the highest value in the snapshot, within its precision.
+func (s *HDRSnapshot) Max() int64 {
+	for i := len(s.hdr.counts) - 1; i >= 0; i-- {
+		if 0 != s.hdr.counts[i] {
+			return s.hdr.highestEquivalent(i)
+		}
+	}
+	return 0
+}
+
+// Return the mean of the values in the snapshot, within its precision.
+func (s *HDRSnapshot) Mean() float64 {
+	if 0 == s.hdr.total {
+		return 0.0
+	}
+	var sum float64
+	for i, n := range s.hdr.counts {
+		if 0 != n {
+			sum += float64(n) * s.hdr.median(i)
+		}
+	}
+	return sum / float64(s.hdr.total)
+}
+
+// Return a new snapshot with the values of both snapshots.  Both must
+// have the same precision; the highest trackable value of the result is
+// the higher of theirs.
+func (s *HDRSnapshot) Merge(o *HDRSnapshot) (*HDRSnapshot, error) {
+	if s.hdr.precision != o.hdr.precision {
+		return nil, errors.New("metrics: can't merge HDR snapshots of different precision")
+	}
+	h := s.hdr
+	if o.hdr.highest > h.highest {
+		h.highest = o.hdr.highest
+	}
+	h.counts = make([]int64, h.index(h.highest)+1)
+	copy(h.counts, s.hdr.counts)
+	for i, n := range o.hdr.counts {
+		h.counts[i] += n
+	}
+	h.total += o.hdr.total
+	return &HDRSnapshot{h}, nil
+}
+
+// Return the lowest value in the snapshot, within its precision.
+func (s *HDRSnapshot) Min() int64 {
+	for i, n := range s.hdr.counts {
+		if 0 != n {
+			return s.hdr.lowestEquivalent(i)
+		}
+	}
+	return 0
+}
+
+// Return an arbitrary percentile of the values in the snapshot.
+func (s *HDRSnapshot) Percentile(p float64) float64 {
+	return s.Percentiles([]float64{p})[0]
+}
+
+// Return a slice of arbitrary percentiles of the values in the snapshot.
+// Each is the highest value that is equivalent, within the precision, to
+// the value at that rank.
+func (s *HDRSnapshot) Percentiles(ps []float64) []float64 {
+	scores := make([]float64, len(ps))
+	if 0 == s.hdr.total {
+		return scores
+	}
+	for j, p := range ps {
+		rank := int64(math.Ceil(p * float64(s.hdr.total)))
+		if rank < 1 {
+			rank = 1
+		} else if rank > s.hdr.total {
+			rank = s.hdr.total
+		}
+		var seen int64
+		for i, n := range s.hdr.counts {
+			seen += n
+			if seen >= rank {
+				scores[j] = float64(s.hdr.highestEquivalent(i))
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// Return every value in the snapshot as the lowest value of its bucket.
+func (s *HDRSnapshot) Values() []int64 {
+	values := make([]int64, 0, s.hdr.total)
+	for i, n := range s.hdr.counts {
+		v := s.hdr.lowestEquivalent(i)
+		for ; n > 0; n-- {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// The JSON form of a snapshot, with only the buckets that hold values.
+type hdrSnapshotJSON struct {
+	Highest   int64      `json:"highest"`
+	Precision int        `json:"precision"`
+	Counts    [][2]int64 `json:"counts"` // bucket index and count
+}
+
+// MarshalJSON returns a JSON representation of the snapshot that can be
+// sent to another process to be merged there.
+func (s *HDRSnapshot) MarshalJSON() ([]byte, error) {
+	j := hdrSnapshotJSON{
+		Highest:   s.hdr.highest,
+		Precision: s.hdr.precision,
+		Counts:    make([][2]int64, 0),
+	}
+	for i, n := range s.hdr.counts {
+		if 0 != n {
+			j.Counts = append(j.Counts, [2]int64{int64(i), n})
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON replaces the snapshot with one in the form returned by
+// MarshalJSON.
+func (s *HDRSnapshot) UnmarshalJSON(b []byte) error {
+	var j hdrSnapshotJSON
+	if err := json.Unmarshal(b, &j); nil != err {
+		return err
+	}
+	if j.Precision < 1 || j.Precision > 5 || j.Highest < 1 {
+		return errors.New("metrics: invalid HDR snapshot")
+	}
+	h := NewHDRSample(j.Highest, j.Precision).hdr
+	for _, c := range j.Counts {
+		if c[0] < 0 || c[0] >= int64(len(h.counts)) || c[1] < 0 {
+			return errors.New("metrics: invalid HDR snapshot")
+		}
+		h.counts[c[0]] += c[1]
+		h.total += c[1]
+	}
+	s.hdr = h
+	return nil
+}
+
+// Return the index of the bucket of the given value.
+func (h *hdrCounts) index(v int64) int {
+	half := int64(1) << h.magnitude
+	if v < 2*half {
+		return int(v)
+	}
+	shift := uint(bitLength(uint64(v))) - (h.magnitude + 1)
+	return int(int64(shift)*half + v>>shift)
+}
+
+// Return the lowest value of the given bucket.
+func (h *hdrCounts) lowestEquivalent(i int) int64 {
+	half := int64(1) << h.magnitude
+	if int64(i) < 2*half {
+		return int64(i)
+	}
+	shift := uint(int64(i)/half - 1)
+	return (int64(i) - int64(shift)*half) << shift
+}
+
+// Return the highest value of the given bucket.
+func (h *hdrCounts) highestEquivalent(i int) int64 {
+	return h.lowestEquivalent(i+1) - 1
+}
+
+// Return the value in the middle of the given bucket.
+func (h *hdrCounts) median(i int) float64 {
+	return (float64(h.lowestEquivalent(i)) + float64(h.highestEquivalent(i))) / 2
+}
+
+// Return the number of bits needed to represent v.
+func bitLength(v uint64) int {
+	n := 0
+	for shift := uint(32); shift > 0; shift >>= 1 {
+		if v >= 1<<shift {
+			v >>= shift
+			n += int(shift)
+		}
+	}
+	if 0 != v {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHDRSampleIndex(t *testing.T) {
+	s := NewHDRSample(math.MaxInt64, 3)
+	for _, v := range []int64{0, 1, 2047, 2048, 2049, 1e6, 1e12, math.MaxInt64} {
+		i := s.hdr.index(v)
+		low, high := s.hdr.lowestEquivalent(i), s.hdr.highestEquivalent(i)
+		if v < low || v > high {
+			t.Errorf("%d: bucket [%d, %d]\n", v, low, high)
+		}
+		if float64(high-low) > float64(low)/1000 {
+			t.Errorf("%d: bucket [%d, %d] too wide\n", v, low, high)
+		}
+	}
+}
+
+func TestHDRSamplePercentiles(t *testing.T) {
+	s := NewHDRSample(3600e9, 3)
+	for i := int64(1); i <= 1000000; i++ {
+		s.Update(i * 1000)
+	}
+	if size := s.Size(); 1000000 != size {
+		t.Errorf("s.Size(): 1000000 != %v\n", size)
+	}
+	ps := []float64{0.5, 0.75, 0.99, 0.999, 1.0}
+	for i, score := range s.Percentiles(ps) {
+		exact := ps[i] * 1e9
+		if math.Abs(score-exact) > exact/1000 {
+			t.Errorf("%v percentile: %v not within 0.1%% of %v\n", ps[i], score, exact)
+		}
+	}
+}
+
+func TestHDRSampleClamp(t *testing.T) {
+	s := NewHDRSample(1000, 2)
+	s.Update(-5)
+	s.Update(5000)
+	snap := s.Snapshot()
+	if min := snap.Min(); 0 != min {
+		t.Errorf("snap.Min(): 0 != %v\n", min)
+	}
+	if max := snap.Max(); max < 1000 || max > 1010 {
+		t.Errorf("snap.Max(): 1000 != %v\n", max)
+	}
+}
+
+func TestHDRSnapshotImmutable(t *testing.T) {
+	s := NewHDRSample(1000, 2)
+	s.Update(1)
+	snap := s.Snapshot()
+	s.Update(2)
+	s.Clear()
+	if count := snap.Count(); 1 != count {
+		t.Errorf("snap.Count(): 1 != %v\n", count)
+	}
+	if values := snap.Values(); 1 != len(values) || 1 != values[0] {
+		t.Errorf("snap.Values(): [1] != %v\n", values)
+	}
+}
+
+func TestHDRSnapshotMerge(t *testing.T) {
+	a, b, all := NewHDRSample(1e6, 3), NewHDRSample(1e9, 3), NewHDRSample(1e9, 3)
+	for i := int64(1); i <= 1000; i++ {
+		a.Update(i)
+		b.Update(i * 1000)
+		all.Update(i)
+		all.Update(i * 1000)
+	}
+
+	// Send b through JSON as if from another process.
+	buf, err := json.Marshal(b.Snapshot())
+	if nil != err {
+		t.Fatal(err)
+	}
+	var bSnap HDRSnapshot
+	if err := json.Unmarshal(buf, &bSnap); nil != err {
+		t.Fatal(err)
+	}
+
+	merged, err := a.Snapshot().Merge(&bSnap)
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := all.Snapshot()
+	if merged.Count() != want.Count() || merged.Min() != want.Min() || merged.Max() != want.Max() {
+		t.Errorf("merged count, min, max: %v %v %v != %v %v %v\n",
+			merged.Count(), merged.Min(), merged.Max(), want.Count(), want.Min(), want.Max())
+	}
+	ps := []float64{0.25, 0.5, 0.9, 0.99}
+	got, exp := merged.Percentiles(ps), want.Percentiles(ps)
+	for i := range ps {
+		if got[i] != exp[i] {
+			t.Errorf("%v percentile: %v != %v\n", ps[i], exp[i], got[i])
+		}
+	}
+
+	if _, err := a.Snapshot().Merge(NewHDRSample(1e6, 2).Snapshot()); nil == err {
+		t.Error("merged snapshots of different precision")
+	}
+}
+
+func TestHDRHistogram(t *testing.T) {
+	h := NewHDRHistogram(100000, 3)
+	for i := 1; i <= 10000; i++ {
+		h.Update(int64(i))
+	}
+	if count := h.Count(); 10000 != count {
+		t.Errorf("h.Count(): 10000 != %v\n", count)
+	}
+	ps := h.Percentiles([]float64{0.5, 0.75, 0.99})
+	if math.Abs(5000.0-ps[0]) > 5.0 {
+		t.Errorf("median: 5000.0 != %v\n", ps[0])
+	}
+	if math.Abs(7500.0-ps[1]) > 7.5 {
+		t.Errorf("75th percentile: 7500.0 != %v\n", ps[1])
+	}
+	if math.Abs(9900.0-ps[2]) > 9.9 {
+		t.Errorf("99th percentile: 9900.0 != %v\n", ps[2])
+	}
+}
+
+func TestHDRTimer(t *testing.T) {
+	tm := NewHDRTimer(time.Minute, 3)
+	tm.Update(time.Millisecond)
+	if count := tm.Count(); 1 != count {
+		t.Errorf("tm.Count(): 1 != %v\n", count)
+	}
+	if p := tm.Percentile(0.5); math.Abs(p-1e6) > 1e3 {
+		t.Errorf("median: 1e6 != %v\n", p)
+	}
+}
+
+func BenchmarkHDRSampleUpdate(b *testing.B) {
+	s := NewHDRSample(3600e9, 3)
+	for i := 0; i < b.N; i++ {
+		s.Update(int64(i))
+	}
+}
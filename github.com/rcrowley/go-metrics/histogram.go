@@ -54,6 +54,15 @@ func NewHistogram(s Sample) *StandardHistogram {
 	return h
 }
 
+// Get an existing or register and return a new histogram using the given
+// Sample by the given name.  A nil registry means the default registry.
+func GetOrRegisterHistogram(name string, r Registry, s Sample) Histogram {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, func() Histogram { return NewHistogram(s) }).(Histogram)
+}
+
 // Create a new histogramV.  The initial values compare so that the first
 // value will be both min and max and the variance is flagged for special
 // treatment on its first iteration.
@@ -110,6 +119,9 @@ func (h *StandardHistogram) Percentile(p float64) float64 {
 // Return a slice of arbitrary percentiles of all values seen since the
 // histogram was last cleared.
 func (h *StandardHistogram) Percentiles(ps []float64) []float64 {
+	if s, ok := h.s.(percentileSample); ok {
+		return s.Percentiles(ps)
+	}
 	scores := make([]float64, len(ps))
 	values := int64Slice(h.s.Values())
 	size := len(values)
@@ -131,6 +143,11 @@ func (h *StandardHistogram) Percentiles(ps []float64) []float64 {
 	return scores
 }
 
+// Return the histogram's Sample.
+func (h *StandardHistogram) Sample() Sample {
+	return h.s
+}
+
 // Return the standard deviation of all values seen since the histogram was
 // last cleared.
 func (h *StandardHistogram) StdDev() float64 {
@@ -185,6 +202,12 @@ func (h *StandardHistogram) arbiter() {
 	}
 }
 
+// A percentileSample is a Sample that computes percentiles itself rather
+// than have the histogram sort its values.
+type percentileSample interface {
+	Percentiles([]float64) []float64
+}
+
 // Cribbed from the standard library's `sort` package.
 type int64Slice []int64
 
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Output each metric in the given registry periodically to an InfluxDB
+// line protocol listener.  Each metric is written as one point whose
+// measurement is the prefixed name of the metric, tagged with the tags of
+// the metric and, unless they include one, a host tag of the hostname of
+// this process, with a field for each of its values.
+func InfluxDB(r Registry, d time.Duration, prefix string, addr *net.TCPAddr) {
+	for {
+		if err := influxDB(r, prefix, addr); nil != err {
+			log.Println(err)
+		}
+		time.Sleep(d)
+	}
+}
+
+// Escape the characters that are special in a measurement and in a tag of
+// the line protocol.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func influxDB(r Registry, prefix string, addr *net.TCPAddr) error {
+	now := time.Now().UnixNano()
+	host, err := os.Hostname()
+	if nil != err {
+		host = "unknown"
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		var fields string
+		switch m := i.(type) {
+		case Counter:
+			fields = fmt.Sprintf("count=%di", m.Count())
+		case Gauge:
+			fields = fmt.Sprintf("value=%di", m.Value())
+		case Histogram:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			fields = fmt.Sprintf(
+				"count=%di,min=%di,max=%di,mean=%f,std-dev=%f,50-percentile=%f,75-percentile=%f,95-percentile=%f,99-percentile=%f,999-percentile=%f",
+				m.Count(), m.Min(), m.Max(), m.Mean(), m.StdDev(),
+				ps[0], ps[1], ps[2], ps[3], ps[4],
+			)
+		case Meter:
+			fields = fmt.Sprintf(
+				"count=%di,one-minute=%f,five-minute=%f,fifteen-minute=%f,mean-rate=%f",
+				m.Count(), m.Rate1(), m.Rate5(), m.Rate15(), m.RateMean(),
+			)
+		case Timer:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			fields = fmt.Sprintf(
+				"count=%di,min=%di,max=%di,mean=%f,std-dev=%f,50-percentile=%f,75-percentile=%f,95-percentile=%f,99-percentile=%f,999-percentile=%f,one-minute=%f,five-minute=%f,fifteen-minute=%f,mean-rate=%f",
+				m.Count(), m.Min(), m.Max(), m.Mean(), m.StdDev(),
+				ps[0], ps[1], ps[2], ps[3], ps[4],
+				m.Rate1(), m.Rate5(), m.Rate15(), m.RateMean(),
+			)
+		default:
+			return
+		}
+		tags = mergeTags(Tags{"host": host}, tags)
+		var tagList string
+		for _, k := range tags.keys() {
+			tagList += "," + influxTagEscaper.Replace(k) + "=" + influxTagEscaper.Replace(tags[k])
+		}
+		fmt.Fprintf(
+			w, "%s.%s%s %s %d\n",
+			influxMeasurementEscaper.Replace(prefix),
+			influxMeasurementEscaper.Replace(name),
+			tagList, fields, now,
+		)
+	})
+	return w.Flush()
+}
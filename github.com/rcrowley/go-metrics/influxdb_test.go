@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfluxDB(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(47)
+	r.Register("foo bar", c)
+	m := NewMeter()
+	m.Mark(1)
+	r.Register("baz", m)
+
+	addr, out := listenOnce(t)
+	if err := influxDB(r, "test", addr); nil != err {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(<-out), "\n")
+	if 2 != len(lines) {
+		t.Fatalf("%d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		var fields string
+		switch {
+		case strings.HasPrefix(line, `test.foo\ bar,host=`):
+			fields = "count=47i"
+		case strings.HasPrefix(line, "test.baz,host="):
+			fields = "count=1i,one-minute="
+		default:
+			t.Errorf("unexpected line %q\n", line)
+			continue
+		}
+		if !strings.Contains(line, " "+fields) {
+			t.Errorf("missing %q in line %q\n", fields, line)
+		}
+	}
+}
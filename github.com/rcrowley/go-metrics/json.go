@@ -2,13 +2,22 @@ package metrics
 
 import (
 	"encoding/json"
+	"io"
+	"time"
 )
 
 // MarshalJSON returns a byte slice containing a JSON representation of all
 // the metrics in the Registry.
 func (r StandardRegistry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(registryJSON(&r))
+}
+
+// Build the JSON representation of the metrics in a Registry.  Each metric
+// is keyed by its name with its tags appended as ";key=value" pairs and
+// the tags of a tagged metric are also given as a "tags" object.
+func registryJSON(r Registry) map[string]map[string]interface{} {
 	data := make(map[string]map[string]interface{})
-	r.Each(func(name string, i interface{}) {
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
 		values := make(map[string]interface{})
 		switch m := i.(type) {
 		case Counter:
@@ -53,7 +62,25 @@ func (r StandardRegistry) MarshalJSON() ([]byte, error) {
 			values["15m.rate"] = m.Rate15()
 			values["mean.rate"] = m.RateMean()
 		}
-		data[name] = values
+		if 0 != len(tags) {
+			values["tags"] = tags
+		}
+		data[taggedName(name, tags)] = values
 	})
-	return json.Marshal(data)
+	return data
+}
+
+// Output each metric in the given registry periodically as JSON to the
+// given writer, one object per line.
+func WriteJSON(r Registry, d time.Duration, w io.Writer) {
+	for {
+		WriteJSONOnce(r, w)
+		time.Sleep(d)
+	}
+}
+
+// Output each metric in the given registry as a JSON object followed by a
+// newline to the given writer.
+func WriteJSONOnce(r Registry, w io.Writer) error {
+	return json.NewEncoder(w).Encode(registryJSON(r))
 }
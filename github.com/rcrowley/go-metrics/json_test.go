@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteJSONOnce(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(47)
+	r.Register("foo", c)
+	r.Child("", Tags{"host": "a"}).Register("foo", NewCounter())
+
+	var b bytes.Buffer
+	if err := WriteJSONOnce(r, &b); nil != err {
+		t.Fatal(err)
+	}
+	var data map[string]map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &data); nil != err {
+		t.Fatal(err)
+	}
+	if count := data["foo"]["count"]; 47.0 != count {
+		t.Errorf("foo count: 47 != %v\n", count)
+	}
+	if _, ok := data["foo"]["tags"]; ok {
+		t.Errorf("untagged foo has tags: %v\n", data["foo"])
+	}
+	tags, _ := data["foo;host=a"]["tags"].(map[string]interface{})
+	if "a" != tags["host"] {
+		t.Errorf("foo;host=a tags: %v\n", data["foo;host=a"])
+	}
+}
@@ -47,6 +47,15 @@ func NewMeter() *StandardMeter {
 	return m
 }
 
+// Get an existing or register and return a new meter by the given name.  A
+// nil registry means the default registry.
+func GetOrRegisterMeter(name string, r Registry) Meter {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewMeter).(Meter)
+}
+
 // Return the count of events seen.
 func (m *StandardMeter) Count() int64 {
 	return (<-m.out).count
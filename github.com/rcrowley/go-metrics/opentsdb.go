@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// Output each metric in the given registry periodically to an OpenTSDB
+// server using its telnet-style "put" protocol.  Every data point is tagged
+// with the tags of its metric and, unless they include one, a host tag of
+// the hostname of this process.
+func OpenTSDB(r Registry, d time.Duration, prefix string, addr *net.TCPAddr) {
+	for {
+		if err := openTSDB(r, prefix, addr); nil != err {
+			log.Println(err)
+		}
+		time.Sleep(d)
+	}
+}
+
+func openTSDB(r Registry, prefix string, addr *net.TCPAddr) error {
+	now := time.Now().Unix()
+	host, err := os.Hostname()
+	if nil != err {
+		host = "unknown"
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		tags = mergeTags(Tags{"host": host}, tags)
+		var tagList string
+		for _, k := range tags.keys() {
+			tagList += " " + k + "=" + tags[k]
+		}
+		put := func(name, key string, value interface{}) {
+			switch v := value.(type) {
+			case float64:
+				fmt.Fprintf(w, "put %s.%s.%s %d %.2f%s\n", prefix, name, key, now, v, tagList)
+			default:
+				fmt.Fprintf(w, "put %s.%s.%s %d %d%s\n", prefix, name, key, now, v, tagList)
+			}
+		}
+		switch m := i.(type) {
+		case Counter:
+			put(name, "count", m.Count())
+		case Gauge:
+			put(name, "value", m.Value())
+		case Histogram:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			put(name, "count", m.Count())
+			put(name, "min", m.Min())
+			put(name, "max", m.Max())
+			put(name, "mean", m.Mean())
+			put(name, "std-dev", m.StdDev())
+			put(name, "50-percentile", ps[0])
+			put(name, "75-percentile", ps[1])
+			put(name, "95-percentile", ps[2])
+			put(name, "99-percentile", ps[3])
+			put(name, "999-percentile", ps[4])
+		case Meter:
+			put(name, "count", m.Count())
+			put(name, "one-minute", m.Rate1())
+			put(name, "five-minute", m.Rate5())
+			put(name, "fifteen-minute", m.Rate15())
+			put(name, "mean-rate", m.RateMean())
+		case Timer:
+			ps := m.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+			put(name, "count", m.Count())
+			put(name, "min", m.Min())
+			put(name, "max", m.Max())
+			put(name, "mean", m.Mean())
+			put(name, "std-dev", m.StdDev())
+			put(name, "50-percentile", ps[0])
+			put(name, "75-percentile", ps[1])
+			put(name, "95-percentile", ps[2])
+			put(name, "99-percentile", ps[3])
+			put(name, "999-percentile", ps[4])
+			put(name, "one-minute", m.Rate1())
+			put(name, "five-minute", m.Rate5())
+			put(name, "fifteen-minute", m.Rate15())
+			put(name, "mean-rate", m.RateMean())
+		}
+	})
+	return w.Flush()
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Listen on a local TCP port and return its address and a channel that
+// receives everything written to the first connection accepted.
+func listenOnce(t *testing.T) (*net.TCPAddr, <-chan string) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatal(err)
+	}
+	c := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if nil != err {
+			c <- ""
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		c <- string(b)
+	}()
+	return l.Addr().(*net.TCPAddr), c
+}
+
+func TestOpenTSDB(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(47)
+	r.Register("foo", c)
+	r.Register("bar", NewTimer())
+
+	addr, out := listenOnce(t)
+	if err := openTSDB(r, "test", addr); nil != err {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(<-out), "\n")
+	if 1+14 != len(lines) {
+		t.Fatalf("%d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if 5 != len(fields) || "put" != fields[0] || !strings.HasPrefix(fields[4], "host=") {
+			t.Errorf("malformed line %q\n", line)
+		}
+		if "test.foo.count" == fields[1] && "47" != fields[3] {
+			t.Errorf("foo.count: 47 != %s\n", fields[3])
+		}
+	}
+}
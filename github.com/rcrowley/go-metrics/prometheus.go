@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Return an http.Handler that renders every metric in the given registry
+// in the Prometheus text exposition format each time it is scraped.
+func PrometheusHandler(r Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, r)
+	})
+}
+
+// Write every metric in the given registry to the given writer in the
+// Prometheus text exposition format.  Counters become counters, gauges
+// become gauges, meters become a counter and gauges of their rates and
+// histograms and timers become summaries.  The tags of a tagged metric
+// become labels, so metrics that differ only in their tags are series of
+// the same family.  Families are sorted and any character Prometheus
+// doesn't allow in a name is replaced with '_'.
+func WritePrometheus(w io.Writer, r Registry) error {
+	families := make(map[string][]prometheusSeries)
+	var names []string
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		name = prometheusName(name)
+		if _, ok := families[name]; !ok {
+			names = append(names, name)
+		}
+		families[name] = append(families[name], prometheusSeries{tags, i})
+	})
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		series := families[name]
+		sort.Sort(prometheusSeriesSlice(series))
+
+		// Every series of a family is written as the type of the first.
+		switch series[0].i.(type) {
+		case Counter:
+			fmt.Fprintf(bw, "# TYPE %s counter\n", name)
+			for _, s := range series {
+				if m, ok := s.i.(Counter); ok {
+					fmt.Fprintf(bw, "%s%s %d\n", name, prometheusLabels(s.tags), m.Count())
+				}
+			}
+		case Gauge:
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+			for _, s := range series {
+				if m, ok := s.i.(Gauge); ok {
+					fmt.Fprintf(bw, "%s%s %d\n", name, prometheusLabels(s.tags), m.Value())
+				}
+			}
+		case Histogram:
+			writePrometheusSummaries(bw, name, series)
+		case Meter:
+			fmt.Fprintf(bw, "# TYPE %s counter\n", name)
+			for _, s := range series {
+				if m, ok := s.i.(Meter); ok {
+					fmt.Fprintf(bw, "%s%s %d\n", name, prometheusLabels(s.tags), m.Count())
+				}
+			}
+			writePrometheusRates(bw, name, series)
+		case Timer:
+			writePrometheusSummaries(bw, name, series)
+			writePrometheusRates(bw, name, series)
+		}
+	}
+	return bw.Flush()
+}
+
+// A prometheusSeries is a metric in a family with its labels.
+type prometheusSeries struct {
+	tags Tags
+	i    interface{}
+}
+
+// prometheusSeriesSlice sorts series by their labels.
+type prometheusSeriesSlice []prometheusSeries
+
+func (s prometheusSeriesSlice) Len() int { return len(s) }
+func (s prometheusSeriesSlice) Less(i, j int) bool {
+	return prometheusLabels(s[i].tags) < prometheusLabels(s[j].tags)
+}
+func (s prometheusSeriesSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Write the histograms or timers of a family as summaries.
+func writePrometheusSummaries(w io.Writer, name string, series []prometheusSeries) {
+	type summary interface {
+		Count() int64
+		Mean() float64
+		Percentiles([]float64) []float64
+	}
+	qs := []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	for _, s := range series {
+		m, ok := s.i.(summary)
+		if !ok {
+			continue
+		}
+		ps := m.Percentiles(qs)
+		for i, q := range qs {
+			tags := mergeTags(s.tags, Tags{"quantile": prometheusFloat(q)})
+			fmt.Fprintf(w, "%s%s %s\n", name, prometheusLabels(tags), prometheusFloat(ps[i]))
+		}
+		labels := prometheusLabels(s.tags)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, prometheusFloat(m.Mean()*float64(m.Count())))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, m.Count())
+	}
+}
+
+// Write the rates of the meters or timers of a family as gauges.
+func writePrometheusRates(w io.Writer, name string, series []prometheusSeries) {
+	type rates interface {
+		Rate1() float64
+		Rate5() float64
+		Rate15() float64
+		RateMean() float64
+	}
+	for _, g := range []struct {
+		suffix string
+		rate   func(rates) float64
+	}{
+		{"rate1", rates.Rate1},
+		{"rate5", rates.Rate5},
+		{"rate15", rates.Rate15},
+		{"rate_mean", rates.RateMean},
+	} {
+		fmt.Fprintf(w, "# TYPE %s_%s gauge\n", name, g.suffix)
+		for _, s := range series {
+			if m, ok := s.i.(rates); ok {
+				fmt.Fprintf(w, "%s_%s%s %s\n", name, g.suffix, prometheusLabels(s.tags), prometheusFloat(g.rate(m)))
+			}
+		}
+	}
+}
+
+// Format tags as Prometheus labels, in order of their names.
+func prometheusLabels(tags Tags) string {
+	if 0 == len(tags) {
+		return ""
+	}
+	b := []byte{'{'}
+	for i, k := range tags.keys() {
+		if 0 != i {
+			b = append(b, ',')
+		}
+		b = append(b, prometheusName(k)...)
+		b = append(b, '=', '"')
+		b = append(b, prometheusLabelEscaper.Replace(tags[k])...)
+		b = append(b, '"')
+	}
+	return string(append(b, '}'))
+}
+
+// Escape the characters that are special in a label value.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format a float the way Prometheus parses it.
+func prometheusFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Replace every character that isn't allowed in a Prometheus metric name
+// with '_'.
+func prometheusName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == ':' {
+			continue
+		}
+		if '0' <= c && c <= '9' && i > 0 {
+			continue
+		}
+		b[i] = '_'
+	}
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	c.Inc(47)
+	r.Register("foo.count", c)
+	g := NewGauge()
+	g.Update(12)
+	r.Register("bar-gauge", g)
+	h := NewHistogram(NewUniformSample(100))
+	h.Update(1)
+	h.Update(3)
+	// The arbiter answers Count only after it has sampled both updates.
+	if n := h.Count(); 2 != n {
+		t.Fatal(n)
+	}
+	r.Register("baz", h)
+	m := NewMeter()
+	m.Mark(5)
+	r.Register("quux", m)
+	c2 := NewCounter()
+	c2.Inc(3)
+	r.Child("foo", Tags{"host": "a\"b"}).Register("count", c2)
+
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type: %q\n", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE bar_gauge gauge\nbar_gauge 12\n",
+		"# TYPE baz summary\n",
+		"baz{quantile=\"0.5\"} 2\n",
+		"baz_sum 4\nbaz_count 2\n",
+		"# TYPE foo_count counter\nfoo_count 47\nfoo_count{host=\"a\\\"b\"} 3\n",
+		"# TYPE quux counter\nquux 5\n",
+		"# TYPE quux_rate1 gauge\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+	if i, j := strings.Index(body, "bar_gauge"), strings.Index(body, "foo_count"); i > j {
+		t.Errorf("metrics not sorted:\n%s", body)
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	for name, want := range map[string]string{
+		"foo":         "foo",
+		"foo.bar-baz": "foo_bar_baz",
+		"9lives":      "_lives",
+		"a:b_c9":      "a:b_c9",
+	} {
+		if got := prometheusName(name); want != got {
+			t.Errorf("prometheusName(%q): %q != %q\n", name, want, got)
+		}
+	}
+}
@@ -1,20 +1,45 @@
 package metrics
 
-import "sync"
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
 
 // A Registry holds references to a set of metrics by name and can iterate
 // over them, calling callback functions provided by the user.
 //
+// Metrics may carry tags, key/value pairs that qualify the name.  Tagged
+// metrics are registered through a child registry, which scopes the names
+// and tags of everything registered through it.
+//
 // This is an interface so as to encourage other structs to implement
 // the Registry API as appropriate.
 type Registry interface {
 
-	// Call the given function for each registered metric.
+	// Return a child registry that registers metrics in this one with the
+	// given prefix joined to their names by a '.' and with the given tags
+	// added to their tags.  Iterating over it only visits those metrics.
+	Child(string, Tags) Registry
+
+	// Call the given function for each registered metric.  The tags of a
+	// tagged metric are appended to its name as ";key=value" pairs.
 	Each(func(string, interface{}))
 
+	// Call the given function for each registered metric with its name
+	// and tags, which the function must not modify.
+	EachTagged(func(string, Tags, interface{}))
+
 	// Get the metric by the given name or nil if none is registered.
 	Get(string) interface{}
 
+	// Get the metric by the given name or register the given one.  If the
+	// given metric is a function it is only called, with no arguments, to
+	// create the metric when none is registered yet.  It's safe to call
+	// concurrently: every caller gets the same metric.
+	GetOrRegister(string, interface{}) interface{}
+
 	// Register the given metric under the given name.
 	Register(string, interface{})
 
@@ -25,11 +50,22 @@ type Registry interface {
 	Unregister(string)
 }
 
+// Tags are key/value pairs that qualify the name of a metric.
+type Tags map[string]string
+
 // The standard implementation of a Registry is a mutex-protected map
-// of names to metrics.
+// of names and tags to metrics.
 type StandardRegistry struct {
 	mutex   *sync.Mutex
-	metrics map[string]interface{}
+	metrics map[string]*registeredMetric
+}
+
+// A registeredMetric is a metric with the name and tags it's registered
+// under.
+type registeredMetric struct {
+	name string
+	tags Tags
+	i    interface{}
 }
 
 // Force the compiler to check that StandardRegistry implements Registry.
@@ -39,42 +75,54 @@ var _ Registry = &StandardRegistry{}
 func NewRegistry() *StandardRegistry {
 	return &StandardRegistry{
 		&sync.Mutex{},
-		make(map[string]interface{}),
+		make(map[string]*registeredMetric),
 	}
 }
 
+// Return a child registry that registers metrics in this one with the
+// given prefix and tags.
+func (r *StandardRegistry) Child(prefix string, tags Tags) Registry {
+	return &childRegistry{r, prefix, mergeTags(nil, tags)}
+}
+
 // Call the given function for each registered metric.
 func (r *StandardRegistry) Each(f func(string, interface{})) {
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		f(taggedName(name, tags), i)
+	})
+}
+
+// Call the given function for each registered metric with its name and
+// tags.
+func (r *StandardRegistry) EachTagged(f func(string, Tags, interface{})) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	for name, i := range r.metrics {
-		f(name, i)
+	for _, m := range r.metrics {
+		f(m.name, m.tags, m.i)
 	}
 }
 
 // Get the metric by the given name or nil if none is registered.
 func (r *StandardRegistry) Get(name string) interface{} {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	return r.metrics[name]
+	return r.get(name, nil)
+}
+
+// Get the metric by the given name or register the given one.
+func (r *StandardRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	return r.getOrRegister(name, nil, i)
 }
 
 // Register the given metric under the given name.
 func (r *StandardRegistry) Register(name string, i interface{}) {
-	switch i.(type) {
-	case Counter, Gauge, Healthcheck, Histogram, Meter, Timer:
-		r.mutex.Lock()
-		defer r.mutex.Unlock()
-		r.metrics[name] = i
-	}
+	r.register(name, nil, i)
 }
 
 // Run all registered healthchecks.
 func (r *StandardRegistry) RunHealthchecks() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	for _, i := range r.metrics {
-		if h, ok := i.(Healthcheck); ok {
+	for _, m := range r.metrics {
+		if h, ok := m.i.(Healthcheck); ok {
 			h.Check()
 		}
 	}
@@ -82,23 +130,189 @@ func (r *StandardRegistry) RunHealthchecks() {
 
 // Unregister the metric with the given name.
 func (r *StandardRegistry) Unregister(name string) {
+	r.unregister(name, nil)
+}
+
+func (r *StandardRegistry) get(name string, tags Tags) interface{} {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	delete(r.metrics, name)
+	if m, ok := r.metrics[taggedName(name, tags)]; ok {
+		return m.i
+	}
+	return nil
+}
+
+func (r *StandardRegistry) getOrRegister(name string, tags Tags, i interface{}) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := taggedName(name, tags)
+	if m, ok := r.metrics[key]; ok {
+		return m.i
+	}
+	if v := reflect.ValueOf(i); v.Kind() == reflect.Func {
+		i = v.Call(nil)[0].Interface()
+	}
+	r.registerLocked(key, name, tags, i)
+	return i
+}
+
+func (r *StandardRegistry) register(name string, tags Tags, i interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.registerLocked(taggedName(name, tags), name, tags, i)
+}
+
+func (r *StandardRegistry) registerLocked(key, name string, tags Tags, i interface{}) {
+	switch i.(type) {
+	case Counter, Gauge, Healthcheck, Histogram, Meter, Timer:
+		r.metrics[key] = &registeredMetric{name, tags, i}
+	}
+}
+
+func (r *StandardRegistry) unregister(name string, tags Tags) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.metrics, taggedName(name, tags))
+}
+
+// A childRegistry registers metrics in a StandardRegistry under its prefix
+// and tags and only iterates over those metrics.
+type childRegistry struct {
+	parent *StandardRegistry
+	prefix string
+	tags   Tags
+}
+
+// Force the compiler to check that childRegistry implements Registry.
+var _ Registry = &childRegistry{}
+
+func (r *childRegistry) Child(prefix string, tags Tags) Registry {
+	return &childRegistry{r.parent, r.name(prefix), mergeTags(r.tags, tags)}
+}
+
+func (r *childRegistry) Each(f func(string, interface{})) {
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		f(taggedName(name, tags), i)
+	})
+}
+
+// Call the given function for each metric in the scope of the child with
+// the prefix trimmed from its name.
+func (r *childRegistry) EachTagged(f func(string, Tags, interface{})) {
+	r.parent.EachTagged(func(name string, tags Tags, i interface{}) {
+		if "" != r.prefix {
+			if !strings.HasPrefix(name, r.prefix+".") {
+				return
+			}
+			name = name[len(r.prefix)+1:]
+		}
+		for k, v := range r.tags {
+			if tags[k] != v {
+				return
+			}
+		}
+		f(name, tags, i)
+	})
+}
+
+func (r *childRegistry) Get(name string) interface{} {
+	return r.parent.get(r.name(name), r.tags)
+}
+
+func (r *childRegistry) GetOrRegister(name string, i interface{}) interface{} {
+	return r.parent.getOrRegister(r.name(name), r.tags, i)
+}
+
+func (r *childRegistry) Register(name string, i interface{}) {
+	r.parent.register(r.name(name), r.tags, i)
+}
+
+func (r *childRegistry) RunHealthchecks() {
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		if h, ok := i.(Healthcheck); ok {
+			h.Check()
+		}
+	})
+}
+
+func (r *childRegistry) Unregister(name string) {
+	r.parent.unregister(r.name(name), r.tags)
+}
+
+// Join the child's prefix to the given name.
+func (r *childRegistry) name(name string) string {
+	if "" == r.prefix {
+		return name
+	}
+	return r.prefix + "." + name
+}
+
+// Return a copy of a with the tags in b added, or nil if there are none.
+func mergeTags(a, b Tags) Tags {
+	if 0 == len(a)+len(b) {
+		return nil
+	}
+	tags := make(Tags, len(a)+len(b))
+	for k, v := range a {
+		tags[k] = v
+	}
+	for k, v := range b {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Return the keys of the tags in order.
+func (t Tags) keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Return the name with the tags appended as ";key=value" pairs in order of
+// their keys, the way Graphite names tagged series.
+func taggedName(name string, tags Tags) string {
+	if 0 == len(tags) {
+		return name
+	}
+	s := name
+	for _, k := range tags.keys() {
+		s += ";" + k + "=" + tags[k]
+	}
+	return s
 }
 
 var DefaultRegistry *StandardRegistry = NewRegistry()
 
+// Return a child registry of the default registry.
+func Child(prefix string, tags Tags) Registry {
+	return DefaultRegistry.Child(prefix, tags)
+}
+
 // Call the given function for each registered metric.
 func Each(f func(string, interface{})) {
 	DefaultRegistry.Each(f)
 }
 
+// Call the given function for each registered metric with its name and
+// tags.
+func EachTagged(f func(string, Tags, interface{})) {
+	DefaultRegistry.EachTagged(f)
+}
+
 // Get the metric by the given name or nil if none is registered.
 func Get(name string) interface{} {
 	return DefaultRegistry.Get(name)
 }
 
+// Get the metric by the given name or register the given one.
+func GetOrRegister(name string, i interface{}) interface{} {
+	return DefaultRegistry.GetOrRegister(name, i)
+}
+
 // Register the given metric under the given name.
 func Register(name string, i interface{}) {
 	DefaultRegistry.Register(name, i)
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("foo", NewCounter())
+	r.Register("bar", "not a metric")
+	i := 0
+	r.Each(func(name string, iface interface{}) {
+		i++
+		if "foo" != name {
+			t.Fatal(name)
+		}
+		if _, ok := iface.(Counter); !ok {
+			t.Fatal(iface)
+		}
+	})
+	if 1 != i {
+		t.Fatal(i)
+	}
+	r.Unregister("foo")
+	if nil != r.Get("foo") {
+		t.Fatal(r.Get("foo"))
+	}
+}
+
+func TestRegistryGetOrRegister(t *testing.T) {
+	r := NewRegistry()
+	c := GetOrRegisterCounter("foo", r)
+	c.Inc(47)
+	if c2 := GetOrRegisterCounter("foo", r); c2 != c {
+		t.Fatal(c2)
+	}
+	if i := r.GetOrRegister("foo", NewGauge()); i != c {
+		t.Fatal(i)
+	}
+	called := false
+	r.GetOrRegister("foo", func() Counter { called = true; return NewCounter() })
+	if called {
+		t.Fatal("constructor called for an existing metric")
+	}
+}
+
+func TestRegistryGetOrRegisterConcurrent(t *testing.T) {
+	r := NewRegistry()
+	cs := make([]Counter, 100)
+	var wg sync.WaitGroup
+	for i := range cs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cs[i] = GetOrRegisterCounter("foo", r)
+			cs[i].Inc(1)
+		}(i)
+	}
+	wg.Wait()
+	for _, c := range cs {
+		if c != cs[0] {
+			t.Fatal("different counters returned")
+		}
+	}
+	if count := cs[0].Count(); 100 != count {
+		t.Errorf("c.Count(): 100 != %v\n", count)
+	}
+}
+
+func TestRegistryChild(t *testing.T) {
+	r := NewRegistry()
+	r.Register("db.queries", NewCounter())
+	child := r.Child("db", Tags{"host": "a"})
+	child.Register("queries", NewCounter())
+	child.Child("pool", Tags{"shard": "1"}).Register("conns", NewGauge())
+
+	if nil == child.Get("queries") || child.Get("queries") == r.Get("db.queries") {
+		t.Fatal("tagged metric is the untagged one")
+	}
+	if nil != child.Get("pool.conns") {
+		t.Fatal("found metric with more tags")
+	}
+
+	names := make(map[string]Tags)
+	r.EachTagged(func(name string, tags Tags, i interface{}) {
+		names[taggedName(name, tags)] = tags
+	})
+	for _, name := range []string{
+		"db.queries",
+		"db.queries;host=a",
+		"db.pool.conns;host=a;shard=1",
+	} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("%s not registered in %v\n", name, names)
+		}
+	}
+
+	var seen []string
+	child.Each(func(name string, i interface{}) {
+		seen = append(seen, name)
+	})
+	if 2 != len(seen) {
+		t.Errorf("child visited %v\n", seen)
+	}
+	for _, name := range seen {
+		if "queries;host=a" != name && "pool.conns;host=a;shard=1" != name {
+			t.Errorf("child visited %s\n", name)
+		}
+	}
+
+	child.Unregister("queries")
+	if nil == r.Get("db.queries") || nil != child.Get("queries") {
+		t.Fatal("Unregister through the child")
+	}
+}
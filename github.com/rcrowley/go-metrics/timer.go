@@ -47,6 +47,15 @@ func NewTimer() *StandardTimer {
 	}
 }
 
+// Get an existing or register and return a new timer by the given name.  A
+// nil registry means the default registry.
+func GetOrRegisterTimer(name string, r Registry) Timer {
+	if nil == r {
+		r = DefaultRegistry
+	}
+	return r.GetOrRegister(name, NewTimer).(Timer)
+}
+
 // Return the count of inputs.
 func (t *StandardTimer) Count() int64 {
 	return t.h.Count()